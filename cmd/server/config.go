@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+)
+
+// runConfigCommand handles `config check`, which prints the effective
+// configuration with secrets redacted and validates it
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: strava-pipeline config check [--config path]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to config file")
+	fs.Parse(args[1:])
+
+	cfg, err := config.ReadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+
+	printSettings(os.Stdout, "", reflect.ValueOf(*cfg.Redacted()))
+
+	if err := cfg.Validate(); err != nil {
+		var verr *config.ValidationError
+		if errors.As(err, &verr) {
+			fmt.Fprintf(os.Stderr, "\nConfiguration has %d problem(s):\n", len(verr.Problems))
+			for _, problem := range verr.Problems {
+				fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		}
+		return 1
+	}
+
+	fmt.Fprintln(os.Stderr, "\nConfiguration is valid")
+	return 0
+}
+
+// printSettings writes one "key: value" line per setting, using the same
+// dotted keys as config.yaml
+func printSettings(w io.Writer, prefix string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := t.Field(i).Tag.Get("mapstructure")
+			if name == "" {
+				name = strings.ToLower(t.Field(i).Name)
+			}
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			printSettings(w, name, v.Field(i))
+		}
+	case reflect.Slice:
+		if v.Len() == 0 {
+			fmt.Fprintf(w, "%s: []\n", prefix)
+		}
+		for i := 0; i < v.Len(); i++ {
+			printSettings(w, fmt.Sprintf("%s[%d]", prefix, i), v.Index(i))
+		}
+	case reflect.String:
+		fmt.Fprintf(w, "%s: %q\n", prefix, v.String())
+	default:
+		fmt.Fprintf(w, "%s: %v\n", prefix, v.Interface())
+	}
+}
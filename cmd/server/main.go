@@ -1,70 +1,236 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/TobiKin/strava-data-pipeline/internal/api"
 	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/cluster"
 	"github.com/TobiKin/strava-data-pipeline/internal/config"
 	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/events"
+	"github.com/TobiKin/strava-data-pipeline/internal/export"
+	"github.com/TobiKin/strava-data-pipeline/internal/jobs"
+	"github.com/TobiKin/strava-data-pipeline/internal/lifecycle"
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
 	"github.com/TobiKin/strava-data-pipeline/internal/strava"
+	"github.com/TobiKin/strava-data-pipeline/internal/tracing"
+	"github.com/TobiKin/strava-data-pipeline/internal/webhooks"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
 	// Parse command-line flags
 	configPath := flag.String("config", "", "path to config file")
+	bootstrapOperator := flag.Int64("bootstrap-operator", 0, "promote the given user ID to operator if no operator exists yet, then exit")
 	flag.Parse()
 
 	// Load configuration
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		fatal("Error loading config", err)
+	}
+	if _, err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		fatal("Error setting up logging", err)
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Error setting up tracing", err)
+	}
+	if cfg.DevMode() {
+		slog.Warn("Running in development mode; default secrets are allowed")
 	}
 
 	// Initialize database connection
 	database, err := db.New(cfg)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		fatal("Error connecting to database", err)
 	}
-	defer database.Close()
 
 	// Initialize database schema
-	if err := database.InitSchema(); err != nil {
-		log.Fatalf("Error initializing database schema: %v", err)
+	database.InitSchema()
+
+	if err := database.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		fatal("Error registering database metrics", err)
+	}
+
+	// Initialize authentication service
+	authService, err := auth.New(cfg, database)
+	if err != nil {
+		fatal("Error creating authentication service", err)
+	}
+
+	if *bootstrapOperator != 0 {
+		defer database.Close()
+		ctx := auth.WithClientInfo(context.Background(), auth.ClientInfo{UserAgent: "server --bootstrap-operator"})
+		if err := promoteFirstOperator(ctx, database, authService, *bootstrapOperator); err != nil {
+			fatal("Error bootstrapping operator", err)
+		}
+		slog.Info("User is now an operator", "user_id", *bootstrapOperator)
+		return
 	}
 
 	// Initialize Strava client
 	stravaClient, err := strava.New(cfg, database)
 	if err != nil {
-		log.Fatalf("Error creating Strava client: %v", err)
+		fatal("Error creating Strava client", err)
 	}
 
-	// Initialize authentication service
-	authService := auth.New(cfg, database)
+	// Initialize job queue and worker
+	nodeID := cluster.NodeID()
+	queue := jobs.NewQueue(database, cfg.Jobs.MaxAttempts)
+	worker := jobs.NewWorker(database, nodeID, cfg.Jobs.Workers, time.Duration(cfg.Jobs.PollInterval)*time.Second)
+	jobs.RegisterStravaHandlers(worker, stravaClient, database)
+	snapshotter := export.NewSnapshotter(database, cfg.Exports)
+	jobs.RegisterExportHandler(worker, snapshotter)
 
-	// Initialize API server
-	apiServer := api.New(database, stravaClient, authService)
+	// Scheduled work runs only on the elected leader
+	elector := cluster.NewElector(database, nodeID, time.Duration(cfg.Cluster.LeaseTTL)*time.Second)
+	elector.OnLead("sync schedule", func(ctx context.Context) {
+		queue.RunSyncSchedule(ctx, 1*time.Hour) // Sync every hour
+	})
+	elector.OnLead("token cleanup", func(ctx context.Context) {
+		authService.RunTokenCleanup(ctx, 6*time.Hour)
+	})
+	elector.OnLead("audit retention", func(ctx context.Context) {
+		authService.RunAuditRetention(ctx, 24*time.Hour)
+	})
+	deliverer := webhooks.NewDeliverer(database, cfg.Webhooks)
+	elector.OnLead("webhook retention", func(ctx context.Context) {
+		deliverer.RunRetention(ctx, time.Hour)
+	})
+	if cfg.Events.Enabled {
+		publisher, err := events.NewPublisher(cfg.Events)
+		if err != nil {
+			fatal("Error creating event publisher", err)
+		}
+		relay := events.NewRelay(database, publisher, cfg.Events)
+		elector.OnLead("event relay", relay.Run)
+		elector.OnLead("event retention", func(ctx context.Context) {
+			relay.RunRetention(ctx, time.Hour)
+		})
+	}
+	if cfg.Exports.Enabled {
+		elector.OnLead("export schedule", func(ctx context.Context) {
+			queue.RunExportSchedule(ctx, snapshotter.Targets(), time.Duration(cfg.Exports.Interval)*time.Hour)
+		})
+	}
 
-	// Start background sync job
-	stravaClient.StartSyncJob(1 * time.Hour) // Sync every hour
+	// Initialize API server
+	apiServer := api.New(database, stravaClient, authService, queue, elector)
 
-	// Start HTTP server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	log.Printf("Starting server on %s", addr)
-
 	server := &http.Server{
 		Addr:         addr,
 		Handler:      apiServer,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorLog:     slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
 	}
+	// Change streams stay open until the client leaves; end them on shutdown
+	server.RegisterOnShutdown(apiServer.CloseStreams)
 
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Error starting server: %v", err)
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Subsystems are started in this order and stopped in reverse: the HTTP
+	// server stops accepting requests first and the database closes last
+	lc := lifecycle.New()
+	lc.Append(lifecycle.Hook{
+		Name:   "tracing",
+		OnStop: shutdownTracing, // flushes the remaining spans
+	})
+	lc.Append(lifecycle.Hook{
+		Name:   "database",
+		OnStop: func(context.Context) error { return database.Close() },
+	})
+	lc.Append(lifecycle.Background("job worker", worker.Run))
+	if cfg.Webhooks.Workers > 0 {
+		lc.Append(lifecycle.Background("webhook deliverer", deliverer.Run))
+	}
+	lc.Append(lifecycle.Background("leader election", elector.Run))
+	lc.Append(httpServerHook(server, stop))
+
+	if err := lc.Start(ctx); err != nil {
+		fatal("Error starting", err)
+	}
+
+	<-ctx.Done()
+	stop() // a second signal terminates immediately
+	slog.Info("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	if err := lc.Stop(shutdownCtx); err != nil {
+		fatal("Error shutting down", err)
+	}
+	slog.Info("Shutdown complete")
+}
+
+// httpServerHook listens on the server's address when started and drains
+// in-flight requests when stopped. If the server fails while running, stop
+// is called to shut down the application.
+func httpServerHook(server *http.Server, stop context.CancelFunc) lifecycle.Hook {
+	return lifecycle.Hook{
+		Name: "HTTP server",
+		OnStart: func(context.Context) error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			slog.Info("Starting server", "addr", server.Addr)
+			go func() {
+				if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					slog.Error("Error serving HTTP", "error", err)
+					stop()
+				}
+			}()
+			return nil
+		},
+		OnStop: server.Shutdown,
+	}
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// promoteFirstOperator makes the user an operator. It refuses to run once an
+// operator exists; further operators are promoted through the admin API. The
+// promotion is recorded in the audit log without an actor.
+func promoteFirstOperator(ctx context.Context, database *db.DB, authService *auth.Service, userID int64) error {
+	count, err := database.CountUsersWithRole(ctx, db.RoleOperator)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("an operator already exists, use PUT /admin/users/{id}/role instead")
+	}
+	if err := database.SetUserRole(ctx, userID, db.RoleOperator); err != nil {
+		return err
 	}
+	authService.Audit(ctx, 0, db.AuditUserRoleChange, "user", strconv.FormatInt(userID, 10), map[string]interface{}{
+		"role":      db.RoleOperator,
+		"bootstrap": true,
+	})
+	return nil
 }
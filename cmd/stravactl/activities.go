@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/export"
+)
+
+// activitiesExport writes stored activities to a file or stdout
+func activitiesExport(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("activities export")
+	format := fs.String("format", string(export.FormatCSV), "export format, csv, ndjson or parquet")
+	out := fs.String("out", "-", "output file, - for stdout")
+	columns := fs.String("columns", "", "comma-separated columns to export (default: common columns)")
+	units := fs.String("units", string(export.UnitsMeters), "units of distances and speeds, m, km or mi")
+	gzip := fs.Bool("gzip", false, "compress the output")
+	filter := db.ActivityFilter{}
+	fs.Int64Var(&filter.AthleteID, "user", 0, "only export activities of this user")
+	fs.StringVar(&filter.Type, "type", "", "only export activities of this type")
+	var after, before timeFlag
+	fs.Var(&after, "after", "only export activities started after this date")
+	fs.Var(&before, "before", "only export activities started before this date")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	filter.After = after.Time
+	filter.Before = before.Time
+
+	opts := export.Options{Gzip: *gzip}
+	var err error
+	if opts.Format, err = export.ParseFormat(*format); err != nil {
+		return usageError{err.Error()}
+	}
+	if opts.Columns, err = export.ParseColumns(*columns); err != nil {
+		return usageError{err.Error()}
+	}
+	if opts.Units, err = export.ParseUnits(*units); err != nil {
+		return usageError{err.Error()}
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	var dst io.Writer = a.stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("error creating output file: %w", err)
+		}
+		defer file.Close()
+		dst = file
+	}
+
+	w, err := export.NewWriter(dst, opts)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for activity, err := range a.db.StreamActivities(ctx, filter) {
+		if err != nil {
+			return err
+		}
+		if err := w.Write(activity); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "Exported %d activities to %s\n", n, *out)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// dbMigrate creates missing tables and columns and prints the schema version
+func dbMigrate(ctx context.Context, a *app, args []string) error {
+	if _, err := a.parse(a.flags("db migrate"), args, 0); err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	before, err := a.db.GetSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	a.db.InitSchema()
+	after, err := a.db.GetSchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	return a.print(map[string]int{"previous_version": before, "version": after}, func(w io.Writer) {
+		if before == after {
+			fmt.Fprintf(w, "Schema is up to date at version %d\n", after)
+		} else {
+			fmt.Fprintf(w, "Migrated schema from version %d to %d\n", before, after)
+		}
+	})
+}
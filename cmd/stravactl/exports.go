@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/export"
+)
+
+// exportsRun writes a snapshot to an export target right away instead of
+// waiting for the schedule
+func exportsRun(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("exports run")
+	target := fs.String("target", "", "name of the export target")
+	full := fs.Bool("full", false, "write a full snapshot in incremental mode")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *target == "" {
+		return usageError{"--target is required"}
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	manifest, err := export.NewSnapshotter(a.db, a.cfg.Exports).Run(ctx, *target, *full)
+	if err != nil {
+		return err
+	}
+	return a.print(manifest, func(w io.Writer) {
+		fmt.Fprintf(w, "Wrote %s snapshot %s with %d activities in %d files\n",
+			manifest.Mode, manifest.ID, manifest.Activities, len(manifest.Files))
+	})
+}
+
+// exportsList prints the snapshots on an export target
+func exportsList(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("exports list")
+	target := fs.String("target", "", "name of the export target")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *target == "" {
+		return usageError{"--target is required"}
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	snapshots, err := export.NewSnapshotter(a.db, a.cfg.Exports).List(ctx, *target)
+	if err != nil {
+		return err
+	}
+	if snapshots == nil {
+		snapshots = []export.Manifest{}
+	}
+	return a.print(snapshots, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tMODE\tBASE\tACTIVITIES\tDELETED\tFILES\tSEQ")
+		for _, s := range snapshots {
+			base := s.Base
+			if base == "" {
+				base = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", s.ID, s.Mode, base, s.Activities, s.Deleted, len(s.Files), s.Seq)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// keyView is the output of the keys commands. The key itself is only
+// printed when it is created.
+type keyView struct {
+	ID          int64  `json:"id"`
+	Key         string `json:"key,omitempty"`
+	Description string `json:"description"`
+	Scope       string `json:"scope"`
+	Active      bool   `json:"active"`
+	CreatedAt   string `json:"created_at"`
+	ExpiresAt   string `json:"expires_at"`
+}
+
+func newKeyView(k db.APIKey) keyView {
+	return keyView{
+		ID:          k.ID,
+		Description: k.Description,
+		Scope:       k.Scope,
+		Active:      k.IsActive,
+		CreatedAt:   formatTime(k.CreatedAt),
+		ExpiresAt:   formatTime(k.ExpiresAt),
+	}
+}
+
+// keysCreate creates an API key for a user and prints it
+func keysCreate(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("keys create")
+	userID := fs.Int64("user", 0, "owner of the key")
+	description := fs.String("description", "", "description of the key")
+	expiryDays := fs.Int("expiry-days", 365, "days until the key expires")
+	scope := fs.String("scope", db.ScopeUser, "scope of the key, user or coach")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return usageError{"--user is required"}
+	}
+	if *expiryDays <= 0 {
+		return usageError{"--expiry-days must be positive"}
+	}
+	if *scope != db.ScopeUser && *scope != db.ScopeCoach {
+		return usageError{fmt.Sprintf("invalid scope %q", *scope)}
+	}
+
+	authService, err := a.auth()
+	if err != nil {
+		return err
+	}
+
+	// Only coaches may own keys that can read team data
+	role, err := a.db.GetUserRole(ctx, *userID)
+	if err != nil {
+		return err
+	}
+	if *scope == db.ScopeCoach && role != db.RoleCoach && role != db.RoleOperator {
+		return fmt.Errorf("user %d is a %s, only coaches can own coach-scoped keys", *userID, role)
+	}
+
+	apiKey, err := authService.GenerateAPIKey(ctx, *userID, *scope, *description, *expiryDays)
+	if err != nil {
+		return err
+	}
+	authService.Audit(ctx, 0, db.AuditKeyCreate, "api_key", strconv.FormatInt(apiKey.ID, 10), map[string]interface{}{
+		"user_id":     *userID,
+		"description": *description,
+		"expiry_days": *expiryDays,
+		"scope":       *scope,
+	})
+
+	v := newKeyView(apiKey)
+	v.Key = apiKey.Key
+	return a.print(v, func(w io.Writer) {
+		fmt.Fprintf(w, "ID:\t%d\n", v.ID)
+		fmt.Fprintf(w, "Key:\t%s\n", v.Key)
+		fmt.Fprintf(w, "Scope:\t%s\n", v.Scope)
+		fmt.Fprintf(w, "Expires:\t%s\n", v.ExpiresAt)
+	})
+}
+
+// keysList prints the API keys of a user
+func keysList(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("keys list")
+	userID := fs.Int64("user", 0, "owner of the keys")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return usageError{"--user is required"}
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	keys, err := a.db.ReadApiKeyByUserID(ctx, *userID)
+	if err != nil {
+		return err
+	}
+
+	views := make([]keyView, len(keys))
+	for i, k := range keys {
+		views[i] = newKeyView(k)
+	}
+	return a.print(views, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tDESCRIPTION\tSCOPE\tACTIVE\tEXPIRES")
+		for _, v := range views {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\n", v.ID, v.Description, v.Scope, v.Active, v.ExpiresAt)
+		}
+	})
+}
+
+// keysRevoke deactivates an API key
+func keysRevoke(ctx context.Context, a *app, args []string) error {
+	rest, err := a.parse(a.flags("keys revoke"), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := parseID("key ID", rest[0])
+	if err != nil {
+		return err
+	}
+	authService, err := a.auth()
+	if err != nil {
+		return err
+	}
+
+	if err := a.db.DeactivateAPIKey(ctx, id); err != nil {
+		return err
+	}
+	authService.Audit(ctx, 0, db.AuditKeyRevoke, "api_key", strconv.FormatInt(id, 10), nil)
+
+	fmt.Fprintf(a.stdout, "Revoked API key %d\n", id)
+	return nil
+}
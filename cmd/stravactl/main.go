@@ -0,0 +1,249 @@
+// Command stravactl operates the pipeline from the command line. It uses the
+// same configuration as the server and talks to the database and Strava
+// directly, so it works while the server is down.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+)
+
+// command is a subcommand such as "users list"
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = []command{
+	{"users list", "", usersList},
+	{"users show", "<user-id>", usersShow},
+	{"users delete", "<user-id>", usersDelete},
+	{"keys create", "--user <id> [--description text] [--expiry-days n] [--scope user|coach]", keysCreate},
+	{"keys list", "--user <id>", keysList},
+	{"keys revoke", "<key-id>", keysRevoke},
+	{"sync run", "--user <id> [--since date]", syncRun},
+	{"backfill", "--user <id> [--after date] [--before date]", backfill},
+	{"activities export", "[--format csv|ndjson|parquet] [--out path] [--columns list] [--units m|km|mi] [--gzip] [--user id] [--type type] [--after date] [--before date]", activitiesExport},
+	{"exports run", "--target <name> [--full]", exportsRun},
+	{"exports list", "--target <name>", exportsList},
+	{"db migrate", "", dbMigrate},
+	{"token refresh", "--user <id>", tokenRefresh},
+}
+
+// usageError is returned for invalid arguments
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string { return e.msg }
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes the command in args and returns the exit code
+func run(args []string) int {
+	cmd, rest, ok := findCommand(args)
+	if !ok {
+		printUsage(os.Stderr)
+		return 2
+	}
+
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx = auth.WithClientInfo(ctx, auth.ClientInfo{UserAgent: "stravactl"})
+
+	a := &app{stdout: os.Stdout, output: "table"}
+	defer a.close()
+
+	err := cmd.run(ctx, a, rest)
+	var uerr usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "%v\nusage: stravactl %s %s\n", err, cmd.name, cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+}
+
+// findCommand returns the command named by the first one or two arguments
+// and the remaining arguments
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: stravactl <command> [--config path] [--output table|json] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.usage)
+	}
+	tw.Flush()
+}
+
+// app holds the state shared by the commands. The configuration and the
+// database connection are opened on first use.
+type app struct {
+	configPath string
+	output     string
+	stdout     io.Writer
+
+	cfg *config.Config
+	db  *db.DB
+
+	// progressShown is set once a progress line has been written to stderr
+	progressShown bool
+}
+
+// flags returns a flag set with the flags every command accepts
+func (a *app) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&a.configPath, "config", "", "path to config file")
+	fs.StringVar(&a.output, "output", a.output, "output format, table or json")
+	fs.StringVar(&a.output, "o", a.output, "shorthand for --output")
+	return fs
+}
+
+// parse parses flags and returns the positional arguments, which may appear
+// before, between or after the flags
+func (a *app) parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usageError{err.Error()}
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(rest) != positional {
+		return nil, usageError{fmt.Sprintf("expected %d argument(s), got %d", positional, len(rest))}
+	}
+	if a.output != "table" && a.output != "json" {
+		return nil, usageError{fmt.Sprintf("unknown output format %q", a.output)}
+	}
+	return rest, nil
+}
+
+// open loads the configuration and connects to the database
+func (a *app) open() error {
+	if a.db != nil {
+		return nil
+	}
+
+	cfg, err := config.LoadConfig(a.configPath)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	// Keep stdout free for command output
+	if _, err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		return fmt.Errorf("error setting up logging: %w", err)
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	a.cfg = cfg
+	a.db = database
+	return nil
+}
+
+func (a *app) close() {
+	if a.progressShown {
+		fmt.Fprintln(os.Stderr)
+	}
+	if a.db != nil {
+		a.db.Close()
+	}
+}
+
+// print writes v as indented JSON or, for table output, calls table with a
+// tabwriter that is flushed afterwards
+func (a *app) print(v interface{}, table func(w io.Writer)) error {
+	if a.output == "json" {
+		enc := json.NewEncoder(a.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// parseID parses a positional ID argument
+func parseID(name, s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, usageError{fmt.Sprintf("invalid %s %q", name, s)}
+	}
+	return id, nil
+}
+
+// timeFlag is a flag that accepts RFC 3339 timestamps and dates
+type timeFlag struct {
+	time.Time
+}
+
+func (t *timeFlag) String() string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (t *timeFlag) Set(s string) error {
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("expected a date (2006-01-02) or an RFC 3339 timestamp")
+}
+
+// formatTime formats a time for table output, zero times as "-"
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/strava"
+)
+
+// syncRun fetches recent activities of a user from Strava
+func syncRun(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("sync run")
+	userID := fs.Int64("user", 0, "user whose activities are synced")
+	since := timeFlag{time.Now().AddDate(0, 0, -1)}
+	fs.Var(&since, "since", "fetch activities started after this date")
+	limit := fs.Int("limit", 100, "maximum number of activities to fetch")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return usageError{"--user is required"}
+	}
+
+	client, err := a.stravaClient(ctx, *userID)
+	if err != nil {
+		return err
+	}
+
+	authService, err := a.auth()
+	if err != nil {
+		return err
+	}
+	authService.Audit(ctx, 0, db.AuditSyncTrigger, "user", strconv.FormatInt(*userID, 10), map[string]interface{}{
+		"type":  "sync",
+		"since": since.Time,
+		"limit": *limit,
+	})
+
+	if err := client.FetchActivities(ctx, since.Time, *limit, a.progress("Synced")); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Synced activities of user %d since %s\n", *userID, since.Format(time.RFC3339))
+	return nil
+}
+
+// backfill fetches all activities of a user in a time range from Strava
+func backfill(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("backfill")
+	userID := fs.Int64("user", 0, "user whose activities are fetched")
+	var after, before timeFlag
+	fs.Var(&after, "after", "fetch activities started after this date (default: all)")
+	fs.Var(&before, "before", "fetch activities started before this date (default: now)")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return usageError{"--user is required"}
+	}
+
+	client, err := a.stravaClient(ctx, *userID)
+	if err != nil {
+		return err
+	}
+
+	authService, err := a.auth()
+	if err != nil {
+		return err
+	}
+	authService.Audit(ctx, 0, db.AuditSyncTrigger, "user", strconv.FormatInt(*userID, 10), map[string]interface{}{
+		"type":   "backfill",
+		"after":  after.Time,
+		"before": before.Time,
+	})
+
+	if err := client.Backfill(ctx, after.Time, before.Time, a.progress("Fetched")); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.stdout, "Backfill of user %d complete\n", *userID)
+	return nil
+}
+
+// tokenRefresh exchanges the Strava refresh token of a user for a new access
+// token
+func tokenRefresh(ctx context.Context, a *app, args []string) error {
+	fs := a.flags("token refresh")
+	userID := fs.Int64("user", 0, "user whose token is refreshed")
+	if _, err := a.parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *userID <= 0 {
+		return usageError{"--user is required"}
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	client, err := strava.New(a.cfg, a.db)
+	if err != nil {
+		return err
+	}
+	expiresAt, err := client.RefreshUserToken(ctx, *userID)
+	if err != nil {
+		return err
+	}
+
+	return a.print(map[string]interface{}{"user_id": *userID, "expires_at": expiresAt}, func(w io.Writer) {
+		fmt.Fprintf(w, "Refreshed Strava token of user %d, expires %s\n", *userID, formatTime(expiresAt))
+	})
+}
+
+// stravaClient returns a Strava client that uses the stored token of a user
+func (a *app) stravaClient(ctx context.Context, userID int64) (*strava.Client, error) {
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	client, err := strava.New(a.cfg, a.db)
+	if err != nil {
+		return nil, err
+	}
+	return client.ForUser(ctx, userID)
+}
+
+// progress returns a progress callback that keeps updating a single line on
+// stderr. The line is ended when the app closes.
+func (a *app) progress(verb string) strava.Progress {
+	return func(done, total int) {
+		a.progressShown = true
+		if total > 0 {
+			fmt.Fprintf(os.Stderr, "\r%s %d/%d activities", verb, done, total)
+		} else {
+			fmt.Fprintf(os.Stderr, "\r%s %d activities", verb, done)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// userView is the output of the users commands. Strava tokens are never
+// printed; the Strava fields are only set by users show.
+type userView struct {
+	ID             int64   `json:"id"`
+	Username       string  `json:"username"`
+	Role           db.Role `json:"role"`
+	StravaLinked   *bool   `json:"strava_linked,omitempty"`
+	TokenExpiresAt string  `json:"token_expires_at,omitempty"`
+	CreatedAt      string  `json:"created_at"`
+	UpdatedAt      string  `json:"updated_at"`
+}
+
+func newUserView(u db.User) userView {
+	return userView{
+		ID:        u.ID,
+		Username:  u.Username,
+		Role:      u.Role,
+		CreatedAt: formatTime(u.CreatedAt),
+		UpdatedAt: formatTime(u.UpdatedAt),
+	}
+}
+
+// usersList prints all users
+func usersList(ctx context.Context, a *app, args []string) error {
+	if _, err := a.parse(a.flags("users list"), args, 0); err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	users, err := a.db.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	views := make([]userView, len(users))
+	for i, u := range users {
+		views[i] = newUserView(u)
+	}
+	return a.print(views, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tUSERNAME\tROLE\tCREATED")
+		for _, v := range views {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", v.ID, v.Username, v.Role, v.CreatedAt)
+		}
+	})
+}
+
+// usersShow prints a single user
+func usersShow(ctx context.Context, a *app, args []string) error {
+	rest, err := a.parse(a.flags("users show"), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := parseID("user ID", rest[0])
+	if err != nil {
+		return err
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	user, err := a.db.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	v := newUserView(user)
+	linked := user.AccessToken != ""
+	v.StravaLinked = &linked
+	if !user.TokenExpiresAt.IsZero() {
+		v.TokenExpiresAt = formatTime(user.TokenExpiresAt)
+	}
+	return a.print(v, func(w io.Writer) {
+		fmt.Fprintf(w, "ID:\t%d\n", v.ID)
+		fmt.Fprintf(w, "Username:\t%s\n", v.Username)
+		fmt.Fprintf(w, "Role:\t%s\n", v.Role)
+		fmt.Fprintf(w, "Strava linked:\t%t\n", linked)
+		if v.TokenExpiresAt != "" {
+			fmt.Fprintf(w, "Token expires:\t%s\n", v.TokenExpiresAt)
+		}
+		fmt.Fprintf(w, "Created:\t%s\n", v.CreatedAt)
+		fmt.Fprintf(w, "Updated:\t%s\n", v.UpdatedAt)
+	})
+}
+
+// usersDelete deletes a user and revokes their sessions
+func usersDelete(ctx context.Context, a *app, args []string) error {
+	rest, err := a.parse(a.flags("users delete"), args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := parseID("user ID", rest[0])
+	if err != nil {
+		return err
+	}
+	authService, err := a.auth()
+	if err != nil {
+		return err
+	}
+
+	if err := authService.RevokeUserSessions(ctx, id); err != nil {
+		return err
+	}
+	if err := a.db.DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	authService.Audit(ctx, 0, db.AuditUserDelete, "user", strconv.FormatInt(id, 10), nil)
+
+	fmt.Fprintf(a.stdout, "Deleted user %d\n", id)
+	return nil
+}
+
+// auth opens the database and returns an authentication service for
+// commands that create audit events
+func (a *app) auth() (*auth.Service, error) {
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return auth.New(a.cfg, a.db)
+}
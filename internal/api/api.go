@@ -1,37 +1,53 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/cluster"
 	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/jobs"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
 	"github.com/TobiKin/strava-data-pipeline/internal/strava"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Server represents the API server
 type Server struct {
-	db           *db.DB
+	db           Store
 	stravaClient *strava.Client
 	authService  *auth.Service
+	queue        *jobs.Queue
+	elector      *cluster.Elector
 	router       *mux.Router
 	templates    *template.Template
+
+	// streams is canceled by CloseStreams to end long-lived responses
+	streams      context.Context
+	closeStreams context.CancelFunc
 }
 
 // New creates a new API server
-func New(db *db.DB, stravaClient *strava.Client, authService *auth.Service) *Server {
+func New(db Store, stravaClient *strava.Client, authService *auth.Service, queue *jobs.Queue, elector *cluster.Elector) *Server {
 	s := &Server{
 		db:           db,
 		stravaClient: stravaClient,
 		authService:  authService,
+		queue:        queue,
+		elector:      elector,
 		router:       mux.NewRouter(),
 	}
+	s.streams, s.closeStreams = context.WithCancel(context.Background())
 
 	// Initialize templates
 	s.templates = template.Must(template.New("").Parse(templateString))
@@ -45,8 +61,22 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.router.ServeHTTP(w, r)
 }
 
+// CloseStreams ends the open change streams. http.Server.Shutdown waits for
+// active requests, so register it with RegisterOnShutdown to let the server
+// stop without waiting for streaming clients to disconnect.
+func (s *Server) CloseStreams() {
+	s.closeStreams()
+}
+
 // routes sets up the routes for the API server
 func (s *Server) routes() {
+	s.router.Use(tracingMiddleware())
+	s.router.Use(requestIDMiddleware)
+	s.router.Use(metricsMiddleware)
+	s.router.Use(s.authService.ClientInfoMiddleware)
+	s.router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+
 	// Static files
 	s.router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
 
@@ -56,16 +86,36 @@ func (s *Server) routes() {
 	s.router.HandleFunc("/dashboard", s.dashboardHandler).Methods("GET")
 
 	// Public routes
-	s.router.HandleFunc("/api/health", s.healthHandler).Methods("GET")
+	s.router.HandleFunc("/api/health", s.readyHandler).Methods("GET")
+	s.router.HandleFunc("/api/health/live", s.liveHandler).Methods("GET")
+	s.router.HandleFunc("/api/health/ready", s.readyHandler).Methods("GET")
+	s.router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	s.router.HandleFunc("/api/auth/strava", s.stravaAuthHandler).Methods("GET")
 	s.router.HandleFunc("/api/auth/callback", s.stravaCallbackHandler).Methods("GET")
+	s.router.HandleFunc("/api/auth/refresh", s.refreshTokenHandler).Methods("POST")
+	s.router.HandleFunc("/api/auth/revoke", s.revokeTokenHandler).Methods("POST")
+	s.router.HandleFunc("/.well-known/jwks.json", s.jwksHandler).Methods("GET")
+	s.router.HandleFunc("/api/openapi.json", s.openAPIHandler).Methods("GET")
+	s.router.HandleFunc("/docs", s.docsHandler).Methods("GET")
+
+	// Team data routes (coach-scoped API key or JWT)
+	teams := s.router.PathPrefix("/api/v1/teams").Subrouter()
+	teams.Use(s.authService.APIKeyOrJWTMiddleware)
+
+	teams.HandleFunc("/{id}/members", s.teamMembersHandler).Methods("GET")
+	teams.HandleFunc("/{id}/activities", s.teamActivitiesHandler).Methods("GET")
+	teams.HandleFunc("/{id}/stats", s.teamStatsHandler).Methods("GET")
+	teams.HandleFunc("/{id}/export", s.teamExportHandler).Methods("GET")
 
 	// API routes (protected)
 	api := s.router.PathPrefix("/api/v1").Subrouter()
 	api.Use(s.authService.AuthMiddleware)
 
 	api.HandleFunc("/activities", s.listActivitiesHandler).Methods("GET")
+	api.HandleFunc("/activities/export", s.exportActivitiesHandler).Methods("GET")
 	api.HandleFunc("/activities/{id}", s.getActivityHandler).Methods("GET")
+	api.HandleFunc("/changes", s.listChangesHandler).Methods("GET")
+	api.HandleFunc("/changes/stream", s.streamChangesHandler).Methods("GET")
 
 	// Admin routes
 	admin := s.router.PathPrefix("/admin").Subrouter()
@@ -73,7 +123,50 @@ func (s *Server) routes() {
 
 	admin.HandleFunc("/keys", s.listKeysHandler).Methods("GET")
 	admin.HandleFunc("/keys", s.createKeyHandler).Methods("POST")
-	admin.HandleFunc("/sync", s.syncActivitiesHandler).Methods("POST")
+	admin.HandleFunc("/keys/{id}", s.revokeKeyHandler).Methods("DELETE")
+	admin.HandleFunc("/keys/{id}/rotate", s.rotateKeyHandler).Methods("POST")
+	admin.HandleFunc("/logout", s.logoutHandler).Methods("POST")
+	admin.HandleFunc("/deauthorize", s.deauthorizeHandler).Methods("POST")
+	admin.HandleFunc("/teams", s.listTeamsHandler).Methods("GET")
+	admin.HandleFunc("/teams/{id}/members/{userID}", s.removeTeamMemberHandler).Methods("DELETE")
+	admin.HandleFunc("/invitations", s.listInvitationsHandler).Methods("GET")
+	admin.HandleFunc("/invitations/{id}/accept", s.acceptInvitationHandler).Methods("POST")
+	admin.HandleFunc("/invitations/{id}/decline", s.declineInvitationHandler).Methods("POST")
+	admin.HandleFunc("/webhooks", s.listWebhooksHandler).Methods("GET")
+	admin.HandleFunc("/webhooks", s.createWebhookHandler).Methods("POST")
+	admin.HandleFunc("/webhooks/{id}", s.getWebhookHandler).Methods("GET")
+	admin.HandleFunc("/webhooks/{id}", s.updateWebhookHandler).Methods("PUT")
+	admin.HandleFunc("/webhooks/{id}", s.deleteWebhookHandler).Methods("DELETE")
+	admin.HandleFunc("/webhooks/{id}/deliveries", s.listWebhookDeliveriesHandler).Methods("GET")
+	admin.HandleFunc("/webhooks/{id}/deliveries/{deliveryID}/redeliver", s.redeliverWebhookHandler).Methods("POST")
+
+	// Coach routes
+	coach := admin.NewRoute().Subrouter()
+	coach.Use(s.authService.RequireRole(db.RoleCoach, db.RoleOperator))
+
+	coach.HandleFunc("/athletes", s.listAthletesHandler).Methods("GET")
+	coach.HandleFunc("/athletes/{id}/activities", s.athleteActivitiesHandler).Methods("GET")
+	coach.HandleFunc("/teams", s.createTeamHandler).Methods("POST")
+	coach.HandleFunc("/teams/{id}", s.deleteTeamHandler).Methods("DELETE")
+	coach.HandleFunc("/teams/{id}/invitations", s.inviteTeamMemberHandler).Methods("POST")
+
+	// Operator routes
+	operator := admin.NewRoute().Subrouter()
+	operator.Use(s.authService.RequireRole(db.RoleOperator))
+
+	operator.HandleFunc("/sync", s.syncActivitiesHandler).Methods("POST")
+	operator.HandleFunc("/backfill", s.backfillHandler).Methods("POST")
+	operator.HandleFunc("/activities/{id}/streams", s.streamDownloadHandler).Methods("POST")
+	operator.HandleFunc("/jobs/{id}", s.getJobHandler).Methods("GET")
+	operator.HandleFunc("/cluster", s.clusterHandler).Methods("GET")
+	operator.HandleFunc("/budget", s.budgetHandler).Methods("GET")
+	operator.HandleFunc("/audit", s.listAuditEventsHandler).Methods("GET")
+	operator.HandleFunc("/users", s.listUsersHandler).Methods("GET")
+	operator.HandleFunc("/users/{id}", s.getUserHandler).Methods("GET")
+	operator.HandleFunc("/users/{id}", s.deleteUserHandler).Methods("DELETE")
+	operator.HandleFunc("/users/{id}/role", s.setUserRoleHandler).Methods("PUT")
+	operator.HandleFunc("/users/{id}/athletes", s.linkAthleteHandler).Methods("POST")
+	operator.HandleFunc("/users/{id}/athletes/{athleteID}", s.unlinkAthleteHandler).Methods("DELETE")
 
 	// Serve static files if needed
 	// s.router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
@@ -87,7 +180,7 @@ func (s *Server) homeHandler(w http.ResponseWriter, r *http.Request) {
 		"Title":       "Strava Data Pipeline",
 		"CurrentYear": time.Now().Year(),
 	}
-	s.renderTemplate(w, "home", data)
+	s.renderTemplate(w, r, "home", data)
 }
 
 // loginHandler handles the login page
@@ -100,7 +193,7 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		"AuthURL":     authURL,
 		"CurrentYear": time.Now().Year(),
 	}
-	s.renderTemplate(w, "login", data)
+	s.renderTemplate(w, r, "login", data)
 }
 
 // dashboardHandler handles the dashboard page
@@ -113,47 +206,55 @@ func (s *Server) dashboardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate token
-	claims, err := s.authService.ValidateJWT(token)
+	claims, err := s.authService.ValidateJWT(r.Context(), token)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 
 	// Get user information
-	user, err := s.stravaClient.GetUserByID(claims.UserID)
-	if err != nil || user == nil {
-		http.Error(w, "Error getting user information", http.StatusInternalServerError)
+	user, err := s.stravaClient.GetUserByID(r.Context(), claims.UserID)
+	if err == nil && user == nil {
+		err = errors.New("user not loaded")
+	}
+	if err != nil {
+		writeError(w, r, err, "Error getting user information")
 		return
 	}
 
 	// Get API keys for user (we'll need to implement this)
-	apiKeys, err := s.db.GetAPIKeysForUser(claims.UserID)
+	apiKeys, err := s.db.ReadApiKeyByUserID(r.Context(), claims.UserID)
 	if err != nil {
-		http.Error(w, "Error getting API keys", http.StatusInternalServerError)
+		writeError(w, r, err, "Error getting API keys")
 		return
 	}
 
+	// Only operators may trigger the global sync
+	role, err := s.db.GetUserRole(r.Context(), claims.UserID)
+	if err != nil {
+		role = db.RoleAthlete
+	}
+
+	// Team invitations waiting for the user's consent
+	invitations, err := s.db.GetPendingInvitations(r.Context(), claims.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error getting invitations", "error", err)
+	}
+
 	data := map[string]interface{}{
 		"Title":       "Dashboard",
 		"User":        user,
+		"Role":        role,
+		"Invitations": invitations,
 		"APIKeys":     apiKeys,
 		"Token":       token,
 		"CurrentYear": time.Now().Year(),
 	}
-	s.renderTemplate(w, "dashboard", data)
+	s.renderTemplate(w, r, "dashboard", data)
 }
 
 // API handlers
 
-// healthHandler handles health check requests
-func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-		"time":   time.Now().Format(time.RFC3339),
-	})
-}
-
 // stravaAuthHandler initiates the Strava OAuth flow
 func (s *Server) stravaAuthHandler(w http.ResponseWriter, r *http.Request) {
 	authURL := s.stravaClient.StartAuthFlow()
@@ -173,61 +274,106 @@ func (s *Server) stravaAuthHandler(w http.ResponseWriter, r *http.Request) {
 func (s *Server) stravaCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, "Missing authorization code")
 		return
 	}
 
 	// Exchange the code for a token
 	resp, err := s.stravaClient.HandleAuthCallback(r.Context(), code)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error exchanging code: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "Error exchanging code")
 		return
 	}
 
-	// Generate a JWT token for the user
-	token, err := s.authService.GenerateJWT(resp.Athlete.Id)
+	s.authService.Audit(r.Context(), resp.Athlete.ID, db.AuditLogin, "user", strconv.FormatInt(resp.Athlete.ID, 10), nil)
+
+	// Issue an access and refresh token for the user
+	tokens, err := s.authService.IssueTokens(r.Context(), resp.Athlete.ID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error generating token: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "Error generating token")
 		return
 	}
 
 	// Check if the request prefers HTML (browser) or JSON (API)
 	if preferHTML(r) {
-		http.Redirect(w, r, "/dashboard?token="+token, http.StatusFound)
+		http.Redirect(w, r, "/dashboard?token="+tokens.AccessToken, http.StatusFound)
 	} else {
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"token": token,
-		})
+		json.NewEncoder(w).Encode(tokens)
 	}
 }
 
-// listActivitiesHandler handles requests to list activities
-func (s *Server) listActivitiesHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse query parameters
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+// refreshTokenHandler exchanges a refresh token for a new token pair
+func (s *Server) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
 
-	limit := 20
-	if limitStr != "" {
-		l, err := strconv.Atoi(limitStr)
-		if err == nil && l > 0 {
-			limit = l
-		}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
 	}
 
-	offset := 0
-	if offsetStr != "" {
-		o, err := strconv.Atoi(offsetStr)
-		if err == nil && o >= 0 {
-			offset = o
+	tokens, err := s.authService.RefreshTokens(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			writeProblemCode(w, r, http.StatusUnauthorized, problem.CodeTokenReused, err.Error())
+			return
 		}
+		if errors.Is(err, auth.ErrInvalidRefreshToken) {
+			writeProblemCode(w, r, http.StatusUnauthorized, problem.CodeInvalidToken, err.Error())
+			return
+		}
+		writeError(w, r, err, "Error refreshing tokens")
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// revokeTokenHandler revokes a refresh token and its rotation family
+func (s *Server) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if err := s.authService.RevokeRefreshToken(r.Context(), req.RefreshToken); err != nil {
+		writeError(w, r, err, "Error revoking refresh token")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwksHandler publishes the public JWT signing keys
+func (s *Server) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(s.authService.JWKS())
+}
+
+// listActivitiesHandler lists the caller's activities, filtered by the query
+// parameters athlete_id, type, after and before (RFC3339)
+func (s *Server) listActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseActivityFilter(w, r)
+	if !ok {
+		return
+	}
+	if filter.AthleteID, ok = s.authorizeAthlete(w, r); !ok {
+		return
+	}
+	filter.Limit, filter.Offset = parsePagination(r)
+
 	// Get activities from the database
-	activities, err := s.db.GetActivities(limit, offset)
+	activities, err := s.db.ListActivities(r.Context(), filter)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error getting activities: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "Error getting activities")
 		return
 	}
 
@@ -242,18 +388,26 @@ func (s *Server) getActivityHandler(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid activity ID", http.StatusBadRequest)
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid activity ID")
 		return
 	}
 
-	activity, err := s.db.GetActivityByID(id)
+	activity, err := s.db.GetActivityByID(r.Context(), id)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error getting activity: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "Error getting activity")
 		return
 	}
 
-	if activity == nil {
-		http.Error(w, "Activity not found", http.StatusNotFound)
+	// Activities of other athletes are reported as missing so that their
+	// IDs are not revealed
+	userID, _ := getUserIDFromContext(r)
+	allowed, err := s.mayReadAthlete(r, userID, activity.AthleteID)
+	if err != nil {
+		writeError(w, r, err, "Error checking access to activity")
+		return
+	}
+	if !allowed {
+		writeError(w, r, fmt.Errorf("activity %d %w", id, db.ErrNotFound), "Error getting activity")
 		return
 	}
 
@@ -268,9 +422,9 @@ func (s *Server) listKeysHandler(w http.ResponseWriter, r *http.Request) {
 	userID, _ := getUserIDFromContext(r)
 
 	// Get API keys for user
-	apiKeys, err := s.db.GetAPIKeysForUser(userID)
+	apiKeys, err := s.db.ReadApiKeyByUserID(r.Context(), userID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error getting API keys: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "Error getting API keys")
 		return
 	}
 
@@ -283,42 +437,155 @@ func (s *Server) createKeyHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Description string `json:"description"`
 		ExpiryDays  int    `json:"expiry_days"`
+		Scope       string `json:"scope"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
 	userID, _ := getUserIDFromContext(r)
 
-	// Generate a new API key
-	apiKey, err := s.authService.GenerateAPIKey(req.Description, req.ExpiryDays)
+	// Only coaches may create keys that can read team data
+	switch req.Scope {
+	case "", db.ScopeUser:
+		req.Scope = db.ScopeUser
+	case db.ScopeCoach:
+		role, err := s.db.GetUserRole(r.Context(), userID)
+		if err != nil || (role != db.RoleCoach && role != db.RoleOperator) {
+			writeProblem(w, r, http.StatusForbidden, "Only coaches can create coach-scoped keys")
+			return
+		}
+	default:
+		writeProblem(w, r, http.StatusBadRequest, "Invalid scope")
+		return
+	}
+
+	apiKey, err := s.authService.GenerateAPIKey(r.Context(), userID, req.Scope, req.Description, req.ExpiryDays)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error creating API key: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err, "Error creating API key")
+		return
+	}
+
+	s.authService.Audit(r.Context(), userID, db.AuditKeyCreate, "api_key", strconv.FormatInt(apiKey.ID, 10), map[string]interface{}{
+		"description": req.Description,
+		"expiry_days": req.ExpiryDays,
+		"scope":       req.Scope,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"key":   apiKey.Key,
+		"scope": apiKey.Scope,
+	})
+}
+
+// revokeKeyHandler deactivates an API key of the current user
+func (s *Server) revokeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	apiKey, ok := s.ownedAPIKey(w, r, userID)
+	if !ok {
 		return
 	}
 
-	// Associate the API key with the user
-	if err := s.db.AssociateAPIKeyWithUser(apiKey, userID); err != nil {
-		http.Error(w, fmt.Sprintf("Error associating API key with user: %v", err), http.StatusInternalServerError)
+	if err := s.db.DeactivateAPIKey(r.Context(), apiKey.ID); err != nil {
+		writeError(w, r, err, "Error revoking API key", "api_key_id", apiKey.ID)
 		return
 	}
 
+	s.authService.Audit(r.Context(), userID, db.AuditKeyRevoke, "api_key", strconv.FormatInt(apiKey.ID, 10), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rotateKeyHandler replaces an API key of the current user with a new key
+// that has the same description, scope and expiry date
+func (s *Server) rotateKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	old, ok := s.ownedAPIKey(w, r, userID)
+	if !ok {
+		return
+	}
+
+	expiryDays := 0
+	if !old.ExpiresAt.IsZero() {
+		expiryDays = int(math.Ceil(time.Until(old.ExpiresAt).Hours() / 24))
+		if expiryDays < 1 {
+			expiryDays = 1
+		}
+	}
+
+	apiKey, err := s.authService.RotateAPIKey(r.Context(), old.ID, expiryDays)
+	if err != nil {
+		writeError(w, r, err, "Error rotating API key", "api_key_id", old.ID)
+		return
+	}
+
+	s.authService.Audit(r.Context(), userID, db.AuditKeyRotate, "api_key", strconv.FormatInt(apiKey.ID, 10), map[string]int64{
+		"replaces": old.ID,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"key": apiKey,
+		"key":   apiKey.Key,
+		"scope": apiKey.Scope,
 	})
 }
 
+// ownedAPIKey loads the API key from the {id} route variable and checks that
+// it belongs to the user. It writes the error response and returns false otherwise.
+func (s *Server) ownedAPIKey(w http.ResponseWriter, r *http.Request, userID int64) (db.APIKey, bool) {
+	id, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid API key ID")
+		return db.APIKey{}, false
+	}
+
+	apiKey, err := s.db.ReadAPIKeyByID(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err, "Error reading API key", "api_key_id", id)
+		return db.APIKey{}, false
+	}
+	// Keys of other users are reported as missing so their IDs are not revealed
+	if apiKey.UserID == nil || *apiKey.UserID != userID {
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("API key %d not found", id))
+		return db.APIKey{}, false
+	}
+
+	return apiKey, true
+}
+
+// deauthorizeHandler disconnects the current user's Strava account and ends all sessions
+func (s *Server) deauthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	if err := s.stravaClient.Deauthorize(r.Context(), userID); err != nil {
+		slog.ErrorContext(r.Context(), "Error deauthorizing Strava account", "error", err)
+		writeProblem(w, r, http.StatusBadGateway, "Error deauthorizing Strava account")
+		return
+	}
+
+	if err := s.authService.RevokeUserSessions(r.Context(), userID); err != nil {
+		slog.ErrorContext(r.Context(), "Error revoking sessions", "error", err)
+	}
+
+	s.authService.Audit(r.Context(), userID, db.AuditStravaDeauthorize, "user", strconv.FormatInt(userID, 10), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // syncActivitiesHandler handles requests to manually sync activities
 func (s *Server) syncActivitiesHandler(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Days int `json:"days"`
+		Days   int   `json:"days"`
+		UserID int64 `json:"user_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
 		return
 	}
 
@@ -326,24 +593,295 @@ func (s *Server) syncActivitiesHandler(w http.ResponseWriter, r *http.Request) {
 		req.Days = 1
 	}
 
-	// Start a goroutine to sync activities
-	go func() {
-		syncStartTime := time.Now().Add(-time.Duration(req.Days) * 24 * time.Hour)
-		if err := s.stravaClient.FetchActivities(syncStartTime, 100); err != nil {
-			log.Printf("Error syncing activities: %v", err)
+	userID, _ := getUserIDFromContext(r)
+
+	job, err := s.queue.EnqueueSync(r.Context(), jobs.SyncPayload{Days: req.Days, UserID: req.UserID}, userID)
+	if err != nil {
+		writeError(w, r, err, "Error queueing sync")
+		return
+	}
+
+	s.authService.Audit(r.Context(), userID, db.AuditSyncTrigger, "job", strconv.FormatInt(job.ID, 10), req)
+
+	s.writeJobAccepted(w, r, job)
+}
+
+// logoutHandler revokes the current access token and all refresh tokens of the user
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := s.authService.Logout(r.Context(), claims); err != nil {
+		writeError(w, r, err, "Error logging out")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Coach handlers
+
+// listAthletesHandler lists the athletes linked to the current coach
+func (s *Server) listAthletesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	athletes, err := s.db.GetCoachAthletes(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "Error getting athletes of coach")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(athletes)
+}
+
+// athleteActivitiesHandler lists the activities of an athlete linked to the
+// current coach. Operators can read the activities of every athlete.
+func (s *Server) athleteActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid athlete ID")
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+	role, _ := auth.RoleFromContext(r.Context())
+	if role != db.RoleOperator && userID != athleteID {
+		linked, err := s.db.IsCoachOf(r.Context(), userID, athleteID)
+		if err != nil {
+			writeError(w, r, err, "Error checking coach link")
+			return
+		}
+		if !linked {
+			writeProblem(w, r, http.StatusForbidden, "Forbidden")
+			return
 		}
-	}()
+	}
+
+	limit, offset := parsePagination(r)
+	activities, err := s.db.GetActivitiesByAthlete(r.Context(), athleteID, limit, offset)
+	if err != nil {
+		writeError(w, r, err, "Error getting activities of athlete", "athlete_id", athleteID)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "sync started",
+	json.NewEncoder(w).Encode(activities)
+}
+
+// Operator handlers
+
+// budgetHandler reports the Strava API rate limit usage
+func (s *Server) budgetHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.stravaClient.RateLimitStatus())
+}
+
+// listAuditEventsHandler lists audit events, filtered by the query parameters
+// actor_id, action, target_type, target_id, since and until (RFC3339)
+func (s *Server) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, offset := parsePagination(r)
+
+	filter := db.AuditFilter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+		Limit:      limit,
+		Offset:     offset,
+	}
+
+	if actor := q.Get("actor_id"); actor != "" {
+		actorID, err := strconv.ParseInt(actor, 10, 64)
+		if err != nil {
+			writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid actor_id")
+			return
+		}
+		filter.ActorID = &actorID
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := q.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, fmt.Sprintf("Invalid %s, expected RFC3339", name))
+				return
+			}
+			*dst = t
+		}
+	}
+
+	events, err := s.db.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, err, "Error listing audit events")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// listUsersHandler lists all users
+func (s *Server) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := s.db.ListUsers(r.Context())
+	if err != nil {
+		writeError(w, r, err, "Error listing users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// getUserHandler returns a single user
+func (s *Server) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid user ID")
+		return
+	}
+
+	user, err := s.db.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err, "Error getting user", "target_user_id", id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// deleteUserHandler deletes a user and revokes their sessions
+func (s *Server) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid user ID")
+		return
+	}
+
+	if userID, _ := getUserIDFromContext(r); userID == id {
+		writeProblem(w, r, http.StatusBadRequest, "Operators can not delete themselves")
+		return
+	}
+
+	if err := s.authService.RevokeUserSessions(r.Context(), id); err != nil {
+		writeError(w, r, err, "Error revoking sessions", "target_user_id", id)
+		return
+	}
+
+	if err := s.db.DeleteUser(r.Context(), id); err != nil {
+		writeError(w, r, err, "Error deleting user", "target_user_id", id)
+		return
+	}
+
+	operatorID, _ := getUserIDFromContext(r)
+	s.authService.Audit(r.Context(), operatorID, db.AuditUserDelete, "user", strconv.FormatInt(id, 10), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setUserRoleHandler changes the role of a user
+func (s *Server) setUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid user ID")
+		return
+	}
+
+	var req struct {
+		Role db.Role `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Role.Valid() {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if userID, _ := getUserIDFromContext(r); userID == id && req.Role != db.RoleOperator {
+		writeProblem(w, r, http.StatusBadRequest, "Operators can not demote themselves")
+		return
+	}
+
+	if err := s.db.SetUserRole(r.Context(), id, req.Role); err != nil {
+		writeError(w, r, err, "Error setting user role", "target_user_id", id)
+		return
+	}
+
+	operatorID, _ := getUserIDFromContext(r)
+	s.authService.Audit(r.Context(), operatorID, db.AuditUserRoleChange, "user", strconv.FormatInt(id, 10), map[string]db.Role{
+		"role": req.Role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":   id,
+		"role": req.Role,
 	})
 }
 
+// linkAthleteHandler links an athlete to a coach
+func (s *Server) linkAthleteHandler(w http.ResponseWriter, r *http.Request) {
+	coachID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid user ID")
+		return
+	}
+
+	var req struct {
+		AthleteID int64 `json:"athlete_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AthleteID == 0 {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	role, err := s.db.GetUserRole(r.Context(), coachID)
+	if err != nil {
+		writeError(w, r, err, "Error getting user role", "target_user_id", coachID)
+		return
+	}
+	if role != db.RoleCoach {
+		writeProblem(w, r, http.StatusBadRequest, "User is not a coach")
+		return
+	}
+
+	if err := s.db.LinkCoachAthlete(r.Context(), coachID, req.AthleteID); err != nil {
+		writeError(w, r, err, "Error linking athlete")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unlinkAthleteHandler removes the link between a coach and an athlete
+func (s *Server) unlinkAthleteHandler(w http.ResponseWriter, r *http.Request) {
+	coachID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid user ID")
+		return
+	}
+	athleteID, err := parseIDVar(r, "athleteID")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid athlete ID")
+		return
+	}
+
+	if err := s.db.UnlinkCoachAthlete(r.Context(), coachID, athleteID); err != nil {
+		writeError(w, r, err, "Error unlinking athlete")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // Helper functions
 
 // renderTemplate renders a template with the given data
-func (s *Server) renderTemplate(w http.ResponseWriter, name string, data map[string]interface{}) {
+func (s *Server) renderTemplate(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
 	w.Header().Set("Content-Type", "text/html")
 
 	// Add the template name to the data for base template to select correct content
@@ -354,8 +892,120 @@ func (s *Server) renderTemplate(w http.ResponseWriter, name string, data map[str
 	data["CurrentYear"] = time.Now().Year()
 
 	if err := s.templates.ExecuteTemplate(w, name, data); err != nil {
-		http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
+		// Web UI pages get a plain error page rather than problem details
+		slog.ErrorContext(r.Context(), "Error rendering template", "template", name, "error", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// parsePagination reads the limit and offset query parameters
+func parsePagination(r *http.Request) (int, int) {
+	limitStr := r.URL.Query().Get("limit")
+	offsetStr := r.URL.Query().Get("offset")
+
+	limit := 20
+	if limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err == nil && l > 0 {
+			limit = l
+		}
 	}
+
+	offset := 0
+	if offsetStr != "" {
+		o, err := strconv.Atoi(offsetStr)
+		if err == nil && o >= 0 {
+			offset = o
+		}
+	}
+
+	return limit, offset
+}
+
+// parseActivityFilter reads the type, after and before query parameters. It
+// writes the error response and returns false if a parameter is invalid.
+func parseActivityFilter(w http.ResponseWriter, r *http.Request) (db.ActivityFilter, bool) {
+	q := r.URL.Query()
+	filter := db.ActivityFilter{Type: q.Get("type")}
+
+	for name, dst := range map[string]*time.Time{"after": &filter.After, "before": &filter.Before} {
+		if value := q.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, fmt.Sprintf("Invalid %s, expected RFC3339", name))
+				return db.ActivityFilter{}, false
+			}
+			*dst = t
+		}
+	}
+	return filter, true
+}
+
+// authorizeAthlete returns the athlete whose activities the caller reads,
+// named by the athlete_id query parameter. Without it callers read their own
+// activities and operators those of every athlete, returned as 0. It writes
+// the error response and returns false if access is denied.
+func (s *Server) authorizeAthlete(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, ok := getUserIDFromContext(r)
+	if !ok {
+		writeProblem(w, r, http.StatusForbidden, "Forbidden")
+		return 0, false
+	}
+
+	athleteID := userID
+	if value := r.URL.Query().Get("athlete_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid athlete_id")
+			return 0, false
+		}
+		athleteID = id
+	} else if role, err := s.db.GetUserRole(r.Context(), userID); err == nil && role == db.RoleOperator {
+		return 0, true
+	}
+
+	allowed, err := s.mayReadAthlete(r, userID, athleteID)
+	if err != nil {
+		writeError(w, r, err, "Error checking access to athlete", "athlete_id", athleteID)
+		return 0, false
+	}
+	if !allowed {
+		writeProblem(w, r, http.StatusForbidden, "Forbidden")
+		return 0, false
+	}
+	return athleteID, true
+}
+
+// mayReadAthlete reports whether the user may read the athlete's data: their
+// own, every athlete's for operators, and that of linked athletes for
+// coaches. API keys need the coach scope to read linked athletes.
+func (s *Server) mayReadAthlete(r *http.Request, userID, athleteID int64) (bool, error) {
+	if userID == athleteID {
+		return true, nil
+	}
+
+	role, err := s.db.GetUserRole(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	switch role {
+	case db.RoleOperator:
+		return true, nil
+	case db.RoleCoach:
+		if scope, isAPIKey := auth.ScopeFromContext(r.Context()); isAPIKey && scope != db.ScopeCoach {
+			return false, nil
+		}
+		return s.db.IsCoachOf(r.Context(), userID, athleteID)
+	}
+	return false, nil
+}
+
+// parseIDVar parses a numeric route variable
+func parseIDVar(r *http.Request, name string) (int64, error) {
+	return strconv.ParseInt(mux.Vars(r)[name], 10, 64)
 }
 
 // getUserIDFromContext gets the user ID from the request context
@@ -511,6 +1161,32 @@ const templateString = `
     <h2>Welcome, {{.User.firstname}} {{.User.lastname}}!</h2>
     <p>Your Strava account is successfully connected.</p>
 
+    {{if .Invitations}}
+    <h3 style="margin-top: 20px;">Team Invitations</h3>
+    <p>Accepting an invitation lets the team's coach read your activities and statistics.</p>
+    <table>
+        <thead>
+            <tr>
+                <th>Team</th>
+                <th>Invited</th>
+                <th></th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Invitations}}
+            <tr>
+                <td>{{.TeamName}}</td>
+                <td>{{.InvitedAt}}</td>
+                <td>
+                    <button class="btn invitation" data-team="{{.TeamID}}" data-action="accept">Accept</button>
+                    <button class="btn invitation" data-team="{{.TeamID}}" data-action="decline">Decline</button>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{end}}
+
     <h3 style="margin-top: 20px;">Your API Keys</h3>
     {{if .APIKeys}}
     <table>
@@ -553,6 +1229,7 @@ const templateString = `
         <div id="apiKeyResult" style="margin-top: 15px; display: none; padding: 15px; background-color: #f8f8f8; border-radius: 4px;"></div>
     </div>
 
+    {{if eq .Role "operator"}}
     <div style="margin-top: 40px;">
         <h3>Sync Activities</h3>
         <p>Sync your recent activities from Strava:</p>
@@ -565,9 +1242,26 @@ const templateString = `
         </form>
         <div id="syncResult" style="margin-top: 15px; display: none; padding: 15px; background-color: #f8f8f8; border-radius: 4px;"></div>
     </div>
+    {{end}}
 </div>
 
 <script>
+document.querySelectorAll('.invitation').forEach(function(button) {
+    button.addEventListener('click', function() {
+        fetch('/admin/invitations/' + button.dataset.team + '/' + button.dataset.action, {
+            method: 'POST',
+            headers: {
+                'Authorization': 'Bearer {{.Token}}'
+            }
+        })
+        .then(response => {
+            if (response.ok) {
+                button.closest('tr').remove();
+            }
+        });
+    });
+});
+
 document.getElementById('apiKeyForm').addEventListener('submit', function(e) {
     e.preventDefault();
     const description = document.getElementById('description').value;
@@ -597,7 +1291,8 @@ document.getElementById('apiKeyForm').addEventListener('submit', function(e) {
     });
 });
 
-document.getElementById('syncForm').addEventListener('submit', function(e) {
+const syncForm = document.getElementById('syncForm');
+if (syncForm) syncForm.addEventListener('submit', function(e) {
     e.preventDefault();
     const days = parseInt(document.getElementById('days').value);
 
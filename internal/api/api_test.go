@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+	"github.com/gorilla/mux"
+)
+
+// unimplementedStore panics on every method of Store
+type unimplementedStore struct{ Store }
+
+// memoryStore serves activities, users and API keys from a db.MemoryStore.
+// Its other methods panic.
+type memoryStore struct {
+	*db.MemoryStore
+	unimplementedStore
+}
+
+// authStore serves users and API keys to the auth service from a
+// db.MemoryStore. Its token methods panic.
+type authStore struct {
+	*db.MemoryStore
+	unimplementedAuthStore
+}
+
+// unimplementedAuthStore panics on every method of auth.Store
+type unimplementedAuthStore struct{ auth.Store }
+
+// newTestServer returns a server backed by an in-memory store
+func newTestServer(t *testing.T) (*Server, *db.MemoryStore) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	return New(memoryStore{MemoryStore: store}, nil, nil, nil, nil), store
+}
+
+// newAuthTestServer returns a server backed by an in-memory store that
+// authenticates requests with API keys
+func newAuthTestServer(t *testing.T) (*Server, *db.MemoryStore) {
+	t.Helper()
+	store := db.NewMemoryStore()
+	authService, err := auth.New(&config.Config{Auth: config.Auth{JWTSecret: "api-test-secret"}}, authStore{MemoryStore: store})
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	return New(memoryStore{MemoryStore: store}, nil, authService, nil, nil), store
+}
+
+// withUser returns r as authenticated by the user
+func withUser(r *http.Request, userID int64) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "userID", userID))
+}
+
+// createUserKey saves an athlete with the role and an API key of theirs
+func createUserKey(t *testing.T, store *db.MemoryStore, userID int64, role db.Role) string {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.SaveAthlete(ctx, db.Athlete{ID: userID}, "access", "refresh", time.Time{}); err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+	if err := store.SetUserRole(ctx, userID, role); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	key := fmt.Sprintf("key-%d", userID)
+	apiKey, err := store.CreateAPIKey(ctx, key, "test", nil)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	if err := store.AssociateAPIKeyWithUser(ctx, apiKey, userID); err != nil {
+		t.Fatalf("Failed to associate API key: %v", err)
+	}
+	return key
+}
+
+func TestActivityHandlers(t *testing.T) {
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	start := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	for i, activityType := range []string{"Run", "Ride", "Run"} {
+		activity := db.Activity{ID: int64(i + 1), Type: activityType, StartDate: start.AddDate(0, 0, i), AthleteID: 42}
+		if _, err := store.CreateActivity(ctx, activity); err != nil {
+			t.Fatalf("Failed to create activity: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.listActivitiesHandler(rec, withUser(httptest.NewRequest(http.MethodGet, "/api/activities?type=Run&limit=5", nil), 42))
+	var activities []db.Activity
+	if err := json.NewDecoder(rec.Body).Decode(&activities); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected activities, got %d, %v", rec.Code, err)
+	}
+	ids := []int64{}
+	for _, activity := range activities {
+		ids = append(ids, activity.ID)
+	}
+	if !slices.Equal(ids, []int64{3, 1}) {
+		t.Fatalf("Expected the runs newest first, got %v", ids)
+	}
+
+	rec = httptest.NewRecorder()
+	s.listActivitiesHandler(rec, withUser(httptest.NewRequest(http.MethodGet, "/api/activities?after=yesterday", nil), 42))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for an invalid date, got %d", rec.Code)
+	}
+
+	for id, want := range map[string]int{"2": http.StatusOK, "9": http.StatusNotFound, "x": http.StatusBadRequest} {
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/activities/"+id, nil), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		s.getActivityHandler(rec, withUser(r, 42))
+		if rec.Code != want {
+			t.Errorf("GET /api/activities/%s: expected %d, got %d", id, want, rec.Code)
+		}
+	}
+}
+
+func TestActivitiesScopedToCaller(t *testing.T) {
+	s, store := newAuthTestServer(t)
+	ctx := context.Background()
+	keys := map[int64]string{
+		1: createUserKey(t, store, 1, db.RoleAthlete),
+		2: createUserKey(t, store, 2, db.RoleAthlete),
+		3: createUserKey(t, store, 3, db.RoleOperator),
+	}
+	for _, activity := range []db.Activity{{ID: 10, AthleteID: 1}, {ID: 20, AthleteID: 2}} {
+		if _, err := store.CreateActivity(ctx, activity); err != nil {
+			t.Fatalf("Failed to create activity: %v", err)
+		}
+	}
+
+	get := func(userID int64, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Header.Set("X-API-Key", keys[userID])
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		return rec
+	}
+	list := func(userID int64, target string) []int64 {
+		t.Helper()
+		rec := get(userID, target)
+		var activities []db.Activity
+		if err := json.NewDecoder(rec.Body).Decode(&activities); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s as %d: expected activities, got %d, %v", target, userID, rec.Code, err)
+		}
+		ids := []int64{}
+		for _, activity := range activities {
+			ids = append(ids, activity.ID)
+		}
+		return ids
+	}
+
+	if ids := list(1, "/api/v1/activities"); !slices.Equal(ids, []int64{10}) {
+		t.Fatalf("Expected only the caller's activity, got %v", ids)
+	}
+	if ids := list(2, "/api/v1/activities"); !slices.Equal(ids, []int64{20}) {
+		t.Fatalf("Expected only the caller's activity, got %v", ids)
+	}
+	if rec := get(1, "/api/v1/activities?athlete_id=2"); rec.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for the activities of another athlete, got %d", rec.Code)
+	}
+	if rec := get(1, "/api/v1/activities/20"); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected the activity of another athlete not to be found, got %d", rec.Code)
+	}
+	if rec := get(1, "/api/v1/activities/10"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the caller's activity, got %d", rec.Code)
+	}
+
+	if ids := list(3, "/api/v1/activities"); len(ids) != 2 {
+		t.Fatalf("Expected operators to see every activity, got %v", ids)
+	}
+	if ids := list(3, "/api/v1/activities?athlete_id=2"); !slices.Equal(ids, []int64{20}) {
+		t.Fatalf("Expected the activities of athlete 2, got %v", ids)
+	}
+	if rec := get(3, "/api/v1/activities/20"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected operators to read any activity, got %d", rec.Code)
+	}
+}
+
+func TestGetUserHandler(t *testing.T) {
+	s, store := newTestServer(t)
+	athlete := db.Athlete{ID: 7, FirstName: "Jane"}
+	if err := store.SaveAthlete(context.Background(), athlete, "access", "refresh", time.Time{}); err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/admin/users/7", nil), map[string]string{"id": "7"})
+	rec := httptest.NewRecorder()
+	s.getUserHandler(rec, r)
+	var user map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&user); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected the user, got %d, %v", rec.Code, err)
+	}
+	if user["Role"] != string(db.RoleAthlete) {
+		t.Fatalf("Expected an athlete, got %v", user)
+	}
+	if _, leaked := user["AccessToken"]; leaked {
+		t.Fatalf("Expected no tokens in the response, got %v", user)
+	}
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/admin/users/8", nil), map[string]string{"id": "8"})
+	rec = httptest.NewRecorder()
+	s.getUserHandler(rec, r)
+	var p problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil || p.Code != problem.CodeNotFound {
+		t.Fatalf("Expected a not found problem, got %d %+v, %v", rec.Code, p, err)
+	}
+}
+
+func TestListKeysHandler(t *testing.T) {
+	s, store := newTestServer(t)
+	ctx := context.Background()
+	for _, key := range []string{"mine", "theirs"} {
+		apiKey, err := store.CreateAPIKey(ctx, key, key, nil)
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		userID := int64(1)
+		if key == "theirs" {
+			userID = 2
+		}
+		if err := store.AssociateAPIKeyWithUser(ctx, apiKey, userID); err != nil {
+			t.Fatalf("Failed to associate API key: %v", err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.listKeysHandler(rec, withUser(httptest.NewRequest(http.MethodGet, "/admin/keys", nil), 1))
+
+	var keys []db.APIKey
+	if err := json.NewDecoder(rec.Body).Decode(&keys); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected API keys, got %d, %v", rec.Code, err)
+	}
+	if len(keys) != 1 || keys[0].Key != "mine" {
+		t.Fatalf("Expected only the caller's key, got %+v", keys)
+	}
+}
+
+// auditStore is an authStore that keeps the audit events
+type auditStore struct {
+	authStore
+	events *[]db.AuditEvent
+}
+
+func (s auditStore) InsertAuditEvent(ctx context.Context, event db.AuditEvent) (db.AuditEvent, error) {
+	*s.events = append(*s.events, event)
+	return event, nil
+}
+
+func TestRotateKeyHandler(t *testing.T) {
+	store := db.NewMemoryStore()
+	var events []db.AuditEvent
+	authService, err := auth.New(&config.Config{Auth: config.Auth{JWTSecret: "api-test-secret"}}, auditStore{authStore{MemoryStore: store}, &events})
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	s := New(memoryStore{MemoryStore: store}, nil, authService, nil, nil)
+	ctx := context.Background()
+
+	old, err := store.CreateUserAPIKey(ctx, "old", "Laptop", nil, 1, db.ScopeCoach)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	rotate := func(userID int64) *httptest.ResponseRecorder {
+		id := fmt.Sprint(old.ID)
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/admin/keys/"+id+"/rotate", nil), map[string]string{"id": id})
+		rec := httptest.NewRecorder()
+		s.rotateKeyHandler(rec, withUser(r, userID))
+		return rec
+	}
+
+	if rec := rotate(2); rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for another user's key, got %d", rec.Code)
+	}
+
+	rec := rotate(1)
+	var resp map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected the new key, got %d, %v", rec.Code, err)
+	}
+	apiKey, err := store.GetAPIKey(ctx, resp["key"])
+	if err != nil || apiKey == nil || !apiKey.IsActive || apiKey.UserID == nil || *apiKey.UserID != 1 ||
+		apiKey.Scope != db.ScopeCoach || apiKey.Description != "Laptop" || resp["scope"] != db.ScopeCoach {
+		t.Fatalf("Unexpected new key %+v, %v", apiKey, err)
+	}
+	if valid, _ := store.ValidateAPIKey(ctx, "old"); valid {
+		t.Fatal("Expected the old key to be revoked")
+	}
+	if len(events) != 1 || events[0].Action != db.AuditKeyRotate || events[0].TargetID != fmt.Sprint(apiKey.ID) {
+		t.Fatalf("Expected a rotation audit event for the new key, got %+v", events)
+	}
+
+	// A revoked key can't be rotated again
+	if rec := rotate(1); rec.Code == http.StatusOK {
+		t.Fatalf("Expected rotating a revoked key to fail, got %d", rec.Code)
+	}
+}
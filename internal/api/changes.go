@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+)
+
+const (
+	changesPageSize    = 100
+	maxChangesPageSize = 1000
+)
+
+// changePollInterval is how often the change stream checks for new changes;
+// changeKeepAlive is the idle time after which it sends a comment to keep
+// proxies from closing the connection
+var (
+	changePollInterval = time.Second
+	changeKeepAlive    = 15 * time.Second
+)
+
+// changesResponse is a page of the activity change log. Next is the since
+// value of the following request, the sequence number of the last change or
+// the requested since if there were none.
+type changesResponse struct {
+	Changes []db.ActivityChange `json:"changes"`
+	Next    int64               `json:"next"`
+}
+
+// listChangesHandler returns the caller's activity changes after the since
+// sequence number, oldest first
+func (s *Server) listChangesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, ok := s.parseChangeFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.Limit = changesPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxChangesPageSize {
+			writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter,
+				fmt.Sprintf("limit must be between 1 and %d", maxChangesPageSize))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	changes, err := s.db.ListActivityChanges(r.Context(), filter)
+	if err != nil {
+		writeError(w, r, err, "Error listing activity changes")
+		return
+	}
+
+	next := filter.Since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changesResponse{Changes: changes, Next: next})
+}
+
+// streamChangesHandler tails the activity change log as Server-Sent Events.
+// Each event carries the sequence number as its id, so a client that
+// reconnects with Last-Event-ID resumes after the last change it received.
+// Without Last-Event-ID or since the stream starts at the current end of the
+// log. The stream ends when the client disconnects or CloseStreams is called.
+func (s *Server) streamChangesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer context.AfterFunc(s.streams, cancel)()
+	filter, ok := s.parseChangeFilter(w, r)
+	if !ok {
+		return
+	}
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		seq, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || seq < 0 {
+			writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid Last-Event-ID")
+			return
+		}
+		filter.Since = seq
+	} else if r.URL.Query().Get("since") == "" {
+		latest, err := s.db.GetLatestChangeSeq(ctx)
+		if err != nil {
+			writeError(w, r, err, "Error reading activity changes")
+			return
+		}
+		filter.Since = latest
+	}
+	filter.Limit = changesPageSize
+
+	rc := http.NewResponseController(w)
+	// The stream stays open far longer than the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(ctx, "Error lifting write deadline for change stream", "error", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering in nginx
+	w.WriteHeader(http.StatusOK)
+	// Clients reconnect after three seconds
+	io.WriteString(w, "retry: 3000\n\n")
+	rc.Flush()
+
+	idle := time.Duration(0)
+	for {
+		changes, err := s.db.ListActivityChanges(ctx, filter)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.ErrorContext(ctx, "Error reading activity changes for stream", "since", filter.Since, "error", err)
+			}
+			return
+		}
+
+		for _, change := range changes {
+			if err := writeChangeEvent(w, change); err != nil {
+				return
+			}
+			filter.Since = change.Seq
+		}
+		if len(changes) > 0 {
+			idle = 0
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			if len(changes) == filter.Limit {
+				continue // more changes are waiting
+			}
+		} else if idle >= changeKeepAlive {
+			idle = 0
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(changePollInterval):
+			idle += changePollInterval
+		}
+	}
+}
+
+// writeChangeEvent writes a change as a Server-Sent Event named after its
+// operation
+func writeChangeEvent(w io.Writer, change db.ActivityChange) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Op, data)
+	return err
+}
+
+// parseChangeFilter reads the since and athlete_id query parameters and
+// limits the changes to the athletes the caller may read. It writes the error
+// response and returns false if a parameter is invalid or access is denied.
+func (s *Server) parseChangeFilter(w http.ResponseWriter, r *http.Request) (db.ChangeFilter, bool) {
+	var filter db.ChangeFilter
+	if value := r.URL.Query().Get("since"); value != "" {
+		seq, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seq < 0 {
+			writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid since")
+			return filter, false
+		}
+		filter.Since = seq
+	}
+	var ok bool
+	filter.AthleteID, ok = s.authorizeAthlete(w, r)
+	return filter, ok
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+func TestWriteChangeEvent(t *testing.T) {
+	var b strings.Builder
+	change := db.ActivityChange{
+		Seq:        42,
+		ActivityID: 7,
+		AthleteID:  3,
+		Op:         db.ChangeUpdate,
+		Activity:   json.RawMessage(`{"ID":7}`),
+		ChangedAt:  time.Date(2024, 5, 1, 6, 30, 0, 0, time.UTC),
+	}
+	if err := writeChangeEvent(&b, change); err != nil {
+		t.Fatalf("Failed to write event: %v", err)
+	}
+
+	want := "id: 42\nevent: update\n" +
+		`data: {"seq":42,"activity_id":7,"athlete_id":3,"op":"update","activity":{"ID":7},"changed_at":"2024-05-01T06:30:00Z"}` +
+		"\n\n"
+	if b.String() != want {
+		t.Fatalf("Expected\n%q\ngot\n%q", want, b.String())
+	}
+}
+
+func TestParseChangeFilter(t *testing.T) {
+	s, store := newTestServer(t)
+	if err := store.SaveAthlete(context.Background(), db.Athlete{ID: 9}, "access", "refresh", time.Time{}); err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+	if err := store.SetUserRole(context.Background(), 9, db.RoleOperator); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+
+	parse := func(userID int64, query string) (db.ChangeFilter, int) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/changes?"+query, nil)
+		filter, ok := s.parseChangeFilter(w, withUser(r, userID))
+		if ok {
+			return filter, http.StatusOK
+		}
+		return filter, w.Code
+	}
+
+	if filter, code := parse(3, "since=10"); code != http.StatusOK || filter.Since != 10 || filter.AthleteID != 3 {
+		t.Fatalf("Expected the caller's changes, got %d %+v", code, filter)
+	}
+	if _, code := parse(3, "athlete_id=4"); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for the changes of another athlete, got %d", code)
+	}
+	if filter, code := parse(9, ""); code != http.StatusOK || filter.AthleteID != 0 {
+		t.Fatalf("Expected operators to see the changes of every athlete, got %d %+v", code, filter)
+	}
+	if filter, code := parse(9, "athlete_id=4"); code != http.StatusOK || filter.AthleteID != 4 {
+		t.Fatalf("Expected the changes of athlete 4, got %d %+v", code, filter)
+	}
+
+	for _, query := range []string{"since=-1", "since=abc", "athlete_id=x"} {
+		if _, code := parse(3, query); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, code)
+		}
+	}
+}
+
+// emptyChangeStore is a memoryStore with an empty change log
+type emptyChangeStore struct{ memoryStore }
+
+func (emptyChangeStore) ListActivityChanges(ctx context.Context, filter db.ChangeFilter) ([]db.ActivityChange, error) {
+	return nil, ctx.Err()
+}
+
+func (emptyChangeStore) GetLatestChangeSeq(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestStreamClosedOnShutdown(t *testing.T) {
+	s := New(emptyChangeStore{memoryStore{MemoryStore: db.NewMemoryStore()}}, nil, nil, nil, nil)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.streamChangesHandler(w, withUser(r, 1))
+	}))
+	ts.Config.RegisterOnShutdown(s.CloseStreams)
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/changes/stream")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if line, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil || line != "retry: 3000\n" {
+		t.Fatalf("Expected the stream to start, got %q, %v", line, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := ts.Config.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected the open stream not to block shutdown, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*changePollInterval {
+		t.Fatalf("Expected the stream to end right away, took %v", elapsed)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/cluster"
+)
+
+type clusterLeader struct {
+	ID         string    `json:"id"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	RenewedAt  time.Time `json:"renewed_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type clusterMember struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Leader    bool      `json:"leader"`
+}
+
+// clusterHandler shows the current leader and the live instances
+func (s *Server) clusterHandler(w http.ResponseWriter, r *http.Request) {
+	lease, err := s.db.GetLease(r.Context(), cluster.LeaseName)
+	if err != nil {
+		writeError(w, r, err, "Error reading leader lease")
+		return
+	}
+
+	nodes, err := s.db.ListClusterMembers(r.Context(), time.Now().Add(-s.elector.TTL()))
+	if err != nil {
+		writeError(w, r, err, "Error listing cluster members")
+		return
+	}
+
+	var leader *clusterLeader
+	if lease != nil {
+		leader = &clusterLeader{
+			ID:         lease.Holder,
+			AcquiredAt: lease.AcquiredAt,
+			RenewedAt:  lease.RenewedAt,
+			ExpiresAt:  lease.ExpiresAt,
+		}
+	}
+
+	members := make([]clusterMember, 0, len(nodes))
+	for _, n := range nodes {
+		members = append(members, clusterMember{
+			ID:        n.ID,
+			StartedAt: n.StartedAt,
+			LastSeen:  n.LastSeen,
+			Leader:    lease != nil && lease.Holder == n.ID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"self":    s.elector.ID(),
+		"leader":  leader,
+		"members": members,
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+)
+
+// writeProblem writes an error response with the status' default code
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	problem.Write(w, r, status, detail)
+}
+
+// writeProblemCode writes an error response with a specific code
+func writeProblemCode(w http.ResponseWriter, r *http.Request, status int, code problem.Code, detail string) {
+	problem.New(status, detail).WithCode(code).Write(w, r)
+}
+
+// writeError writes the error response for an error returned while handling
+// a request. Missing and conflicting rows are reported to the client as 404
+// and 409; any other error is logged with msg and args and answered with a
+// 500 whose detail is msg, so internal details never reach the client.
+func writeError(w http.ResponseWriter, r *http.Request, err error, msg string, args ...any) {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, db.ErrConflict):
+		writeProblem(w, r, http.StatusConflict, err.Error())
+	default:
+		slog.ErrorContext(r.Context(), msg, append(args, "error", err)...)
+		writeProblem(w, r, http.StatusInternalServerError, msg)
+	}
+}
+
+// notFoundHandler answers requests for unknown routes
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "No such route")
+}
+
+// methodNotAllowedHandler answers requests with a method the route does not
+// support
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported for this route")
+}
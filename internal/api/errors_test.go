@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+	"github.com/gorilla/mux"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   problem.Code
+		detail string
+	}{
+		{"not found", fmt.Errorf("activity 5 %w", db.ErrNotFound), http.StatusNotFound, problem.CodeNotFound, "activity 5 not found"},
+		{"conflict", fmt.Errorf("user %q %w", "jane", db.ErrConflict), http.StatusConflict, problem.CodeConflict, `user "jane" already exists`},
+		{"internal", errors.New("pq: password authentication failed"), http.StatusInternalServerError, problem.CodeInternalError, "Error getting activity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := mux.NewRouter()
+			router.Use(requestIDMiddleware)
+			router.HandleFunc("/test/activities/{id}", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, tt.err, "Error getting activity")
+			})
+
+			req := httptest.NewRequest("GET", "/test/activities/5", nil)
+			req.Header.Set(requestIDHeader, "req-1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != problem.ContentType {
+				t.Fatalf("Expected content type %q, got %q", problem.ContentType, got)
+			}
+			if strings.Contains(rec.Body.String(), "pq:") {
+				t.Fatalf("Internal error leaked to the client: %s", rec.Body.String())
+			}
+
+			var p problem.Problem
+			if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+				t.Fatalf("Error decoding problem: %v", err)
+			}
+			want := problem.Problem{
+				Type:      "about:blank",
+				Title:     http.StatusText(tt.status),
+				Status:    tt.status,
+				Code:      tt.code,
+				Detail:    tt.detail,
+				Instance:  "/test/activities/5",
+				RequestID: "req-1",
+			}
+			if p != want {
+				t.Fatalf("Expected %+v, got %+v", want, p)
+			}
+		})
+	}
+}
+
+func TestUnknownRouteIsProblem(t *testing.T) {
+	router := mux.NewRouter()
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	router.HandleFunc("/test/ping", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	for path, status := range map[string]int{"/test/missing": http.StatusNotFound, "/test/ping": http.StatusMethodNotAllowed} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("POST", path, nil))
+		if rec.Code != status || rec.Header().Get("Content-Type") != problem.ContentType {
+			t.Fatalf("POST %s: expected problem with status %d, got %d %q", path, status, rec.Code, rec.Header().Get("Content-Type"))
+		}
+	}
+}
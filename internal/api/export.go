@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/export"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+)
+
+// exportActivitiesHandler streams the caller's activities matching the
+// filters as a CSV, NDJSON or Parquet file. Rows are read through a database
+// cursor and written as they arrive, so exports of any size use constant
+// memory.
+func (s *Server) exportActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	filter, ok := parseActivityFilter(w, r)
+	if !ok {
+		return
+	}
+	if filter.AthleteID, ok = s.authorizeAthlete(w, r); !ok {
+		return
+	}
+
+	opts, err := parseExportOptions(q.Get("format"), q.Get("columns"), q.Get("units"), q.Get("gzip"))
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, err.Error())
+		return
+	}
+
+	// Pull the first row before sending headers, so that a failing query
+	// still gets a proper error response
+	next, stop := iter.Pull2(s.db.StreamActivities(r.Context(), filter))
+	defer stop()
+	activity, err, more := next()
+	if err != nil {
+		writeError(w, r, err, "Error exporting activities")
+		return
+	}
+
+	// Large exports take longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Error lifting write deadline for export", "error", err)
+	}
+
+	filename := opts.Filename("activities-" + time.Now().UTC().Format("20060102"))
+	contentType := opts.Format.ContentType()
+	if opts.Gzip && opts.Format != export.FormatParquet {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	out, _ := export.NewWriter(w, opts) // options were validated above
+	n := 0
+	for ; more; activity, err, more = next() {
+		if err == nil {
+			err = out.Write(activity)
+		}
+		if err != nil {
+			// Headers are already sent; aborting the connection tells the
+			// client that the file is incomplete
+			slog.ErrorContext(r.Context(), "Error exporting activities", "written", n, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		n++
+	}
+	if err := out.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "Error finishing export", "written", n, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+
+	slog.InfoContext(r.Context(), "Exported activities", "count", n, "format", opts.Format)
+}
+
+// parseExportOptions validates the export query parameters. Empty values
+// select the defaults: CSV, the default columns, meters and no compression.
+func parseExportOptions(format, columns, units, gzip string) (export.Options, error) {
+	opts := export.Options{Format: export.FormatCSV, Units: export.UnitsMeters}
+
+	var err error
+	if format != "" {
+		if opts.Format, err = export.ParseFormat(format); err != nil {
+			return export.Options{}, err
+		}
+	}
+	if opts.Columns, err = export.ParseColumns(columns); err != nil {
+		return export.Options{}, err
+	}
+	if units != "" {
+		if opts.Units, err = export.ParseUnits(units); err != nil {
+			return export.Options{}, err
+		}
+	}
+	if gzip != "" {
+		if opts.Gzip, err = strconv.ParseBool(gzip); err != nil {
+			return export.Options{}, fmt.Errorf("invalid gzip %q, expected true or false", gzip)
+		}
+	}
+	return opts, nil
+}
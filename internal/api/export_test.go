@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/export"
+)
+
+func TestParseExportOptions(t *testing.T) {
+	opts, err := parseExportOptions("", "", "", "")
+	if err != nil {
+		t.Fatalf("Expected defaults, got %v", err)
+	}
+	if opts.Format != export.FormatCSV || opts.Units != export.UnitsMeters || opts.Gzip || opts.Columns != nil {
+		t.Errorf("Unexpected defaults %+v", opts)
+	}
+
+	opts, err = parseExportOptions("parquet", "id,pace", "mi", "true")
+	if err != nil {
+		t.Fatalf("Expected valid options, got %v", err)
+	}
+	if opts.Format != export.FormatParquet || opts.Units != export.UnitsMiles || !opts.Gzip || len(opts.Columns) != 2 {
+		t.Errorf("Unexpected options %+v", opts)
+	}
+
+	for _, params := range [][4]string{
+		{"xlsx", "", "", ""},
+		{"", "id,secret", "", ""},
+		{"", "", "furlong", ""},
+		{"", "", "", "maybe"},
+	} {
+		if _, err := parseExportOptions(params[0], params[1], params[2], params[3]); err == nil {
+			t.Errorf("Expected an error for %v", params)
+		}
+	}
+}
+
+func TestExportScopedToCaller(t *testing.T) {
+	s, store := newAuthTestServer(t)
+	keys := map[int64]string{
+		1: createUserKey(t, store, 1, db.RoleAthlete),
+		3: createUserKey(t, store, 3, db.RoleOperator),
+	}
+	for _, activity := range []db.Activity{{ID: 10, AthleteID: 1}, {ID: 20, AthleteID: 2}} {
+		if _, err := store.CreateActivity(context.Background(), activity); err != nil {
+			t.Fatalf("Failed to create activity: %v", err)
+		}
+	}
+
+	download := func(userID int64, query string) (int, []string) {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/activities/export?format=csv&columns=id"+query, nil)
+		r.Header.Set("X-API-Key", keys[userID])
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		return rec.Code, strings.Fields(rec.Body.String())
+	}
+
+	if code, rows := download(1, ""); code != http.StatusOK || strings.Join(rows, ",") != "id,10" {
+		t.Fatalf("Expected only the caller's activity, got %d %v", code, rows)
+	}
+	if code, _ := download(1, "&athlete_id=2"); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for the activities of another athlete, got %d", code)
+	}
+	if code, rows := download(3, ""); code != http.StatusOK || strings.Join(rows, ",") != "id,10,20" {
+		t.Fatalf("Expected operators to export every activity, got %d %v", code, rows)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/health"
+)
+
+const (
+	// healthCheckTimeout bounds each readiness check
+	healthCheckTimeout = 2 * time.Second
+	// maxSyncAge is how long ago the last sync may have succeeded. Syncs are
+	// scheduled hourly, so this allows for a few failed runs.
+	maxSyncAge = 3 * time.Hour
+	// maxQueueWait is how long a due job may wait for a worker
+	maxQueueWait = 15 * time.Minute
+)
+
+// liveHandler reports that the process is up and serving requests. It checks
+// no dependencies, so a database outage does not get the instance restarted.
+func (s *Server) liveHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": string(health.StatusOK),
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// readyHandler runs the readiness checks and answers 503 if a critical one
+// fails, so the instance is taken out of the load balancer
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	report := health.Run(r.Context(), healthCheckTimeout, s.readinessChecks())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(report.HTTPStatus())
+	json.NewEncoder(w).Encode(report)
+}
+
+// readinessChecks returns the checks run by readyHandler. The database and
+// its schema are critical; the others only mark the instance as degraded.
+func (s *Server) readinessChecks() []health.Check {
+	return []health.Check{
+		{Name: "database", Critical: true, Run: func(ctx context.Context) error {
+			return s.db.PingContext(ctx)
+		}},
+		{Name: "schema", Critical: true, Run: func(ctx context.Context) error {
+			version, err := s.db.GetSchemaVersion(ctx)
+			if err != nil {
+				return err
+			}
+			if version < db.SchemaVersion {
+				return fmt.Errorf("schema version %d is older than the required %d", version, db.SchemaVersion)
+			}
+			return nil
+		}},
+		{Name: "sync", Run: func(ctx context.Context) error {
+			last, ok, err := s.db.LastJobSuccess(ctx, db.JobSync)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("no sync has succeeded yet")
+			}
+			if age := time.Since(last); age > maxSyncAge {
+				return fmt.Errorf("last successful sync was %s ago", age.Round(time.Second))
+			}
+			return nil
+		}},
+		{Name: "strava_token", Run: func(ctx context.Context) error {
+			userIDs, err := s.db.ListAuthorizedUserIDs(ctx)
+			if err != nil {
+				return err
+			}
+			if len(userIDs) == 0 {
+				return fmt.Errorf("no user has authorized Strava")
+			}
+			return nil
+		}},
+		{Name: "job_queue", Run: func(ctx context.Context) error {
+			backlog, err := s.db.GetJobBacklog(ctx)
+			if err != nil {
+				return err
+			}
+			if backlog.OldestAge > maxQueueWait {
+				return fmt.Errorf("%d due job(s), the oldest waiting for %s", backlog.Due, backlog.OldestAge.Round(time.Second))
+			}
+			return nil
+		}},
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/jobs"
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+)
+
+// jobResponse is the JSON representation of a queued job
+type jobResponse struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Status      string          `json:"status"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	Progress    jobProgress     `json:"progress"`
+	LastError   string          `json:"last_error,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+type jobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+func newJobResponse(job db.Job) jobResponse {
+	resp := jobResponse{
+		ID:          job.ID,
+		Type:        job.Type,
+		Status:      job.Status,
+		Payload:     job.Payload,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		Progress:    jobProgress{Done: job.ProgressDone, Total: job.ProgressTotal},
+		LastError:   job.LastError.String,
+		RunAt:       job.RunAt,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}
+	if job.FinishedAt.Valid {
+		resp.FinishedAt = &job.FinishedAt.Time
+	}
+	return resp
+}
+
+// writeJobAccepted answers a request that queued a job with its ID. The job
+// ID is added to the access log line so the job's own log lines can be
+// traced back to the request.
+func (s *Server) writeJobAccepted(w http.ResponseWriter, r *http.Request, job db.Job) {
+	logging.With(r.Context(), slog.Int64("job_id", job.ID))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/admin/jobs/"+strconv.FormatInt(job.ID, 10))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"job_id": job.ID,
+		"status": job.Status,
+	})
+}
+
+// getJobHandler returns the status, progress and last error of a job
+func (s *Server) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid job ID")
+		return
+	}
+
+	job, err := s.db.GetJob(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err, "Error getting job", "job_id", id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJobResponse(job))
+}
+
+// backfillHandler queues a backfill of historical activities
+func (s *Server) backfillHandler(w http.ResponseWriter, r *http.Request) {
+	var req jobs.BackfillPayload
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if req.After.IsZero() {
+		writeProblem(w, r, http.StatusBadRequest, "after is required")
+		return
+	}
+	if !req.Before.IsZero() && !req.Before.After(req.After) {
+		writeProblem(w, r, http.StatusBadRequest, "before must be later than after")
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+
+	job, err := s.queue.EnqueueBackfill(r.Context(), req, userID)
+	if err != nil {
+		writeError(w, r, err, "Error queueing backfill")
+		return
+	}
+
+	s.authService.Audit(r.Context(), userID, db.AuditSyncTrigger, "job", strconv.FormatInt(job.ID, 10), req)
+
+	s.writeJobAccepted(w, r, job)
+}
+
+// streamDownloadHandler queues the download of an activity's streams
+func (s *Server) streamDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	activityID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid activity ID")
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+
+	job, err := s.queue.EnqueueStreamDownload(r.Context(), jobs.StreamDownloadPayload{ActivityID: activityID}, userID)
+	if err != nil {
+		writeError(w, r, err, "Error queueing stream download")
+		return
+	}
+
+	s.writeJobAccepted(w, r, job)
+}
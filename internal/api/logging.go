@@ -0,0 +1,84 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+	"github.com/TobiKin/strava-data-pipeline/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// requestIDHeader carries the request ID from clients and proxies and back
+// in the response
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 128
+
+// requestIDMiddleware assigns each request an ID, taken from the
+// X-Request-ID header if the client sent a usable one, and writes an access
+// log line when the request is done. Log lines written while handling the
+// request carry the request ID, method and route.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("route", routeTemplate(r)),
+		}
+		if traceID := tracing.TraceID(r.Context()); traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", traceID))
+		}
+		ctx := logging.With(r.Context(), attrs...)
+		ctx, requestAttrs := logging.StartRequest(ctx)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs = append(requestAttrs(),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+		)
+		slog.LogAttrs(ctx, level, "HTTP request", attrs...)
+	})
+}
+
+// validRequestID accepts printable ASCII IDs of reasonable length, so client
+// supplied IDs cannot inject anything into log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTemplate returns the path template of the matched mux route, e.g.
+// /api/v1/activities/{id}
+func routeTemplate(r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if tpl, err := current.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddlewareUsesRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(metricsMiddleware)
+	router.HandleFunc("/test/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not found", http.StatusNotFound)
+	}).Methods("GET")
+
+	for _, path := range []string{"/test/items/1", "/test/items/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	counter := httpRequestsTotal.WithLabelValues("/test/items/{id}", "GET", "404")
+	if got := testutil.ToFloat64(counter); got != 2 {
+		t.Fatalf("Expected 2 requests for the route template, got %v", got)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.HandleFunc("/test/ping", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+
+	req := httptest.NewRequest("GET", "/test/ping", nil)
+	req.Header.Set(requestIDHeader, "client-id-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got != "client-id-1" {
+		t.Fatalf("Expected the client's request ID to be echoed, got %q", got)
+	}
+
+	req = httptest.NewRequest("GET", "/test/ping", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got == "" || got == "bad id\n" {
+		t.Fatalf("Expected a generated request ID, got %q", got)
+	}
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// undocumentedRoutes are served by the router but are not part of the API
+var undocumentedRoutes = map[string]bool{
+	"/":          true,
+	"/login":     true,
+	"/dashboard": true,
+}
+
+type openAPIDocument struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("Error parsing openapi.json: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	doc := loadOpenAPISpec(t)
+	if !strings.HasPrefix(doc.OpenAPI, "3.1.") {
+		t.Fatalf("Expected an OpenAPI 3.1 document, got version %q", doc.OpenAPI)
+	}
+
+	s := New(nil, nil, nil, nil, nil)
+	registered := map[string]bool{}
+	var missing []string
+
+	err := s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil || undocumentedRoutes[path] {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Path prefixes of subrouters and static files
+			return nil
+		}
+		for _, method := range methods {
+			key := method + " " + path
+			registered[key] = true
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				missing = append(missing, key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error walking routes: %v", err)
+	}
+
+	var stale []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			if key := strings.ToUpper(method) + " " + path; !registered[key] {
+				stale = append(stale, key)
+			}
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(stale)
+	if len(missing) > 0 {
+		t.Errorf("Routes missing from openapi.json:\n  %s", strings.Join(missing, "\n  "))
+	}
+	if len(stale) > 0 {
+		t.Errorf("Operations in openapi.json without a route:\n  %s", strings.Join(stale, "\n  "))
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	s, _ := newAuthTestServer(t)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected the JSON document, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Fatal("Served document is not valid JSON")
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/api/openapi.json") {
+		t.Fatalf("Expected the docs page to load the document, got %d", rec.Code)
+	}
+}
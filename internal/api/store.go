@@ -0,0 +1,59 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// Store is the part of the database the API server uses. Handlers that only
+// need activities, users and API keys can be tested with db.MemoryStore.
+type Store interface {
+	db.ActivityStore
+	db.UserStore
+	db.APIKeyStore
+
+	// Health
+	PingContext(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (int, error)
+
+	// Activity changes
+	ListActivityChanges(ctx context.Context, filter db.ChangeFilter) ([]db.ActivityChange, error)
+	GetLatestChangeSeq(ctx context.Context) (int64, error)
+
+	// Coaches and teams
+	GetCoachAthletes(ctx context.Context, coachID int64) ([]db.User, error)
+	IsCoachOf(ctx context.Context, coachID, athleteID int64) (bool, error)
+	LinkCoachAthlete(ctx context.Context, coachID, athleteID int64) error
+	UnlinkCoachAthlete(ctx context.Context, coachID, athleteID int64) error
+	CreateTeam(ctx context.Context, name string, coachID int64) (db.Team, error)
+	DeleteTeam(ctx context.Context, id int64) error
+	GetTeam(ctx context.Context, id int64) (db.Team, error)
+	GetTeamActivities(ctx context.Context, teamID int64, limit, offset int) ([]db.Activity, error)
+	GetTeamActivitiesAfter(ctx context.Context, teamID int64, cursor db.ActivityCursor, limit int) ([]db.Activity, error)
+	GetTeamMembers(ctx context.Context, teamID int64) ([]db.TeamMember, error)
+	GetTeamStats(ctx context.Context, teamID int64) (db.TeamStats, error)
+	InviteTeamMember(ctx context.Context, teamID, userID int64) error
+	ListTeamsForUser(ctx context.Context, userID int64) ([]db.Team, error)
+	RemoveTeamMember(ctx context.Context, teamID, userID int64) error
+	RespondToInvitation(ctx context.Context, teamID, userID int64, accept bool) error
+	GetPendingInvitations(ctx context.Context, userID int64) ([]db.TeamInvitation, error)
+
+	// Webhooks
+	CreateWebhook(ctx context.Context, webhook db.Webhook) (db.Webhook, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	GetWebhook(ctx context.Context, id int64) (db.Webhook, error)
+	ListWebhooks(ctx context.Context, userID int64) ([]db.Webhook, error)
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]db.WebhookDelivery, error)
+	RedeliverWebhook(ctx context.Context, webhookID, deliveryID int64) (db.WebhookDelivery, error)
+	UpdateWebhook(ctx context.Context, webhook db.Webhook) (db.Webhook, error)
+
+	// Jobs, cluster and audit log
+	GetJob(ctx context.Context, id int64) (db.Job, error)
+	GetJobBacklog(ctx context.Context) (db.JobBacklog, error)
+	LastJobSuccess(ctx context.Context, jobType string) (time.Time, bool, error)
+	GetLease(ctx context.Context, name string) (*db.LeaderLease, error)
+	ListClusterMembers(ctx context.Context, seenSince time.Time) ([]db.ClusterMember, error)
+	ListAuditEvents(ctx context.Context, filter db.AuditFilter) ([]db.AuditEvent, error)
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+)
+
+// Team management handlers
+
+// listTeamsHandler lists the teams the current user coaches or belongs to
+func (s *Server) listTeamsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	teams, err := s.db.ListTeamsForUser(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "Error listing teams")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
+
+// createTeamHandler creates a team coached by the current user
+func (s *Server) createTeamHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+
+	team, err := s.db.CreateTeam(r.Context(), strings.TrimSpace(req.Name), userID)
+	if err != nil {
+		writeError(w, r, err, "Error creating team")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(team)
+}
+
+// deleteTeamHandler deletes a team coached by the current user
+func (s *Server) deleteTeamHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.authorizeTeam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteTeam(r.Context(), team.ID); err != nil {
+		writeError(w, r, err, "Error deleting team", "team_id", team.ID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inviteTeamMemberHandler invites an athlete to a team
+func (s *Server) inviteTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.authorizeTeam(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		AthleteID int64 `json:"athlete_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AthleteID == 0 {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+
+	if _, err := s.db.GetUserRole(r.Context(), req.AthleteID); err != nil {
+		writeError(w, r, err, "Error getting user role", "athlete_id", req.AthleteID)
+		return
+	}
+
+	if err := s.db.InviteTeamMember(r.Context(), team.ID, req.AthleteID); err != nil {
+		writeError(w, r, err, "Error inviting athlete", "team_id", team.ID, "athlete_id", req.AthleteID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeTeamMemberHandler removes a member from a team. The coach can remove
+// any member and members can leave a team themselves.
+func (s *Server) removeTeamMemberHandler(w http.ResponseWriter, r *http.Request) {
+	teamID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid team ID")
+		return
+	}
+	memberID, err := parseIDVar(r, "userID")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid user ID")
+		return
+	}
+
+	team, err := s.db.GetTeam(r.Context(), teamID)
+	if err != nil {
+		writeError(w, r, err, "Error getting team", "team_id", teamID)
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+	if userID != memberID && userID != team.CoachID {
+		writeProblem(w, r, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if err := s.db.RemoveTeamMember(r.Context(), teamID, memberID); err != nil {
+		writeError(w, r, err, "Error removing team member", "team_id", teamID, "member_id", memberID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listInvitationsHandler lists the pending team invitations of the current user
+func (s *Server) listInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	invitations, err := s.db.GetPendingInvitations(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "Error getting invitations")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// acceptInvitationHandler accepts a team invitation, consenting to share data with the coach
+func (s *Server) acceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	s.respondToInvitation(w, r, true)
+}
+
+// declineInvitationHandler declines a team invitation
+func (s *Server) declineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	s.respondToInvitation(w, r, false)
+}
+
+func (s *Server) respondToInvitation(w http.ResponseWriter, r *http.Request, accept bool) {
+	teamID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid team ID")
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+
+	if err := s.db.RespondToInvitation(r.Context(), teamID, userID, accept); err != nil {
+		writeError(w, r, err, "Error responding to invitation", "team_id", teamID)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Team data handlers
+
+// teamMembersHandler lists the members of a team
+func (s *Server) teamMembersHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.authorizeTeam(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := s.db.GetTeamMembers(r.Context(), team.ID)
+	if err != nil {
+		writeError(w, r, err, "Error getting team members", "team_id", team.ID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// teamActivitiesHandler lists the activities of consenting team members
+func (s *Server) teamActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.authorizeTeam(w, r)
+	if !ok {
+		return
+	}
+
+	limit, offset := parsePagination(r)
+	activities, err := s.db.GetTeamActivities(r.Context(), team.ID, limit, offset)
+	if err != nil {
+		writeError(w, r, err, "Error getting team activities", "team_id", team.ID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(activities)
+}
+
+// teamStatsHandler returns per-member and aggregate totals of a team
+func (s *Server) teamStatsHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.authorizeTeam(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := s.db.GetTeamStats(r.Context(), team.ID)
+	if err != nil {
+		writeError(w, r, err, "Error getting team stats", "team_id", team.ID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// teamExportHandler streams the activities of consenting team members as CSV
+func (s *Server) teamExportHandler(w http.ResponseWriter, r *http.Request) {
+	team, ok := s.authorizeTeam(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"team-%d-activities.csv\"", team.ID))
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"id", "athlete_id", "name", "type", "start_date", "distance",
+		"moving_time", "elapsed_time", "total_elevation_gain",
+		"average_speed", "average_heartrate",
+	})
+
+	const pageSize = 500
+	var cursor db.ActivityCursor
+	for {
+		activities, err := s.db.GetTeamActivitiesAfter(r.Context(), team.ID, cursor, pageSize)
+		if err != nil {
+			// Headers are already sent, so the export is cut short
+			slog.ErrorContext(r.Context(), "Error exporting team activities", "team_id", team.ID, "error", err)
+			break
+		}
+		for _, a := range activities {
+			cw.Write([]string{
+				strconv.FormatInt(a.ID, 10),
+				strconv.FormatInt(a.AthleteID, 10),
+				a.Name,
+				a.Type,
+				a.StartDate.Format(time.RFC3339),
+				strconv.FormatFloat(a.Distance, 'f', -1, 64),
+				strconv.Itoa(a.MovingTime),
+				strconv.Itoa(a.ElapsedTime),
+				strconv.FormatFloat(a.TotalElevationGain, 'f', -1, 64),
+				strconv.FormatFloat(a.AverageSpeed, 'f', -1, 64),
+				strconv.FormatFloat(a.AverageHeartRate, 'f', -1, 64),
+			})
+		}
+		cw.Flush()
+		if len(activities) < pageSize {
+			break
+		}
+		cursor = db.CursorOf(activities[len(activities)-1])
+	}
+}
+
+// authorizeTeam loads the team from the {id} route variable and checks that
+// the caller coaches it and still has the coach role. API keys additionally
+// need the coach scope; operators authenticated with a JWT can access every
+// team. It writes the error response and returns false if access is denied.
+func (s *Server) authorizeTeam(w http.ResponseWriter, r *http.Request) (db.Team, bool) {
+	teamID, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid team ID")
+		return db.Team{}, false
+	}
+
+	team, err := s.db.GetTeam(r.Context(), teamID)
+	if err != nil {
+		writeError(w, r, err, "Error getting team", "team_id", teamID)
+		return db.Team{}, false
+	}
+
+	userID, ok := getUserIDFromContext(r)
+	if !ok {
+		writeProblem(w, r, http.StatusForbidden, "Forbidden")
+		return db.Team{}, false
+	}
+
+	// The role is read on every request, so demoted coaches lose access
+	// right away, also through tokens and keys issued before
+	role, err := s.db.GetUserRole(r.Context(), userID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		writeError(w, r, err, "Error getting user role", "user_id", userID)
+		return db.Team{}, false
+	}
+	isCoach := userID == team.CoachID && (role == db.RoleCoach || role == db.RoleOperator)
+
+	if scope, isAPIKey := auth.ScopeFromContext(r.Context()); isAPIKey {
+		if scope != db.ScopeCoach || !isCoach {
+			writeProblem(w, r, http.StatusForbidden, "Forbidden")
+			return db.Team{}, false
+		}
+		return team, true
+	}
+
+	if isCoach || role == db.RoleOperator {
+		return team, true
+	}
+
+	writeProblem(w, r, http.StatusForbidden, "Forbidden")
+	return db.Team{}, false
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// teamStore serves a single team coached by user 1 without members
+type teamStore struct {
+	memoryStore
+}
+
+func (teamStore) GetTeam(ctx context.Context, id int64) (db.Team, error) {
+	if id != 1 {
+		return db.Team{}, db.ErrNotFound
+	}
+	return db.Team{ID: 1, Name: "Club", CoachID: 1}, nil
+}
+
+func (teamStore) GetTeamMembers(ctx context.Context, teamID int64) ([]db.TeamMember, error) {
+	return []db.TeamMember{}, nil
+}
+
+func TestTeamAccessFollowsRole(t *testing.T) {
+	s, store := newAuthTestServer(t)
+	s.db = teamStore{memoryStore{MemoryStore: store}}
+	ctx := context.Background()
+
+	coachKey := createUserKey(t, store, 1, db.RoleCoach)
+	if err := store.SetAPIKeyScope(ctx, coachKey, db.ScopeCoach); err != nil {
+		t.Fatalf("Failed to set scope: %v", err)
+	}
+	athleteKey := createUserKey(t, store, 2, db.RoleAthlete)
+
+	get := func(key string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/teams/1/members", nil)
+		r.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	if code := get(coachKey); code != http.StatusOK {
+		t.Fatalf("Expected the coach to read the team, got %d", code)
+	}
+	if code := get(athleteKey); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for another user, got %d", code)
+	}
+
+	// A demoted coach keeps the key but loses access
+	if err := store.SetUserRole(ctx, 1, db.RoleAthlete); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	if code := get(coachKey); code != http.StatusForbidden {
+		t.Fatalf("Expected 403 for a demoted coach, got %d", code)
+	}
+}
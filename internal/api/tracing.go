@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+)
+
+// tracingMiddleware starts a span named after the matched route for every
+// request, continuing the trace of the caller if it sent a traceparent
+// header. Scrapes of /metrics and health probes are not traced.
+func tracingMiddleware() func(http.Handler) http.Handler {
+	return otelmux.Middleware("strava-data-pipeline",
+		otelmux.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/metrics" && !strings.HasPrefix(r.URL.Path, "/api/health")
+		}),
+	)
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
+	"github.com/TobiKin/strava-data-pipeline/internal/webhooks"
+)
+
+// webhookRequest is the body of webhook create and update requests. Active
+// defaults to true on create and is left unchanged on update if omitted.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// createdWebhook is a new webhook with its secret, which is not returned again
+type createdWebhook struct {
+	db.Webhook
+	Secret string `json:"secret"`
+}
+
+// listWebhooksHandler returns the webhooks of the current user
+func (s *Server) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := getUserIDFromContext(r)
+
+	hooks, err := s.db.ListWebhooks(r.Context(), userID)
+	if err != nil {
+		writeError(w, r, err, "Error listing webhooks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// createWebhookHandler subscribes a URL to activity events. A secret is
+// generated unless one is given.
+func (s *Server) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if !validateWebhookRequest(w, r, req) {
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+
+	if req.Secret == "" {
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			writeError(w, r, err, "Error creating webhook")
+			return
+		}
+		req.Secret = secret
+	}
+	webhook, err := s.db.CreateWebhook(r.Context(), db.Webhook{
+		UserID: userID,
+		URL:    req.URL,
+		Events: req.Events,
+		Secret: req.Secret,
+		Active: req.Active == nil || *req.Active,
+	})
+	if err != nil {
+		writeError(w, r, err, "Error creating webhook")
+		return
+	}
+
+	s.authService.Audit(r.Context(), userID, db.AuditWebhookCreate, "webhook", strconv.FormatInt(webhook.ID, 10), map[string]interface{}{
+		"url":    webhook.URL,
+		"events": webhook.Events,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdWebhook{Webhook: webhook, Secret: webhook.Secret})
+}
+
+// getWebhookHandler returns a webhook of the current user
+func (s *Server) getWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// updateWebhookHandler changes the URL, events or active flag of a webhook.
+// The secret cannot be changed; create a new webhook to rotate it.
+func (s *Server) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "Invalid request body")
+		return
+	}
+	if !validateWebhookRequest(w, r, req) {
+		return
+	}
+
+	webhook.URL = req.URL
+	webhook.Events = req.Events
+	if req.Active != nil {
+		webhook.Active = *req.Active
+	}
+	updated, err := s.db.UpdateWebhook(r.Context(), webhook)
+	if err != nil {
+		writeError(w, r, err, "Error updating webhook", "webhook_id", webhook.ID)
+		return
+	}
+	webhook = updated
+
+	userID, _ := getUserIDFromContext(r)
+	s.authService.Audit(r.Context(), userID, db.AuditWebhookUpdate, "webhook", strconv.FormatInt(webhook.ID, 10), map[string]interface{}{
+		"url":    webhook.URL,
+		"events": webhook.Events,
+		"active": webhook.Active,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// deleteWebhookHandler deletes a webhook and its delivery log
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteWebhook(r.Context(), webhook.ID); err != nil {
+		writeError(w, r, err, "Error deleting webhook", "webhook_id", webhook.ID)
+		return
+	}
+
+	userID, _ := getUserIDFromContext(r)
+	s.authService.Audit(r.Context(), userID, db.AuditWebhookDelete, "webhook", strconv.FormatInt(webhook.ID, 10), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveriesHandler returns the delivery log of a webhook, newest
+// first
+func (s *Server) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+	limit, offset := parsePagination(r)
+
+	deliveries, err := s.db.ListWebhookDeliveries(r.Context(), webhook.ID, limit, offset)
+	if err != nil {
+		writeError(w, r, err, "Error listing webhook deliveries", "webhook_id", webhook.ID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// redeliverWebhookHandler queues a delivery of a webhook again, whatever
+// the outcome of its earlier attempts
+func (s *Server) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := s.ownedWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := parseIDVar(r, "deliveryID")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := s.db.RedeliverWebhook(r.Context(), webhook.ID, deliveryID)
+	if err != nil {
+		writeError(w, r, err, "Error redelivering webhook", "webhook_id", webhook.ID, "delivery_id", deliveryID)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// ownedWebhook loads the webhook from the {id} route variable and checks
+// that it belongs to the current user. It writes the error response and
+// returns false otherwise.
+func (s *Server) ownedWebhook(w http.ResponseWriter, r *http.Request) (db.Webhook, bool) {
+	userID, _ := getUserIDFromContext(r)
+
+	id, err := parseIDVar(r, "id")
+	if err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid webhook ID")
+		return db.Webhook{}, false
+	}
+
+	webhook, err := s.db.GetWebhook(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err, "Error reading webhook", "webhook_id", id)
+		return db.Webhook{}, false
+	}
+	// Webhooks of other users are reported as missing so their IDs are not revealed
+	if webhook.UserID != userID {
+		writeProblem(w, r, http.StatusNotFound, fmt.Sprintf("Webhook %d not found", id))
+		return db.Webhook{}, false
+	}
+
+	return webhook, true
+}
+
+// validateWebhookRequest checks the URL and events of a webhook. It writes
+// the error response and returns false if one is invalid.
+func validateWebhookRequest(w http.ResponseWriter, r *http.Request, req webhookRequest) bool {
+	if err := webhooks.ValidateURL(req.URL); err != nil {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, err.Error())
+		return false
+	}
+	if len(req.Events) == 0 {
+		writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, "At least one event is required")
+		return false
+	}
+	for _, event := range req.Events {
+		if !slices.Contains(db.ActivityEvents, event) {
+			writeProblemCode(w, r, http.StatusBadRequest, problem.CodeInvalidBody, fmt.Sprintf("Unknown event %q", event))
+			return false
+		}
+	}
+	return true
+}
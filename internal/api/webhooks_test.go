@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+func TestValidateWebhookRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/admin/webhooks", nil)
+	valid := webhookRequest{URL: "https://hooks.example.com/strava", Events: []string{db.EventActivityCreated, db.EventActivityDeleted}}
+	if !validateWebhookRequest(httptest.NewRecorder(), r, valid) {
+		t.Fatal("Expected a valid request")
+	}
+
+	for name, req := range map[string]webhookRequest{
+		"no url":        {Events: []string{db.EventActivityCreated}},
+		"ftp url":       {URL: "ftp://hooks.example.com", Events: []string{db.EventActivityCreated}},
+		"no events":     {URL: "https://hooks.example.com"},
+		"unknown event": {URL: "https://hooks.example.com", Events: []string{"activity.kudos"}},
+	} {
+		w := httptest.NewRecorder()
+		if validateWebhookRequest(w, r, req) || w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", name, w.Code)
+		}
+	}
+}
+
+func TestCreatedWebhookShowsSecretOnce(t *testing.T) {
+	webhook := db.Webhook{ID: 1, URL: "https://hooks.example.com", Secret: "whsec_abc"}
+
+	data, _ := json.Marshal(webhook)
+	if strings.Contains(string(data), "whsec_abc") {
+		t.Fatalf("Expected the secret to be hidden, got %s", data)
+	}
+	data, _ = json.Marshal(createdWebhook{Webhook: webhook, Secret: webhook.Secret})
+	if !strings.Contains(string(data), `"secret":"whsec_abc"`) {
+		t.Fatalf("Expected the secret on creation, got %s", data)
+	}
+}
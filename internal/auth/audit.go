@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+const clientInfoKey contextKey = "client"
+
+// ClientInfo identifies the client that made a request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// ClientInfoMiddleware stores the client's IP address and user agent in the
+// request context so audit events can be attributed to a client. Behind the
+// configured trusted proxies the address is taken from X-Forwarded-For.
+func (s *Service) ClientInfoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := ClientInfo{IP: s.clientIP(r), UserAgent: r.UserAgent()}
+		next.ServeHTTP(w, r.WithContext(WithClientInfo(r.Context(), info)))
+	})
+}
+
+// clientIP returns the address of the client that made the request. Trusted
+// proxies append the address they received the request from to
+// X-Forwarded-For, so the header is read from the right and the first address
+// that is not a trusted proxy is the client. Anything left of it may have been
+// sent by the client and is ignored.
+func (s *Service) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !s.trustedProxy(addr) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = hop
+		if !s.trustedProxy(addr) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
+
+// trustedProxy reports whether addr belongs to a configured trusted proxy
+func (s *Service) trustedProxy(addr netip.Addr) bool {
+	if s == nil {
+		// Servers without an auth service trust no proxies
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithClientInfo returns a context that attributes audit events to the given
+// client. Tools outside the HTTP server use it to identify themselves.
+func WithClientInfo(ctx context.Context, info ClientInfo) context.Context {
+	return context.WithValue(ctx, clientInfoKey, info)
+}
+
+// ClientInfoFromContext returns the client info stored by ClientInfoMiddleware
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	info, _ := ctx.Value(clientInfoKey).(ClientInfo)
+	return info
+}
+
+// Audit appends a security-relevant action to the audit log. An actorID of 0
+// records the action without an actor. Failures are logged and never abort
+// the audited action.
+func (s *Service) Audit(ctx context.Context, actorID int64, action, targetType, targetID string, payload interface{}) {
+	event := db.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+	if actorID != 0 {
+		event.ActorID = &actorID
+	}
+
+	info := ClientInfoFromContext(ctx)
+	event.IP = info.IP
+	event.UserAgent = info.UserAgent
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error encoding audit payload", "action", action, "error", err)
+		} else {
+			event.Payload = data
+		}
+	}
+
+	// Recorded even if the client has gone away in the meantime
+	if _, err := s.db.InsertAuditEvent(context.WithoutCancel(ctx), event); err != nil {
+		slog.ErrorContext(ctx, "Error writing audit event", "action", action, "error", err)
+	}
+}
+
+// RunAuditRetention deletes audit events older than the configured retention
+// period every interval until ctx is cancelled
+func (s *Service) RunAuditRetention(ctx context.Context, interval time.Duration) {
+	if s.config.Audit.RetentionDays <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -s.config.Audit.RetentionDays)
+		n, err := s.db.PurgeAuditEvents(ctx, cutoff)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error purging audit events", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "Purged audit events", "count", n, "retention_days", s.config.Audit.RetentionDays)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+)
+
+func TestClientInfoMiddleware(t *testing.T) {
+	cfg := &config.Config{
+		Auth:   config.Auth{JWTSecret: "secret"},
+		Server: config.Server{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}},
+	}
+	s, err := New(cfg, newTestStore())
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", nil, "203.0.113.7"},
+		{"untrusted peer", "203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", "10.1.2.3:4000", []string{"198.51.100.1, 192.168.1.1"}, "198.51.100.1"},
+		{"spoofed hops", "10.1.2.3:4000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.1.2.3:4000", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:4000", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"garbage", "10.1.2.3:4000", []string{"unknown"}, "10.1.2.3"},
+		{"mapped IPv4 proxy", "[::ffff:10.1.2.3]:4000", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ClientInfo
+			handler := s.ClientInfoMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientInfoFromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got.IP != tt.want {
+				t.Fatalf("Expected client %s, got %s", tt.want, got.IP)
+			}
+		})
+	}
+}
@@ -6,62 +6,114 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
 	"github.com/TobiKin/strava-data-pipeline/internal/config"
 	"github.com/TobiKin/strava-data-pipeline/internal/db"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+	"github.com/TobiKin/strava-data-pipeline/internal/problem"
 )
 
 // Service provides authentication functionality
 type Service struct {
-	config *config.Config
-	db     *db.DB
+	config         *config.Config
+	db             Store
+	keys           *keyring
+	trustedProxies []netip.Prefix
 }
 
+// Store is the part of the database the authentication service uses
+type Store interface {
+	db.UserStore
+	db.APIKeyStore
+
+	CreateRefreshToken(ctx context.Context, userID int64, tokenHash, familyID string, expiresAt time.Time) (db.RefreshToken, error)
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (db.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, oldID int64, newHash string, expiresAt time.Time) (db.RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeRefreshTokensForUser(ctx context.Context, userID int64) error
+	RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	PurgeExpiredTokens(ctx context.Context) (int64, error)
+
+	InsertAuditEvent(ctx context.Context, event db.AuditEvent) (db.AuditEvent, error)
+	PurgeAuditEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// contextKey is the type for values stored in the request context
+type contextKey string
+
+const (
+	claimsKey contextKey = "claims"
+	roleKey   contextKey = "role"
+	scopeKey  contextKey = "scope"
+)
+
 // New creates a new authentication service
-func New(config *config.Config, database *db.DB) *Service {
-	return &Service{
-		config: config,
-		db:     database,
+func New(config *config.Config, database Store) (*Service, error) {
+	keys, err := newKeyring(config.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("error loading JWT signing keys: %w", err)
+	}
+	trustedProxies, err := config.Server.TrustedProxyPrefixes()
+	if err != nil {
+		return nil, err
 	}
-}
 
-// Claims represents the JWT claims
-type Claims struct {
-	UserID int64 `json:"user_id"`
-	jwt.StandardClaims
+	return &Service{
+		config:         config,
+		db:             database,
+		keys:           keys,
+		trustedProxies: trustedProxies,
+	}, nil
 }
 
-// GenerateAPIKey generates a new API key
-func (s *Service) GenerateAPIKey(description string, expiryDays int) (string, error) {
-	// Generate a random key
+// GenerateAPIKey generates a new API key owned by the user with the given scope
+func (s *Service) GenerateAPIKey(ctx context.Context, userID int64, scope, description string, expiryDays int) (db.APIKey, error) {
 	key, err := generateRandomString(32)
 	if err != nil {
-		return "", fmt.Errorf("error generating API key: %w", err)
+		return db.APIKey{}, fmt.Errorf("error generating API key: %w", err)
+	}
+
+	apiKey, err := s.db.CreateUserAPIKey(ctx, key, description, keyExpiry(expiryDays), userID, scope)
+	if err != nil {
+		return db.APIKey{}, fmt.Errorf("error saving API key: %w", err)
 	}
+	return apiKey, nil
+}
 
-	// Set expiry date if specified
-	var expiresAt *string
-	if expiryDays > 0 {
-		exp := time.Now().AddDate(0, 0, expiryDays).Format(time.RFC3339)
-		expiresAt = &exp
+// RotateAPIKey replaces an active API key with a new one of the same owner,
+// scope and description. The old key stops working in the same transaction.
+func (s *Service) RotateAPIKey(ctx context.Context, oldID int64, expiryDays int) (db.APIKey, error) {
+	key, err := generateRandomString(32)
+	if err != nil {
+		return db.APIKey{}, fmt.Errorf("error generating API key: %w", err)
 	}
 
-	// Save API key to database
-	err = s.db.CreateAPIKey(key, description, expiresAt)
+	apiKey, err := s.db.ReplaceAPIKey(ctx, oldID, key, keyExpiry(expiryDays))
 	if err != nil {
-		return "", fmt.Errorf("error saving API key: %w", err)
+		return db.APIKey{}, fmt.Errorf("error rotating API key: %w", err)
 	}
+	return apiKey, nil
+}
 
-	return key, nil
+// keyExpiry returns the expiry date of a key valid for expiryDays, or nil if
+// it does not expire
+func keyExpiry(expiryDays int) *string {
+	if expiryDays <= 0 {
+		return nil
+	}
+	exp := time.Now().AddDate(0, 0, expiryDays).Format(time.RFC3339)
+	return &exp
 }
 
 // ValidateAPIKey validates an API key
-func (s *Service) ValidateAPIKey(key string) (bool, error) {
-	return s.db.ValidateAPIKey(key)
+func (s *Service) ValidateAPIKey(ctx context.Context, key string) (bool, error) {
+	return s.db.ValidateAPIKey(ctx, key)
 }
 
 // generateRandomString generates a random string of the given length
@@ -85,101 +137,159 @@ func (s *Service) AuthMiddleware(next http.Handler) http.Handler {
 		}
 
 		if apiKey == "" {
-			http.Error(w, "API key required", http.StatusUnauthorized)
-			return
-		}
-
-		// Validate API key
-		valid, err := s.ValidateAPIKey(apiKey)
-		if err != nil {
-			http.Error(w, "Error validating API key", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusUnauthorized, "API key required")
 			return
 		}
 
-		if !valid {
-			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		ctx, p := s.authenticateAPIKey(r.Context(), apiKey)
+		if p != nil {
+			p.Write(w, r)
 			return
 		}
 
 		// API key is valid, call next handler
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// GenerateJWT generates a JWT token for the given user ID
-func (s *Service) GenerateJWT(userID int64) (string, error) {
-	expirationTime := time.Now().Add(time.Duration(s.config.Auth.TokenDuration) * time.Minute)
-
-	claims := &Claims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
+// JWTMiddleware is a middleware function that validates JWT tokens
+func (s *Service) JWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Get JWT token from header
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			problem.Write(w, r, http.StatusUnauthorized, "Authorization header required")
+			return
+		}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.config.Auth.JWTSecret))
-	if err != nil {
-		return "", fmt.Errorf("error generating JWT token: %w", err)
-	}
+		ctx, p := s.authenticateJWT(r.Context(), authHeader)
+		if p != nil {
+			p.Write(w, r)
+			return
+		}
 
-	return tokenString, nil
+		// Token is valid, call next handler
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
-// ValidateJWT validates a JWT token
-func (s *Service) ValidateJWT(tokenString string) (*Claims, error) {
-	claims := &Claims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// APIKeyOrJWTMiddleware accepts either a bearer JWT or an API key. JWTs take
+// precedence when an Authorization header is present.
+func (s *Service) APIKeyOrJWTMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+			s.JWTMiddleware(next).ServeHTTP(w, r)
+			return
 		}
-		return []byte(s.config.Auth.JWTSecret), nil
+		s.AuthMiddleware(next).ServeHTTP(w, r)
 	})
+}
 
+// authenticateAPIKey validates an API key and returns a context carrying the
+// key's owner and scope. The problem is nil on success.
+func (s *Service) authenticateAPIKey(ctx context.Context, key string) (context.Context, *problem.Problem) {
+	apiKey, err := s.db.GetAPIKey(ctx, key)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing token: %w", err)
+		slog.ErrorContext(ctx, "Error validating API key", "error", err)
+		p := problem.New(http.StatusInternalServerError, "Error validating API key")
+		return ctx, &p
 	}
 
-	if !token.Valid {
-		return nil, errors.New("invalid token")
+	if apiKey == nil || !apiKey.IsActive || (!apiKey.ExpiresAt.IsZero() && apiKey.ExpiresAt.Before(time.Now())) {
+		p := problem.New(http.StatusUnauthorized, "Invalid API key").WithCode(problem.CodeInvalidAPIKey)
+		return ctx, &p
 	}
 
-	return claims, nil
+	if apiKey.UserID != nil {
+		ctx = context.WithValue(ctx, "userID", *apiKey.UserID)
+		ctx = logging.With(ctx, slog.Int64("user_id", *apiKey.UserID))
+	}
+	scope := apiKey.Scope
+	if scope == "" {
+		scope = db.ScopeUser
+	}
+	ctx = context.WithValue(ctx, scopeKey, scope)
+	ctx = logging.With(ctx, slog.Int64("api_key_id", apiKey.ID))
+	return ctx, nil
 }
 
-// JWTMiddleware is a middleware function that validates JWT tokens
-func (s *Service) JWTMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get JWT token from header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
+// authenticateJWT validates a bearer Authorization header and returns a
+// context carrying the user ID and claims. The problem is nil on success.
+func (s *Service) authenticateJWT(ctx context.Context, authHeader string) (context.Context, *problem.Problem) {
+	// Check if the auth header is in the correct format
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		p := problem.New(http.StatusUnauthorized, "Invalid authorization header format")
+		return ctx, &p
+	}
 
-		// Check if the auth header is in the correct format
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
-			return
-		}
+	// Validate JWT token. The reason is only logged, clients learn no more
+	// than that the token was not accepted.
+	claims, err := s.ValidateJWT(ctx, parts[1])
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected access token", "error", err)
+		p := problem.New(http.StatusUnauthorized, "Invalid or expired token").WithCode(problem.CodeInvalidToken)
+		return ctx, &p
+	}
 
-		tokenString := parts[1]
+	// Add user ID and claims to request context
+	ctx = context.WithValue(ctx, "userID", claims.UserID)
+	ctx = context.WithValue(ctx, claimsKey, claims)
+	ctx = logging.With(ctx, slog.Int64("user_id", claims.UserID))
+	return ctx, nil
+}
 
-		// Validate JWT token
-		claims, err := s.ValidateJWT(tokenString)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
-			return
-		}
+// ScopeFromContext returns the scope of the API key used for the request.
+// Requests authenticated with a JWT have no scope restriction and return false.
+func ScopeFromContext(ctx context.Context) (string, bool) {
+	scope, ok := ctx.Value(scopeKey).(string)
+	return scope, ok
+}
 
-		// Add user ID to request context
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "userID", claims.UserID)
-		r = r.WithContext(ctx)
+// ClaimsFromContext returns the JWT claims stored by JWTMiddleware
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
 
-		// Token is valid, call next handler
-		next.ServeHTTP(w, r)
-	})
+// RequireRole returns a middleware that only lets users with one of the given
+// roles through. It must run after JWTMiddleware. The role is read from the
+// database on every request so role changes take effect immediately.
+func (s *Service) RequireRole(roles ...db.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				problem.Write(w, r, http.StatusUnauthorized, "Authorization required")
+				return
+			}
+
+			role, err := s.db.GetUserRole(r.Context(), claims.UserID)
+			if errors.Is(err, db.ErrNotFound) {
+				problem.Write(w, r, http.StatusForbidden, "Forbidden")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error getting user role", "error", err)
+				problem.Write(w, r, http.StatusInternalServerError, "Error checking role")
+				return
+			}
+
+			for _, allowed := range roles {
+				if role == allowed {
+					ctx := context.WithValue(r.Context(), roleKey, role)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+
+			problem.Write(w, r, http.StatusForbidden, "Forbidden")
+		})
+	}
+}
+
+// RoleFromContext returns the role stored by RequireRole
+func RoleFromContext(ctx context.Context) (db.Role, bool) {
+	role, ok := ctx.Value(roleKey).(db.Role)
+	return role, ok
 }
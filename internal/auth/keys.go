@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultKeyID is the key ID used for the legacy JWTSecret. Tokens without a
+// "kid" header are verified against this key.
+const defaultKeyID = "default"
+
+// signingKey is a single key of the keyring. Verify-only keys have no
+// signing material and are kept so tokens issued before a rotation stay valid.
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// keyring holds all keys accepted for verification and the key used for signing
+type keyring struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// newKeyring builds the keyring from the auth configuration
+func newKeyring(cfg config.Auth) (*keyring, error) {
+	kr := &keyring{keys: make(map[string]*signingKey)}
+
+	for _, kc := range cfg.SigningKeys {
+		if kc.ID == "" {
+			return nil, errors.New("signing key without id")
+		}
+		if _, exists := kr.keys[kc.ID]; exists {
+			return nil, fmt.Errorf("duplicate signing key id %q", kc.ID)
+		}
+		key, err := loadSigningKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("error loading signing key %q: %w", kc.ID, err)
+		}
+		kr.keys[kc.ID] = key
+	}
+
+	// The legacy secret stays usable so existing deployments keep working
+	if _, exists := kr.keys[defaultKeyID]; !exists && cfg.JWTSecret != "" {
+		kr.keys[defaultKeyID] = &signingKey{
+			id:        defaultKeyID,
+			method:    jwt.SigningMethodHS256,
+			signKey:   []byte(cfg.JWTSecret),
+			verifyKey: []byte(cfg.JWTSecret),
+		}
+	}
+
+	activeID := cfg.ActiveKeyID
+	if activeID == "" {
+		if len(cfg.SigningKeys) > 0 {
+			activeID = cfg.SigningKeys[0].ID
+		} else {
+			activeID = defaultKeyID
+		}
+	}
+
+	active, ok := kr.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q is not configured", activeID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeID)
+	}
+	kr.active = active
+
+	return kr, nil
+}
+
+// loadSigningKey parses the key material for a configured signing key
+func loadSigningKey(kc config.SigningKey) (*signingKey, error) {
+	key := &signingKey{id: kc.ID}
+
+	switch strings.ToUpper(kc.Algorithm) {
+	case "", "HS256":
+		if kc.Secret == "" {
+			return nil, errors.New("HS256 key requires a secret")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(kc.Secret)
+		key.verifyKey = []byte(kc.Secret)
+
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			public, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = public
+		} else {
+			return nil, errors.New("RS256 key requires private_key_file or public_key_file")
+		}
+
+	case "EDDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = private
+			key.verifyKey = private.(ed25519.PrivateKey).Public()
+		} else if kc.PublicKeyFile != "" {
+			pem, err := os.ReadFile(kc.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			public, err := jwt.ParseEdPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.verifyKey = public
+		} else {
+			return nil, errors.New("EdDSA key requires private_key_file or public_key_file")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+
+	return key, nil
+}
+
+// sign signs the claims with the active key and sets the "kid" header
+func (kr *keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.active.method, claims)
+	token.Header["kid"] = kr.active.id
+	return token.SignedString(kr.active.signKey)
+}
+
+// keyFunc resolves the verification key for a parsed token by its "kid"
+func (kr *keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = defaultKeyID
+	}
+
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// methods returns the algorithms accepted during verification
+func (kr *keyring) methods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, key := range kr.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// jwks returns the public keys of all asymmetric keys. Symmetric keys are
+// never published.
+func (kr *keyring) jwks() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range kr.keys {
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeEd25519Key(t *testing.T) string {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ed25519.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+func setupTestService(t *testing.T, authConfig config.Auth) *Service {
+	if authConfig.TokenDuration == 0 {
+		authConfig.TokenDuration = 15
+	}
+	service, err := New(&config.Config{Auth: authConfig}, newTestStore())
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+	return service
+}
+
+func TestLegacySecret(t *testing.T) {
+	s := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "test", Audience: "api"})
+
+	token, err := s.GenerateJWT(42)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+
+	claims, err := s.ValidateJWT(context.Background(), token)
+	if err != nil {
+		t.Fatalf("Failed to parse JWT: %v", err)
+	}
+	if claims.UserID != 42 || claims.Subject != "42" {
+		t.Fatalf("Expected user 42, got %d (sub %s)", claims.UserID, claims.Subject)
+	}
+	if claims.ID == "" {
+		t.Fatal("Expected token to carry a jti")
+	}
+}
+
+func TestNoSigningKey(t *testing.T) {
+	if _, err := New(&config.Config{}, nil); err == nil {
+		t.Fatal("Expected error without any signing key")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	before := setupTestService(t, config.Auth{
+		JWTSecret: "old-secret",
+		Issuer:    "test",
+		Audience:  "api",
+	})
+	oldToken, err := before.GenerateJWT(1)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+
+	after := setupTestService(t, config.Auth{
+		JWTSecret:   "old-secret",
+		Issuer:      "test",
+		Audience:    "api",
+		ActiveKeyID: "2025",
+		SigningKeys: []config.SigningKey{
+			{ID: "2025", Algorithm: "EdDSA", PrivateKeyFile: writeEd25519Key(t)},
+		},
+	})
+
+	if _, err := after.ValidateJWT(context.Background(), oldToken); err != nil {
+		t.Fatalf("Expected token signed with previous key to stay valid: %v", err)
+	}
+
+	newToken, err := after.GenerateJWT(1)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	if err != nil {
+		t.Fatalf("Failed to decode JWT: %v", err)
+	}
+	if parsed.Header["kid"] != "2025" || parsed.Header["alg"] != "EdDSA" {
+		t.Fatalf("Expected EdDSA token with kid 2025, got %v", parsed.Header)
+	}
+	if _, err := after.ValidateJWT(context.Background(), newToken); err != nil {
+		t.Fatalf("Failed to validate rotated token: %v", err)
+	}
+	if _, err := before.ValidateJWT(context.Background(), newToken); err == nil {
+		t.Fatal("Expected service without the new key to reject the token")
+	}
+}
+
+func TestRejectsWrongIssuerAndAudience(t *testing.T) {
+	issuer := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "other", Audience: "api"})
+	token, err := issuer.GenerateJWT(1)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+
+	verifier := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "test", Audience: "api"})
+	if _, err := verifier.ValidateJWT(context.Background(), token); err == nil {
+		t.Fatal("Expected token with wrong issuer to be rejected")
+	}
+
+	audience := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "test", Audience: "web"})
+	token, err = audience.GenerateJWT(1)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	if _, err := verifier.ValidateJWT(context.Background(), token); err == nil {
+		t.Fatal("Expected token with wrong audience to be rejected")
+	}
+}
+
+func TestRejectsUnknownKeyID(t *testing.T) {
+	s := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "test", Audience: "api"})
+
+	claims := &Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    "test",
+		Audience:  jwt.ClaimStrings{"api"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "missing"
+	signed, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to sign JWT: %v", err)
+	}
+
+	if _, err := s.ValidateJWT(context.Background(), signed); err == nil {
+		t.Fatal("Expected token with unknown kid to be rejected")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	s := setupTestService(t, config.Auth{
+		JWTSecret: "secret",
+		SigningKeys: []config.SigningKey{
+			{ID: "ed", Algorithm: "EdDSA", PrivateKeyFile: writeEd25519Key(t)},
+		},
+	})
+
+	set := s.JWKS()
+	if len(set.Keys) != 1 {
+		t.Fatalf("Expected only the asymmetric key to be published, got %d keys", len(set.Keys))
+	}
+	if key := set.Keys[0]; key.Kid != "ed" || key.Kty != "OKP" || key.Crv != "Ed25519" || key.X == "" {
+		t.Fatalf("Unexpected JWK: %+v", key)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	if hashToken("a") == hashToken("b") {
+		t.Fatal("Expected different hashes for different tokens")
+	}
+	if hashToken("a") != hashToken("a") {
+		t.Fatal("Expected stable hash")
+	}
+}
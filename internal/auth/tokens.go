@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown or expired refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a rotated refresh token is
+	// presented again. The whole token family is revoked in that case.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+	// ErrTokenRevoked is returned for access tokens on the denylist
+	ErrTokenRevoked = errors.New("token has been revoked")
+)
+
+// Claims represents the JWT claims
+type Claims struct {
+	UserID int64 `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is a short-lived access token together with its refresh token
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// GenerateJWT generates a JWT token for the given user ID
+func (s *Service) GenerateJWT(userID int64) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(s.accessTokenDuration())
+
+	claims := &Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   strconv.FormatInt(userID, 10),
+			Issuer:    s.config.Auth.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+	}
+	if s.config.Auth.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.config.Auth.Audience}
+	}
+
+	tokenString, err := s.keys.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("error generating JWT token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+// ValidateJWT validates a JWT token
+func (s *Service) ValidateJWT(ctx context.Context, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(s.keys.methods()),
+		jwt.WithExpirationRequired(),
+	}
+	if s.config.Auth.Issuer != "" {
+		options = append(options, jwt.WithIssuer(s.config.Auth.Issuer))
+	}
+	if s.config.Auth.Audience != "" {
+		options = append(options, jwt.WithAudience(s.config.Auth.Audience))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, s.keys.keyFunc, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.ID != "" {
+		revoked, err := s.db.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error checking token revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// IssueTokens starts a new session for the user and returns an access token
+// and a refresh token that starts a new rotation family
+func (s *Service) IssueTokens(ctx context.Context, userID int64) (TokenPair, error) {
+	familyID := uuid.New().String()
+	tokens, err := s.issueTokens(userID, func(hash string, expiresAt time.Time) error {
+		_, err := s.db.CreateRefreshToken(ctx, userID, hash, familyID, expiresAt)
+		return err
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	s.Audit(ctx, userID, db.AuditJWTIssue, "user", strconv.FormatInt(userID, 10), map[string]string{
+		"family_id": familyID,
+	})
+	return tokens, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. The presented
+// refresh token is rotated and can not be used again; presenting it a second
+// time revokes every token of its family.
+func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (TokenPair, error) {
+	stored, err := s.db.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	target := strconv.FormatInt(stored.UserID, 10)
+	family := map[string]string{"family_id": stored.FamilyID}
+
+	if stored.Revoked() {
+		return TokenPair{}, s.refreshTokenReused(ctx, stored)
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return TokenPair{}, ErrInvalidRefreshToken
+	}
+
+	tokens, err := s.issueTokens(stored.UserID, func(hash string, expiresAt time.Time) error {
+		_, err := s.db.RotateRefreshToken(ctx, stored.ID, hash, expiresAt)
+		return err
+	})
+	if errors.Is(err, db.ErrRevoked) {
+		// A concurrent request presented the same token and rotated it first
+		return TokenPair{}, s.refreshTokenReused(ctx, stored)
+	}
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	s.Audit(ctx, stored.UserID, db.AuditJWTRefresh, "user", target, family)
+	return tokens, nil
+}
+
+// refreshTokenReused revokes the family of a refresh token that was presented
+// after it had been rotated and returns ErrRefreshTokenReused
+func (s *Service) refreshTokenReused(ctx context.Context, stored db.RefreshToken) error {
+	if err := s.db.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		return err
+	}
+	slog.WarnContext(ctx, "Refresh token reuse detected, revoked token family",
+		"user_id", stored.UserID, "family_id", stored.FamilyID)
+	s.Audit(ctx, stored.UserID, db.AuditJWTRefreshReuse, "user", strconv.FormatInt(stored.UserID, 10),
+		map[string]string{"family_id": stored.FamilyID})
+	return ErrRefreshTokenReused
+}
+
+// issueTokens creates an access token and a refresh token, persisting the
+// refresh token hash through store
+func (s *Service) issueTokens(userID int64, store func(hash string, expiresAt time.Time) error) (TokenPair, error) {
+	accessToken, err := s.GenerateJWT(userID)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := generateRandomString(32)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error generating refresh token: %w", err)
+	}
+
+	expiresAt := time.Now().Add(s.refreshTokenDuration())
+	if err := store(hashToken(refreshToken), expiresAt); err != nil {
+		return TokenPair{}, fmt.Errorf("error saving refresh token: %w", err)
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.accessTokenDuration().Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// RevokeRefreshToken revokes a refresh token and every token rotated from the
+// same login. Unknown tokens are ignored.
+func (s *Service) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	stored, err := s.db.GetRefreshTokenByHash(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil
+	}
+	if err := s.db.RevokeRefreshTokenFamily(ctx, stored.FamilyID); err != nil {
+		return err
+	}
+
+	s.Audit(ctx, stored.UserID, db.AuditJWTRevoke, "user", strconv.FormatInt(stored.UserID, 10), map[string]string{
+		"family_id": stored.FamilyID,
+	})
+	return nil
+}
+
+// RevokeJWT puts an access token on the denylist until it expires
+func (s *Service) RevokeJWT(ctx context.Context, claims *Claims) error {
+	if claims.ID == "" {
+		return errors.New("token has no id")
+	}
+	expiresAt := time.Now().Add(s.accessTokenDuration())
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return s.db.RevokeToken(ctx, claims.ID, claims.UserID, expiresAt)
+}
+
+// Logout revokes the given access token and all refresh tokens of its user
+func (s *Service) Logout(ctx context.Context, claims *Claims) error {
+	if err := s.RevokeJWT(ctx, claims); err != nil {
+		return err
+	}
+	if err := s.db.RevokeRefreshTokensForUser(ctx, claims.UserID); err != nil {
+		return err
+	}
+
+	s.Audit(ctx, claims.UserID, db.AuditLogout, "user", strconv.FormatInt(claims.UserID, 10), map[string]string{
+		"jti": claims.ID,
+	})
+	return nil
+}
+
+// RevokeUserSessions revokes all refresh tokens of a user. Access tokens
+// already issued stay valid until they expire.
+func (s *Service) RevokeUserSessions(ctx context.Context, userID int64) error {
+	return s.db.RevokeRefreshTokensForUser(ctx, userID)
+}
+
+// JWKS returns the public signing keys in JSON Web Key Set format
+func (s *Service) JWKS() JWKS {
+	return s.keys.jwks()
+}
+
+// RunTokenCleanup removes expired refresh tokens and denylist entries every
+// interval until ctx is cancelled
+func (s *Service) RunTokenCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := s.db.PurgeExpiredTokens(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error purging expired tokens", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "Purged expired tokens", "count", n)
+		}
+	}
+}
+
+func (s *Service) accessTokenDuration() time.Duration {
+	return time.Duration(s.config.Auth.TokenDuration) * time.Minute
+}
+
+func (s *Service) refreshTokenDuration() time.Duration {
+	return time.Duration(s.config.Auth.RefreshTokenDuration) * time.Hour
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
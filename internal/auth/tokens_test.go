@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testStore keeps refresh tokens, the access token denylist and the audit
+// log in memory. Store methods the tests do not use panic through the nil
+// embedded Store.
+type testStore struct {
+	Store
+
+	mu      sync.Mutex
+	refresh []db.RefreshToken
+	revoked map[string]bool
+	audit   []db.AuditEvent
+}
+
+func newTestStore() *testStore {
+	return &testStore{revoked: make(map[string]bool)}
+}
+
+func (m *testStore) CreateRefreshToken(ctx context.Context, userID int64, tokenHash, familyID string, expiresAt time.Time) (db.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token := db.RefreshToken{
+		ID:        int64(len(m.refresh) + 1),
+		UserID:    userID,
+		TokenHash: tokenHash,
+		FamilyID:  familyID,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	m.refresh = append(m.refresh, token)
+	return token, nil
+}
+
+func (m *testStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (db.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, token := range m.refresh {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return db.RefreshToken{}, db.ErrNotFound
+}
+
+func (m *testStore) RotateRefreshToken(ctx context.Context, oldID int64, newHash string, expiresAt time.Time) (db.RefreshToken, error) {
+	m.mu.Lock()
+	old := &m.refresh[oldID-1]
+	if old.Revoked() {
+		m.mu.Unlock()
+		return db.RefreshToken{}, fmt.Errorf("refresh token %d %w", oldID, db.ErrRevoked)
+	}
+	old.RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	userID, familyID := old.UserID, old.FamilyID
+	m.mu.Unlock()
+	return m.CreateRefreshToken(ctx, userID, newHash, familyID, expiresAt)
+}
+
+func (m *testStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.refresh {
+		if m.refresh[i].FamilyID == familyID && !m.refresh[i].Revoked() {
+			m.refresh[i].RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+func (m *testStore) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revoked[jti] = true
+	return nil
+}
+
+func (m *testStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.revoked[jti], nil
+}
+
+func (m *testStore) InsertAuditEvent(ctx context.Context, event db.AuditEvent) (db.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = append(m.audit, event)
+	return event, nil
+}
+
+func TestValidateJWTRequiresExpiry(t *testing.T) {
+	s := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "test", Audience: "api"})
+
+	claims := &Claims{UserID: 1, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:   "test",
+		Audience: jwt.ClaimStrings{"api"},
+	}}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to sign JWT: %v", err)
+	}
+
+	if _, err := s.ValidateJWT(context.Background(), signed); !errors.Is(err, jwt.ErrTokenRequiredClaimMissing) {
+		t.Fatalf("Expected token without exp to be rejected, got %v", err)
+	}
+}
+
+func TestValidateJWTRevoked(t *testing.T) {
+	ctx := context.Background()
+	s := setupTestService(t, config.Auth{JWTSecret: "secret", Issuer: "test", Audience: "api"})
+
+	token, err := s.GenerateJWT(42)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+	claims, err := s.ValidateJWT(ctx, token)
+	if err != nil {
+		t.Fatalf("Failed to validate JWT: %v", err)
+	}
+
+	if err := s.RevokeJWT(ctx, claims); err != nil {
+		t.Fatalf("Failed to revoke JWT: %v", err)
+	}
+	if _, err := s.ValidateJWT(ctx, token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked, got %v", err)
+	}
+}
+
+// racingStore rotates every refresh token right after it is read, as a
+// concurrent request presenting the same token would
+type racingStore struct {
+	*testStore
+}
+
+func (m racingStore) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (db.RefreshToken, error) {
+	stored, err := m.testStore.GetRefreshTokenByHash(ctx, tokenHash)
+	if err != nil {
+		return stored, err
+	}
+	if _, err := m.testStore.RotateRefreshToken(ctx, stored.ID, "concurrent", stored.ExpiresAt); err != nil {
+		return db.RefreshToken{}, err
+	}
+	return stored, nil
+}
+
+func TestRefreshTokensReuse(t *testing.T) {
+	ctx := context.Background()
+	s := setupTestService(t, config.Auth{JWTSecret: "secret", RefreshTokenDuration: 24})
+	store := s.db.(*testStore)
+
+	first, err := s.IssueTokens(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to issue tokens: %v", err)
+	}
+	second, err := s.RefreshTokens(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Failed to refresh tokens: %v", err)
+	}
+
+	if _, err := s.RefreshTokens(ctx, first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := s.RefreshTokens(ctx, second.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Expected the family to be revoked, got %v", err)
+	}
+	if last := store.audit[len(store.audit)-1]; last.Action != db.AuditJWTRefreshReuse {
+		t.Fatalf("Expected the reuse to be audited, got %s", last.Action)
+	}
+}
+
+func TestRefreshTokensConcurrentReuse(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore()
+	s, err := New(&config.Config{Auth: config.Auth{JWTSecret: "secret", TokenDuration: 15, RefreshTokenDuration: 24}}, racingStore{store})
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	tokens, err := s.IssueTokens(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to issue tokens: %v", err)
+	}
+
+	// The losing rotation is reuse, not a server error
+	if _, err := s.RefreshTokens(ctx, tokens.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	for _, token := range store.refresh {
+		if !token.Revoked() {
+			t.Fatalf("Expected the whole family to be revoked, got %+v", token)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package cluster elects a leader among the server instances sharing a
+// database, so scheduled work runs on exactly one of them.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// LeaseName is the name of the lease held by the leader
+const LeaseName = "scheduler"
+
+// NodeID identifies this process among the instances of the cluster
+func NodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// Elector competes for the leader lease and runs the registered tasks while
+// this node leads. If the leader dies, its lease expires after the TTL and
+// another node takes over.
+type Elector struct {
+	db        *db.DB
+	id        string
+	ttl       time.Duration
+	startedAt time.Time
+
+	mu     sync.RWMutex
+	leader bool
+	tasks  []task
+}
+
+type task struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+// NewElector creates an elector for the node with the given ID. The lease is
+// renewed every third of ttl.
+func NewElector(database *db.DB, id string, ttl time.Duration) *Elector {
+	return &Elector{
+		db:        database,
+		id:        id,
+		ttl:       ttl,
+		startedAt: time.Now(),
+	}
+}
+
+// ID returns the ID of this node
+func (e *Elector) ID() string {
+	return e.id
+}
+
+// TTL returns how long a lease lasts without renewal
+func (e *Elector) TTL() time.Duration {
+	return e.ttl
+}
+
+// IsLeader reports whether this node currently holds the lease
+func (e *Elector) IsLeader() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.leader
+}
+
+// OnLead registers a task that runs while this node leads. Its context is
+// cancelled when leadership is lost. Tasks must be registered before Run.
+func (e *Elector) OnLead(name string, fn func(ctx context.Context)) {
+	e.tasks = append(e.tasks, task{name: name, fn: fn})
+}
+
+// Run takes part in the election until ctx is cancelled, then releases the
+// lease so another node can take over immediately
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	var stopTasks func()
+	stepDown := func() {
+		if stopTasks != nil {
+			stopTasks()
+			stopTasks = nil
+		}
+		e.setLeader(false)
+	}
+
+	for {
+		if err := e.db.TouchClusterMember(ctx, e.id, e.startedAt); err != nil {
+			slog.ErrorContext(ctx, "Error checking in as cluster member", "error", err)
+		}
+
+		acquired, err := e.db.AcquireLease(ctx, LeaseName, e.id, e.ttl)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error renewing leader lease", "error", err)
+			acquired = false
+		}
+
+		switch {
+		case acquired && stopTasks == nil:
+			slog.InfoContext(ctx, "Became leader", "node_id", e.id)
+			e.setLeader(true)
+			stopTasks = e.startTasks(ctx)
+		case !acquired && stopTasks != nil:
+			slog.WarnContext(ctx, "Lost leadership", "node_id", e.id)
+			stepDown()
+		}
+
+		if acquired {
+			if _, err := e.db.PruneClusterMembers(ctx, time.Now().Add(-e.ttl)); err != nil {
+				slog.ErrorContext(ctx, "Error pruning cluster members", "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			stepDown()
+			ctx := context.WithoutCancel(ctx)
+			if err := e.db.ReleaseLease(ctx, LeaseName, e.id); err != nil {
+				slog.ErrorContext(ctx, "Error releasing leader lease", "error", err)
+			}
+			if err := e.db.RemoveClusterMember(ctx, e.id); err != nil {
+				slog.ErrorContext(ctx, "Error leaving cluster", "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startTasks runs the leader tasks and returns a function that cancels them
+// and waits until they have returned
+func (e *Elector) startTasks(ctx context.Context) func() {
+	taskCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, t := range e.tasks {
+		wg.Add(1)
+		go func(t task) {
+			defer wg.Done()
+			t.fn(taskCtx)
+		}(t)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (e *Elector) setLeader(leader bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leader = leader
+}
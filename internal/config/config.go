@@ -2,48 +2,237 @@ package config
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"net/netip"
+	"strings"
 
 	"github.com/spf13/viper"
 )
 
+// Modes the application can run in. Development mode allows the default
+// secrets from config.yaml; production refuses to start with them.
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
+)
+
+// Database drivers. SQLite stores everything in a single file and suits
+// single-user and edge deployments.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
 type Database struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Name     string
-	SSLMode  string
+	// Driver is DriverPostgres or DriverSQLite. Host, port, user, password,
+	// name and sslmode only apply to PostgreSQL, path only to SQLite.
+	Driver   string `mapstructure:"driver"`
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	Name     string `mapstructure:"name"`
+	SSLMode  string `mapstructure:"sslmode"`
+	Path     string `mapstructure:"path"`
 }
 
 type Strava struct {
-	ClientID     int
-	ClientSecret string
-	CallbackURL  string
-	AccessToken  string
-	RefreshToken string
+	ClientID     int    `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	CallbackURL  string `mapstructure:"callback_url"`
+	// BaseURL is where the Strava API and OAuth endpoints are served,
+	// https://www.strava.com unless pointed at a proxy or a fake
+	BaseURL string `mapstructure:"base_url"`
 }
 
 type Server struct {
-	Port int
-	Host string
+	Port            int    `mapstructure:"port"`
+	Host            string `mapstructure:"host"`
+	ShutdownTimeout int    `mapstructure:"shutdown_timeout"` // in seconds
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For header is believed when recording the client
+	// address of a request
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. A plain address is a prefix
+// that matches only itself.
+func (s Server) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// SigningKey is a key used to sign or verify JWTs. The ID is written to the
+// "kid" header of issued tokens so keys can be rotated without invalidating
+// tokens signed by an older key.
+type SigningKey struct {
+	ID             string `mapstructure:"id"`
+	Algorithm      string `mapstructure:"algorithm"`   // HS256, RS256 or EdDSA
+	Secret         string `mapstructure:"secret"`      // HS256 only
+	SecretFile     string `mapstructure:"secret_file"` // HS256 only, read into Secret
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKeyFile  string `mapstructure:"public_key_file"` // verify-only keys
 }
 
 type Auth struct {
-	JWTSecret     string
-	TokenDuration int // in minutes
+	JWTSecret            string       `mapstructure:"jwt_secret"`
+	TokenDuration        int          `mapstructure:"token_duration"`         // in minutes
+	RefreshTokenDuration int          `mapstructure:"refresh_token_duration"` // in hours
+	Issuer               string       `mapstructure:"issuer"`
+	Audience             string       `mapstructure:"audience"`
+	ActiveKeyID          string       `mapstructure:"active_key_id"`
+	SigningKeys          []SigningKey `mapstructure:"signing_keys"`
+}
+
+type Jobs struct {
+	Workers      int `mapstructure:"workers"`       // jobs run concurrently per instance
+	PollInterval int `mapstructure:"poll_interval"` // in seconds
+	MaxAttempts  int `mapstructure:"max_attempts"`
+}
+
+// Webhooks configures the delivery of outbound webhooks
+type Webhooks struct {
+	Workers      int `mapstructure:"workers"`       // deliveries sent concurrently per instance, 0 disables delivery
+	PollInterval int `mapstructure:"poll_interval"` // in seconds
+	MaxAttempts  int `mapstructure:"max_attempts"`
+	Timeout      int `mapstructure:"timeout"` // in seconds
+	// AllowPrivateNetworks permits webhook URLs that resolve to loopback,
+	// private or link-local addresses. Off by default so users cannot make
+	// the server call internal services.
+	AllowPrivateNetworks bool `mapstructure:"allow_private_networks"`
+	RetentionDays        int  `mapstructure:"retention_days"` // days finished deliveries are kept
+}
+
+// Events configures publishing activity events to a message broker. Events
+// are written to an outbox in the transaction that changes the activity and
+// relayed to the broker by the leader, so none are lost while the broker is
+// down.
+type Events struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Broker  string `mapstructure:"broker"` // nats or redis
+	// URL of the broker, e.g. nats://localhost:4222 or redis://localhost:6379/0.
+	// tls:// and rediss:// connect with TLS. A user name may be part of the URL.
+	URL      string `mapstructure:"url"`
+	Password string `mapstructure:"password"`
+	// Topic is the NATS subject prefix, events are published to
+	// <topic>.<event>, or the name of the Redis stream
+	Topic         string `mapstructure:"topic"`
+	MaxLen        int    `mapstructure:"max_len"`        // approximate length cap of the Redis stream, 0 for none
+	PollInterval  int    `mapstructure:"poll_interval"`  // in seconds
+	BatchSize     int    `mapstructure:"batch_size"`     // events published per round trip
+	RetentionDays int    `mapstructure:"retention_days"` // days published events are kept in the outbox
+}
+
+type Logging struct {
+	Level  string `mapstructure:"level"`  // debug, info, warn or error
+	Format string `mapstructure:"format"` // text or json
+}
+
+// Tracing configures OpenTelemetry traces exported over OTLP/HTTP
+type Tracing struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Endpoint    string  `mapstructure:"endpoint"` // host:port of the OTLP/HTTP collector
+	Insecure    bool    `mapstructure:"insecure"` // plain HTTP instead of HTTPS
+	ServiceName string  `mapstructure:"service_name"`
+	SampleRatio float64 `mapstructure:"sample_ratio"` // share of new traces recorded, 0 to 1
+}
+
+type Cluster struct {
+	LeaseTTL int `mapstructure:"lease_ttl"` // in seconds
+}
+
+type Audit struct {
+	RetentionDays int `mapstructure:"retention_days"` // 0 keeps events forever
+}
+
+// ExportTarget is where scheduled exports are written: a local directory or
+// a bucket of an S3-compatible object store
+type ExportTarget struct {
+	Name   string `mapstructure:"name"`
+	Type   string `mapstructure:"type"`   // local or s3
+	Path   string `mapstructure:"path"`   // local only
+	Prefix string `mapstructure:"prefix"` // s3 only, key prefix of all snapshots
+
+	Endpoint        string `mapstructure:"endpoint"` // e.g. http://localhost:9000, AWS if empty
+	Region          string `mapstructure:"region"`
+	Bucket          string `mapstructure:"bucket"`
+	PathStyle       bool   `mapstructure:"path_style"` // bucket in the path instead of the host name, for MinIO
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	// SecretAccessKeyFile is read into SecretAccessKey
+	SecretAccessKeyFile string `mapstructure:"secret_access_key_file"`
+}
+
+// Exports configures scheduled Parquet snapshots of all activities
+type Exports struct {
+	Enabled  bool `mapstructure:"enabled"`
+	Interval int  `mapstructure:"interval"` // in hours
+	// Incremental snapshots only contain activities changed since the
+	// previous snapshot. A full snapshot is still taken every
+	// FullInterval days.
+	Incremental   bool           `mapstructure:"incremental"`
+	FullInterval  int            `mapstructure:"full_interval"`  // in days
+	RetentionDays int            `mapstructure:"retention_days"` // 0 keeps snapshots forever
+	Units         string         `mapstructure:"units"`          // m, km or mi
+	Targets       []ExportTarget `mapstructure:"targets"`
 }
 
 // Config holds all configuration for the application
 type Config struct {
-	Database Database
-	Strava   Strava
-	Server   Server
-	Auth     Auth
+	Mode     string   `mapstructure:"mode"`
+	Database Database `mapstructure:"database"`
+	Strava   Strava   `mapstructure:"strava"`
+	Server   Server   `mapstructure:"server"`
+	Auth     Auth     `mapstructure:"auth"`
+	Audit    Audit    `mapstructure:"audit"`
+	Jobs     Jobs     `mapstructure:"jobs"`
+	Cluster  Cluster  `mapstructure:"cluster"`
+	Logging  Logging  `mapstructure:"logging"`
+	Tracing  Tracing  `mapstructure:"tracing"`
+	Exports  Exports  `mapstructure:"exports"`
+	Webhooks Webhooks `mapstructure:"webhooks"`
+	Events   Events   `mapstructure:"events"`
+}
+
+// DevMode reports whether the application runs in development mode
+func (c *Config) DevMode() bool {
+	return c.Mode == ModeDevelopment
 }
 
-// LoadConfig loads configuration from file and environment variables
+// LoadConfig loads configuration from file and environment variables and
+// validates it
 func LoadConfig(configPath string) (*Config, error) {
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ReadConfig loads configuration from file and environment variables without
+// validating it
+func ReadConfig(configPath string) (*Config, error) {
 	var config Config
 
 	viper.SetConfigName("config") // name of config file (without extension)
@@ -61,7 +250,7 @@ func LoadConfig(configPath string) (*Config, error) {
 	// Read config file
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			log.Println("Config file not found; using environment variables and defaults")
+			slog.Info("Config file not found; using environment variables and defaults")
 		} else {
 			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
@@ -73,53 +262,179 @@ func LoadConfig(configPath string) (*Config, error) {
 	// Explicitly bind environment variables
 	bindEnvironmentVariables()
 
+	// Read secrets from the files named by the *_FILE environment variables
+	if err := loadSecretFiles(); err != nil {
+		return nil, err
+	}
+
 	// Unmarshal config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("unable to decode config into struct: %w", err)
 	}
 
+	if err := loadSigningKeySecrets(&config); err != nil {
+		return nil, err
+	}
+	if err := loadExportTargetSecrets(&config); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
 // setDefaults sets the default configuration values
 func setDefaults() {
+	viper.SetDefault("mode", ModeProduction)
+
 	// Database defaults
+	viper.SetDefault("database.driver", DriverPostgres)
 	viper.SetDefault("database.host", "localhost")
 	viper.SetDefault("database.port", 5432)
 	viper.SetDefault("database.user", "postgres")
 	viper.SetDefault("database.name", "strava_data")
 	viper.SetDefault("database.sslmode", "disable")
+	viper.SetDefault("database.path", "strava_data.db")
+
+	// Strava defaults
+	viper.SetDefault("strava.base_url", "https://www.strava.com")
 
 	// Server defaults
 	viper.SetDefault("server.port", 8080)
 	viper.SetDefault("server.host", "0.0.0.0")
+	viper.SetDefault("server.shutdown_timeout", 30)
 
 	// Auth defaults
-	viper.SetDefault("auth.tokenduration", 60) // 1 hour
+	viper.SetDefault("auth.token_duration", 15)          // 15 minutes
+	viper.SetDefault("auth.refresh_token_duration", 720) // 30 days
+	viper.SetDefault("auth.issuer", "strava-data-pipeline")
+	viper.SetDefault("auth.audience", "strava-data-pipeline")
+
+	// Audit defaults
+	viper.SetDefault("audit.retention_days", 365)
+
+	// Job queue defaults
+	viper.SetDefault("jobs.workers", 2)
+	viper.SetDefault("jobs.poll_interval", 5)
+	viper.SetDefault("jobs.max_attempts", 5)
+
+	// Cluster defaults
+	viper.SetDefault("cluster.lease_ttl", 30)
+
+	// Logging defaults
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.format", "text")
+
+	// Tracing defaults
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.endpoint", "localhost:4318")
+	viper.SetDefault("tracing.service_name", "strava-data-pipeline")
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+
+	// Webhook defaults
+	viper.SetDefault("webhooks.workers", 2)
+	viper.SetDefault("webhooks.poll_interval", 5)
+	viper.SetDefault("webhooks.max_attempts", 8)
+	viper.SetDefault("webhooks.timeout", 10)
+	viper.SetDefault("webhooks.allow_private_networks", false)
+	viper.SetDefault("webhooks.retention_days", 30)
+
+	// Event defaults
+	viper.SetDefault("events.enabled", false)
+	viper.SetDefault("events.broker", "nats")
+	viper.SetDefault("events.topic", "strava")
+	viper.SetDefault("events.max_len", 0)
+	viper.SetDefault("events.poll_interval", 1)
+	viper.SetDefault("events.batch_size", 100)
+	viper.SetDefault("events.retention_days", 7)
+
+	// Export defaults
+	viper.SetDefault("exports.enabled", false)
+	viper.SetDefault("exports.interval", 24)
+	viper.SetDefault("exports.incremental", false)
+	viper.SetDefault("exports.full_interval", 7)
+	viper.SetDefault("exports.retention_days", 30)
+	viper.SetDefault("exports.units", "m")
 }
 
 // bindEnvironmentVariables explicitly binds environment variables to configuration keys
 func bindEnvironmentVariables() {
+	viper.BindEnv("mode", "APP_MODE")
+
 	// Database bindings
+	viper.BindEnv("database.driver", "DB_DRIVER")
 	viper.BindEnv("database.host", "DB_HOST")
 	viper.BindEnv("database.port", "DB_PORT")
 	viper.BindEnv("database.user", "DB_USER")
 	viper.BindEnv("database.password", "DB_PASSWORD")
 	viper.BindEnv("database.name", "DB_NAME")
 	viper.BindEnv("database.sslmode", "DB_SSL_MODE")
+	viper.BindEnv("database.path", "DB_PATH")
 
 	// Strava bindings
 	viper.BindEnv("strava.client_id", "STRAVA_CLIENT_ID")
 	viper.BindEnv("strava.client_secret", "STRAVA_CLIENT_SECRET")
 	viper.BindEnv("strava.callback_url", "STRAVA_CALLBACK_URL")
-	viper.BindEnv("strava.access_token", "STRAVA_ACCESS_TOKEN")
-	viper.BindEnv("strava.refresh_token", "STRAVA_REFRESH_TOKEN")
+	viper.BindEnv("strava.base_url", "STRAVA_BASE_URL")
 
 	// Server bindings
 	viper.BindEnv("server.port", "SERVER_PORT")
 	viper.BindEnv("server.host", "SERVER_HOST")
+	viper.BindEnv("server.shutdown_timeout", "SERVER_SHUTDOWN_TIMEOUT")
+	viper.BindEnv("server.trusted_proxies", "SERVER_TRUSTED_PROXIES")
 
 	// Auth bindings
 	viper.BindEnv("auth.jwt_secret", "JWT_SECRET")
 	viper.BindEnv("auth.token_duration", "TOKEN_DURATION")
+	viper.BindEnv("auth.refresh_token_duration", "REFRESH_TOKEN_DURATION")
+	viper.BindEnv("auth.issuer", "JWT_ISSUER")
+	viper.BindEnv("auth.audience", "JWT_AUDIENCE")
+	viper.BindEnv("auth.active_key_id", "JWT_ACTIVE_KEY_ID")
+
+	// Audit bindings
+	viper.BindEnv("audit.retention_days", "AUDIT_RETENTION_DAYS")
+
+	// Job queue bindings
+	viper.BindEnv("jobs.workers", "JOB_WORKERS")
+	viper.BindEnv("jobs.poll_interval", "JOB_POLL_INTERVAL")
+	viper.BindEnv("jobs.max_attempts", "JOB_MAX_ATTEMPTS")
+
+	// Cluster bindings
+	viper.BindEnv("cluster.lease_ttl", "CLUSTER_LEASE_TTL")
+
+	// Logging bindings
+	viper.BindEnv("logging.level", "LOG_LEVEL")
+	viper.BindEnv("logging.format", "LOG_FORMAT")
+
+	// Tracing bindings
+	viper.BindEnv("tracing.enabled", "TRACING_ENABLED")
+	viper.BindEnv("tracing.endpoint", "TRACING_ENDPOINT")
+	viper.BindEnv("tracing.insecure", "TRACING_INSECURE")
+	viper.BindEnv("tracing.service_name", "TRACING_SERVICE_NAME")
+	viper.BindEnv("tracing.sample_ratio", "TRACING_SAMPLE_RATIO")
+
+	// Webhook bindings
+	viper.BindEnv("webhooks.workers", "WEBHOOK_WORKERS")
+	viper.BindEnv("webhooks.poll_interval", "WEBHOOK_POLL_INTERVAL")
+	viper.BindEnv("webhooks.max_attempts", "WEBHOOK_MAX_ATTEMPTS")
+	viper.BindEnv("webhooks.timeout", "WEBHOOK_TIMEOUT")
+	viper.BindEnv("webhooks.allow_private_networks", "WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+	viper.BindEnv("webhooks.retention_days", "WEBHOOK_RETENTION_DAYS")
+
+	// Event bindings
+	viper.BindEnv("events.enabled", "EVENTS_ENABLED")
+	viper.BindEnv("events.broker", "EVENTS_BROKER")
+	viper.BindEnv("events.url", "EVENTS_URL")
+	viper.BindEnv("events.password", "EVENTS_PASSWORD")
+	viper.BindEnv("events.topic", "EVENTS_TOPIC")
+	viper.BindEnv("events.max_len", "EVENTS_MAX_LEN")
+	viper.BindEnv("events.poll_interval", "EVENTS_POLL_INTERVAL")
+	viper.BindEnv("events.batch_size", "EVENTS_BATCH_SIZE")
+	viper.BindEnv("events.retention_days", "EVENTS_RETENTION_DAYS")
+
+	// Export bindings
+	viper.BindEnv("exports.enabled", "EXPORTS_ENABLED")
+	viper.BindEnv("exports.interval", "EXPORTS_INTERVAL")
+	viper.BindEnv("exports.incremental", "EXPORTS_INCREMENTAL")
+	viper.BindEnv("exports.full_interval", "EXPORTS_FULL_INTERVAL")
+	viper.BindEnv("exports.retention_days", "EXPORTS_RETENTION_DAYS")
 }
@@ -0,0 +1,225 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Mode: ModeProduction,
+		Database: Database{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Password: "postgres",
+			Name:     "strava_data",
+			SSLMode:  "disable",
+		},
+		Strava: Strava{
+			ClientID:     1234,
+			ClientSecret: "client-secret",
+			CallbackURL:  "http://localhost:8080/auth/callback",
+		},
+		Server: Server{Port: 8080, Host: "0.0.0.0", ShutdownTimeout: 30},
+		Auth: Auth{
+			JWTSecret:            strings.Repeat("s", minSecretLength),
+			TokenDuration:        15,
+			RefreshTokenDuration: 720,
+		},
+		Audit:    Audit{RetentionDays: 365},
+		Jobs:     Jobs{Workers: 2, PollInterval: 5, MaxAttempts: 5},
+		Cluster:  Cluster{LeaseTTL: 30},
+		Logging:  Logging{Level: "info", Format: "text"},
+		Tracing:  Tracing{Endpoint: "localhost:4318", ServiceName: "strava-data-pipeline", SampleRatio: 1},
+		Exports:  Exports{Interval: 24, FullInterval: 7, RetentionDays: 30, Units: "m"},
+		Webhooks: Webhooks{Workers: 2, PollInterval: 5, MaxAttempts: 8, Timeout: 10, RetentionDays: 30},
+		Events:   Events{Broker: "nats", Topic: "strava", PollInterval: 1, BatchSize: 100, RetentionDays: 7},
+	}
+}
+
+func TestValidateValidConfig(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+}
+
+func TestValidateAggregatesProblems(t *testing.T) {
+	cfg := validConfig()
+	cfg.Database.Password = ""
+	cfg.Strava.ClientID = 0
+	cfg.Server.Port = 0
+	cfg.Auth.JWTSecret = defaultJWTSecret
+
+	err := cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if len(verr.Problems) != 4 {
+		t.Fatalf("Expected 4 problems, got %d: %v", len(verr.Problems), verr.Problems)
+	}
+}
+
+func TestValidateDevModeAllowsDefaultSecrets(t *testing.T) {
+	cfg := validConfig()
+	cfg.Mode = ModeDevelopment
+	cfg.Database.Password = ""
+	cfg.Strava.ClientID = 0
+	cfg.Strava.ClientSecret = ""
+	cfg.Auth.JWTSecret = defaultJWTSecret
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected dev config to be valid, got %v", err)
+	}
+}
+
+func TestValidateSQLite(t *testing.T) {
+	cfg := validConfig()
+	cfg.Database = Database{Driver: DriverSQLite, Path: "/var/lib/strava/strava_data.db"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid SQLite config, got %v", err)
+	}
+
+	cfg.Database.Path = ""
+	var verr *ValidationError
+	if !errors.As(cfg.Validate(), &verr) || len(verr.Problems) != 1 {
+		t.Fatalf("Expected a missing path to be rejected, got %v", cfg.Validate())
+	}
+
+	cfg.Database.Driver = "mysql"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "database.driver") {
+		t.Fatalf("Expected an unknown driver to be rejected, got %v", err)
+	}
+}
+
+func TestValidateSigningKeys(t *testing.T) {
+	cfg := validConfig()
+	cfg.Auth.ActiveKeyID = "missing"
+	cfg.Auth.SigningKeys = []SigningKey{
+		{ID: "a", Algorithm: "HS256", Secret: strings.Repeat("k", minSecretLength)},
+		{ID: "a", Algorithm: "RS256"},
+		{ID: "b", Algorithm: "ES512"},
+	}
+
+	err := cfg.Validate()
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected *ValidationError, got %v", err)
+	}
+	if len(verr.Problems) != 4 {
+		t.Fatalf("Expected 4 problems, got %d: %v", len(verr.Problems), verr.Problems)
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret file: %v", err)
+	}
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_SECRET_FILE", path)
+
+	cfg, err := ReadConfig(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if cfg.Auth.JWTSecret != "from-file" {
+		t.Fatalf("Expected secret from file, got %q", cfg.Auth.JWTSecret)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+
+	cfg, err := ReadConfig(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	prefixes, err := cfg.Server.TrustedProxyPrefixes()
+	if err != nil || len(prefixes) != 2 || prefixes[1].String() != "192.168.1.1/32" {
+		t.Fatalf("Expected 2 trusted proxies, got %v, %v", prefixes, err)
+	}
+
+	cfg = validConfig()
+	cfg.Server.TrustedProxies = []string{"proxy.internal"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.trusted_proxies") {
+		t.Fatalf("Expected an invalid trusted proxy to be rejected, got %v", err)
+	}
+}
+
+func TestValidateExports(t *testing.T) {
+	cfg := validConfig()
+	cfg.Exports.Enabled = true
+	cfg.Exports.Targets = []ExportTarget{
+		{Name: "lake", Type: "s3", Bucket: "activities", Region: "eu-central-1", Endpoint: "http://localhost:9000", AccessKeyID: "id", SecretAccessKey: "secret"},
+		{Name: "disk", Type: "local", Path: "/var/lib/exports"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid export config, got %v", err)
+	}
+
+	cfg.Exports.Targets = append(cfg.Exports.Targets,
+		ExportTarget{Name: "disk", Type: "local"},
+		ExportTarget{Name: "ftp", Type: "ftp"},
+		ExportTarget{Name: "s3", Type: "s3", Endpoint: "localhost:9000"},
+	)
+	var verr *ValidationError
+	if !errors.As(cfg.Validate(), &verr) {
+		t.Fatal("Expected *ValidationError")
+	}
+	// duplicate name, missing path, unknown type, missing bucket, region,
+	// credentials and a relative endpoint
+	if len(verr.Problems) != 7 {
+		t.Fatalf("Expected 7 problems, got %d: %v", len(verr.Problems), verr.Problems)
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	cfg := validConfig()
+	cfg.Events.Enabled = true
+	for broker, url := range map[string]string{"nats": "tls://nats.internal:4222", "redis": "redis://events@localhost:6379/2"} {
+		cfg.Events.Broker = broker
+		cfg.Events.URL = url
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("Expected valid %s config, got %v", broker, err)
+		}
+	}
+
+	cfg.Events.Broker = "redis"
+	cfg.Events.URL = "nats://localhost:4222" // wrong scheme for redis
+	cfg.Events.BatchSize = 0
+	var verr *ValidationError
+	if !errors.As(cfg.Validate(), &verr) || len(verr.Problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %v", cfg.Validate())
+	}
+
+	cfg.Events.BatchSize = 100
+	cfg.Events.URL = "redis://:secret@localhost:6379"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "events.password") {
+		t.Fatalf("Expected a password in the URL to be rejected, got %v", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := validConfig()
+	cfg.Auth.SigningKeys = []SigningKey{{ID: "a", Secret: "key-secret"}}
+	cfg.Exports.Targets = []ExportTarget{{Name: "lake", SecretAccessKey: "s3-secret"}}
+	cfg.Events.Password = "broker-secret"
+	cfg.Strava.ClientSecret = ""
+
+	r := cfg.Redacted()
+	if r.Database.Password != redacted || r.Auth.JWTSecret != redacted || r.Auth.SigningKeys[0].Secret != redacted ||
+		r.Exports.Targets[0].SecretAccessKey != redacted || r.Events.Password != redacted {
+		t.Fatal("Expected secrets to be redacted")
+	}
+	if cfg.Auth.SigningKeys[0].Secret != "key-secret" || cfg.Exports.Targets[0].SecretAccessKey != "s3-secret" {
+		t.Fatal("Expected original config to be unchanged")
+	}
+	if r.Strava.ClientSecret != "" {
+		t.Fatal("Expected empty secrets to stay empty")
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// secretKeys maps configuration keys holding secrets to their environment
+// variable. Each secret can also be read from a file named by the variable
+// with a _FILE suffix, e.g. DB_PASSWORD_FILE=/run/secrets/db_password.
+var secretKeys = map[string]string{
+	"database.password":    "DB_PASSWORD",
+	"strava.client_secret": "STRAVA_CLIENT_SECRET",
+	"auth.jwt_secret":      "JWT_SECRET",
+	"events.password":      "EVENTS_PASSWORD",
+}
+
+// redacted replaces secrets in the output of config check
+const redacted = "[REDACTED]"
+
+// loadSecretFiles sets every secret whose _FILE environment variable is set
+// to the contents of that file
+func loadSecretFiles() error {
+	for key, env := range secretKeys {
+		path := os.Getenv(env + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(env) != "" {
+			return fmt.Errorf("both %s and %s_FILE are set", env, env)
+		}
+		value, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s_FILE: %w", env, err)
+		}
+		viper.Set(key, value)
+	}
+	return nil
+}
+
+// loadSigningKeySecrets reads the secret_file of HS256 signing keys
+func loadSigningKeySecrets(config *Config) error {
+	for i, key := range config.Auth.SigningKeys {
+		if key.SecretFile == "" {
+			continue
+		}
+		if key.Secret != "" {
+			return fmt.Errorf("signing key %q sets both secret and secret_file", key.ID)
+		}
+		value, err := readSecretFile(key.SecretFile)
+		if err != nil {
+			return fmt.Errorf("error reading secret_file of signing key %q: %w", key.ID, err)
+		}
+		config.Auth.SigningKeys[i].Secret = value
+	}
+	return nil
+}
+
+// loadExportTargetSecrets reads the secret_access_key_file of S3 export
+// targets. Targets without credentials fall back to the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables.
+func loadExportTargetSecrets(config *Config) error {
+	for i := range config.Exports.Targets {
+		target := &config.Exports.Targets[i]
+		if target.SecretAccessKeyFile != "" {
+			if target.SecretAccessKey != "" {
+				return fmt.Errorf("export target %q sets both secret_access_key and secret_access_key_file", target.Name)
+			}
+			value, err := readSecretFile(target.SecretAccessKeyFile)
+			if err != nil {
+				return fmt.Errorf("error reading secret_access_key_file of export target %q: %w", target.Name, err)
+			}
+			target.SecretAccessKey = value
+		}
+		if target.Type == "s3" && target.AccessKeyID == "" && target.SecretAccessKey == "" {
+			target.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+			target.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+	}
+	return nil
+}
+
+// readSecretFile reads a secret, dropping the trailing newline most editors
+// and `echo` add
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Redacted returns a copy of the configuration with all secrets replaced, so
+// it can be printed or logged
+func (c *Config) Redacted() *Config {
+	r := *c
+	redact(&r.Database.Password)
+	redact(&r.Strava.ClientSecret)
+	redact(&r.Auth.JWTSecret)
+	redact(&r.Events.Password)
+
+	r.Auth.SigningKeys = make([]SigningKey, len(c.Auth.SigningKeys))
+	for i, key := range c.Auth.SigningKeys {
+		redact(&key.Secret)
+		r.Auth.SigningKeys[i] = key
+	}
+
+	r.Exports.Targets = make([]ExportTarget, len(c.Exports.Targets))
+	for i, target := range c.Exports.Targets {
+		redact(&target.SecretAccessKey)
+		r.Exports.Targets[i] = target
+	}
+	return &r
+}
+
+func redact(secret *string) {
+	if *secret != "" {
+		*secret = redacted
+	}
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultJWTSecret is the placeholder secret shipped in config.yaml
+const defaultJWTSecret = "change-me-in-production"
+
+// minSecretLength is the minimum length of HS256 secrets outside development mode
+const minSecretLength = 32
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// all problems. Outside development mode, missing or default secrets are
+// rejected.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	switch c.Mode {
+	case ModeDevelopment, ModeProduction:
+	default:
+		v.addf("mode must be %q or %q, got %q", ModeDevelopment, ModeProduction, c.Mode)
+	}
+	dev := c.DevMode()
+
+	// Database
+	switch c.Database.Driver {
+	case DriverPostgres, "":
+		v.require(c.Database.Host != "", "database.host is required")
+		v.port("database.port", c.Database.Port)
+		v.require(c.Database.User != "", "database.user is required")
+		v.require(c.Database.Name != "", "database.name is required")
+		switch c.Database.SSLMode {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
+			v.addf("database.sslmode %q is not a valid PostgreSQL sslmode", c.Database.SSLMode)
+		}
+		if !dev {
+			v.require(c.Database.Password != "", "database.password is required (set DB_PASSWORD or DB_PASSWORD_FILE)")
+		}
+	case DriverSQLite:
+		v.require(c.Database.Path != "", "database.path is required for SQLite")
+	default:
+		v.addf("database.driver must be %q or %q, got %q", DriverPostgres, DriverSQLite, c.Database.Driver)
+	}
+
+	// Strava
+	if !dev {
+		v.require(c.Strava.ClientID > 0, "strava.client_id is required (set STRAVA_CLIENT_ID)")
+		v.require(c.Strava.ClientSecret != "", "strava.client_secret is required (set STRAVA_CLIENT_SECRET or STRAVA_CLIENT_SECRET_FILE)")
+	}
+	if c.Strava.CallbackURL != "" {
+		if u, err := url.Parse(c.Strava.CallbackURL); err != nil || u.Scheme == "" || u.Host == "" {
+			v.addf("strava.callback_url %q is not an absolute URL", c.Strava.CallbackURL)
+		}
+	}
+	if c.Strava.BaseURL != "" {
+		if u, err := url.Parse(c.Strava.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			v.addf("strava.base_url %q is not an absolute URL", c.Strava.BaseURL)
+		}
+	}
+
+	// Server
+	v.port("server.port", c.Server.Port)
+	v.require(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	if _, err := c.Server.TrustedProxyPrefixes(); err != nil {
+		v.addf("server.trusted_proxies: %v", err)
+	}
+
+	// Auth
+	v.require(c.Auth.TokenDuration > 0, "auth.token_duration must be positive")
+	v.require(c.Auth.RefreshTokenDuration > 0, "auth.refresh_token_duration must be positive")
+	if len(c.Auth.SigningKeys) == 0 {
+		v.require(c.Auth.JWTSecret != "", "auth.jwt_secret is required when no signing keys are configured (set JWT_SECRET or JWT_SECRET_FILE)")
+	}
+	if c.Auth.JWTSecret != "" && !dev {
+		v.secret("auth.jwt_secret", c.Auth.JWTSecret)
+	}
+	c.validateSigningKeys(v, dev)
+
+	// Audit
+	v.require(c.Audit.RetentionDays >= 0, "audit.retention_days must not be negative")
+
+	// Jobs
+	v.require(c.Jobs.Workers >= 0, "jobs.workers must not be negative")
+	v.require(c.Jobs.PollInterval > 0, "jobs.poll_interval must be positive")
+	v.require(c.Jobs.MaxAttempts > 0, "jobs.max_attempts must be positive")
+
+	// Webhooks
+	v.require(c.Webhooks.Workers >= 0, "webhooks.workers must not be negative")
+	v.require(c.Webhooks.PollInterval > 0, "webhooks.poll_interval must be positive")
+	v.require(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	v.require(c.Webhooks.Timeout > 0, "webhooks.timeout must be positive")
+	v.require(c.Webhooks.RetentionDays > 0, "webhooks.retention_days must be positive")
+
+	// Cluster
+	v.require(c.Cluster.LeaseTTL >= 3, "cluster.lease_ttl must be at least 3 seconds")
+
+	// Logging
+	switch strings.ToLower(c.Logging.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		v.addf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level)
+	}
+	switch strings.ToLower(c.Logging.Format) {
+	case "text", "json":
+	default:
+		v.addf("logging.format must be text or json, got %q", c.Logging.Format)
+	}
+
+	// Tracing
+	if c.Tracing.Enabled {
+		v.require(c.Tracing.Endpoint != "", "tracing.endpoint is required when tracing is enabled (set TRACING_ENDPOINT)")
+		v.require(c.Tracing.ServiceName != "", "tracing.service_name is required when tracing is enabled")
+	}
+	v.require(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	// Exports
+	if c.Exports.Enabled {
+		c.validateExports(v)
+	}
+
+	// Events
+	if c.Events.Enabled {
+		c.validateEvents(v)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (c *Config) validateSigningKeys(v *validator, dev bool) {
+	ids := make(map[string]bool)
+	for i, key := range c.Auth.SigningKeys {
+		name := fmt.Sprintf("auth.signing_keys[%d]", i)
+		if key.ID == "" {
+			v.addf("%s.id is required", name)
+		} else if ids[key.ID] {
+			v.addf("%s.id %q is used more than once", name, key.ID)
+		}
+		ids[key.ID] = true
+
+		switch strings.ToUpper(key.Algorithm) {
+		case "", "HS256":
+			if key.Secret == "" {
+				v.addf("%s requires a secret or secret_file for HS256", name)
+			} else if !dev {
+				v.secret(name+".secret", key.Secret)
+			}
+		case "RS256", "EDDSA":
+			if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+				v.addf("%s requires private_key_file or public_key_file for %s", name, key.Algorithm)
+			}
+			v.file(name+".private_key_file", key.PrivateKeyFile)
+			v.file(name+".public_key_file", key.PublicKeyFile)
+		default:
+			v.addf("%s.algorithm %q is not supported, use HS256, RS256 or EdDSA", name, key.Algorithm)
+		}
+	}
+
+	if c.Auth.ActiveKeyID != "" && len(c.Auth.SigningKeys) > 0 && !ids[c.Auth.ActiveKeyID] {
+		v.addf("auth.active_key_id %q does not match any signing key", c.Auth.ActiveKeyID)
+	}
+}
+
+func (c *Config) validateEvents(v *validator) {
+	schemes := map[string][]string{
+		"nats":  {"nats", "tls"},
+		"redis": {"redis", "rediss"},
+	}
+	allowed, ok := schemes[c.Events.Broker]
+	if !ok {
+		v.addf("events.broker must be nats or redis, got %q", c.Events.Broker)
+	}
+	if c.Events.URL == "" {
+		v.addf("events.url is required when events are enabled (set EVENTS_URL)")
+	} else if u, err := url.Parse(c.Events.URL); err != nil || u.Host == "" {
+		v.addf("events.url %q is not an absolute URL", c.Events.URL)
+	} else if ok && !slices.Contains(allowed, u.Scheme) {
+		v.addf("events.url must use %s for the %s broker, got %q", strings.Join(allowed, " or "), c.Events.Broker, u.Scheme)
+	} else if _, hasPassword := u.User.Password(); hasPassword {
+		v.addf("events.url must not contain a password, set events.password (or EVENTS_PASSWORD) instead")
+	}
+	v.require(c.Events.Topic != "", "events.topic is required when events are enabled")
+	v.require(c.Events.MaxLen >= 0, "events.max_len must not be negative")
+	v.require(c.Events.PollInterval > 0, "events.poll_interval must be positive")
+	v.require(c.Events.BatchSize > 0, "events.batch_size must be positive")
+	v.require(c.Events.RetentionDays > 0, "events.retention_days must be positive")
+}
+
+func (c *Config) validateExports(v *validator) {
+	v.require(c.Exports.Interval > 0, "exports.interval must be positive")
+	v.require(c.Exports.RetentionDays >= 0, "exports.retention_days must not be negative")
+	if c.Exports.Incremental {
+		v.require(c.Exports.FullInterval > 0, "exports.full_interval must be positive in incremental mode")
+	}
+	switch c.Exports.Units {
+	case "m", "km", "mi":
+	default:
+		v.addf("exports.units must be m, km or mi, got %q", c.Exports.Units)
+	}
+	v.require(len(c.Exports.Targets) > 0, "exports.targets must not be empty when exports are enabled")
+
+	names := make(map[string]bool)
+	for i, target := range c.Exports.Targets {
+		name := fmt.Sprintf("exports.targets[%d]", i)
+		if target.Name == "" {
+			v.addf("%s.name is required", name)
+		} else if names[target.Name] {
+			v.addf("%s.name %q is used more than once", name, target.Name)
+		}
+		names[target.Name] = true
+
+		switch target.Type {
+		case "local":
+			v.require(target.Path != "", name+".path is required for local targets")
+		case "s3":
+			v.require(target.Bucket != "", name+".bucket is required for s3 targets")
+			v.require(target.Region != "", name+".region is required for s3 targets")
+			if target.Endpoint != "" {
+				if u, err := url.Parse(target.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+					v.addf("%s.endpoint %q is not an absolute URL", name, target.Endpoint)
+				}
+			}
+			v.require(target.AccessKeyID != "" && target.SecretAccessKey != "",
+				name+" requires access_key_id and secret_access_key (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+		default:
+			v.addf("%s.type must be local or s3, got %q", name, target.Type)
+		}
+	}
+}
+
+// validator collects validation problems
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) require(ok bool, problem string) {
+	if !ok {
+		v.problems = append(v.problems, problem)
+	}
+}
+
+func (v *validator) port(name string, port int) {
+	if port < 1 || port > 65535 {
+		v.addf("%s must be between 1 and 65535, got %d", name, port)
+	}
+}
+
+func (v *validator) secret(name, secret string) {
+	if secret == defaultJWTSecret {
+		v.addf("%s is the default from config.yaml, set a random secret or use mode: %s", name, ModeDevelopment)
+	} else if len(secret) < minSecretLength {
+		v.addf("%s must be at least %d characters", name, minSecretLength)
+	}
+}
+
+func (v *validator) file(name, path string) {
+	if path == "" {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		v.addf("%s: %v", name, err)
+	}
+}
@@ -1,7 +1,14 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -49,6 +56,50 @@ CREATE TABLE IF NOT EXISTS activities (
 	updated_at TIMESTAMP DEFAULT NOW()
 );`
 
+var sqliteActivitySchema = `
+CREATE TABLE IF NOT EXISTS activities (
+	id BIGINT NOT NULL PRIMARY KEY,
+	name TEXT,
+	description TEXT,
+	type TEXT,
+	distance FLOAT,
+	moving_time INT,
+	elapsed_time INT,
+	total_elevation_gain FLOAT,
+	start_date TIMESTAMP,
+	start_date_local TIMESTAMP,
+	timezone TEXT,
+	start_latlng TEXT,
+	end_latlng TEXT,
+	achievement_count INT,
+	kudos_count INT,
+	comment_count INT,
+	athlete_count INT,
+	photo_count INT,
+	map_id TEXT,
+	map_polyline TEXT,
+	trainer BOOLEAN,
+	commute BOOLEAN,
+	manual BOOLEAN,
+	private BOOLEAN,
+	visibility TEXT,
+	flagged BOOLEAN,
+	workout_type INT,
+	average_speed FLOAT,
+	max_speed FLOAT,
+	has_heartrate BOOLEAN,
+	average_heartrate FLOAT,
+	max_heartrate FLOAT,
+	elev_high FLOAT,
+	elev_low FLOAT,
+	upload_id BIGINT,
+	upload_id_str TEXT,
+	external_id TEXT,
+	athlete_id BIGINT,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	updated_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);`
+
 type Activity struct {
 	ID                 int64     `db:"id"`
 	Name               string    `db:"name"`
@@ -93,10 +144,12 @@ type Activity struct {
 }
 
 func (db *DB) CreateActivitySchema() {
-	db.MustExec(activitySchema)
+	db.MustExec(dialect(db, activitySchema, sqliteActivitySchema))
 }
 
-func (db *DB) CreateActivity(activity Activity) (Activity, error) {
+// CreateActivity inserts an activity or replaces the stored one with the same
+// ID, and records the change in the activity change log
+func (db *DB) CreateActivity(ctx context.Context, activity Activity) (Activity, error) {
 	query := `
 		INSERT INTO activities (
 			id, name, description, type, distance, moving_time, elapsed_time,
@@ -109,30 +162,30 @@ func (db *DB) CreateActivity(activity Activity) (Activity, error) {
 			elev_high, elev_low, upload_id, upload_id_str,
 			external_id, athlete_id
 		) VALUES (
-			:ID, :Name, :Description, :Type, :Distance,
-			:MovingTime, :ElapsedTime, :TotalElevationGain,
-			:StartDate, :StartDateLocal, :Timezone,
-			:StartLatLng, :EndLatLng,
-			:AchievementCount, :KudosCount,
-			:CommentCount, :AthleteCount,
-			:PhotoCount, :MapID,
-			:MapPolyline,
-			:Trainer, :Commute,
-			:Manual, :Private,
-			:Visibility,
-			:Flagged,
-			:WorkoutType,
-			:AverageSpeed,
-			:MaxSpeed,
-			:HasHeartRate,
-			:AverageHeartRate,
-			:MaxHeartRate,
-			:ElevHigh,
-			:ElevLow,
-			:UploadID,
-			:UploadIDStr,
-			:ExternalID,
-			:AthleteID
+			:id, :name, :description, :type, :distance,
+			:moving_time, :elapsed_time, :total_elevation_gain,
+			:start_date, :start_date_local, :timezone,
+			:start_latlng, :end_latlng,
+			:achievement_count, :kudos_count,
+			:comment_count, :athlete_count,
+			:photo_count, :map_id,
+			:map_polyline,
+			:trainer, :commute,
+			:manual, :private,
+			:visibility,
+			:flagged,
+			:workout_type,
+			:average_speed,
+			:max_speed,
+			:has_heartrate,
+			:average_heartrate,
+			:max_heartrate,
+			:elev_high,
+			:elev_low,
+			:upload_id,
+			:upload_id_str,
+			:external_id,
+			:athlete_id
 		)
 		ON CONFLICT (id) DO UPDATE SET
 			name = EXCLUDED.name,
@@ -173,84 +226,459 @@ func (db *DB) CreateActivity(activity Activity) (Activity, error) {
 			external_id = EXCLUDED.external_id,
 			athlete_id = EXCLUDED.athlete_id,
 			updated_at = NOW()
-		RETURNING *
-	`
+		RETURNING *` + dialect(db, `, (xmax = 0) AS inserted`, ``)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Activity{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	err := db.Get(&activity, query, activity)
+	query, args, err := tx.BindNamed(query, activity)
 	if err != nil {
 		return Activity{}, fmt.Errorf("error creating activity: %w", err)
 	}
+	var row struct {
+		Activity
+		Inserted bool `db:"inserted"`
+	}
+	// xmax is 0 for rows the upsert inserted. SQLite has no xmax, but its
+	// transactions hold the write lock from the start, so a row missing
+	// before the upsert has been inserted by it.
+	if isSQLite(tx) {
+		err := tx.GetContext(ctx, &row.Inserted, `SELECT NOT EXISTS (SELECT 1 FROM activities WHERE id = $1)`, activity.ID)
+		if err != nil {
+			return Activity{}, fmt.Errorf("error creating activity: %w", err)
+		}
+	}
+	if err := tx.GetContext(ctx, &row, query, args...); err != nil {
+		return Activity{}, fmt.Errorf("error creating activity: %w", err)
+	}
 
-	return activity, nil
+	op := ChangeUpdate
+	if row.Inserted {
+		op = ChangeInsert
+	}
+	if err := db.recordActivityChange(ctx, tx, op, row.Activity); err != nil {
+		return Activity{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Activity{}, fmt.Errorf("error committing activity: %w", err)
+	}
+	return row.Activity, nil
 }
 
-func (db *DB) GetActivityByID(id int64) (Activity, error) {
+func (db *DB) GetActivityByID(ctx context.Context, id int64) (Activity, error) {
 	var activity Activity
 	query := `
 		SELECT * FROM activities WHERE id = $1
 	`
-	err := db.Get(&activity, query, id)
+	err := db.GetContext(ctx, &activity, query, id)
 	if err != nil {
-		if isNoRows(err) {
-			return Activity{}, fmt.Errorf("no activity found with id %d", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Activity{}, fmt.Errorf("activity %d %w", id, ErrNotFound)
 		}
 		return Activity{}, fmt.Errorf("error retrieving activity: %w", err)
 	}
 	return activity, nil
 }
 
-func (db *DB) GetLastActivities(limit int) ([]Activity, error) {
+func (db *DB) GetLastActivities(ctx context.Context, limit int) ([]Activity, error) {
 	var activities []Activity
 	query := `
 		SELECT * FROM activities
 		ORDER BY start_date DESC
 		LIMIT $1
 	`
-	err := db.Select(&activities, query, limit)
+	err := db.SelectContext(ctx, &activities, query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving last activities: %w", err)
 	}
 	return activities, nil
 }
 
-func (db *DB) UpdateActivity(activity Activity) (Activity, error) {
+// UpdateActivity overwrites a stored activity and records the change in the
+// activity change log
+func (db *DB) UpdateActivity(ctx context.Context, activity Activity) (Activity, error) {
 	query := `
 		UPDATE activities
-		SET name = :Name, description = :Description, type = :Type,
-			distance = :Distance, moving_time = :MovingTime,
-			elapsed_time = :ElapsedTime, total_elevation_gain = :TotalElevationGain,
-			start_date = :StartDate, start_date_local = :StartDateLocal,
-			timezone = :Timezone, start_latlng = :StartLatLng,
-			end_latlng = :EndLatLng, achievement_count = :AchievementCount,
-			kudos_count = :KudosCount, comment_count = :CommentCount,
-			athlete_count = :AthleteCount, photo_count = :PhotoCount,
-			map_id = :MapID, map_polyline = :MapPolyline,
-			trainer = :Trainer, commute = :Commute, manual = :Manual,
-			private = :Private, visibility = :Visibility,
-			flagged = :Flagged, workout_type = :WorkoutType,
-			average_speed = :AverageSpeed, max_speed = :MaxSpeed,
-			has_heartrate = :HasHeartRate, average_heartrate = :AverageHeartRate,
-			max_heartrate = :MaxHeartRate, elev_high = :ElevHigh,
-			elev_low = :ElevLow, upload_id = :UploadID,
-			upload_id_str = :UploadIDStr, external_id = :ExternalID,
-			athlete_id = :AthleteID, updated_at = NOW()
-		WHERE id = $1
+		SET name = :name, description = :description, type = :type,
+			distance = :distance, moving_time = :moving_time,
+			elapsed_time = :elapsed_time, total_elevation_gain = :total_elevation_gain,
+			start_date = :start_date, start_date_local = :start_date_local,
+			timezone = :timezone, start_latlng = :start_latlng,
+			end_latlng = :end_latlng, achievement_count = :achievement_count,
+			kudos_count = :kudos_count, comment_count = :comment_count,
+			athlete_count = :athlete_count, photo_count = :photo_count,
+			map_id = :map_id, map_polyline = :map_polyline,
+			trainer = :trainer, commute = :commute, manual = :manual,
+			private = :private, visibility = :visibility,
+			flagged = :flagged, workout_type = :workout_type,
+			average_speed = :average_speed, max_speed = :max_speed,
+			has_heartrate = :has_heartrate, average_heartrate = :average_heartrate,
+			max_heartrate = :max_heartrate, elev_high = :elev_high,
+			elev_low = :elev_low, upload_id = :upload_id,
+			upload_id_str = :upload_id_str, external_id = :external_id,
+			athlete_id = :athlete_id, updated_at = NOW()
+		WHERE id = :id
 		RETURNING *
 	`
-	err := db.Get(&activity, query, activity.ID)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Activity{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := tx.BindNamed(query, activity)
 	if err != nil {
 		return Activity{}, fmt.Errorf("error updating activity: %w", err)
 	}
+	if err := tx.GetContext(ctx, &activity, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Activity{}, fmt.Errorf("activity %d %w", activity.ID, ErrNotFound)
+		}
+		return Activity{}, fmt.Errorf("error updating activity: %w", err)
+	}
+
+	if err := db.recordActivityChange(ctx, tx, ChangeUpdate, activity); err != nil {
+		return Activity{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Activity{}, fmt.Errorf("error committing activity: %w", err)
+	}
 	return activity, nil
 }
 
-func (db *DB) DeleteActivity(id int64) error {
+// DeleteActivity deletes an activity and records the deletion, with the last
+// state of the activity, in the activity change log. Deleting a missing
+// activity is not an error.
+func (db *DB) DeleteActivity(ctx context.Context, id int64) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var activity Activity
 	query := `
 		DELETE FROM activities WHERE id = $1
+		RETURNING *
 	`
-	_, err := db.Exec(query, id)
+	err = tx.GetContext(ctx, &activity, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("error deleting activity with id %d: %w", id, err)
 	}
+
+	if err := db.recordActivityChange(ctx, tx, ChangeDelete, activity); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error deleting activity with id %d: %w", id, err)
+	}
+	return nil
+}
+
+func (db *DB) GetActivities(ctx context.Context, limit, offset int) ([]Activity, error) {
+	var activities []Activity
+	query := `
+		SELECT * FROM activities
+		ORDER BY start_date DESC
+		LIMIT $1 OFFSET $2
+	`
+	err := db.SelectContext(ctx, &activities, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving activities: %w", err)
+	}
+	return activities, nil
+}
+
+// ActivityFilter restricts the activities returned by ListActivities. Zero
+// values are ignored.
+type ActivityFilter struct {
+	AthleteID int64
+	Type      string
+	After     time.Time
+	Before    time.Time
+	// UpdatedAfter only matches activities created or changed after it
+	UpdatedAfter time.Time
+	Limit        int
+	Offset       int
+	// GroupByAthlete makes StreamActivities return the activities of each
+	// athlete together, oldest first within an athlete
+	GroupByAthlete bool
+}
+
+// ListActivities returns the activities matching the filter, newest first
+func (db *DB) ListActivities(ctx context.Context, filter ActivityFilter) ([]Activity, error) {
+	where, args := activityConditions(filter)
+	query := `
+		SELECT * FROM activities
+	` + where
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	args = append(args, limit, filter.Offset)
+	query += fmt.Sprintf("ORDER BY start_date DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	activities := []Activity{}
+	err := db.SelectContext(ctx, &activities, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing activities: %w", err)
+	}
+	return activities, nil
+}
+
+// streamBatchSize is the number of rows fetched from the export cursor at once
+const streamBatchSize = 500
+
+// StreamActivities yields every activity matching the filter, oldest first.
+// The rows are read in batches through a server-side cursor, so the result
+// set is never held in memory. Limit and Offset of the filter are ignored.
+// Iteration stops after the first error.
+func (db *DB) StreamActivities(ctx context.Context, filter ActivityFilter) iter.Seq2[Activity, error] {
+	return func(yield func(Activity, error) bool) {
+		order := "ORDER BY start_date, id"
+		if filter.GroupByAthlete {
+			order = "ORDER BY athlete_id, start_date, id"
+		}
+		where, args := activityConditions(filter)
+		if isSQLite(db) {
+			db.streamSQLiteActivities(ctx, "SELECT * FROM activities "+where+order, args, yield)
+			return
+		}
+
+		// Cursors only live as long as their transaction
+		tx, err := db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			yield(Activity{}, fmt.Errorf("error starting export transaction: %w", err))
+			return
+		}
+		defer tx.Rollback()
+
+		query := `
+			DECLARE activity_export NO SCROLL CURSOR FOR
+			SELECT * FROM activities
+		` + where + order
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			yield(Activity{}, fmt.Errorf("error declaring export cursor: %w", err))
+			return
+		}
+
+		fetch := fmt.Sprintf("FETCH %d FROM activity_export", streamBatchSize)
+		for {
+			batch := make([]Activity, 0, streamBatchSize)
+			if err := tx.SelectContext(ctx, &batch, fetch); err != nil {
+				yield(Activity{}, fmt.Errorf("error fetching activities: %w", err))
+				return
+			}
+			for _, activity := range batch {
+				if !yield(activity, nil) {
+					return
+				}
+			}
+			if len(batch) < streamBatchSize {
+				return
+			}
+		}
+	}
+}
+
+// streamSQLiteActivities is StreamActivities for SQLite, which has no
+// cursors. It does not need them: SQLite produces the rows of a query as they
+// are read, and in WAL mode the query sees a consistent snapshot without
+// blocking writers.
+func (db *DB) streamSQLiteActivities(ctx context.Context, query string, args []interface{}, yield func(Activity, error) bool) {
+	rows, err := db.QueryxContext(ctx, query, args...)
+	if err != nil {
+		yield(Activity{}, fmt.Errorf("error querying activities: %w", err))
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var activity Activity
+		if err := rows.StructScan(&activity); err != nil {
+			yield(Activity{}, fmt.Errorf("error fetching activities: %w", err))
+			return
+		}
+		if !yield(activity, nil) {
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		yield(Activity{}, fmt.Errorf("error fetching activities: %w", err))
+	}
+}
+
+// activityConditions returns the WHERE clause for filter, or an empty string
+// if the filter matches all activities, together with its arguments
+func activityConditions(filter ActivityFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.AthleteID != 0 {
+		add("athlete_id = $%d", filter.AthleteID)
+	}
+	if filter.Type != "" {
+		add("type = $%d", filter.Type)
+	}
+	if !filter.After.IsZero() {
+		add("start_date >= $%d", filter.After)
+	}
+	if !filter.Before.IsZero() {
+		add("start_date < $%d", filter.Before)
+	}
+	if !filter.UpdatedAfter.IsZero() {
+		add("updated_at > $%d", filter.UpdatedAfter)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND ") + "\n", args
+}
+
+func (db *DB) GetActivitiesByAthlete(ctx context.Context, athleteID int64, limit, offset int) ([]Activity, error) {
+	var activities []Activity
+	query := `
+		SELECT * FROM activities
+		WHERE athlete_id = $1
+		ORDER BY start_date DESC
+		LIMIT $2 OFFSET $3
+	`
+	err := db.SelectContext(ctx, &activities, query, athleteID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving activities for athlete %d: %w", athleteID, err)
+	}
+	return activities, nil
+}
+
+// stravaActivity mirrors the JSON representation of a Strava activity summary
+type stravaActivity struct {
+	ID                 int64      `json:"id"`
+	Name               string     `json:"name"`
+	Description        string     `json:"description"`
+	Type               string     `json:"type"`
+	Distance           float64    `json:"distance"`
+	MovingTime         int        `json:"moving_time"`
+	ElapsedTime        int        `json:"elapsed_time"`
+	TotalElevationGain float64    `json:"total_elevation_gain"`
+	StartDate          time.Time  `json:"start_date"`
+	StartDateLocal     time.Time  `json:"start_date_local"`
+	Timezone           string     `json:"timezone"`
+	StartLatLng        [2]float64 `json:"start_latlng"`
+	EndLatLng          [2]float64 `json:"end_latlng"`
+	AchievementCount   int        `json:"achievement_count"`
+	KudosCount         int        `json:"kudos_count"`
+	CommentCount       int        `json:"comment_count"`
+	AthleteCount       int        `json:"athlete_count"`
+	PhotoCount         int        `json:"photo_count"`
+	Map                struct {
+		ID              string `json:"id"`
+		SummaryPolyline string `json:"summary_polyline"`
+	} `json:"map"`
+	Trainer          bool    `json:"trainer"`
+	Commute          bool    `json:"commute"`
+	Manual           bool    `json:"manual"`
+	Private          bool    `json:"private"`
+	Visibility       string  `json:"visibility"`
+	Flagged          bool    `json:"flagged"`
+	WorkoutType      int     `json:"workout_type"`
+	AverageSpeed     float64 `json:"average_speed"`
+	MaxSpeed         float64 `json:"max_speed"`
+	HasHeartRate     bool    `json:"has_heartrate"`
+	AverageHeartRate float64 `json:"average_heartrate"`
+	MaxHeartRate     float64 `json:"max_heartrate"`
+	ElevHigh         float64 `json:"elev_high"`
+	ElevLow          float64 `json:"elev_low"`
+	UploadID         int64   `json:"upload_id"`
+	ExternalID       string  `json:"external_id"`
+	Athlete          struct {
+		ID int64 `json:"id"`
+	} `json:"athlete"`
+}
+
+// SaveActivity stores an activity in the shape returned by the Strava API
+func (db *DB) SaveActivity(ctx context.Context, data map[string]interface{}) error {
+	activity, err := activityFromStrava(data)
+	if err != nil {
+		return err
+	}
+	if _, err := db.CreateActivity(ctx, activity); err != nil {
+		return fmt.Errorf("error saving activity %d: %w", activity.ID, err)
+	}
 	return nil
 }
+
+// activityFromStrava converts an activity in the shape returned by the
+// Strava API
+func activityFromStrava(data map[string]interface{}) (Activity, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Activity{}, fmt.Errorf("error encoding activity: %w", err)
+	}
+
+	var sa stravaActivity
+	if err := json.Unmarshal(raw, &sa); err != nil {
+		return Activity{}, fmt.Errorf("error decoding activity: %w", err)
+	}
+
+	return Activity{
+		ID:                 sa.ID,
+		Name:               sa.Name,
+		Description:        sa.Description,
+		Type:               sa.Type,
+		Distance:           sa.Distance,
+		MovingTime:         sa.MovingTime,
+		ElapsedTime:        sa.ElapsedTime,
+		TotalElevationGain: sa.TotalElevationGain,
+		StartDate:          sa.StartDate,
+		StartDateLocal:     sa.StartDateLocal,
+		Timezone:           sa.Timezone,
+		StartLatLng:        formatLatLng(sa.StartLatLng),
+		EndLatLng:          formatLatLng(sa.EndLatLng),
+		AchievementCount:   sa.AchievementCount,
+		KudosCount:         sa.KudosCount,
+		CommentCount:       sa.CommentCount,
+		AthleteCount:       sa.AthleteCount,
+		PhotoCount:         sa.PhotoCount,
+		MapID:              sa.Map.ID,
+		MapPolyline:        sa.Map.SummaryPolyline,
+		Trainer:            sa.Trainer,
+		Commute:            sa.Commute,
+		Manual:             sa.Manual,
+		Private:            sa.Private,
+		Visibility:         sa.Visibility,
+		Flagged:            sa.Flagged,
+		WorkoutType:        sa.WorkoutType,
+		AverageSpeed:       sa.AverageSpeed,
+		MaxSpeed:           sa.MaxSpeed,
+		HasHeartRate:       sa.HasHeartRate || sa.AverageHeartRate > 0,
+		AverageHeartRate:   sa.AverageHeartRate,
+		MaxHeartRate:       sa.MaxHeartRate,
+		ElevHigh:           sa.ElevHigh,
+		ElevLow:            sa.ElevLow,
+		UploadID:           sa.UploadID,
+		UploadIDStr:        strconv.FormatInt(sa.UploadID, 10),
+		ExternalID:         sa.ExternalID,
+		AthleteID:          sa.Athlete.ID,
+	}, nil
+}
+
+// formatLatLng renders a coordinate pair as "lat,lng", or "" when unset
+func formatLatLng(latlng [2]float64) string {
+	if latlng[0] == 0 && latlng[1] == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g,%g", latlng[0], latlng[1])
+}
@@ -1,17 +1,24 @@
 package db
 
 import (
+	"context"
 	"testing"
 	"time"
 )
 
 func setupTestActivityDB(t *testing.T) *DB {
 	db := setupTestDB(t)
+	db.CreateUserSchema()
+	db.CreateCoachSchema()
 	db.CreateActivitySchema()
+	db.CreateActivityChangeSchema()
+	db.CreateWebhookSchema()
+	db.CreateOutboxSchema()
 	return db
 }
 
 func createTestActivity(t *testing.T, db *DB) Activity {
+	ctx := context.Background()
 	activity := Activity{
 		ID:                 1,
 		Name:               "Test Activity",
@@ -52,7 +59,7 @@ func createTestActivity(t *testing.T, db *DB) Activity {
 		ExternalID:         "extid",
 		AthleteID:          1,
 	}
-	created, err := db.CreateActivity(activity)
+	created, err := db.CreateActivity(ctx, activity)
 	if err != nil {
 		t.Fatalf("Failed to create test activity: %v", err)
 	}
@@ -66,10 +73,11 @@ func TestCreateActivity(t *testing.T) {
 }
 
 func TestGetActivityByID(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestActivityDB(t)
 	defer db.Close()
 	created := createTestActivity(t, db)
-	activity, err := db.GetActivityByID(created.ID)
+	activity, err := db.GetActivityByID(ctx, created.ID)
 	if err != nil {
 		t.Fatalf("Failed to get activity by ID: %v", err)
 	}
@@ -79,11 +87,12 @@ func TestGetActivityByID(t *testing.T) {
 }
 
 func TestUpdateActivity(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestActivityDB(t)
 	defer db.Close()
 	created := createTestActivity(t, db)
 	created.Name = "Updated Name"
-	updated, err := db.UpdateActivity(created)
+	updated, err := db.UpdateActivity(ctx, created)
 	if err != nil {
 		t.Fatalf("Failed to update activity: %v", err)
 	}
@@ -93,15 +102,94 @@ func TestUpdateActivity(t *testing.T) {
 }
 
 func TestDeleteActivity(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestActivityDB(t)
 	defer db.Close()
 	created := createTestActivity(t, db)
-	err := db.DeleteActivity(created.ID)
+	err := db.DeleteActivity(ctx, created.ID)
 	if err != nil {
 		t.Fatalf("Failed to delete activity: %v", err)
 	}
-	_, err = db.GetActivityByID(created.ID)
+	_, err = db.GetActivityByID(ctx, created.ID)
 	if err == nil {
 		t.Fatal("Expected error for deleted activity, got nil")
 	}
 }
+
+func TestListActivities(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestActivityDB(t)
+	defer db.Close()
+	created := createTestActivity(t, db)
+
+	activities, err := db.ListActivities(ctx, ActivityFilter{Type: created.Type, AthleteID: created.AthleteID})
+	if err != nil {
+		t.Fatalf("Failed to list activities: %v", err)
+	}
+	found := false
+	for _, a := range activities {
+		if a.ID == created.ID {
+			found = true
+		}
+		if a.Type != created.Type {
+			t.Fatalf("Expected only %s activities, got %s", created.Type, a.Type)
+		}
+	}
+	if !found {
+		t.Fatalf("Expected activity %d in the filtered list", created.ID)
+	}
+
+	activities, err = db.ListActivities(ctx, ActivityFilter{After: created.StartDate.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to list activities: %v", err)
+	}
+	for _, a := range activities {
+		if a.ID == created.ID {
+			t.Fatalf("Expected activity %d to be filtered out by after", created.ID)
+		}
+	}
+}
+
+func TestStreamActivities(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestActivityDB(t)
+	defer db.Close()
+	created := createTestActivity(t, db)
+
+	found := 0
+	var last time.Time
+	for activity, err := range db.StreamActivities(ctx, ActivityFilter{AthleteID: created.AthleteID}) {
+		if err != nil {
+			t.Fatalf("Failed to stream activities: %v", err)
+		}
+		if activity.AthleteID != created.AthleteID {
+			t.Fatalf("Expected only activities of athlete %d, got %d", created.AthleteID, activity.AthleteID)
+		}
+		if activity.StartDate.Before(last) {
+			t.Fatalf("Expected activities oldest first")
+		}
+		last = activity.StartDate
+		if activity.ID == created.ID {
+			found++
+		}
+	}
+	if found != 1 {
+		t.Fatalf("Expected activity %d once in the stream, got it %d times", created.ID, found)
+	}
+
+	// Incremental exports only see activities changed after the watermark
+	for activity, err := range db.StreamActivities(ctx, ActivityFilter{UpdatedAfter: time.Now().Add(time.Hour), GroupByAthlete: true}) {
+		if err != nil {
+			t.Fatalf("Failed to stream activities: %v", err)
+		}
+		t.Fatalf("Expected no activities updated in the future, got %d", activity.ID)
+	}
+
+	// Stopping early must end the cursor's transaction
+	for range db.StreamActivities(ctx, ActivityFilter{}) {
+		break
+	}
+	if _, err := db.ListActivities(ctx, ActivityFilter{}); err != nil {
+		t.Fatalf("Failed to list activities after stopping a stream: %v", err)
+	}
+}
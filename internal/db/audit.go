@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// audit_events is append-only: updates are rejected by a trigger and rows are
+// only removed by the retention job
+var auditEventSchema = `
+CREATE TABLE IF NOT EXISTS audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	occurred_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	actor_id BIGINT,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL DEFAULT '',
+	target_id TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	payload JSONB
+);
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at_idx ON audit_events (occurred_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action);
+CREATE OR REPLACE FUNCTION audit_events_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_events_immutable ON audit_events;
+CREATE TRIGGER audit_events_immutable BEFORE UPDATE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_immutable();`
+
+var sqliteAuditEventSchema = `
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	occurred_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	actor_id BIGINT,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL DEFAULT '',
+	target_id TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	payload TEXT
+);
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at_idx ON audit_events (occurred_at);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_action_idx ON audit_events (action);
+CREATE TRIGGER IF NOT EXISTS audit_events_immutable BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;`
+
+// Audited actions
+const (
+	AuditLogin             = "auth.login"
+	AuditLogout            = "auth.logout"
+	AuditJWTIssue          = "jwt.issue"
+	AuditJWTRefresh        = "jwt.refresh"
+	AuditJWTRefreshReuse   = "jwt.refresh_reuse"
+	AuditJWTRevoke         = "jwt.revoke"
+	AuditKeyCreate         = "key.create"
+	AuditKeyRevoke         = "key.revoke"
+	AuditKeyRotate         = "key.rotate"
+	AuditSyncTrigger       = "sync.trigger"
+	AuditStravaDeauthorize = "strava.deauthorize"
+	AuditUserDelete        = "user.delete"
+	AuditUserRoleChange    = "user.role_change"
+	AuditWebhookCreate     = "webhook.create"
+	AuditWebhookUpdate     = "webhook.update"
+	AuditWebhookDelete     = "webhook.delete"
+)
+
+type AuditEvent struct {
+	ID         int64           `db:"id"`
+	OccurredAt time.Time       `db:"occurred_at"`
+	ActorID    *int64          `db:"actor_id"`
+	Action     string          `db:"action"`
+	TargetType string          `db:"target_type"`
+	TargetID   string          `db:"target_id"`
+	IP         string          `db:"ip"`
+	UserAgent  string          `db:"user_agent"`
+	Payload    json.RawMessage `db:"payload"`
+}
+
+// AuditFilter restricts the events returned by ListAuditEvents. Zero values
+// are ignored.
+type AuditFilter struct {
+	ActorID    *int64
+	Action     string
+	TargetType string
+	TargetID   string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+	Offset     int
+}
+
+// DB Schema for the audit log
+func (db *DB) CreateAuditSchema() {
+	db.MustExec(dialect(db, auditEventSchema, sqliteAuditEventSchema))
+}
+
+// InsertAuditEvent appends an event to the audit log
+func (db *DB) InsertAuditEvent(ctx context.Context, event AuditEvent) (AuditEvent, error) {
+	var payload interface{}
+	if len(event.Payload) > 0 {
+		payload = string(event.Payload)
+	}
+	query := `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, ip, user_agent, payload)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, occurred_at
+	`
+	err := db.QueryRowContext(ctx, query, event.ActorID, event.Action, event.TargetType, event.TargetID,
+		event.IP, event.UserAgent, payload).Scan(&event.ID, &event.OccurredAt)
+	if err != nil {
+		return AuditEvent{}, fmt.Errorf("error inserting audit event: %w", err)
+	}
+	return event, nil
+}
+
+// ListAuditEvents returns audit events matching the filter, newest first
+func (db *DB) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ActorID != nil {
+		add("actor_id = $%d", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.TargetType != "" {
+		add("target_type = $%d", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		add("target_id = $%d", filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		add("occurred_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("occurred_at < $%d", filter.Until)
+	}
+
+	query := `
+		SELECT id, occurred_at, actor_id, action, target_type, target_id, ip, user_agent, payload
+		FROM audit_events
+	`
+	if len(conditions) > 0 {
+		query += "WHERE " + strings.Join(conditions, " AND ") + "\n"
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	args = append(args, limit, filter.Offset)
+	query += fmt.Sprintf("ORDER BY occurred_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	events := []AuditEvent{}
+	err := db.SelectContext(ctx, &events, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error listing audit events: %w", err)
+	}
+	return events, nil
+}
+
+// PurgeAuditEvents deletes audit events older than the given time
+func (db *DB) PurgeAuditEvents(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM audit_events WHERE occurred_at < $1
+	`
+	result, err := db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("error purging audit events: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
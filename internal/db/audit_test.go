@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func setupTestAuditDB(t *testing.T) *DB {
+	db := setupTestDB(t)
+	db.CreateAuditSchema()
+	return db
+}
+
+func TestInsertAndListAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestAuditDB(t)
+	defer db.Close()
+
+	actorID := int64(42)
+	target := uuid.New().String()
+	event, err := db.InsertAuditEvent(ctx, AuditEvent{
+		ActorID:    &actorID,
+		Action:     AuditKeyCreate,
+		TargetType: "api_key",
+		TargetID:   target,
+		IP:         "127.0.0.1",
+		UserAgent:  "test",
+		Payload:    json.RawMessage(`{"scope":"user"}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to insert audit event: %v", err)
+	}
+	if event.ID == 0 {
+		t.Fatal("Expected audit event to have an ID")
+	}
+
+	events, err := db.ListAuditEvents(ctx, AuditFilter{TargetID: target})
+	if err != nil {
+		t.Fatalf("Failed to list audit events: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != AuditKeyCreate {
+		t.Fatalf("Expected 1 %s event, got %v", AuditKeyCreate, events)
+	}
+
+	events, err = db.ListAuditEvents(ctx, AuditFilter{TargetID: target, Since: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Failed to list audit events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no events after since, got %d", len(events))
+	}
+}
+
+func TestAuditEventsAreImmutable(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestAuditDB(t)
+	defer db.Close()
+
+	event, err := db.InsertAuditEvent(ctx, AuditEvent{Action: AuditLogin})
+	if err != nil {
+		t.Fatalf("Failed to insert audit event: %v", err)
+	}
+
+	if _, err := db.Exec(`UPDATE audit_events SET action = 'tampered' WHERE id = $1`, event.ID); err == nil {
+		t.Fatal("Expected update of audit event to fail")
+	}
+}
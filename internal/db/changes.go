@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// activity_changes is the change data capture log of the activities table.
+// Every insert, update and delete appends a row with the state of the
+// activity after the change, or before it for deletes.
+var activityChangeSchema = `
+CREATE TABLE IF NOT EXISTS activity_changes (
+	seq BIGSERIAL PRIMARY KEY,
+	activity_id BIGINT NOT NULL,
+	athlete_id BIGINT NOT NULL,
+	op VARCHAR(10) NOT NULL,
+	activity JSONB NOT NULL,
+	changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS activity_changes_athlete_idx ON activity_changes (athlete_id, seq);`
+
+var sqliteActivityChangeSchema = `
+CREATE TABLE IF NOT EXISTS activity_changes (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	activity_id BIGINT NOT NULL,
+	athlete_id BIGINT NOT NULL,
+	op VARCHAR(10) NOT NULL,
+	activity TEXT NOT NULL,
+	changed_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);
+CREATE INDEX IF NOT EXISTS activity_changes_athlete_idx ON activity_changes (athlete_id, seq);`
+
+// Change operations
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Activity events published to webhooks and the message broker
+const (
+	EventActivityCreated = "activity.created"
+	EventActivityUpdated = "activity.updated"
+	EventActivityDeleted = "activity.deleted"
+)
+
+// ActivityEvents are the events webhooks can subscribe to
+var ActivityEvents = []string{EventActivityCreated, EventActivityUpdated, EventActivityDeleted}
+
+// changeEvents maps change operations to events
+var changeEvents = map[string]string{
+	ChangeInsert: EventActivityCreated,
+	ChangeUpdate: EventActivityUpdated,
+	ChangeDelete: EventActivityDeleted,
+}
+
+// activityChangesLock is the advisory lock that orders writers of the change
+// log
+const activityChangesLock = 0x61637463 // "actc"
+
+// ActivityChange is an entry of the activity change log. Activity holds the
+// activity as returned by the API.
+type ActivityChange struct {
+	Seq        int64           `db:"seq" json:"seq"`
+	ActivityID int64           `db:"activity_id" json:"activity_id"`
+	AthleteID  int64           `db:"athlete_id" json:"athlete_id"`
+	Op         string          `db:"op" json:"op"`
+	Activity   json.RawMessage `db:"activity" json:"activity"`
+	ChangedAt  time.Time       `db:"changed_at" json:"changed_at"`
+}
+
+// ActivityEvent is the body of webhook deliveries and broker messages
+type ActivityEvent struct {
+	Event      string          `json:"event"`
+	Seq        int64           `json:"seq"` // sequence number in the activity change log
+	OccurredAt time.Time       `json:"occurred_at"`
+	Activity   json.RawMessage `json:"activity"`
+}
+
+// ChangeFilter restricts the changes returned by ListActivityChanges
+type ChangeFilter struct {
+	// Since is the last sequence number the caller has seen
+	Since     int64
+	AthleteID int64
+	Limit     int
+}
+
+// DB Schema for the activity change log
+func (db *DB) CreateActivityChangeSchema() {
+	db.MustExec(dialect(db, activityChangeSchema, sqliteActivityChangeSchema))
+}
+
+// recordActivityChange appends a change to the log within tx, queues the
+// webhook deliveries it triggers and, if events are enabled, writes the event
+// to the outbox. Sequence numbers are drawn while holding a transaction-level
+// advisory lock, so they become visible in commit order: a reader that has
+// seen sequence N never misses a change below N that commits later.
+func (db *DB) recordActivityChange(ctx context.Context, tx *sqlx.Tx, op string, activity Activity) error {
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return fmt.Errorf("error encoding activity change: %w", err)
+	}
+	// SQLite transactions hold the write lock from the start and need no
+	// further locking
+	if !isSQLite(tx) {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, activityChangesLock); err != nil {
+			return fmt.Errorf("error locking activity change log: %w", err)
+		}
+	}
+
+	change := ActivityChange{
+		ActivityID: activity.ID,
+		AthleteID:  activity.AthleteID,
+		Op:         op,
+		Activity:   data,
+	}
+	query := `
+		INSERT INTO activity_changes (activity_id, athlete_id, op, activity)
+		VALUES ($1, $2, $3, $4)
+		RETURNING seq, changed_at
+	`
+	err = tx.QueryRowContext(ctx, query, change.ActivityID, change.AthleteID, op, data).Scan(&change.Seq, &change.ChangedAt)
+	if err != nil {
+		return fmt.Errorf("error recording activity change: %w", err)
+	}
+
+	event := changeEvents[op]
+	payload, err := json.Marshal(ActivityEvent{
+		Event:      event,
+		Seq:        change.Seq,
+		OccurredAt: change.ChangedAt,
+		Activity:   change.Activity,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding activity event: %w", err)
+	}
+	if err := queueWebhookDeliveries(ctx, tx, event, payload, change.AthleteID); err != nil {
+		return err
+	}
+	if db.outbox {
+		return queueOutboxEvent(ctx, tx, event, change, payload)
+	}
+	return nil
+}
+
+// ListActivityChanges returns the changes after filter.Since, oldest first
+func (db *DB) ListActivityChanges(ctx context.Context, filter ChangeFilter) ([]ActivityChange, error) {
+	changes := []ActivityChange{}
+	query := `
+		SELECT * FROM activity_changes
+		WHERE seq > $1 AND ($2 = 0 OR athlete_id = $2)
+		ORDER BY seq
+		LIMIT $3
+	`
+	err := db.SelectContext(ctx, &changes, query, filter.Since, filter.AthleteID, filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("error listing activity changes: %w", err)
+	}
+	return changes, nil
+}
+
+// GetLatestChangeSeq returns the sequence number of the newest change, 0 if
+// the log is empty
+func (db *DB) GetLatestChangeSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	err := db.GetContext(ctx, &seq, `SELECT COALESCE(MAX(seq), 0) FROM activity_changes`)
+	if err != nil {
+		return 0, fmt.Errorf("error reading latest change: %w", err)
+	}
+	return seq, nil
+}
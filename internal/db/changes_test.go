@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestActivityChanges(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestActivityDB(t)
+	defer db.Close()
+
+	since, err := db.GetLatestChangeSeq(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read latest change: %v", err)
+	}
+
+	// Start from a clean slate so the first write is an insert
+	if err := db.DeleteActivity(ctx, 1); err != nil {
+		t.Fatalf("Failed to delete activity: %v", err)
+	}
+	created := createTestActivity(t, db)
+	if _, err := db.CreateActivity(ctx, created); err != nil {
+		t.Fatalf("Failed to upsert activity: %v", err)
+	}
+	created.Name = "Renamed"
+	if _, err := db.UpdateActivity(ctx, created); err != nil {
+		t.Fatalf("Failed to update activity: %v", err)
+	}
+	if err := db.DeleteActivity(ctx, created.ID); err != nil {
+		t.Fatalf("Failed to delete activity: %v", err)
+	}
+
+	changes, err := db.ListActivityChanges(ctx, ChangeFilter{Since: since, AthleteID: created.AthleteID, Limit: 100})
+	if err != nil {
+		t.Fatalf("Failed to list changes: %v", err)
+	}
+	var ops []string
+	for i, change := range changes {
+		if change.ActivityID != created.ID {
+			continue
+		}
+		if i > 0 && change.Seq <= changes[i-1].Seq {
+			t.Fatalf("Expected increasing sequence numbers, got %d after %d", change.Seq, changes[i-1].Seq)
+		}
+		ops = append(ops, change.Op)
+	}
+	want := []string{ChangeInsert, ChangeUpdate, ChangeUpdate, ChangeDelete}
+	if len(ops) < len(want) || !slices.Equal(ops[len(ops)-len(want):], want) {
+		t.Fatalf("Expected changes ending in %v, got %v", want, ops)
+	}
+
+	var last Activity
+	if err := json.Unmarshal(changes[len(changes)-1].Activity, &last); err != nil {
+		t.Fatalf("Failed to decode activity of change: %v", err)
+	}
+	if last.Name != "Renamed" {
+		t.Fatalf("Expected the deleted activity's last state, got name %q", last.Name)
+	}
+
+	latest, err := db.GetLatestChangeSeq(ctx)
+	if err != nil || latest != changes[len(changes)-1].Seq {
+		t.Fatalf("Expected latest change %d, got %d, %v", changes[len(changes)-1].Seq, latest, err)
+	}
+}
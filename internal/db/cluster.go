@@ -0,0 +1,174 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// leader_leases holds one row per elected role. A lease belongs to its
+// holder until it expires; other nodes can only take over an expired lease.
+// cluster_members lists the nodes that have recently checked in.
+var clusterSchema = `
+CREATE TABLE IF NOT EXISTS leader_leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	acquired_at TIMESTAMP NOT NULL,
+	renewed_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cluster_members (
+	id TEXT PRIMARY KEY,
+	started_at TIMESTAMP NOT NULL,
+	last_seen TIMESTAMP NOT NULL
+);`
+
+var sqliteClusterSchema = `
+CREATE TABLE IF NOT EXISTS leader_leases (
+	name TEXT NOT NULL PRIMARY KEY,
+	holder TEXT NOT NULL,
+	acquired_at TIMESTAMP NOT NULL,
+	renewed_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cluster_members (
+	id TEXT NOT NULL PRIMARY KEY,
+	started_at TIMESTAMP NOT NULL,
+	last_seen TIMESTAMP NOT NULL
+);`
+
+type LeaderLease struct {
+	Name       string    `db:"name"`
+	Holder     string    `db:"holder"`
+	AcquiredAt time.Time `db:"acquired_at"`
+	RenewedAt  time.Time `db:"renewed_at"`
+	ExpiresAt  time.Time `db:"expires_at"`
+}
+
+type ClusterMember struct {
+	ID        string    `db:"id"`
+	StartedAt time.Time `db:"started_at"`
+	LastSeen  time.Time `db:"last_seen"`
+}
+
+// DB Schema for leader election
+func (db *DB) CreateClusterSchema() {
+	db.MustExec(dialect(db, clusterSchema, sqliteClusterSchema))
+}
+
+// AcquireLease takes or renews the named lease for holder. It returns false
+// if another holder has a lease that has not expired.
+func (db *DB) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	expiresAt := dialect(db, `NOW() + $3 * INTERVAL '1 millisecond'`,
+		`strftime('%Y-%m-%d %H:%M:%f000', NOW(), ($3 / 1000.0) || ' seconds')`)
+	query := `
+		INSERT INTO leader_leases (name, holder, acquired_at, renewed_at, expires_at)
+		VALUES ($1, $2, NOW(), NOW(), ` + expiresAt + `)
+		ON CONFLICT (name) DO UPDATE SET
+			holder = EXCLUDED.holder,
+			acquired_at = CASE WHEN leader_leases.holder = EXCLUDED.holder
+				THEN leader_leases.acquired_at ELSE NOW() END,
+			renewed_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE leader_leases.holder = EXCLUDED.holder OR leader_leases.expires_at < NOW()
+	`
+	result, err := db.ExecContext(ctx, query, name, holder, ttl.Milliseconds())
+	if err != nil {
+		return false, fmt.Errorf("error acquiring lease %s: %w", name, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// ReleaseLease gives up the named lease if holder holds it, so another node
+// can take over without waiting for it to expire
+func (db *DB) ReleaseLease(ctx context.Context, name, holder string) error {
+	query := `
+		DELETE FROM leader_leases WHERE name = $1 AND holder = $2
+	`
+	_, err := db.ExecContext(ctx, query, name, holder)
+	if err != nil {
+		return fmt.Errorf("error releasing lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetLease returns the named lease, or nil if nobody holds it
+func (db *DB) GetLease(ctx context.Context, name string) (*LeaderLease, error) {
+	var lease LeaderLease
+	query := `
+		SELECT name, holder, acquired_at, renewed_at, expires_at
+		FROM leader_leases
+		WHERE name = $1 AND expires_at >= NOW()
+	`
+	err := db.GetContext(ctx, &lease, query, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading lease %s: %w", name, err)
+	}
+	return &lease, nil
+}
+
+// TouchClusterMember records that a node is alive
+func (db *DB) TouchClusterMember(ctx context.Context, id string, startedAt time.Time) error {
+	query := `
+		INSERT INTO cluster_members (id, started_at, last_seen)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (id) DO UPDATE SET last_seen = NOW()
+	`
+	_, err := db.ExecContext(ctx, query, id, startedAt)
+	if err != nil {
+		return fmt.Errorf("error updating cluster member %s: %w", id, err)
+	}
+	return nil
+}
+
+// RemoveClusterMember removes a node that is shutting down
+func (db *DB) RemoveClusterMember(ctx context.Context, id string) error {
+	query := `
+		DELETE FROM cluster_members WHERE id = $1
+	`
+	_, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error removing cluster member %s: %w", id, err)
+	}
+	return nil
+}
+
+// ListClusterMembers returns the nodes seen since the given time
+func (db *DB) ListClusterMembers(ctx context.Context, seenSince time.Time) ([]ClusterMember, error) {
+	members := []ClusterMember{}
+	query := `
+		SELECT id, started_at, last_seen FROM cluster_members
+		WHERE last_seen >= $1
+		ORDER BY started_at
+	`
+	err := db.SelectContext(ctx, &members, query, seenSince)
+	if err != nil {
+		return nil, fmt.Errorf("error listing cluster members: %w", err)
+	}
+	return members, nil
+}
+
+// PruneClusterMembers deletes nodes not seen since the given time
+func (db *DB) PruneClusterMembers(ctx context.Context, seenBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM cluster_members WHERE last_seen < $1
+	`
+	result, err := db.ExecContext(ctx, query, seenBefore)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning cluster members: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
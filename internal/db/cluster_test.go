@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLeaderLease(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	defer db.Close()
+	db.CreateClusterSchema()
+
+	name := "test_" + uuid.New().String()
+
+	acquired, err := db.AcquireLease(ctx, name, "node-a", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Expected node-a to acquire lease, got %v, %v", acquired, err)
+	}
+
+	acquired, err = db.AcquireLease(ctx, name, "node-b", time.Minute)
+	if err != nil || acquired {
+		t.Fatalf("Expected node-b not to acquire a held lease, got %v, %v", acquired, err)
+	}
+
+	acquired, err = db.AcquireLease(ctx, name, "node-a", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Expected node-a to renew its lease, got %v, %v", acquired, err)
+	}
+
+	if err := db.ReleaseLease(ctx, name, "node-a"); err != nil {
+		t.Fatalf("Failed to release lease: %v", err)
+	}
+
+	acquired, err = db.AcquireLease(ctx, name, "node-b", time.Millisecond)
+	if err != nil || !acquired {
+		t.Fatalf("Expected node-b to acquire released lease, got %v, %v", acquired, err)
+	}
+
+	// node-b's lease expires without renewal, so node-a takes over
+	time.Sleep(10 * time.Millisecond)
+	acquired, err = db.AcquireLease(ctx, name, "node-a", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Expected node-a to take over expired lease, got %v, %v", acquired, err)
+	}
+
+	lease, err := db.GetLease(ctx, name)
+	if err != nil || lease == nil {
+		t.Fatalf("Failed to read lease: %v", err)
+	}
+	if lease.Holder != "node-a" {
+		t.Fatalf("Expected node-a to hold the lease, got %s", lease.Holder)
+	}
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+var coachAthleteSchema = `
+CREATE TABLE IF NOT EXISTS coach_athletes (
+	coach_id BIGINT NOT NULL,
+	athlete_id BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW(),
+	PRIMARY KEY (coach_id, athlete_id)
+);`
+
+var sqliteCoachAthleteSchema = `
+CREATE TABLE IF NOT EXISTS coach_athletes (
+	coach_id BIGINT NOT NULL,
+	athlete_id BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	PRIMARY KEY (coach_id, athlete_id)
+);`
+
+// CoachAthlete links a coach to an athlete whose data the coach may read
+type CoachAthlete struct {
+	CoachID   int64     `db:"coach_id"`
+	AthleteID int64     `db:"athlete_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// DB Schema for coach to athlete links
+func (db *DB) CreateCoachSchema() {
+	db.MustExec(dialect(db, coachAthleteSchema, sqliteCoachAthleteSchema))
+}
+
+// LinkCoachAthlete gives a coach read access to an athlete's data
+func (db *DB) LinkCoachAthlete(ctx context.Context, coachID, athleteID int64) error {
+	query := `
+		INSERT INTO coach_athletes (coach_id, athlete_id)
+		VALUES ($1, $2)
+		ON CONFLICT (coach_id, athlete_id) DO NOTHING
+	`
+	_, err := db.ExecContext(ctx, query, coachID, athleteID)
+	if err != nil {
+		return fmt.Errorf("error linking coach %d to athlete %d: %w", coachID, athleteID, err)
+	}
+	return nil
+}
+
+// UnlinkCoachAthlete removes a coach's access to an athlete's data
+func (db *DB) UnlinkCoachAthlete(ctx context.Context, coachID, athleteID int64) error {
+	query := `
+		DELETE FROM coach_athletes
+		WHERE coach_id = $1 AND athlete_id = $2
+	`
+	_, err := db.ExecContext(ctx, query, coachID, athleteID)
+	if err != nil {
+		return fmt.Errorf("error unlinking coach %d from athlete %d: %w", coachID, athleteID, err)
+	}
+	return nil
+}
+
+// GetCoachAthletes returns the athletes linked to a coach
+func (db *DB) GetCoachAthletes(ctx context.Context, coachID int64) ([]User, error) {
+	users := []User{}
+	query := `
+		SELECT u.id, COALESCE(u.username, '') AS username, u.role, u.created_at, u.updated_at
+		FROM coach_athletes ca
+		JOIN users u ON u.id = ca.athlete_id
+		WHERE ca.coach_id = $1
+		ORDER BY u.id
+	`
+	err := db.SelectContext(ctx, &users, query, coachID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading athletes for coach %d: %w", coachID, err)
+	}
+	return users, nil
+}
+
+// IsCoachOf reports whether the coach is linked to the athlete
+func (db *DB) IsCoachOf(ctx context.Context, coachID, athleteID int64) (bool, error) {
+	var linked bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM coach_athletes WHERE coach_id = $1 AND athlete_id = $2)
+	`
+	err := db.GetContext(ctx, &linked, query, coachID, athleteID)
+	if err != nil {
+		return false, fmt.Errorf("error checking coach link: %w", err)
+	}
+	return linked, nil
+}
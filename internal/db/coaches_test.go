@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func setupTestCoachDB(t *testing.T) *DB {
+	db := setupTestDB(t)
+	db.CreateUserSchema()
+	db.CreateCoachSchema()
+	return db
+}
+
+func TestLinkCoachAthlete(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestCoachDB(t)
+	defer db.Close()
+
+	coachID, athleteID := int64(9001), int64(9002)
+	for _, id := range []int64{coachID, athleteID} {
+		if _, err := db.Exec(`INSERT INTO users (id) VALUES ($1) ON CONFLICT (id) DO NOTHING`, id); err != nil {
+			t.Fatalf("Failed to create test user: %v", err)
+		}
+		defer db.DeleteUser(ctx, id)
+	}
+	if err := db.SetUserRole(ctx, coachID, RoleCoach); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+
+	if err := db.LinkCoachAthlete(ctx, coachID, athleteID); err != nil {
+		t.Fatalf("Failed to link coach: %v", err)
+	}
+	defer db.UnlinkCoachAthlete(ctx, coachID, athleteID)
+
+	linked, err := db.IsCoachOf(ctx, coachID, athleteID)
+	if err != nil {
+		t.Fatalf("Failed to check link: %v", err)
+	}
+	if !linked {
+		t.Fatal("Expected coach to be linked to athlete")
+	}
+
+	athletes, err := db.GetCoachAthletes(ctx, coachID)
+	if err != nil {
+		t.Fatalf("Failed to get athletes: %v", err)
+	}
+	if len(athletes) != 1 || athletes[0].ID != athleteID {
+		t.Fatalf("Expected athlete %d, got %v", athleteID, athletes)
+	}
+
+	if err := db.UnlinkCoachAthlete(ctx, coachID, athleteID); err != nil {
+		t.Fatalf("Failed to unlink coach: %v", err)
+	}
+	if linked, _ := db.IsCoachOf(ctx, coachID, athleteID); linked {
+		t.Fatal("Expected coach link to be removed")
+	}
+}
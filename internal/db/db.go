@@ -2,20 +2,64 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/XSAM/otelsql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // PostgreSQL driver
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
+// SchemaVersion is the schema level InitSchema creates. Bump it with every
+// schema change so readiness checks notice instances running against a
+// database that has not been upgraded yet.
+const SchemaVersion = 4
+
+// schema_version holds a single row with the level of the last InitSchema run
+var schemaVersionSchema = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	version INT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);`
+
+var sqliteSchemaVersionSchema = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	version INT NOT NULL,
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);`
+
+// sqliteConfigDriver is config.DriverSQLite, which New cannot refer to as its
+// parameter shadows the package
+const sqliteConfigDriver = config.DriverSQLite
+
+// spanOptions limit the spans of the instrumented driver to queries and
+// transactions
+var spanOptions = otelsql.SpanOptions{
+	DisableErrSkip:       true,
+	OmitConnResetSession: true,
+	OmitConnPrepare:      true,
+	OmitRows:             true,
+}
+
 // DB represents the database connection
 type DB struct {
 	*sqlx.DB
+	// outbox enables writing activity events to event_outbox for the broker
+	outbox bool
 }
 
 // New creates a new database connection
 func New(config *config.Config) (*DB, error) {
+	if config.Database.Driver == sqliteConfigDriver {
+		return newSQLite(config)
+	}
+
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		config.Database.Host,
 		config.Database.Port,
@@ -25,17 +69,44 @@ func New(config *config.Config) (*DB, error) {
 		config.Database.SSLMode,
 	)
 
-	db, err := sqlx.Connect("postgres", connStr)
+	// The instrumented driver records a span for every query run with a
+	// context that carries a trace
+	sqlDB, err := otelsql.Open("postgres", connStr,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(spanOptions),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("error opening database connection: %w", err)
 	}
+	db := sqlx.NewDb(sqlDB, "postgres")
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
 
-	return &DB{db}, nil
+	return &DB{DB: db, outbox: config.Events.Enabled}, nil
+}
+
+// newSQLite opens the SQLite database file at config.Database.Path, creating
+// it if it does not exist
+func newSQLite(config *config.Config) (*DB, error) {
+	sqlDB, err := otelsql.Open(sqliteDriverName, sqliteDSN(config.Database.Path),
+		otelsql.WithAttributes(semconv.DBSystemSqlite),
+		otelsql.WithSpanOptions(spanOptions),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database connection: %w", err)
+	}
+	db := sqlx.NewDb(sqlDB, sqliteBindDriver)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error opening database %s: %w", config.Database.Path, err)
+	}
+
+	return &DB{DB: db, outbox: config.Events.Enabled}, nil
 }
 
 // Close closes the database connection
@@ -48,4 +119,37 @@ func (db *DB) InitSchema() {
 	db.CreateUserSchema()
 	db.CreateAPIKeySchema()
 	db.CreateActivitySchema()
+	db.CreateActivityChangeSchema()
+	db.CreateTokenSchema()
+	db.CreateCoachSchema()
+	db.CreateTeamSchema()
+	db.CreateAuditSchema()
+	db.CreateSyncSchema()
+	db.CreateJobSchema()
+	db.CreateActivityStreamSchema()
+	db.CreateClusterSchema()
+	db.CreateWebhookSchema()
+	db.CreateOutboxSchema()
+
+	db.MustExec(dialect(db, schemaVersionSchema, sqliteSchemaVersionSchema))
+	db.MustExec(`
+		INSERT INTO schema_version (version) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET
+			version = GREATEST(schema_version.version, EXCLUDED.version),
+			updated_at = NOW()
+	`, SchemaVersion)
+}
+
+// GetSchemaVersion returns the schema level of the database, 0 if InitSchema
+// has never run against it
+func (db *DB) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.GetContext(ctx, &version, `SELECT version FROM schema_version`)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
 }
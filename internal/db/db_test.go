@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+)
+
+// testDriver is the backend the tests currently run against, testSQLitePath
+// the database file used for SQLite
+var (
+	testDriver     = config.DriverPostgres
+	testSQLitePath string
+)
+
+// TestMain runs the tests once against PostgreSQL and once against SQLite.
+// TEST_DB_DRIVER=postgres or TEST_DB_DRIVER=sqlite runs only one of them.
+func TestMain(m *testing.M) {
+	drivers := []string{config.DriverPostgres, config.DriverSQLite}
+	if driver := os.Getenv("TEST_DB_DRIVER"); driver != "" {
+		drivers = []string{driver}
+	}
+
+	dir, err := os.MkdirTemp("", "strava-db-test")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create SQLite directory: %v\n", err)
+		os.Exit(1)
+	}
+	testSQLitePath = filepath.Join(dir, "strava_data.db")
+
+	code := 0
+	for _, driver := range drivers {
+		fmt.Printf("=== Testing against %s\n", driver)
+		testDriver = driver
+		if c := m.Run(); c != 0 {
+			code = c
+		}
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestSchemaVersion(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// InitSchema is idempotent
+	db.InitSchema()
+	db.InitSchema()
+	version, err := db.GetSchemaVersion(context.Background())
+	if err != nil || version != SchemaVersion {
+		t.Fatalf("Expected schema version %d, got %d, %v", SchemaVersion, version, err)
+	}
+}
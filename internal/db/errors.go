@@ -0,0 +1,31 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound is wrapped by the errors returned for rows that do not
+	// exist, e.g. fmt.Errorf("activity %d %w", id, ErrNotFound)
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by the errors returned for writes that would
+	// violate a unique constraint
+	ErrConflict = errors.New("already exists")
+	// ErrRevoked is wrapped by the errors returned for tokens that were
+	// revoked or rotated in the meantime
+	ErrRevoked = errors.New("already revoked")
+)
+
+// uniqueViolation is the PostgreSQL error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err was caused by a unique constraint
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == uniqueViolation
+	}
+	return isSQLiteUniqueViolation(err)
+}
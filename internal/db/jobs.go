@@ -0,0 +1,360 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// jobs is the queue of background work. Workers claim queued jobs with
+// SELECT ... FOR UPDATE SKIP LOCKED, so several workers and server instances
+// can share the queue without handing out a job twice. SQLite runs one write
+// at a time and needs no row locks.
+var jobSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGSERIAL PRIMARY KEY,
+	type TEXT NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	status TEXT NOT NULL DEFAULT 'queued',
+	attempts INT NOT NULL DEFAULT 0,
+	max_attempts INT NOT NULL,
+	run_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	locked_by TEXT,
+	locked_at TIMESTAMP,
+	progress_done INT NOT NULL DEFAULT 0,
+	progress_total INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_by BIGINT,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs (run_at, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_at) WHERE status = 'running';`
+
+var sqliteJobSchema = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	type TEXT NOT NULL,
+	payload TEXT NOT NULL DEFAULT '{}',
+	status TEXT NOT NULL DEFAULT 'queued',
+	attempts INT NOT NULL DEFAULT 0,
+	max_attempts INT NOT NULL,
+	run_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	locked_by TEXT,
+	locked_at TIMESTAMP,
+	progress_done INT NOT NULL DEFAULT 0,
+	progress_total INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_by BIGINT,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS jobs_queued_idx ON jobs (run_at, id) WHERE status = 'queued';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_at) WHERE status = 'running';`
+
+// Job types
+const (
+	JobSync           = "sync"
+	JobBackfill       = "backfill"
+	JobStreamDownload = "stream_download"
+	JobExport         = "export"
+)
+
+// Job states. Failed jobs are queued again until they run out of attempts
+// and are dead-lettered.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+type Job struct {
+	ID            int64           `db:"id"`
+	Type          string          `db:"type"`
+	Payload       json.RawMessage `db:"payload"`
+	Status        string          `db:"status"`
+	Attempts      int             `db:"attempts"`
+	MaxAttempts   int             `db:"max_attempts"`
+	RunAt         time.Time       `db:"run_at"`
+	LockedBy      sql.NullString  `db:"locked_by"`
+	LockedAt      sql.NullTime    `db:"locked_at"`
+	ProgressDone  int             `db:"progress_done"`
+	ProgressTotal int             `db:"progress_total"`
+	LastError     sql.NullString  `db:"last_error"`
+	CreatedBy     *int64          `db:"created_by"`
+	CreatedAt     time.Time       `db:"created_at"`
+	UpdatedAt     time.Time       `db:"updated_at"`
+	FinishedAt    sql.NullTime    `db:"finished_at"`
+}
+
+const jobColumns = `id, type, payload, status, attempts, max_attempts, run_at, locked_by, locked_at,
+	progress_done, progress_total, last_error, created_by, created_at, updated_at, finished_at`
+
+// DB Schema for the job queue
+func (db *DB) CreateJobSchema() {
+	db.MustExec(dialect(db, jobSchema, sqliteJobSchema))
+}
+
+// EnqueueJob adds a job to the queue. createdBy may be 0 for jobs not
+// started by a user.
+func (db *DB) EnqueueJob(ctx context.Context, jobType string, payload interface{}, maxAttempts int, createdBy int64) (Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("error encoding job payload: %w", err)
+	}
+
+	var creator *int64
+	if createdBy != 0 {
+		creator = &createdBy
+	}
+
+	var job Job
+	query := `
+		INSERT INTO jobs (type, payload, max_attempts, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + jobColumns
+	err = db.GetContext(ctx, &job, query, jobType, string(data), maxAttempts, creator)
+	if err != nil {
+		return Job{}, fmt.Errorf("error enqueueing %s job: %w", jobType, err)
+	}
+	return job, nil
+}
+
+func (db *DB) GetJob(ctx context.Context, id int64) (Job, error) {
+	var job Job
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = $1`
+	err := db.GetContext(ctx, &job, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, fmt.Errorf("job %d %w", id, ErrNotFound)
+		}
+		return Job{}, fmt.Errorf("error retrieving job: %w", err)
+	}
+	return job, nil
+}
+
+// ClaimJob locks the next due job for the worker and marks it running. It
+// returns nil if no job is due. Jobs that have used all of their attempts are
+// never claimed.
+func (db *DB) ClaimJob(ctx context.Context, workerID string) (*Job, error) {
+	var job Job
+	query := `
+		UPDATE jobs SET
+			status = 'running',
+			attempts = attempts + 1,
+			locked_by = $1,
+			locked_at = NOW(),
+			updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE status = 'queued' AND run_at <= NOW() AND attempts < max_attempts
+			ORDER BY run_at, id
+			` + dialect(db, "FOR UPDATE SKIP LOCKED", "") + `
+			LIMIT 1
+		)
+		RETURNING ` + jobColumns
+	err := db.GetContext(ctx, &job, query, workerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error claiming job: %w", err)
+	}
+	return &job, nil
+}
+
+// UpdateJobProgress records the progress of a job locked by the worker and
+// extends its lock. It wraps ErrNotFound if the worker no longer holds the
+// job's lock.
+func (db *DB) UpdateJobProgress(ctx context.Context, id int64, workerID string, done, total int) error {
+	query := `
+		UPDATE jobs SET progress_done = $2, progress_total = $3, locked_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'running' AND locked_by = $4
+	`
+	result, err := db.ExecContext(ctx, query, id, done, total, workerID)
+	if err != nil {
+		return fmt.Errorf("error updating progress of job %d: %w", id, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("job %d locked by %s %w", id, workerID, ErrNotFound)
+	}
+	return nil
+}
+
+// HeartbeatJob extends the lock of a job held by the worker so it is not
+// requeued as stale. It wraps ErrNotFound if the worker no longer holds the
+// job's lock.
+func (db *DB) HeartbeatJob(ctx context.Context, id int64, workerID string) error {
+	query := `
+		UPDATE jobs SET locked_at = NOW() WHERE id = $1 AND status = 'running' AND locked_by = $2
+	`
+	result, err := db.ExecContext(ctx, query, id, workerID)
+	if err != nil {
+		return fmt.Errorf("error extending lock of job %d: %w", id, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("job %d locked by %s %w", id, workerID, ErrNotFound)
+	}
+	return nil
+}
+
+// CompleteJob marks a job locked by the worker as succeeded. It wraps
+// ErrNotFound if the worker no longer holds the job's lock.
+func (db *DB) CompleteJob(ctx context.Context, id int64, workerID string) error {
+	query := `
+		UPDATE jobs SET
+			status = 'succeeded',
+			locked_by = NULL,
+			locked_at = NULL,
+			last_error = NULL,
+			updated_at = NOW(),
+			finished_at = NOW()
+		WHERE id = $1 AND locked_by = $2
+	`
+	result, err := db.ExecContext(ctx, query, id, workerID)
+	if err != nil {
+		return fmt.Errorf("error completing job %d: %w", id, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("job %d locked by %s %w", id, workerID, ErrNotFound)
+	}
+	return nil
+}
+
+// FailJob records a failed attempt of a job locked by the worker. The job is
+// queued again at retryAt, or dead-lettered if it has used all of its
+// attempts. It wraps ErrNotFound if the worker no longer holds the job's lock.
+func (db *DB) FailJob(ctx context.Context, id int64, workerID, errMsg string, retryAt time.Time) (Job, error) {
+	var job Job
+	query := `
+		UPDATE jobs SET
+			status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			run_at = CASE WHEN attempts >= max_attempts THEN run_at ELSE $3 END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN NOW() ELSE NULL END,
+			last_error = $2,
+			locked_by = NULL,
+			locked_at = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND locked_by = $4
+		RETURNING ` + jobColumns
+	err := db.GetContext(ctx, &job, query, id, errMsg, retryAt, workerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, fmt.Errorf("job %d locked by %s %w", id, workerID, ErrNotFound)
+		}
+		return Job{}, fmt.Errorf("error failing job %d: %w", id, err)
+	}
+	return job, nil
+}
+
+// ReleaseJob puts a running job back on the queue without counting the
+// attempt, e.g. when the worker shuts down
+func (db *DB) ReleaseJob(ctx context.Context, id int64) error {
+	query := `
+		UPDATE jobs SET
+			status = 'queued',
+			attempts = GREATEST(attempts - 1, 0),
+			locked_by = NULL,
+			locked_at = NULL,
+			updated_at = NOW()
+		WHERE id = $1 AND status = 'running'
+	`
+	_, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error releasing job %d: %w", id, err)
+	}
+	return nil
+}
+
+// JobBacklog describes the jobs that are due but not yet claimed by a worker
+type JobBacklog struct {
+	Due       int64
+	OldestAge time.Duration
+}
+
+// GetJobBacklog returns the number of due jobs and how long the oldest of
+// them has been waiting
+func (db *DB) GetJobBacklog(ctx context.Context) (JobBacklog, error) {
+	var row struct {
+		Due    int64   `db:"due"`
+		MaxAge float64 `db:"max_age"`
+	}
+	age := dialect(db, `EXTRACT(EPOCH FROM NOW() - MIN(run_at))`,
+		`(julianday(NOW()) - julianday(MIN(run_at))) * 86400`)
+	query := `
+		SELECT COUNT(*) AS due, COALESCE(` + age + `, 0) AS max_age
+		FROM jobs
+		WHERE status = 'queued' AND run_at <= NOW()
+	`
+	if err := db.GetContext(ctx, &row, query); err != nil {
+		return JobBacklog{}, fmt.Errorf("error reading job backlog: %w", err)
+	}
+	return JobBacklog{
+		Due:       row.Due,
+		OldestAge: time.Duration(row.MaxAge * float64(time.Second)),
+	}, nil
+}
+
+// LastJobSuccess returns when a job of the given type last succeeded. The
+// boolean is false if none has.
+func (db *DB) LastJobSuccess(ctx context.Context, jobType string) (time.Time, bool, error) {
+	// Selecting the row rather than MAX(finished_at) keeps the column type,
+	// which SQLite needs to return a time
+	var finishedAt time.Time
+	query := `
+		SELECT finished_at FROM jobs
+		WHERE type = $1 AND status = 'succeeded' AND finished_at IS NOT NULL
+		ORDER BY finished_at DESC
+		LIMIT 1
+	`
+	if err := db.GetContext(ctx, &finishedAt, query, jobType); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, fmt.Errorf("error reading last %s job: %w", jobType, err)
+	}
+	return finishedAt, true, nil
+}
+
+// RequeueStaleJobs queues running jobs whose lock has not been extended since
+// the given time again. Their worker is assumed to have crashed, so jobs that
+// have used all of their attempts are dead-lettered instead.
+func (db *DB) RequeueStaleJobs(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	query := `
+		UPDATE jobs SET
+			status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'queued' END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN NOW() ELSE NULL END,
+			last_error = CASE WHEN attempts >= max_attempts THEN 'worker stopped responding' ELSE last_error END,
+			locked_by = NULL,
+			locked_at = NULL,
+			updated_at = NOW()
+		WHERE status = 'running' AND locked_at < $1
+	`
+	result, err := db.ExecContext(ctx, query, lockedBefore)
+	if err != nil {
+		return 0, fmt.Errorf("error requeueing stale jobs: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return rowsAffected, nil
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func setupTestJobDB(t *testing.T) *DB {
+	db := setupTestDB(t)
+	db.CreateJobSchema()
+	// Claimed jobs are global to the table, so start from an empty queue
+	db.MustExec(`DELETE FROM jobs`)
+	return db
+}
+
+func TestClaimAndCompleteJob(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestJobDB(t)
+	defer db.Close()
+
+	job, err := db.EnqueueJob(ctx, JobSync, map[string]int{"days": 1}, 3, 0)
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	if job.Status != JobQueued {
+		t.Fatalf("Expected status %s, got %s", JobQueued, job.Status)
+	}
+
+	backlog, err := db.GetJobBacklog(ctx)
+	if err != nil || backlog.Due != 1 || backlog.OldestAge < 0 || backlog.OldestAge > time.Minute {
+		t.Fatalf("Expected one due job, got %+v, %v", backlog, err)
+	}
+
+	claimed, err := db.ClaimJob(ctx, "worker-1")
+	if err != nil || claimed == nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if claimed.ID != job.ID || claimed.Status != JobRunning || claimed.Attempts != 1 {
+		t.Fatalf("Unexpected claimed job: %+v", claimed)
+	}
+
+	// The only job is locked, so a second worker gets nothing
+	other, err := db.ClaimJob(ctx, "worker-2")
+	if err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if other != nil {
+		t.Fatalf("Expected no job for second worker, got %d", other.ID)
+	}
+
+	if err := db.UpdateJobProgress(ctx, job.ID, "worker-1", 5, 10); err != nil {
+		t.Fatalf("Failed to update progress: %v", err)
+	}
+	if err := db.HeartbeatJob(ctx, job.ID, "worker-1"); err != nil {
+		t.Fatalf("Failed to extend lock: %v", err)
+	}
+	// Only the worker holding the lock may update or finish the job
+	if err := db.UpdateJobProgress(ctx, job.ID, "worker-2", 6, 10); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating another worker's job, got %v", err)
+	}
+	if err := db.HeartbeatJob(ctx, job.ID, "worker-2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound extending another worker's lock, got %v", err)
+	}
+	if err := db.CompleteJob(ctx, job.ID, "worker-2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound completing another worker's job, got %v", err)
+	}
+	if _, err := db.FailJob(ctx, job.ID, "worker-2", "boom", time.Now()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound failing another worker's job, got %v", err)
+	}
+	if err := db.CompleteJob(ctx, job.ID, "worker-1"); err != nil {
+		t.Fatalf("Failed to complete job: %v", err)
+	}
+
+	done, err := db.GetJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if done.Status != JobSucceeded || done.ProgressDone != 5 || !done.FinishedAt.Valid {
+		t.Fatalf("Unexpected completed job: %+v", done)
+	}
+
+	last, ok, err := db.LastJobSuccess(ctx, JobSync)
+	if err != nil || !ok || !last.Equal(done.FinishedAt.Time) {
+		t.Fatalf("Expected the last success at %v, got %v, %v, %v", done.FinishedAt.Time, last, ok, err)
+	}
+	if _, ok, err := db.LastJobSuccess(ctx, JobExport); err != nil || ok {
+		t.Fatalf("Expected no successful export, got %v, %v", ok, err)
+	}
+}
+
+func TestFailJobDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestJobDB(t)
+	defer db.Close()
+
+	job, err := db.EnqueueJob(ctx, JobBackfill, map[string]string{}, 2, 0)
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		claimed, err := db.ClaimJob(ctx, "worker-1")
+		if err != nil || claimed == nil {
+			t.Fatalf("Failed to claim job on attempt %d: %v", attempt, err)
+		}
+		failed, err := db.FailJob(ctx, job.ID, "worker-1", "rate limited", time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatalf("Failed to fail job: %v", err)
+		}
+		expected := JobQueued
+		if attempt == 2 {
+			expected = JobDead
+		}
+		if failed.Status != expected {
+			t.Fatalf("Expected status %s after attempt %d, got %s", expected, attempt, failed.Status)
+		}
+	}
+
+	if claimed, err := db.ClaimJob(ctx, "worker-1"); err != nil || claimed != nil {
+		t.Fatalf("Expected dead job not to be claimed, got %v, %v", claimed, err)
+	}
+}
+
+func TestReleaseAndRequeueStaleJobs(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestJobDB(t)
+	defer db.Close()
+
+	job, err := db.EnqueueJob(ctx, JobStreamDownload, map[string]int64{"activity_id": 1}, 3, 0)
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	if _, err := db.ClaimJob(ctx, "worker-1"); err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if err := db.ReleaseJob(ctx, job.ID); err != nil {
+		t.Fatalf("Failed to release job: %v", err)
+	}
+	released, _ := db.GetJob(ctx, job.ID)
+	if released.Status != JobQueued || released.Attempts != 0 {
+		t.Fatalf("Expected released job to be queued without attempts, got %+v", released)
+	}
+
+	if _, err := db.ClaimJob(ctx, "worker-1"); err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	n, err := db.RequeueStaleJobs(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to requeue stale jobs: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 stale job, got %d", n)
+	}
+
+	requeued, _ := db.GetJob(ctx, job.ID)
+	if requeued.Status != JobQueued || requeued.LockedBy.Valid {
+		t.Fatalf("Expected stale job to be queued again, got %+v", requeued)
+	}
+}
+
+func TestRequeueStaleJobsDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestJobDB(t)
+	defer db.Close()
+
+	job, err := db.EnqueueJob(ctx, JobSync, map[string]int{"days": 1}, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+
+	// The worker crashes on the only attempt
+	if _, err := db.ClaimJob(ctx, "worker-1"); err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if _, err := db.RequeueStaleJobs(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to requeue stale jobs: %v", err)
+	}
+
+	dead, err := db.GetJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if dead.Status != JobDead || !dead.FinishedAt.Valid || !dead.LastError.Valid {
+		t.Fatalf("Expected exhausted stale job to be dead-lettered, got %+v", dead)
+	}
+	if claimed, err := db.ClaimJob(ctx, "worker-1"); err != nil || claimed != nil {
+		t.Fatalf("Expected dead job not to be claimed, got %v, %v", claimed, err)
+	}
+}
+
+func TestClaimJobSkipsExhaustedJobs(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestJobDB(t)
+	defer db.Close()
+
+	job, err := db.EnqueueJob(ctx, JobSync, map[string]int{"days": 1}, 1, 0)
+	if err != nil {
+		t.Fatalf("Failed to enqueue job: %v", err)
+	}
+	// A queued job that already used its attempts, e.g. requeued before the
+	// dead-lettering of stale jobs
+	db.MustExec(`UPDATE jobs SET attempts = 1 WHERE id = $1`, job.ID)
+
+	if claimed, err := db.ClaimJob(ctx, "worker-1"); err != nil || claimed != nil {
+		t.Fatalf("Expected exhausted job not to be claimed, got %v, %v", claimed, err)
+	}
+}
@@ -0,0 +1,576 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps activities, users and API keys in memory. It behaves
+// like the PostgreSQL store, so tests can use it instead of a database. It
+// keeps no activity change log.
+type MemoryStore struct {
+	mu         sync.Mutex
+	activities map[int64]Activity
+	users      map[int64]memoryUser
+	apiKeys    map[int64]APIKey
+	lastKeyID  int64
+	now        func() time.Time
+}
+
+// memoryUser is a user together with their Strava profile
+type memoryUser struct {
+	User
+	athlete Athlete
+}
+
+var (
+	_ ActivityStore = (*MemoryStore)(nil)
+	_ UserStore     = (*MemoryStore)(nil)
+	_ APIKeyStore   = (*MemoryStore)(nil)
+)
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		activities: make(map[int64]Activity),
+		users:      make(map[int64]memoryUser),
+		apiKeys:    make(map[int64]APIKey),
+		now:        time.Now,
+	}
+}
+
+// storedTime returns t the way a TIMESTAMP column gives it back: the wall
+// clock time as UTC, rounded to microseconds
+func storedTime(t time.Time) time.Time {
+	t = t.Round(time.Microsecond)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
+
+// timestamp returns the current time like NOW()
+func (m *MemoryStore) timestamp() time.Time {
+	return storedTime(m.now().UTC())
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 ACTIVITIES                                 */
+/* -------------------------------------------------------------------------- */
+
+// normalizeActivity rounds the times of an activity like the database does
+func normalizeActivity(activity Activity) Activity {
+	activity.StartDate = storedTime(activity.StartDate)
+	activity.StartDateLocal = storedTime(activity.StartDateLocal)
+	return activity
+}
+
+func (m *MemoryStore) CreateActivity(ctx context.Context, activity Activity) (Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	activity = normalizeActivity(activity)
+	now := m.timestamp()
+	activity.CreatedAt = now
+	if stored, ok := m.activities[activity.ID]; ok {
+		activity.CreatedAt = stored.CreatedAt
+	}
+	activity.UpdatedAt = now
+	m.activities[activity.ID] = activity
+	return activity, nil
+}
+
+func (m *MemoryStore) SaveActivity(ctx context.Context, data map[string]interface{}) error {
+	activity, err := activityFromStrava(data)
+	if err != nil {
+		return err
+	}
+	if _, err := m.CreateActivity(ctx, activity); err != nil {
+		return fmt.Errorf("error saving activity %d: %w", activity.ID, err)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetActivityByID(ctx context.Context, id int64) (Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	activity, ok := m.activities[id]
+	if !ok {
+		return Activity{}, fmt.Errorf("activity %d %w", id, ErrNotFound)
+	}
+	return activity, nil
+}
+
+func (m *MemoryStore) UpdateActivity(ctx context.Context, activity Activity) (Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.activities[activity.ID]
+	if !ok {
+		return Activity{}, fmt.Errorf("activity %d %w", activity.ID, ErrNotFound)
+	}
+	activity = normalizeActivity(activity)
+	activity.CreatedAt = stored.CreatedAt
+	activity.UpdatedAt = m.timestamp()
+	m.activities[activity.ID] = activity
+	return activity, nil
+}
+
+func (m *MemoryStore) DeleteActivity(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.activities, id)
+	return nil
+}
+
+// matchActivities returns the activities matching the filter in no
+// particular order
+func (m *MemoryStore) matchActivities(filter ActivityFilter) []Activity {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matches []Activity
+	for _, activity := range m.activities {
+		switch {
+		case filter.AthleteID != 0 && activity.AthleteID != filter.AthleteID,
+			filter.Type != "" && activity.Type != filter.Type,
+			!filter.After.IsZero() && activity.StartDate.Before(storedTime(filter.After)),
+			!filter.Before.IsZero() && !activity.StartDate.Before(storedTime(filter.Before)),
+			!filter.UpdatedAfter.IsZero() && !activity.UpdatedAt.After(storedTime(filter.UpdatedAfter)):
+			continue
+		}
+		matches = append(matches, activity)
+	}
+	return matches
+}
+
+// newestFirst orders activities by start date and ID, newest first
+func newestFirst(a, b Activity) int {
+	return cmp.Or(b.StartDate.Compare(a.StartDate), cmp.Compare(b.ID, a.ID))
+}
+
+// page returns the activities selected by LIMIT limit OFFSET offset
+func page(activities []Activity, limit, offset int) []Activity {
+	offset = min(max(offset, 0), len(activities))
+	return activities[offset:min(offset+max(limit, 0), len(activities))]
+}
+
+func (m *MemoryStore) ListActivities(ctx context.Context, filter ActivityFilter) ([]Activity, error) {
+	matches := m.matchActivities(filter)
+	slices.SortFunc(matches, newestFirst)
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	return append([]Activity{}, page(matches, limit, filter.Offset)...), nil
+}
+
+func (m *MemoryStore) StreamActivities(ctx context.Context, filter ActivityFilter) iter.Seq2[Activity, error] {
+	return func(yield func(Activity, error) bool) {
+		matches := m.matchActivities(filter)
+		slices.SortFunc(matches, func(a, b Activity) int {
+			if filter.GroupByAthlete {
+				if c := cmp.Compare(a.AthleteID, b.AthleteID); c != 0 {
+					return c
+				}
+			}
+			return -newestFirst(a, b)
+		})
+
+		for _, activity := range matches {
+			if err := ctx.Err(); err != nil {
+				yield(Activity{}, fmt.Errorf("error fetching activities: %w", err))
+				return
+			}
+			if !yield(activity, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *MemoryStore) GetActivitiesByAthlete(ctx context.Context, athleteID int64, limit, offset int) ([]Activity, error) {
+	matches := m.matchActivities(ActivityFilter{AthleteID: athleteID})
+	slices.SortFunc(matches, newestFirst)
+
+	var activities []Activity
+	return append(activities, page(matches, limit, offset)...), nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                    USERS                                   */
+/* -------------------------------------------------------------------------- */
+
+func (m *MemoryStore) GetUserByID(ctx context.Context, userID int64) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return User{}, fmt.Errorf("user %d %w", userID, ErrNotFound)
+	}
+	return user.User, nil
+}
+
+func (m *MemoryStore) ListAuthorizedUserIDs(ctx context.Context) ([]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := []int64{}
+	for _, user := range m.users {
+		if user.AccessToken != "" {
+			ids = append(ids, user.ID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (m *MemoryStore) ListUsers(ctx context.Context) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []User{}
+	for _, user := range m.users {
+		users = append(users, User{
+			ID:        user.ID,
+			Username:  user.Username,
+			Role:      user.Role,
+			CreatedAt: user.CreatedAt,
+			UpdatedAt: user.UpdatedAt,
+		})
+	}
+	slices.SortFunc(users, func(a, b User) int { return cmp.Compare(a.ID, b.ID) })
+	return users, nil
+}
+
+func (m *MemoryStore) DeleteUser(ctx context.Context, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.users, userID)
+	return nil
+}
+
+func (m *MemoryStore) GetUserRole(ctx context.Context, userID int64) (Role, error) {
+	user, err := m.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+func (m *MemoryStore) SetUserRole(ctx context.Context, userID int64, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	return m.updateUser(userID, func(user *memoryUser) {
+		user.Role = role
+	})
+}
+
+func (m *MemoryStore) CountUsersWithRole(ctx context.Context, role Role) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, user := range m.users {
+		if user.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStore) SaveAthlete(ctx context.Context, athlete Athlete, accessToken, refreshToken string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.timestamp()
+	user, ok := m.users[athlete.ID]
+	if !ok {
+		user.User = User{
+			ID:        athlete.ID,
+			Username:  athlete.Username,
+			Role:      RoleAthlete,
+			CreatedAt: now,
+		}
+	}
+	user.AthleteID = athlete.ID
+	user.AccessToken = accessToken
+	user.RefreshToken = refreshToken
+	user.TokenExpiresAt = storedTime(expiresAt)
+	user.UpdatedAt = now
+	user.athlete = Athlete{
+		FirstName: athlete.FirstName,
+		LastName:  athlete.LastName,
+		City:      athlete.City,
+		Country:   athlete.Country,
+		Sex:       athlete.Sex,
+	}
+	m.users[athlete.ID] = user
+	return nil
+}
+
+func (m *MemoryStore) GetAthlete(ctx context.Context, userID int64) (Athlete, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return Athlete{}, fmt.Errorf("user %d %w", userID, ErrNotFound)
+	}
+	athlete := user.athlete
+	athlete.ID = user.ID
+	athlete.Username = user.Username
+	athlete.CreatedAt = user.CreatedAt
+	athlete.UpdatedAt = user.UpdatedAt
+	athlete.TokenExpiresAt = user.TokenExpiresAt
+	return athlete, nil
+}
+
+func (m *MemoryStore) SaveUserTokens(ctx context.Context, userID int64, accessToken, refreshToken string, expiresAt time.Time) error {
+	return m.updateUser(userID, func(user *memoryUser) {
+		user.AccessToken = accessToken
+		user.RefreshToken = refreshToken
+		user.TokenExpiresAt = storedTime(expiresAt)
+	})
+}
+
+func (m *MemoryStore) GetUserAccessToken(ctx context.Context, userID int64) (string, error) {
+	user, err := m.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return user.AccessToken, nil
+}
+
+func (m *MemoryStore) ClearUserTokens(ctx context.Context, userID int64) error {
+	// Like the UPDATE it mirrors, clearing the tokens of a missing user is a
+	// no-op
+	err := m.updateUser(userID, func(user *memoryUser) {
+		user.AccessToken = ""
+		user.RefreshToken = ""
+		user.TokenExpiresAt = time.Time{}
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// updateUser changes a user with fn and bumps their updated_at, or returns
+// ErrNotFound
+func (m *MemoryStore) updateUser(userID int64, fn func(user *memoryUser)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return fmt.Errorf("user %d %w", userID, ErrNotFound)
+	}
+	fn(&user)
+	user.UpdatedAt = m.timestamp()
+	m.users[userID] = user
+	return nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                  API KEYS                                  */
+/* -------------------------------------------------------------------------- */
+
+func (m *MemoryStore) CreateAPIKey(ctx context.Context, key, description string, expiresAt *string) (APIKey, error) {
+	expiresAtTime, err := parseKeyExpiry(expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertAPIKey(APIKey{
+		Key:         key,
+		Description: description,
+		ExpiresAt:   storedTime(expiresAtTime),
+		Scope:       ScopeUser,
+	})
+}
+
+// keyByValue returns the ID of an API key, or 0 if it does not exist. The
+// caller holds m.mu.
+func (m *MemoryStore) keyByValue(key string) int64 {
+	for id, apiKey := range m.apiKeys {
+		if apiKey.Key == key {
+			return id
+		}
+	}
+	return 0
+}
+
+func (m *MemoryStore) GetAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.keyByValue(key)
+	if id == 0 {
+		return nil, nil
+	}
+	apiKey := m.apiKeys[id]
+	return &apiKey, nil
+}
+
+func (m *MemoryStore) ValidateAPIKey(ctx context.Context, key string) (bool, error) {
+	apiKey, err := m.GetAPIKey(ctx, key)
+	if err != nil || apiKey == nil || !apiKey.IsActive {
+		return false, err
+	}
+	if !apiKey.ExpiresAt.IsZero() && apiKey.ExpiresAt.Before(time.Now()) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *MemoryStore) ReadAPIKeyByID(ctx context.Context, id int64) (APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apiKey, ok := m.apiKeys[id]
+	if !ok {
+		return APIKey{}, fmt.Errorf("API key %d %w", id, ErrNotFound)
+	}
+	return apiKey, nil
+}
+
+func (m *MemoryStore) ReadApiKeyByUserID(ctx context.Context, userID int64) ([]APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var apiKeys []APIKey
+	for _, apiKey := range m.apiKeys {
+		if apiKey.UserID != nil && *apiKey.UserID == userID {
+			apiKeys = append(apiKeys, apiKey)
+		}
+	}
+	slices.SortFunc(apiKeys, func(a, b APIKey) int { return cmp.Compare(a.ID, b.ID) })
+	return apiKeys, nil
+}
+
+func (m *MemoryStore) CreateUserAPIKey(ctx context.Context, key, description string, expiresAt *string, userID int64, scope string) (APIKey, error) {
+	if scope != ScopeUser && scope != ScopeCoach {
+		return APIKey{}, fmt.Errorf("invalid API key scope %q", scope)
+	}
+	expiresAtTime, err := parseKeyExpiry(expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertAPIKey(APIKey{
+		Key:         key,
+		Description: description,
+		ExpiresAt:   storedTime(expiresAtTime),
+		UserID:      &userID,
+		Scope:       scope,
+	})
+}
+
+func (m *MemoryStore) ReplaceAPIKey(ctx context.Context, oldID int64, key string, expiresAt *string) (APIKey, error) {
+	expiresAtTime, err := parseKeyExpiry(expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.apiKeys[oldID]
+	if !ok || !old.IsActive {
+		return APIKey{}, fmt.Errorf("API key %d %w", oldID, ErrNotFound)
+	}
+	apiKey, err := m.insertAPIKey(APIKey{
+		Key:         key,
+		Description: old.Description,
+		ExpiresAt:   storedTime(expiresAtTime),
+		UserID:      old.UserID,
+		Scope:       old.Scope,
+	})
+	if err != nil {
+		return APIKey{}, err
+	}
+	old.IsActive = false
+	m.apiKeys[oldID] = old
+	return apiKey, nil
+}
+
+// insertAPIKey stores apiKey as a new active key. The caller holds m.mu.
+func (m *MemoryStore) insertAPIKey(apiKey APIKey) (APIKey, error) {
+	if m.keyByValue(apiKey.Key) != 0 {
+		return APIKey{}, fmt.Errorf("API key %w", ErrConflict)
+	}
+	m.lastKeyID++
+	apiKey.ID = m.lastKeyID
+	apiKey.CreatedAt = m.timestamp()
+	apiKey.IsActive = true
+	m.apiKeys[apiKey.ID] = apiKey
+	return apiKey, nil
+}
+
+func (m *MemoryStore) AssociateAPIKeyWithUser(ctx context.Context, apiKey APIKey, userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id := m.keyByValue(apiKey.Key); id != 0 {
+		stored := m.apiKeys[id]
+		stored.UserID = &userID
+		m.apiKeys[id] = stored
+	}
+	return nil
+}
+
+func (m *MemoryStore) SetAPIKeyScope(ctx context.Context, key, scope string) error {
+	if scope != ScopeUser && scope != ScopeCoach {
+		return fmt.Errorf("invalid API key scope %q", scope)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id := m.keyByValue(key); id != 0 {
+		stored := m.apiKeys[id]
+		stored.Scope = scope
+		m.apiKeys[id] = stored
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeactivateAPIKey(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apiKey, ok := m.apiKeys[id]
+	if !ok {
+		return fmt.Errorf("API key %d %w", id, ErrNotFound)
+	}
+	apiKey.IsActive = false
+	m.apiKeys[id] = apiKey
+	return nil
+}
+
+func (m *MemoryStore) DeleteAPIKey(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apiKeys[id]; !ok {
+		return fmt.Errorf("API key %d %w", id, ErrNotFound)
+	}
+	delete(m.apiKeys, id)
+	return nil
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RegisterMetrics registers the connection pool statistics and the job queue
+// depth with reg
+func (db *DB) RegisterMetrics(reg prometheus.Registerer) error {
+	if err := reg.Register(collectors.NewDBStatsCollector(db.DB.DB, db.DriverName())); err != nil {
+		return err
+	}
+	if err := reg.Register(&jobQueueCollector{db: db}); err != nil {
+		return err
+	}
+	if db.outbox {
+		return reg.Register(&outboxCollector{db: db})
+	}
+	return nil
+}
+
+var (
+	jobsDesc = prometheus.NewDesc(
+		"jobs",
+		"Jobs in the queue by type and status.",
+		[]string{"type", "status"}, nil,
+	)
+	jobsOldestQueuedDesc = prometheus.NewDesc(
+		"jobs_oldest_queued_age_seconds",
+		"Age of the oldest job that is due but not yet claimed.",
+		nil, nil,
+	)
+	outboxPendingDesc = prometheus.NewDesc(
+		"event_outbox_pending",
+		"Activity events in the outbox not yet published to the broker.",
+		nil, nil,
+	)
+)
+
+// jobQueueCollector queries the job queue when scraped
+type jobQueueCollector struct {
+	db *DB
+}
+
+func (c *jobQueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- jobsDesc
+	ch <- jobsOldestQueuedDesc
+}
+
+func (c *jobQueueCollector) Collect(ch chan<- prometheus.Metric) {
+	var counts []struct {
+		Type   string `db:"type"`
+		Status string `db:"status"`
+		Count  int64  `db:"count"`
+	}
+	query := `
+		SELECT type, status, COUNT(*) AS count FROM jobs GROUP BY type, status
+	`
+	if err := c.db.Select(&counts, query); err != nil {
+		slog.Error("Error collecting job queue metrics", "error", err)
+		return
+	}
+	for _, row := range counts {
+		ch <- prometheus.MustNewConstMetric(jobsDesc, prometheus.GaugeValue, float64(row.Count), row.Type, row.Status)
+	}
+
+	backlog, err := c.db.GetJobBacklog(context.Background())
+	if err != nil {
+		slog.Error("Error collecting job queue metrics", "error", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(jobsOldestQueuedDesc, prometheus.GaugeValue, backlog.OldestAge.Seconds())
+}
+
+// outboxCollector counts the unpublished events in the outbox when scraped
+type outboxCollector struct {
+	db *DB
+}
+
+func (c *outboxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- outboxPendingDesc
+}
+
+func (c *outboxCollector) Collect(ch chan<- prometheus.Metric) {
+	n, err := c.db.CountPendingOutboxEvents(context.Background())
+	if err != nil {
+		slog.Error("Error collecting outbox metrics", "error", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(outboxPendingDesc, prometheus.GaugeValue, float64(n))
+}
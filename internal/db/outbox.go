@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// event_outbox holds activity events for the message broker. Events are
+// written in the transaction that changes the activity and stay until the
+// relay has published them, so a broker outage delays events but never loses
+// them. IDs are drawn under the change log lock and thus follow commit order.
+var outboxSchema = `
+CREATE TABLE IF NOT EXISTS event_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event TEXT NOT NULL,
+	seq BIGINT NOT NULL,
+	activity_id BIGINT NOT NULL,
+	athlete_id BIGINT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	published_at TIMESTAMP,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS event_outbox_pending_idx ON event_outbox (id) WHERE published_at IS NULL;`
+
+var sqliteOutboxSchema = `
+CREATE TABLE IF NOT EXISTS event_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event TEXT NOT NULL,
+	seq BIGINT NOT NULL,
+	activity_id BIGINT NOT NULL,
+	athlete_id BIGINT NOT NULL,
+	payload TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	published_at TIMESTAMP,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS event_outbox_pending_idx ON event_outbox (id) WHERE published_at IS NULL;`
+
+// OutboxEvent is an activity event waiting to be published. Payload is an
+// encoded ActivityEvent.
+type OutboxEvent struct {
+	ID          int64           `db:"id"`
+	Event       string          `db:"event"`
+	Seq         int64           `db:"seq"`
+	ActivityID  int64           `db:"activity_id"`
+	AthleteID   int64           `db:"athlete_id"`
+	Payload     json.RawMessage `db:"payload"`
+	CreatedAt   time.Time       `db:"created_at"`
+	PublishedAt *time.Time      `db:"published_at"`
+	Attempts    int             `db:"attempts"`
+	LastError   string          `db:"last_error"`
+}
+
+// DB Schema for the event outbox
+func (db *DB) CreateOutboxSchema() {
+	db.MustExec(dialect(db, outboxSchema, sqliteOutboxSchema))
+}
+
+// queueOutboxEvent writes the event of a change to the outbox within tx
+func queueOutboxEvent(ctx context.Context, tx *sqlx.Tx, event string, change ActivityChange, payload []byte) error {
+	query := `
+		INSERT INTO event_outbox (event, seq, activity_id, athlete_id, payload)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := tx.ExecContext(ctx, query, event, change.Seq, change.ActivityID, change.AthleteID, payload); err != nil {
+		return fmt.Errorf("error writing event to outbox: %w", err)
+	}
+	return nil
+}
+
+// ListPendingOutboxEvents returns up to limit unpublished events, oldest first
+func (db *DB) ListPendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	query := `
+		SELECT * FROM event_outbox
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`
+	if err := db.SelectContext(ctx, &events, query, limit); err != nil {
+		return nil, fmt.Errorf("error listing outbox events: %w", err)
+	}
+	return events, nil
+}
+
+// MarkOutboxEventsPublished marks events as published
+func (db *DB) MarkOutboxEventsPublished(ctx context.Context, ids []int64) error {
+	query := `
+		UPDATE event_outbox SET published_at = NOW(), attempts = attempts + 1
+		WHERE ` + dialect(db, `id = ANY ($1)`, `array_contains($1, id)`)
+	if _, err := db.ExecContext(ctx, query, pq.Array(ids)); err != nil {
+		return fmt.Errorf("error marking outbox events published: %w", err)
+	}
+	return nil
+}
+
+// RecordOutboxFailure records a failed attempt to publish an event
+func (db *DB) RecordOutboxFailure(ctx context.Context, id int64, cause string) error {
+	query := `
+		UPDATE event_outbox SET attempts = attempts + 1, last_error = $2
+		WHERE id = $1
+	`
+	if _, err := db.ExecContext(ctx, query, id, cause); err != nil {
+		return fmt.Errorf("error recording outbox failure of event %d: %w", id, err)
+	}
+	return nil
+}
+
+// CountPendingOutboxEvents returns the number of unpublished events
+func (db *DB) CountPendingOutboxEvents(ctx context.Context) (int64, error) {
+	var n int64
+	if err := db.GetContext(ctx, &n, `SELECT COUNT(*) FROM event_outbox WHERE published_at IS NULL`); err != nil {
+		return 0, fmt.Errorf("error counting outbox events: %w", err)
+	}
+	return n, nil
+}
+
+// PruneOutboxEvents deletes events published before the given time
+func (db *DB) PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	result, err := db.ExecContext(ctx, `DELETE FROM event_outbox WHERE published_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning outbox events: %w", err)
+	}
+	return result.RowsAffected()
+}
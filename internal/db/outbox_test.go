@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestActivityDB(t)
+	defer db.Close()
+
+	// Drain what earlier tests left behind
+	for {
+		pending, err := db.ListPendingOutboxEvents(ctx, 100)
+		if err != nil {
+			t.Fatalf("Failed to list outbox: %v", err)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		ids := make([]int64, len(pending))
+		for i, event := range pending {
+			ids[i] = event.ID
+		}
+		if err := db.MarkOutboxEventsPublished(ctx, ids); err != nil {
+			t.Fatalf("Failed to mark events published: %v", err)
+		}
+	}
+
+	// Without the outbox enabled no events are written
+	activity := createTestActivity(t, db)
+	if pending, _ := db.ListPendingOutboxEvents(ctx, 10); len(pending) != 0 {
+		t.Fatalf("Expected no events with the outbox disabled, got %d", len(pending))
+	}
+
+	db.outbox = true
+	activity.Name = "Outbox test"
+	if _, err := db.UpdateActivity(ctx, activity); err != nil {
+		t.Fatalf("Failed to update activity: %v", err)
+	}
+	if err := db.DeleteActivity(ctx, activity.ID); err != nil {
+		t.Fatalf("Failed to delete activity: %v", err)
+	}
+
+	pending, err := db.ListPendingOutboxEvents(ctx, 10)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("Expected 2 events, got %d, %v", len(pending), err)
+	}
+	if pending[0].Event != EventActivityUpdated || pending[1].Event != EventActivityDeleted || pending[0].Seq >= pending[1].Seq {
+		t.Fatalf("Expected the update before the delete, got %+v", pending)
+	}
+
+	if err := db.RecordOutboxFailure(ctx, pending[0].ID, "broker down"); err != nil {
+		t.Fatalf("Failed to record failure: %v", err)
+	}
+	if err := db.MarkOutboxEventsPublished(ctx, []int64{pending[0].ID, pending[1].ID}); err != nil {
+		t.Fatalf("Failed to mark events published: %v", err)
+	}
+	if n, err := db.CountPendingOutboxEvents(ctx); err != nil || n != 0 {
+		t.Fatalf("Expected no pending events, got %d, %v", n, err)
+	}
+	if n, err := db.PruneOutboxEvents(ctx, time.Now().Add(time.Minute)); err != nil || n < 2 {
+		t.Fatalf("Expected published events to be pruned, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SQLite support. Most queries are shared with PostgreSQL; the driver in
+// sqlite_driver.go bridges the differences that would otherwise need a second
+// copy of every query:
+//   - $1 placeholders are bound by number rather than by order of appearance
+//   - times are stored as UTC text with a fixed number of fractional digits,
+//     so they compare in chronological order
+//   - text is returned as []byte like lib/pq does, so JSON columns scan into
+//     json.RawMessage
+//   - NOW(), GREATEST and array_contains, which stands in for = ANY, are
+//     provided as functions
+//
+// Statements that SQLite cannot express the same way are picked per driver
+// with isSQLite. Transactions take the write lock when they begin, so
+// writers are serialized and need neither row locks nor advisory locks.
+
+// sqliteDriverName is the name the SQLite driver is registered under.
+// sqliteBindDriver is the name sqlx knows it by; it selects ? bind variables
+// for named queries.
+const (
+	sqliteDriverName = "sqlite3_strava"
+	sqliteBindDriver = "sqlite3"
+)
+
+// sqliteTimeFormat matches the defaults of TIMESTAMP columns in the SQLite
+// schema
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000"
+
+// sqliteDSN returns the data source name for the database file at path
+func sqliteDSN(path string) string {
+	return path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+}
+
+// isSQLite reports whether a database handle or transaction runs against
+// SQLite
+func isSQLite(q interface{ DriverName() string }) bool {
+	return q.DriverName() == sqliteBindDriver
+}
+
+// dialect returns the query written for the driver of q
+func dialect(q interface{ DriverName() string }, postgres, sqlite string) string {
+	if isSQLite(q) {
+		return sqlite
+	}
+	return postgres
+}
+
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Round(time.Microsecond).Format(sqliteTimeFormat)
+}
+
+// sqliteGreatest returns the largest of its arguments. Like GREATEST in
+// PostgreSQL and unlike SQLite's max it ignores NULLs.
+func sqliteGreatest(args []driver.Value) driver.Value {
+	var greatest driver.Value
+	for _, arg := range args {
+		if arg == nil {
+			continue
+		}
+		if greatest == nil || sqliteLess(greatest, arg) {
+			greatest = arg
+		}
+	}
+	return greatest
+}
+
+func sqliteLess(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return a < b
+		case float64:
+			return float64(a) < b
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return a < float64(b)
+		case float64:
+			return a < b
+		}
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// sqliteArrayContains reports whether a PostgreSQL array literal as written
+// by pq.Array, e.g. {1,2,3}, contains value
+func sqliteArrayContains(array string, value interface{}) (bool, error) {
+	var elements pq.StringArray
+	if err := elements.Scan(array); err != nil {
+		return false, err
+	}
+	want := fmt.Sprint(value)
+	if b, ok := value.([]byte); ok {
+		want = string(b)
+	}
+	for _, element := range elements {
+		if element == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
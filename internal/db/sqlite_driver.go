@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+func init() {
+	registerSQLiteFunctions()
+
+	// Functions registered with the sqlite package reach the connections of
+	// the driver it registered as "sqlite", so that driver is wrapped rather
+	// than a new sqlite.Driver. Opening a handle does not connect.
+	base, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(fmt.Sprintf("db: error loading the SQLite driver: %v", err))
+	}
+	sql.Register(sqliteDriverName, sqliteDriver{base.Driver()})
+	base.Close()
+}
+
+func registerSQLiteFunctions() {
+	now := func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return formatSQLiteTime(time.Now()), nil
+	}
+	greatest := func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return sqliteGreatest(args), nil
+	}
+	arrayContains := func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		switch array := args[0].(type) {
+		case nil:
+			return nil, nil
+		case []byte:
+			return sqliteArrayContains(string(array), args[1])
+		case string:
+			return sqliteArrayContains(array, args[1])
+		default:
+			return nil, fmt.Errorf("array_contains: unexpected array %T", array)
+		}
+	}
+	sqlite.MustRegisterScalarFunction("now", 0, now)
+	sqlite.MustRegisterDeterministicScalarFunction("greatest", -1, greatest)
+	sqlite.MustRegisterDeterministicScalarFunction("array_contains", 2, arrayContains)
+}
+
+// isSQLiteUniqueViolation reports whether err was caused by a unique
+// constraint of SQLite
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
+
+type sqliteDriver struct {
+	driver.Driver
+}
+
+func (d sqliteDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{conn.(sqliteBaseConn)}, nil
+}
+
+// sqliteBaseConn lists the interfaces of the connections of the sqlite
+// package that database/sql uses
+type sqliteBaseConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+	driver.SessionResetter
+	driver.Validator
+}
+
+type sqliteConn struct {
+	sqliteBaseConn
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.sqliteBaseConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStmt{stmt.(sqliteBaseStmt)}, nil
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := c.sqliteBaseConn.QueryContext(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteRows{rows}, nil
+}
+
+// CheckNamedValue converts arguments to the types SQLite stores
+func (c *sqliteConn) CheckNamedValue(nv *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := value.(time.Time); ok {
+		value = formatSQLiteTime(t)
+	}
+	nv.Value = value
+	return nil
+}
+
+type sqliteBaseStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+}
+
+type sqliteStmt struct {
+	sqliteBaseStmt
+}
+
+func (s *sqliteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	rows, err := s.sqliteBaseStmt.QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteRows{rows}, nil
+}
+
+type sqliteRows struct {
+	driver.Rows
+}
+
+// Next returns text as []byte, like lib/pq does
+func (r sqliteRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	for i, value := range dest {
+		if s, ok := value.(string); ok {
+			dest[i] = []byte(s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"iter"
+	"time"
+)
+
+// ActivityStore stores the activities synced from Strava
+type ActivityStore interface {
+	// CreateActivity inserts an activity or replaces the stored one with the
+	// same ID
+	CreateActivity(ctx context.Context, activity Activity) (Activity, error)
+	// SaveActivity stores an activity in the shape returned by the Strava API
+	SaveActivity(ctx context.Context, data map[string]interface{}) error
+	GetActivityByID(ctx context.Context, id int64) (Activity, error)
+	// UpdateActivity overwrites a stored activity, ErrNotFound if it is missing
+	UpdateActivity(ctx context.Context, activity Activity) (Activity, error)
+	// DeleteActivity deletes an activity. Deleting a missing activity is not
+	// an error.
+	DeleteActivity(ctx context.Context, id int64) error
+	// ListActivities returns the activities matching the filter, newest first
+	ListActivities(ctx context.Context, filter ActivityFilter) ([]Activity, error)
+	// StreamActivities yields every activity matching the filter, oldest first
+	StreamActivities(ctx context.Context, filter ActivityFilter) iter.Seq2[Activity, error]
+	GetActivitiesByAthlete(ctx context.Context, athleteID int64, limit, offset int) ([]Activity, error)
+}
+
+// UserStore stores users, their roles and their Strava tokens
+type UserStore interface {
+	GetUserByID(ctx context.Context, userID int64) (User, error)
+	// ListUsers returns all users ordered by ID, without their tokens
+	ListUsers(ctx context.Context) ([]User, error)
+	DeleteUser(ctx context.Context, userID int64) error
+	GetUserRole(ctx context.Context, userID int64) (Role, error)
+	SetUserRole(ctx context.Context, userID int64, role Role) error
+	CountUsersWithRole(ctx context.Context, role Role) (int, error)
+	// SaveAthlete creates or updates the user of a Strava athlete together
+	// with their tokens
+	SaveAthlete(ctx context.Context, athlete Athlete, accessToken, refreshToken string, expiresAt time.Time) error
+	GetAthlete(ctx context.Context, userID int64) (Athlete, error)
+	SaveUserTokens(ctx context.Context, userID int64, accessToken, refreshToken string, expiresAt time.Time) error
+	GetUserAccessToken(ctx context.Context, userID int64) (string, error)
+	ClearUserTokens(ctx context.Context, userID int64) error
+	// ListAuthorizedUserIDs returns the IDs of the users with a Strava access
+	// token, ordered by ID
+	ListAuthorizedUserIDs(ctx context.Context) ([]int64, error)
+}
+
+// APIKeyStore stores API keys and the users they belong to
+type APIKeyStore interface {
+	// CreateAPIKey stores a new active key, ErrConflict if it exists
+	CreateAPIKey(ctx context.Context, key, description string, expiresAt *string) (APIKey, error)
+	// CreateUserAPIKey stores a new active key with its owner and scope
+	CreateUserAPIKey(ctx context.Context, key, description string, expiresAt *string, userID int64, scope string) (APIKey, error)
+	// ReplaceAPIKey atomically deactivates an active key and stores its
+	// replacement with the same description, owner and scope
+	ReplaceAPIKey(ctx context.Context, oldID int64, key string, expiresAt *string) (APIKey, error)
+	// GetAPIKey returns the record for a key, or nil if the key does not exist
+	GetAPIKey(ctx context.Context, key string) (*APIKey, error)
+	// ValidateAPIKey reports whether a key exists, is active and not expired
+	ValidateAPIKey(ctx context.Context, key string) (bool, error)
+	ReadAPIKeyByID(ctx context.Context, id int64) (APIKey, error)
+	// ReadApiKeyByUserID returns the keys of a user ordered by ID
+	ReadApiKeyByUserID(ctx context.Context, userID int64) ([]APIKey, error)
+	AssociateAPIKeyWithUser(ctx context.Context, apiKey APIKey, userID int64) error
+	SetAPIKeyScope(ctx context.Context, key, scope string) error
+	DeactivateAPIKey(ctx context.Context, id int64) error
+	DeleteAPIKey(ctx context.Context, id int64) error
+}
+
+var (
+	_ ActivityStore = (*DB)(nil)
+	_ UserStore     = (*DB)(nil)
+	_ APIKeyStore   = (*DB)(nil)
+)
@@ -0,0 +1,402 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// conformanceStore is what the store conformance tests exercise
+type conformanceStore interface {
+	ActivityStore
+	UserStore
+	APIKeyStore
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestDBStore(t *testing.T) {
+	db := setupTestActivityDB(t)
+	defer db.Close()
+	db.CreateAPIKeySchema()
+
+	testStore(t, db)
+}
+
+// testStore checks that a store behaves like the PostgreSQL store. It only
+// touches rows it creates, so it can run against a shared database.
+func testStore(t *testing.T, store conformanceStore) {
+	t.Run("Activities", func(t *testing.T) { testActivityStore(t, store) })
+	t.Run("Users", func(t *testing.T) { testUserStore(t, store) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeyStore(t, store) })
+	t.Run("UserAPIKeys", func(t *testing.T) { testUserAPIKeyStore(t, store) })
+}
+
+// uniqueID returns an ID no other test run uses
+func uniqueID() int64 {
+	return time.Now().UnixNano() / 1000
+}
+
+func activityIDs(activities []Activity) []int64 {
+	ids := make([]int64, len(activities))
+	for i, activity := range activities {
+		ids[i] = activity.ID
+	}
+	return ids
+}
+
+func testActivityStore(t *testing.T, store ActivityStore) {
+	ctx := context.Background()
+	athleteID := uniqueID()
+	day := time.Date(2024, 5, 1, 7, 30, 0, 0, time.UTC)
+
+	var ids []int64
+	for i, activityType := range []string{"Run", "Run", "Ride"} {
+		activity, err := store.CreateActivity(ctx, Activity{
+			ID:        athleteID + int64(i),
+			Name:      "Morning " + activityType,
+			Type:      activityType,
+			Distance:  1000 * float64(i+1),
+			StartDate: day.AddDate(0, 0, i),
+			AthleteID: athleteID,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create activity: %v", err)
+		}
+		if activity.CreatedAt.IsZero() || !activity.StartDate.Equal(day.AddDate(0, 0, i)) {
+			t.Fatalf("Unexpected activity %+v", activity)
+		}
+		ids = append(ids, activity.ID)
+		defer store.DeleteActivity(ctx, activity.ID)
+	}
+
+	// Creating an existing activity replaces it
+	first, err := store.GetActivityByID(ctx, ids[0])
+	if err != nil {
+		t.Fatalf("Failed to get activity: %v", err)
+	}
+	first.Name = "Renamed"
+	replaced, err := store.CreateActivity(ctx, first)
+	if err != nil {
+		t.Fatalf("Failed to replace activity: %v", err)
+	}
+	if replaced.Name != "Renamed" || !replaced.CreatedAt.Equal(first.CreatedAt) || replaced.UpdatedAt.Before(first.UpdatedAt) {
+		t.Fatalf("Expected the renamed activity with its creation time, got %+v", replaced)
+	}
+
+	if _, err := store.GetActivityByID(ctx, athleteID+10); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for a missing activity, got %v", err)
+	}
+	if _, err := store.UpdateActivity(ctx, Activity{ID: athleteID + 10}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating a missing activity, got %v", err)
+	}
+	replaced.Distance = 1500
+	updated, err := store.UpdateActivity(ctx, replaced)
+	if err != nil || updated.Distance != 1500 || !updated.CreatedAt.Equal(first.CreatedAt) {
+		t.Fatalf("Expected the updated activity, got %+v, %v", updated, err)
+	}
+
+	for name, tt := range map[string]struct {
+		filter ActivityFilter
+		want   []int64
+	}{
+		"athlete":       {ActivityFilter{AthleteID: athleteID}, []int64{ids[2], ids[1], ids[0]}},
+		"type":          {ActivityFilter{AthleteID: athleteID, Type: "Run"}, []int64{ids[1], ids[0]}},
+		"dates":         {ActivityFilter{AthleteID: athleteID, After: day.AddDate(0, 0, 1), Before: day.AddDate(0, 0, 2)}, []int64{ids[1]}},
+		"page":          {ActivityFilter{AthleteID: athleteID, Limit: 1, Offset: 1}, []int64{ids[1]}},
+		"updated":       {ActivityFilter{AthleteID: athleteID, UpdatedAfter: time.Now().UTC().Add(24 * time.Hour)}, []int64{}},
+		"other athlete": {ActivityFilter{AthleteID: athleteID + 10}, []int64{}},
+	} {
+		activities, err := store.ListActivities(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("%s: failed to list activities: %v", name, err)
+		}
+		if activities == nil || !slices.Equal(activityIDs(activities), tt.want) {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, activityIDs(activities))
+		}
+	}
+
+	var streamed []int64
+	for activity, err := range store.StreamActivities(ctx, ActivityFilter{AthleteID: athleteID, Limit: 1}) {
+		if err != nil {
+			t.Fatalf("Failed to stream activities: %v", err)
+		}
+		streamed = append(streamed, activity.ID)
+	}
+	if !slices.Equal(streamed, ids) {
+		t.Fatalf("Expected all activities oldest first, got %v", streamed)
+	}
+
+	byAthlete, err := store.GetActivitiesByAthlete(ctx, athleteID, 2, 0)
+	if err != nil || !slices.Equal(activityIDs(byAthlete), []int64{ids[2], ids[1]}) {
+		t.Fatalf("Expected the two newest activities, got %v, %v", activityIDs(byAthlete), err)
+	}
+	if none, err := store.GetActivitiesByAthlete(ctx, athleteID+10, 2, 0); err != nil || len(none) != 0 {
+		t.Fatalf("Expected no activities, got %v, %v", none, err)
+	}
+
+	// Activities in the shape of the Strava API
+	savedID := athleteID + 3
+	defer store.DeleteActivity(ctx, savedID)
+	err = store.SaveActivity(ctx, map[string]interface{}{
+		"id":           savedID,
+		"name":         "Lunch Ride",
+		"type":         "Ride",
+		"start_date":   "2024-05-04T12:00:00Z",
+		"start_latlng": []float64{52.5, 13.4},
+		"upload_id":    7,
+		"athlete":      map[string]interface{}{"id": athleteID},
+	})
+	if err != nil {
+		t.Fatalf("Failed to save activity: %v", err)
+	}
+	saved, err := store.GetActivityByID(ctx, savedID)
+	if err != nil || saved.AthleteID != athleteID || saved.StartLatLng != "52.5,13.4" || saved.UploadIDStr != "7" {
+		t.Fatalf("Unexpected saved activity %+v, %v", saved, err)
+	}
+
+	if err := store.DeleteActivity(ctx, ids[0]); err != nil {
+		t.Fatalf("Failed to delete activity: %v", err)
+	}
+	if err := store.DeleteActivity(ctx, ids[0]); err != nil {
+		t.Fatalf("Expected deleting a missing activity to succeed, got %v", err)
+	}
+	if _, err := store.GetActivityByID(ctx, ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound after deleting, got %v", err)
+	}
+}
+
+func testUserStore(t *testing.T, store UserStore) {
+	ctx := context.Background()
+	userID := uniqueID()
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	coaches, err := store.CountUsersWithRole(ctx, RoleCoach)
+	if err != nil {
+		t.Fatalf("Failed to count coaches: %v", err)
+	}
+
+	athlete := Athlete{ID: userID, FirstName: "Jane", LastName: "Doe", City: "Berlin", Country: "Germany", Sex: "F"}
+	if err := store.SaveAthlete(ctx, athlete, "access", "refresh", expiresAt); err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+	defer store.DeleteUser(ctx, userID)
+
+	user, err := store.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.AthleteID != userID || user.Role != RoleAthlete || user.AccessToken != "access" ||
+		user.RefreshToken != "refresh" || !user.TokenExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Unexpected user %+v", user)
+	}
+
+	// Saving the athlete again updates the profile
+	athlete.City = "Hamburg"
+	if err := store.SaveAthlete(ctx, athlete, "access2", "refresh2", time.Time{}); err != nil {
+		t.Fatalf("Failed to update athlete: %v", err)
+	}
+	profile, err := store.GetAthlete(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to get athlete: %v", err)
+	}
+	if profile.FirstName != "Jane" || profile.City != "Hamburg" || !profile.TokenExpiresAt.IsZero() || !profile.CreatedAt.Equal(user.CreatedAt) {
+		t.Fatalf("Unexpected athlete %+v", profile)
+	}
+
+	if err := store.SaveUserTokens(ctx, userID, "access3", "refresh3", expiresAt); err != nil {
+		t.Fatalf("Failed to save tokens: %v", err)
+	}
+	if token, err := store.GetUserAccessToken(ctx, userID); err != nil || token != "access3" {
+		t.Fatalf("Expected the saved access token, got %q, %v", token, err)
+	}
+	if ids, err := store.ListAuthorizedUserIDs(ctx); err != nil || !slices.Contains(ids, userID) {
+		t.Fatalf("Expected the user to be authorized, got %v, %v", ids, err)
+	}
+	if err := store.ClearUserTokens(ctx, userID); err != nil {
+		t.Fatalf("Failed to clear tokens: %v", err)
+	}
+	if user, _ := store.GetUserByID(ctx, userID); user.AccessToken != "" || user.RefreshToken != "" || !user.TokenExpiresAt.IsZero() {
+		t.Fatalf("Expected no tokens, got %+v", user)
+	}
+	if ids, err := store.ListAuthorizedUserIDs(ctx); err != nil || slices.Contains(ids, userID) {
+		t.Fatalf("Expected the user to be unauthorized, got %v, %v", ids, err)
+	}
+
+	if err := store.SetUserRole(ctx, userID, "admin"); err == nil {
+		t.Fatal("Expected an error for an invalid role")
+	}
+	if err := store.SetUserRole(ctx, userID, RoleCoach); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	if role, err := store.GetUserRole(ctx, userID); err != nil || role != RoleCoach {
+		t.Fatalf("Expected coach, got %q, %v", role, err)
+	}
+	if n, err := store.CountUsersWithRole(ctx, RoleCoach); err != nil || n != coaches+1 {
+		t.Fatalf("Expected %d coaches, got %d, %v", coaches+1, n, err)
+	}
+
+	users, err := store.ListUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	i := slices.IndexFunc(users, func(u User) bool { return u.ID == userID })
+	if i < 0 || users[i].Role != RoleCoach || users[i].AccessToken != "" {
+		t.Fatalf("Expected the user without tokens in %+v", users)
+	}
+	if !slices.IsSortedFunc(users, func(a, b User) int { return cmp.Compare(a.ID, b.ID) }) {
+		t.Fatal("Expected users ordered by ID")
+	}
+
+	missing := userID + 1
+	for name, err := range map[string]error{
+		"GetUserByID":    errOnly(store.GetUserByID(ctx, missing)),
+		"GetAthlete":     errOnly(store.GetAthlete(ctx, missing)),
+		"GetUserRole":    errOnly(store.GetUserRole(ctx, missing)),
+		"GetAccessToken": errOnly(store.GetUserAccessToken(ctx, missing)),
+		"SetUserRole":    store.SetUserRole(ctx, missing, RoleCoach),
+		"SaveUserTokens": store.SaveUserTokens(ctx, missing, "a", "r", expiresAt),
+	} {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound for a missing user, got %v", name, err)
+		}
+	}
+	if err := store.ClearUserTokens(ctx, missing); err != nil {
+		t.Errorf("Expected clearing the tokens of a missing user to succeed, got %v", err)
+	}
+
+	if err := store.DeleteUser(ctx, userID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := store.GetUserByID(ctx, userID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound after deleting, got %v", err)
+	}
+}
+
+// errOnly drops the value of a (value, error) result
+func errOnly[T any](_ T, err error) error {
+	return err
+}
+
+func testAPIKeyStore(t *testing.T, store APIKeyStore) {
+	ctx := context.Background()
+	userID := uniqueID()
+	key := "conformance-" + time.Now().Format(time.RFC3339Nano)
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	created, err := store.CreateAPIKey(ctx, key, "Conformance", &expiresAt)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	defer store.DeleteAPIKey(ctx, created.ID)
+	if created.ID == 0 || !created.IsActive || created.Scope != ScopeUser || created.UserID != nil {
+		t.Fatalf("Unexpected API key %+v", created)
+	}
+	if _, err := store.CreateAPIKey(ctx, key, "Duplicate", nil); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict for a duplicate key, got %v", err)
+	}
+	invalid := "tomorrow"
+	if _, err := store.CreateAPIKey(ctx, key+"-invalid", "Invalid", &invalid); err == nil {
+		t.Fatal("Expected an error for an invalid expiry")
+	}
+
+	if valid, err := store.ValidateAPIKey(ctx, key); err != nil || !valid {
+		t.Fatalf("Expected the key to be valid, got %v, %v", valid, err)
+	}
+	if valid, err := store.ValidateAPIKey(ctx, key+"-missing"); err != nil || valid {
+		t.Fatalf("Expected a missing key to be invalid, got %v, %v", valid, err)
+	}
+	if apiKey, err := store.GetAPIKey(ctx, key+"-missing"); err != nil || apiKey != nil {
+		t.Fatalf("Expected no record for a missing key, got %+v, %v", apiKey, err)
+	}
+
+	if keys, err := store.ReadApiKeyByUserID(ctx, userID); err != nil || len(keys) != 0 {
+		t.Fatalf("Expected no keys for the user, got %+v, %v", keys, err)
+	}
+	if err := store.AssociateAPIKeyWithUser(ctx, created, userID); err != nil {
+		t.Fatalf("Failed to associate key: %v", err)
+	}
+	if err := store.SetAPIKeyScope(ctx, key, "admin"); err == nil {
+		t.Fatal("Expected an error for an invalid scope")
+	}
+	if err := store.SetAPIKeyScope(ctx, key, ScopeCoach); err != nil {
+		t.Fatalf("Failed to set scope: %v", err)
+	}
+	apiKey, err := store.GetAPIKey(ctx, key)
+	if err != nil || apiKey == nil || apiKey.UserID == nil || *apiKey.UserID != userID || apiKey.Scope != ScopeCoach {
+		t.Fatalf("Unexpected API key %+v, %v", apiKey, err)
+	}
+	keys, err := store.ReadApiKeyByUserID(ctx, userID)
+	if err != nil || len(keys) != 1 || keys[0].ID != created.ID {
+		t.Fatalf("Expected the user's key, got %+v, %v", keys, err)
+	}
+
+	if err := store.DeactivateAPIKey(ctx, created.ID); err != nil {
+		t.Fatalf("Failed to deactivate key: %v", err)
+	}
+	if valid, err := store.ValidateAPIKey(ctx, key); err != nil || valid {
+		t.Fatalf("Expected a deactivated key to be invalid, got %v, %v", valid, err)
+	}
+	if stored, err := store.ReadAPIKeyByID(ctx, created.ID); err != nil || stored.IsActive {
+		t.Fatalf("Expected the inactive key, got %+v, %v", stored, err)
+	}
+
+	if err := store.DeleteAPIKey(ctx, created.ID); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	for name, err := range map[string]error{
+		"ReadAPIKeyByID":   errOnly(store.ReadAPIKeyByID(ctx, created.ID)),
+		"DeactivateAPIKey": store.DeactivateAPIKey(ctx, created.ID),
+		"DeleteAPIKey":     store.DeleteAPIKey(ctx, created.ID),
+	} {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound for a deleted key, got %v", name, err)
+		}
+	}
+}
+
+func testUserAPIKeyStore(t *testing.T, store APIKeyStore) {
+	ctx := context.Background()
+	userID := uniqueID()
+	key := "conformance-user-" + time.Now().Format(time.RFC3339Nano)
+
+	if _, err := store.CreateUserAPIKey(ctx, key, "Invalid", nil, userID, "admin"); err == nil {
+		t.Fatal("Expected an error for an invalid scope")
+	}
+	created, err := store.CreateUserAPIKey(ctx, key, "Owned", nil, userID, ScopeCoach)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	defer store.DeleteAPIKey(ctx, created.ID)
+	if created.ID == 0 || !created.IsActive || created.Scope != ScopeCoach || created.UserID == nil || *created.UserID != userID {
+		t.Fatalf("Unexpected API key %+v", created)
+	}
+	if _, err := store.CreateUserAPIKey(ctx, key, "Duplicate", nil, userID, ScopeUser); !errors.Is(err, ErrConflict) {
+		t.Fatalf("Expected ErrConflict for a duplicate key, got %v", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	replaced, err := store.ReplaceAPIKey(ctx, created.ID, key+"-rotated", &expiresAt)
+	if err != nil {
+		t.Fatalf("Failed to replace key: %v", err)
+	}
+	defer store.DeleteAPIKey(ctx, replaced.ID)
+	if replaced.ID == created.ID || !replaced.IsActive || replaced.Description != "Owned" ||
+		replaced.Scope != ScopeCoach || replaced.UserID == nil || *replaced.UserID != userID || replaced.ExpiresAt.IsZero() {
+		t.Fatalf("Unexpected replacement %+v", replaced)
+	}
+	if valid, err := store.ValidateAPIKey(ctx, key); err != nil || valid {
+		t.Fatalf("Expected the replaced key to be invalid, got %v, %v", valid, err)
+	}
+	if _, err := store.ReplaceAPIKey(ctx, created.ID, key+"-again", nil); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for an inactive key, got %v", err)
+	}
+	if valid, err := store.ValidateAPIKey(ctx, key+"-again"); err != nil || valid {
+		t.Fatalf("Expected no key for a failed rotation, got %v, %v", valid, err)
+	}
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var activityStreamSchema = `
+CREATE TABLE IF NOT EXISTS activity_streams (
+	activity_id BIGINT PRIMARY KEY,
+	data JSONB NOT NULL,
+	fetched_at TIMESTAMP NOT NULL DEFAULT NOW()
+);`
+
+var sqliteActivityStreamSchema = `
+CREATE TABLE IF NOT EXISTS activity_streams (
+	activity_id BIGINT NOT NULL PRIMARY KEY,
+	data TEXT NOT NULL,
+	fetched_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);`
+
+// ActivityStreams holds the time series of an activity as returned by Strava
+type ActivityStreams struct {
+	ActivityID int64           `db:"activity_id"`
+	Data       json.RawMessage `db:"data"`
+	FetchedAt  time.Time       `db:"fetched_at"`
+}
+
+// DB Schema for activity streams
+func (db *DB) CreateActivityStreamSchema() {
+	db.MustExec(dialect(db, activityStreamSchema, sqliteActivityStreamSchema))
+}
+
+// SaveActivityStreams stores the streams of an activity, replacing earlier downloads
+func (db *DB) SaveActivityStreams(ctx context.Context, activityID int64, data json.RawMessage) error {
+	query := `
+		INSERT INTO activity_streams (activity_id, data)
+		VALUES ($1, $2)
+		ON CONFLICT (activity_id) DO UPDATE SET data = EXCLUDED.data, fetched_at = NOW()
+	`
+	_, err := db.ExecContext(ctx, query, activityID, string(data))
+	if err != nil {
+		return fmt.Errorf("error saving streams of activity %d: %w", activityID, err)
+	}
+	return nil
+}
+
+func (db *DB) GetActivityStreams(ctx context.Context, activityID int64) (ActivityStreams, error) {
+	var streams ActivityStreams
+	query := `
+		SELECT activity_id, data, fetched_at FROM activity_streams WHERE activity_id = $1
+	`
+	err := db.GetContext(ctx, &streams, query, activityID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ActivityStreams{}, fmt.Errorf("streams of activity %d %w", activityID, ErrNotFound)
+		}
+		return ActivityStreams{}, fmt.Errorf("error retrieving streams: %w", err)
+	}
+	return streams, nil
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sync_checkpoints records how far a sync got, so a sync that was cancelled
+// during shutdown resumes where it stopped
+var syncCheckpointSchema = `
+CREATE TABLE IF NOT EXISTS sync_checkpoints (
+	name TEXT PRIMARY KEY,
+	synced_until TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP DEFAULT NOW()
+);`
+
+var sqliteSyncCheckpointSchema = `
+CREATE TABLE IF NOT EXISTS sync_checkpoints (
+	name TEXT NOT NULL PRIMARY KEY,
+	synced_until TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);`
+
+// DB Schema for sync checkpoints
+func (db *DB) CreateSyncSchema() {
+	db.MustExec(dialect(db, syncCheckpointSchema, sqliteSyncCheckpointSchema))
+}
+
+// GetSyncCheckpoint returns the checkpoint of a sync. The boolean is false if
+// the sync has never saved a checkpoint.
+func (db *DB) GetSyncCheckpoint(ctx context.Context, name string) (time.Time, bool, error) {
+	var syncedUntil time.Time
+	query := `
+		SELECT synced_until FROM sync_checkpoints WHERE name = $1
+	`
+	err := db.GetContext(ctx, &syncedUntil, query, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, false, nil
+		}
+		return time.Time{}, false, fmt.Errorf("error reading sync checkpoint %s: %w", name, err)
+	}
+	return syncedUntil, true, nil
+}
+
+// SaveSyncCheckpoint stores the checkpoint of a sync. A checkpoint never moves
+// backwards.
+func (db *DB) SaveSyncCheckpoint(ctx context.Context, name string, syncedUntil time.Time) error {
+	query := `
+		INSERT INTO sync_checkpoints (name, synced_until)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET
+			synced_until = GREATEST(sync_checkpoints.synced_until, EXCLUDED.synced_until),
+			updated_at = NOW()
+	`
+	_, err := db.ExecContext(ctx, query, name, syncedUntil)
+	if err != nil {
+		return fmt.Errorf("error saving sync checkpoint %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSyncCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestDB(t)
+	defer db.Close()
+	db.CreateSyncSchema()
+
+	name := "test_" + uuid.New().String()
+	if _, ok, err := db.GetSyncCheckpoint(ctx, name); err != nil || ok {
+		t.Fatalf("Expected no checkpoint, got ok=%v err=%v", ok, err)
+	}
+
+	later := time.Now().UTC().Truncate(time.Second)
+	if err := db.SaveSyncCheckpoint(ctx, name, later); err != nil {
+		t.Fatalf("Failed to save checkpoint: %v", err)
+	}
+	if err := db.SaveSyncCheckpoint(ctx, name, later.Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to save checkpoint: %v", err)
+	}
+
+	checkpoint, ok, err := db.GetSyncCheckpoint(ctx, name)
+	if err != nil || !ok {
+		t.Fatalf("Failed to read checkpoint: ok=%v err=%v", ok, err)
+	}
+	if !checkpoint.Equal(later) {
+		t.Fatalf("Expected checkpoint %v, got %v", later, checkpoint)
+	}
+}
@@ -0,0 +1,349 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var teamSchema = `
+CREATE TABLE IF NOT EXISTS teams (
+	id BIGSERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	coach_id BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE TABLE IF NOT EXISTS team_members (
+	team_id BIGINT NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'invited',
+	invited_at TIMESTAMP DEFAULT NOW(),
+	responded_at TIMESTAMP,
+	PRIMARY KEY (team_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS team_members_user_id_idx ON team_members (user_id);`
+
+var sqliteTeamSchema = `
+CREATE TABLE IF NOT EXISTS teams (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	coach_id BIGINT NOT NULL,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);
+CREATE TABLE IF NOT EXISTS team_members (
+	team_id BIGINT NOT NULL REFERENCES teams (id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'invited',
+	invited_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	responded_at TIMESTAMP,
+	PRIMARY KEY (team_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS team_members_user_id_idx ON team_members (user_id);`
+
+// Membership states. Only active members have consented to share their data.
+const (
+	MemberInvited  = "invited"
+	MemberActive   = "active"
+	MemberDeclined = "declined"
+)
+
+type Team struct {
+	ID        int64     `db:"id"`
+	Name      string    `db:"name"`
+	CoachID   int64     `db:"coach_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type TeamMember struct {
+	TeamID      int64        `db:"team_id"`
+	UserID      int64        `db:"user_id"`
+	Username    string       `db:"username"`
+	Status      string       `db:"status"`
+	InvitedAt   time.Time    `db:"invited_at"`
+	RespondedAt sql.NullTime `db:"responded_at"`
+}
+
+// TeamInvitation is a pending invitation as shown to the invited athlete
+type TeamInvitation struct {
+	TeamID    int64     `db:"team_id"`
+	TeamName  string    `db:"team_name"`
+	CoachID   int64     `db:"coach_id"`
+	InvitedAt time.Time `db:"invited_at"`
+}
+
+// MemberStats are the totals of a single team member
+type MemberStats struct {
+	UserID             int64   `db:"user_id"`
+	ActivityCount      int     `db:"activity_count"`
+	Distance           float64 `db:"distance"`
+	MovingTime         int64   `db:"moving_time"`
+	TotalElevationGain float64 `db:"total_elevation_gain"`
+}
+
+// TeamStats are the totals of a team and its members
+type TeamStats struct {
+	TeamID             int64         `db:"team_id"`
+	MemberCount        int           `db:"member_count"`
+	ActivityCount      int           `db:"activity_count"`
+	Distance           float64       `db:"distance"`
+	MovingTime         int64         `db:"moving_time"`
+	TotalElevationGain float64       `db:"total_elevation_gain"`
+	Members            []MemberStats `db:"-"`
+}
+
+// DB Schema for teams and memberships
+func (db *DB) CreateTeamSchema() {
+	db.MustExec(dialect(db, teamSchema, sqliteTeamSchema))
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                  CRUD TEAM                                 */
+/* -------------------------------------------------------------------------- */
+
+func (db *DB) CreateTeam(ctx context.Context, name string, coachID int64) (Team, error) {
+	team := Team{Name: name, CoachID: coachID}
+	query := `
+		INSERT INTO teams (name, coach_id)
+		VALUES ($1, $2)
+		RETURNING id, created_at
+	`
+	err := db.QueryRowContext(ctx, query, name, coachID).Scan(&team.ID, &team.CreatedAt)
+	if err != nil {
+		return Team{}, fmt.Errorf("error creating team: %w", err)
+	}
+	return team, nil
+}
+
+func (db *DB) GetTeam(ctx context.Context, id int64) (Team, error) {
+	var team Team
+	query := `
+		SELECT id, name, coach_id, created_at FROM teams WHERE id = $1
+	`
+	err := db.GetContext(ctx, &team, query, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Team{}, fmt.Errorf("team %d %w", id, ErrNotFound)
+		}
+		return Team{}, fmt.Errorf("error retrieving team: %w", err)
+	}
+	return team, nil
+}
+
+// ListTeamsForUser returns the teams a user coaches or is an active member of
+func (db *DB) ListTeamsForUser(ctx context.Context, userID int64) ([]Team, error) {
+	teams := []Team{}
+	query := `
+		SELECT t.id, t.name, t.coach_id, t.created_at
+		FROM teams t
+		WHERE t.coach_id = $1
+		   OR EXISTS (
+			SELECT 1 FROM team_members m
+			WHERE m.team_id = t.id AND m.user_id = $1 AND m.status = 'active'
+		   )
+		ORDER BY t.name
+	`
+	err := db.SelectContext(ctx, &teams, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing teams for user %d: %w", userID, err)
+	}
+	return teams, nil
+}
+
+func (db *DB) DeleteTeam(ctx context.Context, id int64) error {
+	query := `
+		DELETE FROM teams WHERE id = $1
+	`
+	_, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error deleting team with id %d: %w", id, err)
+	}
+	return nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                 MEMBERSHIP                                 */
+/* -------------------------------------------------------------------------- */
+
+// InviteTeamMember invites a user to a team. Re-inviting a user who declined
+// or left resets the invitation.
+func (db *DB) InviteTeamMember(ctx context.Context, teamID, userID int64) error {
+	query := `
+		INSERT INTO team_members (team_id, user_id, status)
+		VALUES ($1, $2, 'invited')
+		ON CONFLICT (team_id, user_id) DO UPDATE SET
+			status = 'invited',
+			invited_at = NOW(),
+			responded_at = NULL
+		WHERE team_members.status <> 'active'
+	`
+	_, err := db.ExecContext(ctx, query, teamID, userID)
+	if err != nil {
+		return fmt.Errorf("error inviting user %d to team %d: %w", userID, teamID, err)
+	}
+	return nil
+}
+
+// RespondToInvitation accepts or declines a pending invitation
+func (db *DB) RespondToInvitation(ctx context.Context, teamID, userID int64, accept bool) error {
+	status := MemberDeclined
+	if accept {
+		status = MemberActive
+	}
+	query := `
+		UPDATE team_members
+		SET status = $1, responded_at = NOW()
+		WHERE team_id = $2 AND user_id = $3 AND status = 'invited'
+	`
+	result, err := db.ExecContext(ctx, query, status, teamID, userID)
+	if err != nil {
+		return fmt.Errorf("error responding to invitation: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("invitation of user %d to team %d %w", userID, teamID, ErrNotFound)
+	}
+	return nil
+}
+
+// RemoveTeamMember removes a user from a team, withdrawing their consent
+func (db *DB) RemoveTeamMember(ctx context.Context, teamID, userID int64) error {
+	query := `
+		DELETE FROM team_members
+		WHERE team_id = $1 AND user_id = $2
+	`
+	_, err := db.ExecContext(ctx, query, teamID, userID)
+	if err != nil {
+		return fmt.Errorf("error removing user %d from team %d: %w", userID, teamID, err)
+	}
+	return nil
+}
+
+// GetTeamMembers returns all members of a team, including pending invitations
+func (db *DB) GetTeamMembers(ctx context.Context, teamID int64) ([]TeamMember, error) {
+	members := []TeamMember{}
+	query := `
+		SELECT m.team_id, m.user_id, COALESCE(u.username, '') AS username,
+			m.status, m.invited_at, m.responded_at
+		FROM team_members m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.team_id = $1
+		ORDER BY m.user_id
+	`
+	err := db.SelectContext(ctx, &members, query, teamID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading members of team %d: %w", teamID, err)
+	}
+	return members, nil
+}
+
+// GetPendingInvitations returns the open team invitations of a user
+func (db *DB) GetPendingInvitations(ctx context.Context, userID int64) ([]TeamInvitation, error) {
+	invitations := []TeamInvitation{}
+	query := `
+		SELECT t.id AS team_id, t.name AS team_name, t.coach_id, m.invited_at
+		FROM team_members m
+		JOIN teams t ON t.id = m.team_id
+		WHERE m.user_id = $1 AND m.status = 'invited'
+		ORDER BY m.invited_at DESC
+	`
+	err := db.SelectContext(ctx, &invitations, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading invitations for user %d: %w", userID, err)
+	}
+	return invitations, nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                  TEAM DATA                                 */
+/* -------------------------------------------------------------------------- */
+
+// GetTeamActivities returns the activities of the active members of a team
+func (db *DB) GetTeamActivities(ctx context.Context, teamID int64, limit, offset int) ([]Activity, error) {
+	var activities []Activity
+	query := `
+		SELECT a.* FROM activities a
+		JOIN team_members m ON m.user_id = a.athlete_id
+		WHERE m.team_id = $1 AND m.status = 'active'
+		ORDER BY a.start_date DESC, a.id DESC
+		LIMIT $2 OFFSET $3
+	`
+	err := db.SelectContext(ctx, &activities, query, teamID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving activities for team %d: %w", teamID, err)
+	}
+	return activities, nil
+}
+
+// ActivityCursor is the position of an activity in the newest first order of
+// keyset pagination. The zero value is the position before the newest
+// activity.
+type ActivityCursor struct {
+	StartDate time.Time
+	ID        int64
+}
+
+// CursorOf returns the position of the activity
+func CursorOf(activity Activity) ActivityCursor {
+	return ActivityCursor{StartDate: activity.StartDate, ID: activity.ID}
+}
+
+// GetTeamActivitiesAfter returns up to limit activities of the active members
+// of a team that follow the cursor, newest first. Unlike an offset, the
+// cursor does not skip or repeat activities when new ones are synced between
+// pages.
+func (db *DB) GetTeamActivitiesAfter(ctx context.Context, teamID int64, cursor ActivityCursor, limit int) ([]Activity, error) {
+	var activities []Activity
+	args := []interface{}{teamID, limit}
+	after := ""
+	if cursor != (ActivityCursor{}) {
+		after = `AND (a.start_date < $3 OR (a.start_date = $3 AND a.id < $4))`
+		args = append(args, cursor.StartDate, cursor.ID)
+	}
+	query := `
+		SELECT a.* FROM activities a
+		JOIN team_members m ON m.user_id = a.athlete_id
+		WHERE m.team_id = $1 AND m.status = 'active' ` + after + `
+		ORDER BY a.start_date DESC, a.id DESC
+		LIMIT $2
+	`
+	err := db.SelectContext(ctx, &activities, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving activities for team %d: %w", teamID, err)
+	}
+	return activities, nil
+}
+
+// GetTeamStats returns activity totals per active member and for the whole team
+func (db *DB) GetTeamStats(ctx context.Context, teamID int64) (TeamStats, error) {
+	members := []MemberStats{}
+	query := `
+		SELECT m.user_id,
+			COUNT(a.id) AS activity_count,
+			COALESCE(SUM(a.distance), 0) AS distance,
+			COALESCE(SUM(a.moving_time), 0) AS moving_time,
+			COALESCE(SUM(a.total_elevation_gain), 0) AS total_elevation_gain
+		FROM team_members m
+		LEFT JOIN activities a ON a.athlete_id = m.user_id
+		WHERE m.team_id = $1 AND m.status = 'active'
+		GROUP BY m.user_id
+		ORDER BY m.user_id
+	`
+	err := db.SelectContext(ctx, &members, query, teamID)
+	if err != nil {
+		return TeamStats{}, fmt.Errorf("error retrieving stats for team %d: %w", teamID, err)
+	}
+
+	stats := TeamStats{TeamID: teamID, MemberCount: len(members), Members: members}
+	for _, m := range members {
+		stats.ActivityCount += m.ActivityCount
+		stats.Distance += m.Distance
+		stats.MovingTime += m.MovingTime
+		stats.TotalElevationGain += m.TotalElevationGain
+	}
+	return stats, nil
+}
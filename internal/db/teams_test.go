@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func setupTestTeamDB(t *testing.T) *DB {
+	db := setupTestActivityDB(t)
+	db.CreateTeamSchema()
+	return db
+}
+
+func TestTeamMembership(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestTeamDB(t)
+	defer db.Close()
+
+	coachID, athleteID := int64(9101), int64(9102)
+	team, err := db.CreateTeam(ctx, "Test Team", coachID)
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	defer db.DeleteTeam(ctx, team.ID)
+
+	if err := db.InviteTeamMember(ctx, team.ID, athleteID); err != nil {
+		t.Fatalf("Failed to invite member: %v", err)
+	}
+
+	invitations, err := db.GetPendingInvitations(ctx, athleteID)
+	if err != nil {
+		t.Fatalf("Failed to get invitations: %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].TeamID != team.ID {
+		t.Fatalf("Expected invitation to team %d, got %v", team.ID, invitations)
+	}
+
+	activity := Activity{ID: 9102001, Name: "Team Run", Type: "Run", Distance: 5000, MovingTime: 1500,
+		StartDate: time.Now(), StartDateLocal: time.Now(), AthleteID: athleteID}
+	if _, err := db.CreateActivity(ctx, activity); err != nil {
+		t.Fatalf("Failed to create activity: %v", err)
+	}
+	defer db.DeleteActivity(ctx, activity.ID)
+
+	// Invited members have not consented yet
+	activities, err := db.GetTeamActivities(ctx, team.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get team activities: %v", err)
+	}
+	if len(activities) != 0 {
+		t.Fatalf("Expected no activities before consent, got %d", len(activities))
+	}
+
+	if err := db.RespondToInvitation(ctx, team.ID, athleteID, true); err != nil {
+		t.Fatalf("Failed to accept invitation: %v", err)
+	}
+	if err := db.RespondToInvitation(ctx, team.ID, athleteID, true); err == nil {
+		t.Fatal("Expected error when answering an invitation twice")
+	}
+
+	activities, err = db.GetTeamActivities(ctx, team.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to get team activities: %v", err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("Expected 1 activity after consent, got %d", len(activities))
+	}
+
+	stats, err := db.GetTeamStats(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("Failed to get team stats: %v", err)
+	}
+	if stats.MemberCount != 1 || stats.ActivityCount != 1 || stats.Distance != 5000 {
+		t.Fatalf("Unexpected team stats: %+v", stats)
+	}
+
+	if err := db.RemoveTeamMember(ctx, team.ID, athleteID); err != nil {
+		t.Fatalf("Failed to remove member: %v", err)
+	}
+	activities, _ = db.GetTeamActivities(ctx, team.ID, 10, 0)
+	if len(activities) != 0 {
+		t.Fatal("Expected no activities after member left")
+	}
+}
+
+func TestGetTeamActivitiesAfter(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestTeamDB(t)
+	defer db.Close()
+
+	coachID, athleteID := int64(9201), int64(9202)
+	team, err := db.CreateTeam(ctx, "Keyset Team", coachID)
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	defer db.DeleteTeam(ctx, team.ID)
+	if err := db.InviteTeamMember(ctx, team.ID, athleteID); err != nil {
+		t.Fatalf("Failed to invite member: %v", err)
+	}
+	if err := db.RespondToInvitation(ctx, team.ID, athleteID, true); err != nil {
+		t.Fatalf("Failed to accept invitation: %v", err)
+	}
+
+	// Two activities share a start date, so pages must break ties by ID
+	start := time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)
+	create := func(id int64, startDate time.Time) {
+		t.Helper()
+		activity := Activity{ID: id, Name: "Run", Type: "Run", StartDate: startDate, StartDateLocal: startDate, AthleteID: athleteID}
+		if _, err := db.CreateActivity(ctx, activity); err != nil {
+			t.Fatalf("Failed to create activity: %v", err)
+		}
+		t.Cleanup(func() { db.DeleteActivity(ctx, id) })
+	}
+	create(9202001, start)
+	create(9202002, start.Add(time.Hour))
+	create(9202003, start.Add(time.Hour))
+
+	first, err := db.GetTeamActivitiesAfter(ctx, team.ID, ActivityCursor{}, 2)
+	if err != nil {
+		t.Fatalf("Failed to get team activities: %v", err)
+	}
+	if len(first) != 2 || first[0].ID != 9202003 || first[1].ID != 9202002 {
+		t.Fatalf("Unexpected first page: %v", activityIDs(first))
+	}
+
+	// A newer activity synced between pages shifts offsets but not the cursor
+	create(9202004, start.Add(2*time.Hour))
+
+	second, err := db.GetTeamActivitiesAfter(ctx, team.ID, CursorOf(first[1]), 2)
+	if err != nil {
+		t.Fatalf("Failed to get team activities: %v", err)
+	}
+	if len(second) != 1 || second[0].ID != 9202001 {
+		t.Fatalf("Unexpected second page: %v", activityIDs(second))
+	}
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var refreshTokenSchema = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	family_id TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	replaced_by BIGINT
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);`
+
+var sqliteRefreshTokenSchema = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id BIGINT NOT NULL,
+	token_hash TEXT UNIQUE NOT NULL,
+	family_id TEXT NOT NULL,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	replaced_by BIGINT
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);`
+
+var revokedTokenSchema = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	user_id BIGINT,
+	revoked_at TIMESTAMP DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL
+);`
+
+var sqliteRevokedTokenSchema = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT NOT NULL PRIMARY KEY,
+	user_id BIGINT,
+	revoked_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	expires_at TIMESTAMP NOT NULL
+);`
+
+// RefreshToken is a persisted refresh token. Only the SHA-256 hash of the
+// token is stored; the plain value is handed to the client once.
+type RefreshToken struct {
+	ID         int64        `db:"id"`
+	UserID     int64        `db:"user_id"`
+	TokenHash  string       `db:"token_hash"`
+	FamilyID   string       `db:"family_id"`
+	CreatedAt  time.Time    `db:"created_at"`
+	ExpiresAt  time.Time    `db:"expires_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+	ReplacedBy *int64       `db:"replaced_by"`
+}
+
+// Revoked reports whether the refresh token has been revoked or rotated
+func (t RefreshToken) Revoked() bool {
+	return t.RevokedAt.Valid
+}
+
+// DB Schema for refresh tokens and the access token denylist
+func (db *DB) CreateTokenSchema() {
+	db.MustExec(dialect(db, refreshTokenSchema, sqliteRefreshTokenSchema))
+	db.MustExec(dialect(db, revokedTokenSchema, sqliteRevokedTokenSchema))
+}
+
+/* -------------------------------------------------------------------------- */
+/*                               REFRESH TOKENS                               */
+/* -------------------------------------------------------------------------- */
+
+// CreateRefreshToken stores a new refresh token hash for a user
+func (db *DB) CreateRefreshToken(ctx context.Context, userID int64, tokenHash, familyID string, expiresAt time.Time) (RefreshToken, error) {
+	token := RefreshToken{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		FamilyID:  familyID,
+		ExpiresAt: expiresAt,
+	}
+	query := `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	err := db.QueryRowContext(ctx, query, userID, tokenHash, familyID, expiresAt).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("error creating refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// GetRefreshTokenByHash looks up a refresh token by its hash
+func (db *DB) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	var token RefreshToken
+	query := `
+		SELECT id, user_id, token_hash, family_id, created_at, expires_at, revoked_at, replaced_by
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+	err := db.GetContext(ctx, &token, query, tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, fmt.Errorf("refresh token %w", ErrNotFound)
+		}
+		return RefreshToken{}, fmt.Errorf("error reading refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// RotateRefreshToken atomically revokes the token with the given id and
+// stores its replacement in the same family. It wraps ErrRevoked if the old
+// token was already revoked, so a refresh token can only be exchanged once
+// even when it is presented twice at the same time.
+func (db *DB) RotateRefreshToken(ctx context.Context, oldID int64, newHash string, expiresAt time.Time) (RefreshToken, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Only one of several concurrent rotations matches the unrevoked row
+	var old RefreshToken
+	err = tx.GetContext(ctx, &old, `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+		RETURNING id, user_id, token_hash, family_id, created_at, expires_at, revoked_at, replaced_by
+	`, oldID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, fmt.Errorf("refresh token %d %w", oldID, ErrRevoked)
+		}
+		return RefreshToken{}, fmt.Errorf("error revoking refresh token: %w", err)
+	}
+
+	next := RefreshToken{
+		UserID:    old.UserID,
+		TokenHash: newHash,
+		FamilyID:  old.FamilyID,
+		ExpiresAt: expiresAt,
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, next.UserID, next.TokenHash, next.FamilyID, next.ExpiresAt).Scan(&next.ID, &next.CreatedAt)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("error creating refresh token: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET replaced_by = $1 WHERE id = $2
+	`, next.ID, old.ID)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("error linking refresh token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, fmt.Errorf("error committing refresh token rotation: %w", err)
+	}
+	return next, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same login
+func (db *DB) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+	_, err := db.ExecContext(ctx, query, familyID)
+	if err != nil {
+		return fmt.Errorf("error revoking refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RevokeRefreshTokensForUser revokes all active refresh tokens of a user
+func (db *DB) RevokeRefreshTokensForUser(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+	_, err := db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking refresh tokens for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                               TOKEN DENYLIST                               */
+/* -------------------------------------------------------------------------- */
+
+// RevokeToken adds an access token ID to the denylist until it expires
+func (db *DB) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING
+	`
+	_, err := db.ExecContext(ctx, query, jti, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error revoking token: %w", err)
+	}
+	return nil
+}
+
+// IsTokenRevoked checks whether an access token ID is on the denylist
+func (db *DB) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+	`
+	err := db.GetContext(ctx, &revoked, query, jti)
+	if err != nil {
+		return false, fmt.Errorf("error checking revoked token: %w", err)
+	}
+	return revoked, nil
+}
+
+// PurgeExpiredTokens removes denylist entries and refresh tokens that have
+// expired and can no longer be presented
+func (db *DB) PurgeExpiredTokens(ctx context.Context) (int64, error) {
+	var total int64
+	for _, query := range []string{
+		`DELETE FROM revoked_tokens WHERE expires_at < NOW()`,
+		`DELETE FROM refresh_tokens WHERE expires_at < NOW()`,
+	} {
+		result, err := db.ExecContext(ctx, query)
+		if err != nil {
+			return total, fmt.Errorf("error purging expired tokens: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("error getting rows affected: %w", err)
+		}
+		total += n
+	}
+	return total, nil
+}
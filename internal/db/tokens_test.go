@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func setupTestTokenDB(t *testing.T) *DB {
+	db := setupTestDB(t)
+	db.CreateTokenSchema()
+	return db
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestTokenDB(t)
+	defer db.Close()
+
+	family := uuid.New().String()
+	original, err := db.CreateRefreshToken(ctx, 1, "hash_"+uuid.New().String(), family, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create refresh token: %v", err)
+	}
+
+	rotated, err := db.RotateRefreshToken(ctx, original.ID, "hash_"+uuid.New().String(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to rotate refresh token: %v", err)
+	}
+	if rotated.FamilyID != family {
+		t.Fatalf("Expected family %s, got %s", family, rotated.FamilyID)
+	}
+
+	old, err := db.GetRefreshTokenByHash(ctx, original.TokenHash)
+	if err != nil {
+		t.Fatalf("Failed to read refresh token: %v", err)
+	}
+	if !old.Revoked() || old.ReplacedBy == nil || *old.ReplacedBy != rotated.ID {
+		t.Fatal("Expected original token to be revoked and replaced")
+	}
+
+	if _, err := db.RotateRefreshToken(ctx, original.ID, "hash_"+uuid.New().String(), time.Now().Add(time.Hour)); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("Expected ErrRevoked when rotating a revoked token, got %v", err)
+	}
+
+	if err := db.RevokeRefreshTokenFamily(ctx, family); err != nil {
+		t.Fatalf("Failed to revoke family: %v", err)
+	}
+	current, err := db.GetRefreshTokenByHash(ctx, rotated.TokenHash)
+	if err != nil {
+		t.Fatalf("Failed to read refresh token: %v", err)
+	}
+	if !current.Revoked() {
+		t.Fatal("Expected family revocation to revoke the current token")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestTokenDB(t)
+	defer db.Close()
+
+	jti := uuid.New().String()
+	if revoked, err := db.IsTokenRevoked(ctx, jti); err != nil {
+		t.Fatalf("Failed to check token: %v", err)
+	} else if revoked {
+		t.Fatal("Expected token not to be revoked")
+	}
+
+	if err := db.RevokeToken(ctx, jti, 1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+
+	if revoked, err := db.IsTokenRevoked(ctx, jti); err != nil {
+		t.Fatalf("Failed to check token: %v", err)
+	} else if !revoked {
+		t.Fatal("Expected token to be revoked")
+	}
+}
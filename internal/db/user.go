@@ -1,6 +1,9 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -14,24 +17,71 @@ CREATE TABLE IF NOT EXISTS users (
 	access_token TEXT,
 	refresh_token TEXT,
 	token_expires_at TIMESTAMP
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS athlete_id BIGINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS firstname TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS lastname TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS city TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS country TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sex TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'athlete';`
+
+var sqliteUserSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id BIGINT NOT NULL PRIMARY KEY,
+	username TEXT UNIQUE,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	updated_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	access_token TEXT,
+	refresh_token TEXT,
+	token_expires_at TIMESTAMP,
+	athlete_id BIGINT,
+	firstname TEXT,
+	lastname TEXT,
+	city TEXT,
+	country TEXT,
+	sex TEXT,
+	role TEXT NOT NULL DEFAULT 'athlete'
 );`
 
+// Role determines what a user is allowed to do
+type Role string
+
+const (
+	// RoleAthlete can access their own data
+	RoleAthlete Role = "athlete"
+	// RoleCoach can additionally read the data of linked athletes
+	RoleCoach Role = "coach"
+	// RoleOperator can run system-wide actions and manage users
+	RoleOperator Role = "operator"
+)
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAthlete, RoleCoach, RoleOperator:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID             int64     `db:"id"`
 	Username       string    `db:"username"`
 	AthleteID      int64     `db:"athlete_id"`
+	Role           Role      `db:"role"`
 	CreatedAt      time.Time `db:"created_at"`
 	UpdatedAt      time.Time `db:"updated_at"`
-	AccessToken    string    `db:"access_token"`
-	RefreshToken   string    `db:"refresh_token"`
+	AccessToken    string    `db:"access_token" json:"-"`
+	RefreshToken   string    `db:"refresh_token" json:"-"`
 	TokenExpiresAt time.Time `db:"token_expires_at"`
 }
 
 func (db *DB) CreateUserSchema() {
-	db.MustExec(userSchema)
+	db.MustExec(dialect(db, userSchema, sqliteUserSchema))
 }
 
-func (db *DB) CreateUser(username string, athleteID int64) (User, error) {
+func (db *DB) CreateUser(ctx context.Context, username string, athleteID int64) (User, error) {
 	user := User{
 		Username:  username,
 		AthleteID: athleteID,
@@ -45,76 +95,93 @@ func (db *DB) CreateUser(username string, athleteID int64) (User, error) {
 		RETURNING id, created_at, updated_at
 	`
 
-	err := db.QueryRow(query, username, athleteID).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := db.QueryRowContext(ctx, query, username, athleteID).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return User{}, fmt.Errorf("user %q %w", username, ErrConflict)
+		}
 		return User{}, fmt.Errorf("error creating user: %w", err)
 	}
 
 	return user, nil
 }
 
-func (db *DB) GetUserByID(userID int64) (User, error) {
+func (db *DB) GetUserByID(ctx context.Context, userID int64) (User, error) {
 	user := User{}
 
+	// Users that signed in with Strava have no username, users that
+	// deauthorized have no tokens
 	query := `
-		SELECT id, username, created_at, updated_at, access_token, refresh_token, token_expires_at
+		SELECT id, COALESCE(username, ''), COALESCE(athlete_id, 0), role, created_at, updated_at,
+			COALESCE(access_token, ''), COALESCE(refresh_token, ''), token_expires_at
 		FROM users
 		WHERE id = $1
 	`
 
-	err := db.QueryRow(query, userID).Scan(&user.ID, &user.Username, &user.CreatedAt, &user.UpdatedAt,
-		&user.AccessToken, &user.RefreshToken, &user.TokenExpiresAt)
+	var expiresAt sql.NullTime
+	err := db.QueryRowContext(ctx, query, userID).Scan(&user.ID, &user.Username, &user.AthleteID, &user.Role, &user.CreatedAt, &user.UpdatedAt,
+		&user.AccessToken, &user.RefreshToken, &expiresAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, fmt.Errorf("user %d %w", userID, ErrNotFound)
+		}
 		return User{}, fmt.Errorf("error retrieving user: %w", err)
 	}
+	user.TokenExpiresAt = expiresAt.Time
 
 	return user, nil
 }
 
-func (db *DB) GetUserByUsername(username string) (User, error) {
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	user := User{}
 
 	query := `
-		SELECT id, username, created_at, updated_at, access_token, refresh_token, token_expires_at
+		SELECT id, username, role, created_at, updated_at, access_token, refresh_token, token_expires_at
 		FROM users
 		WHERE username = $1
 	`
 
-	err := db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.CreatedAt, &user.UpdatedAt,
+	err := db.QueryRowContext(ctx, query, username).Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt, &user.UpdatedAt,
 		&user.AccessToken, &user.RefreshToken, &user.TokenExpiresAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, fmt.Errorf("user %q %w", username, ErrNotFound)
+		}
 		return User{}, fmt.Errorf("error retrieving user by username: %w", err)
 	}
 
 	return user, nil
 }
 
-func (db *DB) GetUserByAthleteID(athleteID int64) (User, error) {
+func (db *DB) GetUserByAthleteID(ctx context.Context, athleteID int64) (User, error) {
 	user := User{}
 
 	query := `
-		SELECT id, username, created_at, updated_at, access_token, refresh_token, token_expires_at
+		SELECT id, username, role, created_at, updated_at, access_token, refresh_token, token_expires_at
 		FROM users
 		WHERE athlete_id = $1
 	`
 
-	err := db.QueryRow(query, athleteID).Scan(&user.ID, &user.Username, &user.CreatedAt, &user.UpdatedAt,
+	err := db.QueryRowContext(ctx, query, athleteID).Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt, &user.UpdatedAt,
 		&user.AccessToken, &user.RefreshToken, &user.TokenExpiresAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, fmt.Errorf("user with athlete ID %d %w", athleteID, ErrNotFound)
+		}
 		return User{}, fmt.Errorf("error retrieving user by athlete ID: %w", err)
 	}
 
 	return user, nil
 }
 
-func (db *DB) UpdateUser(user User) error {
+func (db *DB) UpdateUser(ctx context.Context, user User) error {
 	query := `
 		UPDATE users
 		SET username = $1, athlete_id = $2, updated_at = NOW()
 		WHERE id = $3
 	`
 
-	_, err := db.Exec(query, user.Username, user.AthleteID, user.ID)
+	_, err := db.ExecContext(ctx, query, user.Username, user.AthleteID, user.ID)
 	if err != nil {
 		return fmt.Errorf("error updating user: %w", err)
 	}
@@ -122,16 +189,222 @@ func (db *DB) UpdateUser(user User) error {
 	return nil
 }
 
-func (db *DB) DeleteUser(userID int64) error {
+func (db *DB) DeleteUser(ctx context.Context, userID int64) error {
 	query := `
 		DELETE FROM users
 		WHERE id = $1
 	`
 
-	_, err := db.Exec(query, userID)
+	_, err := db.ExecContext(ctx, query, userID)
 	if err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
 
 	return nil
 }
+
+// ListAuthorizedUserIDs returns the IDs of the users with a Strava access
+// token, ordered by ID
+func (db *DB) ListAuthorizedUserIDs(ctx context.Context) ([]int64, error) {
+	ids := []int64{}
+	query := `
+		SELECT id FROM users
+		WHERE access_token IS NOT NULL AND access_token <> ''
+		ORDER BY id
+	`
+	if err := db.SelectContext(ctx, &ids, query); err != nil {
+		return nil, fmt.Errorf("error listing authorized users: %w", err)
+	}
+	return ids, nil
+}
+
+// ClearUserTokens removes the stored Strava tokens of a user
+func (db *DB) ClearUserTokens(ctx context.Context, userID int64) error {
+	query := `
+		UPDATE users
+		SET access_token = NULL, refresh_token = NULL, token_expires_at = NULL, updated_at = NOW()
+		WHERE id = $1
+	`
+	_, err := db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("error clearing tokens for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// SaveUserTokens stores refreshed Strava tokens of a user
+func (db *DB) SaveUserTokens(ctx context.Context, userID int64, accessToken, refreshToken string, expiresAt time.Time) error {
+	query := `
+		UPDATE users
+		SET access_token = $1, refresh_token = $2, token_expires_at = $3, updated_at = NOW()
+		WHERE id = $4
+	`
+	result, err := db.ExecContext(ctx, query, accessToken, refreshToken, expiresAt, userID)
+	if err != nil {
+		return fmt.Errorf("error saving tokens for user %d: %w", userID, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d %w", userID, ErrNotFound)
+	}
+	return nil
+}
+
+// GetUserAccessToken returns the stored Strava access token of a user
+func (db *DB) GetUserAccessToken(ctx context.Context, userID int64) (string, error) {
+	var token string
+	query := `
+		SELECT COALESCE(access_token, '') FROM users WHERE id = $1
+	`
+	err := db.GetContext(ctx, &token, query, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("user %d %w", userID, ErrNotFound)
+		}
+		return "", fmt.Errorf("error retrieving access token for user %d: %w", userID, err)
+	}
+	return token, nil
+}
+
+// Athlete is the Strava profile of a user who signed in with Strava. Their
+// user ID is their athlete ID.
+type Athlete struct {
+	ID             int64     `db:"id"`
+	Username       string    `db:"username"`
+	FirstName      string    `db:"firstname"`
+	LastName       string    `db:"lastname"`
+	City           string    `db:"city"`
+	Country        string    `db:"country"`
+	Sex            string    `db:"sex"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+	TokenExpiresAt time.Time `db:"token_expires_at"`
+}
+
+// SaveAthlete creates the user of a Strava athlete or updates their profile,
+// and stores their Strava tokens. A zero expiresAt is stored as unknown.
+func (db *DB) SaveAthlete(ctx context.Context, athlete Athlete, accessToken, refreshToken string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO users (
+			id, athlete_id, username, firstname, lastname, city, country, sex,
+			access_token, refresh_token, token_expires_at
+		) VALUES (
+			$1, $1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10
+		) ON CONFLICT (id) DO UPDATE SET
+			athlete_id = $1,
+			firstname = $3,
+			lastname = $4,
+			city = $5,
+			country = $6,
+			sex = $7,
+			access_token = $8,
+			refresh_token = $9,
+			token_expires_at = $10,
+			updated_at = NOW()
+	`
+	expires := sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero()}
+	_, err := db.ExecContext(ctx, query, athlete.ID, athlete.Username, athlete.FirstName, athlete.LastName,
+		athlete.City, athlete.Country, athlete.Sex, accessToken, refreshToken, expires)
+	if err != nil {
+		return fmt.Errorf("error saving athlete %d: %w", athlete.ID, err)
+	}
+	return nil
+}
+
+// GetAthlete returns the Strava profile of a user
+func (db *DB) GetAthlete(ctx context.Context, userID int64) (Athlete, error) {
+	var athlete Athlete
+	query := `
+		SELECT id, COALESCE(username, ''), COALESCE(firstname, ''), COALESCE(lastname, ''),
+			COALESCE(city, ''), COALESCE(country, ''), COALESCE(sex, ''),
+			created_at, updated_at, token_expires_at
+		FROM users
+		WHERE id = $1
+	`
+	var expiresAt sql.NullTime
+	err := db.QueryRowContext(ctx, query, userID).Scan(&athlete.ID, &athlete.Username, &athlete.FirstName, &athlete.LastName,
+		&athlete.City, &athlete.Country, &athlete.Sex, &athlete.CreatedAt, &athlete.UpdatedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Athlete{}, fmt.Errorf("user %d %w", userID, ErrNotFound)
+		}
+		return Athlete{}, fmt.Errorf("error retrieving athlete: %w", err)
+	}
+	athlete.TokenExpiresAt = expiresAt.Time
+	return athlete, nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*                                    ROLES                                   */
+/* -------------------------------------------------------------------------- */
+
+// ListUsers returns all users ordered by ID
+func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
+	users := []User{}
+	query := `
+		SELECT id, COALESCE(username, '') AS username, role, created_at, updated_at
+		FROM users
+		ORDER BY id
+	`
+	err := db.SelectContext(ctx, &users, query)
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+	return users, nil
+}
+
+// GetUserRole returns the role of a user
+func (db *DB) GetUserRole(ctx context.Context, userID int64) (Role, error) {
+	var role Role
+	query := `
+		SELECT role FROM users WHERE id = $1
+	`
+	err := db.GetContext(ctx, &role, query, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("user %d %w", userID, ErrNotFound)
+		}
+		return "", fmt.Errorf("error retrieving user role: %w", err)
+	}
+	return role, nil
+}
+
+// SetUserRole changes the role of a user
+func (db *DB) SetUserRole(ctx context.Context, userID int64, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	query := `
+		UPDATE users
+		SET role = $1, updated_at = NOW()
+		WHERE id = $2
+	`
+	result, err := db.ExecContext(ctx, query, role, userID)
+	if err != nil {
+		return fmt.Errorf("error updating user role: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user %d %w", userID, ErrNotFound)
+	}
+	return nil
+}
+
+// CountUsersWithRole returns the number of users that have the given role
+func (db *DB) CountUsersWithRole(ctx context.Context, role Role) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM users WHERE role = $1
+	`
+	err := db.GetContext(ctx, &count, query, role)
+	if err != nil {
+		return 0, fmt.Errorf("error counting users with role %s: %w", role, err)
+	}
+	return count, nil
+}
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 )
 
@@ -15,8 +18,28 @@ CREATE TABLE IF NOT EXISTS api_keys (
 	expires_at TIMESTAMP,
 	is_active BOOLEAN DEFAULT TRUE,
 	user_id BIGINT
+);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT 'user';`
+
+var sqliteAPIKeySchema = `
+CREATE TABLE IF NOT EXISTS api_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	key TEXT UNIQUE,
+	description TEXT,
+	created_at TIMESTAMP DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	expires_at TIMESTAMP,
+	is_active BOOLEAN DEFAULT TRUE,
+	user_id BIGINT,
+	scope TEXT NOT NULL DEFAULT 'user'
 );`
 
+// API key scopes. User keys read the data visible to their owner; coach keys
+// can additionally read the data of teams coached by their owner.
+const (
+	ScopeUser  = "user"
+	ScopeCoach = "coach"
+)
+
 type APIKey struct {
 	ID          int64     `db:"id"`
 	Key         string    `db:"key"`
@@ -25,24 +48,25 @@ type APIKey struct {
 	ExpiresAt   time.Time `db:"expires_at"`
 	IsActive    bool      `db:"is_active"`
 	UserID      *int64    `db:"user_id"`
+	Scope       string    `db:"scope"`
 }
 
 // DB Schema for API keys
 func (db *DB) CreateAPIKeySchema() {
-	db.MustExec(apiKeySchema)
+	db.MustExec(dialect(db, apiKeySchema, sqliteAPIKeySchema))
 }
 
 // ValidateAPIKey checks if an API key is valid
-func (db *DB) ValidateAPIKey(key string) (bool, error) {
+func (db *DB) ValidateAPIKey(ctx context.Context, key string) (bool, error) {
 	var apiKey APIKey
 	query := `
 		SELECT is_active, expires_at
 		FROM api_keys
 		WHERE key = $1
 	`
-	err := db.Get(&apiKey, query, key)
+	err := db.GetContext(ctx, &apiKey, query, key)
 	if err != nil {
-		if isNoRows(err) {
+		if errors.Is(err, sql.ErrNoRows) {
 			return false, nil // Key not found
 		}
 		return false, fmt.Errorf("error validating API key: %w", err)
@@ -56,24 +80,50 @@ func (db *DB) ValidateAPIKey(key string) (bool, error) {
 	return true, nil
 }
 
+// GetAPIKey returns the API key record for a key, or nil if the key does not exist
+func (db *DB) GetAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	var apiKey APIKey
+	query := `
+		SELECT id, key, description, created_at, expires_at, is_active, user_id, scope
+		FROM api_keys
+		WHERE key = $1
+	`
+	err := db.GetContext(ctx, &apiKey, query, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil // Key not found
+		}
+		return nil, fmt.Errorf("error reading API key: %w", err)
+	}
+	return &apiKey, nil
+}
+
 /* -------------------------------------------------------------------------- */
 /*                                CRUD API KEY                                */
 /* -------------------------------------------------------------------------- */
 
+// parseKeyExpiry parses an optional RFC3339 expiry date
+func parseKeyExpiry(expiresAt *string) (time.Time, error) {
+	if expiresAt == nil {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, *expiresAt)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires_at format, expected RFC3339: %w", err)
+	}
+	return t, nil
+}
+
 // CreateAPIKey creates a new API key
-func (db *DB) CreateAPIKey(key, description string, expiresAt *string) (APIKey, error) {
-	var expiresAtTime time.Time
-	if expiresAt != nil {
-		var err error
-		expiresAtTime, err = time.Parse(time.RFC3339, *expiresAt)
-		if err != nil {
-			return APIKey{}, fmt.Errorf("invalid expires_at format, expected RFC3339: %w", err)
-		}
+func (db *DB) CreateAPIKey(ctx context.Context, key, description string, expiresAt *string) (APIKey, error) {
+	expiresAtTime, err := parseKeyExpiry(expiresAt)
+	if err != nil {
+		return APIKey{}, err
 	}
 	query := `
 		INSERT INTO api_keys (key, description, expires_at)
-		VALUES (:key, :description, :expires_at)
-		RETURNING id, created_at, is_active
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, is_active, scope
 	`
 	params := map[string]interface{}{
 		"key":         key,
@@ -81,8 +131,11 @@ func (db *DB) CreateAPIKey(key, description string, expiresAt *string) (APIKey,
 		"expires_at":  expiresAtTime,
 	}
 	var apiKey APIKey
-	err := db.QueryRowx(query, params["key"], params["description"], params["expires_at"]).Scan(&apiKey.ID, &apiKey.CreatedAt, &apiKey.IsActive)
+	err = db.QueryRowxContext(ctx, query, params["key"], params["description"], params["expires_at"]).Scan(&apiKey.ID, &apiKey.CreatedAt, &apiKey.IsActive, &apiKey.Scope)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return APIKey{}, fmt.Errorf("API key %w", ErrConflict)
+		}
 		return APIKey{}, fmt.Errorf("error creating API key: %w", err)
 	}
 	apiKey.Key = key
@@ -91,28 +144,108 @@ func (db *DB) CreateAPIKey(key, description string, expiresAt *string) (APIKey,
 	return apiKey, nil
 }
 
-func (db *DB) ReadAPIKeyByID(id int64) (APIKey, error) {
+// CreateUserAPIKey creates an active key owned by the user with the given
+// scope. The key is stored in a single statement, so it is never usable
+// without its owner or with a wider scope.
+func (db *DB) CreateUserAPIKey(ctx context.Context, key, description string, expiresAt *string, userID int64, scope string) (APIKey, error) {
+	if scope != ScopeUser && scope != ScopeCoach {
+		return APIKey{}, fmt.Errorf("invalid API key scope %q", scope)
+	}
+	expiresAtTime, err := parseKeyExpiry(expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	apiKey := APIKey{
+		Key:         key,
+		Description: description,
+		ExpiresAt:   expiresAtTime,
+		UserID:      &userID,
+		Scope:       scope,
+	}
+	err = db.QueryRowxContext(ctx, `
+		INSERT INTO api_keys (key, description, expires_at, user_id, scope)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, is_active
+	`, key, description, expiresAtTime, userID, scope).Scan(&apiKey.ID, &apiKey.CreatedAt, &apiKey.IsActive)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return APIKey{}, fmt.Errorf("API key %w", ErrConflict)
+		}
+		return APIKey{}, fmt.Errorf("error creating API key: %w", err)
+	}
+	return apiKey, nil
+}
+
+// ReplaceAPIKey deactivates the active key with the given id and stores key
+// with its description, owner and scope in the same transaction. It wraps
+// ErrNotFound if there is no such active key.
+func (db *DB) ReplaceAPIKey(ctx context.Context, oldID int64, key string, expiresAt *string) (APIKey, error) {
+	expiresAtTime, err := parseKeyExpiry(expiresAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	apiKey := APIKey{Key: key, ExpiresAt: expiresAtTime}
+	err = tx.QueryRowxContext(ctx, `
+		UPDATE api_keys
+		SET is_active = FALSE
+		WHERE id = $1 AND is_active
+		RETURNING description, user_id, scope
+	`, oldID).Scan(&apiKey.Description, &apiKey.UserID, &apiKey.Scope)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, fmt.Errorf("API key %d %w", oldID, ErrNotFound)
+		}
+		return APIKey{}, fmt.Errorf("error deactivating API key: %w", err)
+	}
+
+	err = tx.QueryRowxContext(ctx, `
+		INSERT INTO api_keys (key, description, expires_at, user_id, scope)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, is_active
+	`, key, apiKey.Description, expiresAtTime, apiKey.UserID, apiKey.Scope).Scan(&apiKey.ID, &apiKey.CreatedAt, &apiKey.IsActive)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return APIKey{}, fmt.Errorf("API key %w", ErrConflict)
+		}
+		return APIKey{}, fmt.Errorf("error creating API key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return APIKey{}, fmt.Errorf("error committing API key rotation: %w", err)
+	}
+	return apiKey, nil
+}
+
+func (db *DB) ReadAPIKeyByID(ctx context.Context, id int64) (APIKey, error) {
 	var apiKey APIKey
 	query := `
-		SELECT id, key, description, created_at, expires_at, is_active, user_id
+		SELECT id, key, description, created_at, expires_at, is_active, user_id, scope
 		FROM api_keys
 		WHERE id = $1
 	`
-	err := db.Get(&apiKey, query, id)
+	err := db.GetContext(ctx, &apiKey, query, id)
 	if err != nil {
-		if isNoRows(err) {
-			return APIKey{}, fmt.Errorf("no API key found with the provided id %d", id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, fmt.Errorf("API key %d %w", id, ErrNotFound)
 		}
 		return APIKey{}, fmt.Errorf("error reading API key: %w", err)
 	}
 	return apiKey, nil
 }
 
-func (db *DB) UpdateAPIKey(apiKey APIKey) (APIKey, error) {
+func (db *DB) UpdateAPIKey(ctx context.Context, apiKey APIKey) (APIKey, error) {
 	query := `
 		UPDATE api_keys
-		SET key = :key, description = :description, expires_at = :expires_at, is_active = :is_active, user_id = :user_id, updated_at = NOW()
-		WHERE id = :id
+		SET key = $1, description = $2, expires_at = $3, is_active = $4, user_id = $5
+		WHERE id = $6
 		RETURNING created_at
 	`
 	params := map[string]interface{}{
@@ -124,7 +257,7 @@ func (db *DB) UpdateAPIKey(apiKey APIKey) (APIKey, error) {
 		"user_id":     apiKey.UserID,
 	}
 	var createdAt time.Time
-	err := db.QueryRowx(query, params["key"], params["description"], params["expires_at"], params["is_active"], params["user_id"], params["id"]).Scan(&createdAt)
+	err := db.QueryRowxContext(ctx, query, params["key"], params["description"], params["expires_at"], params["is_active"], params["user_id"], params["id"]).Scan(&createdAt)
 	if err != nil {
 		return APIKey{}, fmt.Errorf("error updating API key: %w", err)
 	}
@@ -132,12 +265,12 @@ func (db *DB) UpdateAPIKey(apiKey APIKey) (APIKey, error) {
 	return apiKey, nil
 }
 
-func (db *DB) DeleteAPIKey(id int64) error {
+func (db *DB) DeleteAPIKey(ctx context.Context, id int64) error {
 	query := `
 		DELETE FROM api_keys
 		WHERE id = $1
 	`
-	result, err := db.Exec(query, id)
+	result, err := db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("error deleting API key: %w", err)
 	}
@@ -146,9 +279,30 @@ func (db *DB) DeleteAPIKey(id int64) error {
 		return fmt.Errorf("error getting rows affected: %w", err)
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("no API key found with the provided id %d", id)
+		return fmt.Errorf("API key %d %w", id, ErrNotFound)
 	} else {
-		log.Printf("Deleted %d API key(s)", rowsAffected)
+		slog.Info("Deleted API keys", "count", rowsAffected)
+	}
+	return nil
+}
+
+// DeactivateAPIKey marks an API key as inactive so it can no longer be used
+func (db *DB) DeactivateAPIKey(ctx context.Context, id int64) error {
+	query := `
+		UPDATE api_keys
+		SET is_active = FALSE
+		WHERE id = $1
+	`
+	result, err := db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("error deactivating API key: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("API key %d %w", id, ErrNotFound)
 	}
 	return nil
 }
@@ -158,34 +312,47 @@ func (db *DB) DeleteAPIKey(id int64) error {
 /* -------------------------------------------------------------------------- */
 
 // AssociateAPIKeyWithUser associates an API key with a user
-func (db *DB) AssociateAPIKeyWithUser(apiKey APIKey, userID int64) error {
+func (db *DB) AssociateAPIKeyWithUser(ctx context.Context, apiKey APIKey, userID int64) error {
 	query := `
 		UPDATE api_keys
 		SET user_id = $1
 		WHERE key = $2
 	`
-	_, err := db.Exec(query, userID, apiKey.Key)
+	_, err := db.ExecContext(ctx, query, userID, apiKey.Key)
 	if err != nil {
 		return fmt.Errorf("error associating API key with user: %w", err)
 	}
 	return nil
 }
 
-func (db *DB) ReadApiKeyByUserID(userID int64) ([]APIKey, error) {
+// SetAPIKeyScope changes the scope of an API key
+func (db *DB) SetAPIKeyScope(ctx context.Context, key, scope string) error {
+	if scope != ScopeUser && scope != ScopeCoach {
+		return fmt.Errorf("invalid API key scope %q", scope)
+	}
+	query := `
+		UPDATE api_keys
+		SET scope = $1
+		WHERE key = $2
+	`
+	_, err := db.ExecContext(ctx, query, scope, key)
+	if err != nil {
+		return fmt.Errorf("error setting API key scope: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) ReadApiKeyByUserID(ctx context.Context, userID int64) ([]APIKey, error) {
 	var apiKeys []APIKey
 	query := `
-		SELECT id, key, description, created_at, expires_at, is_active, user_id
+		SELECT id, key, description, created_at, expires_at, is_active, user_id, scope
 		FROM api_keys
 		WHERE user_id = $1
+		ORDER BY id
 	`
-	err := db.Select(&apiKeys, query, userID)
+	err := db.SelectContext(ctx, &apiKeys, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("error reading API keys for user %d: %w", userID, err)
 	}
 	return apiKeys, nil
 }
-
-// Helper to check for sqlx no rows error
-func isNoRows(err error) bool {
-	return err != nil && (err.Error() == "sql: no rows in result set" || err.Error() == "sqlx: no rows in result set")
-}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -18,8 +19,14 @@ const (
 )
 
 func setupTestConfig() *config.Config {
+	if testDriver == config.DriverSQLite {
+		return &config.Config{
+			Database: config.Database{Driver: config.DriverSQLite, Path: testSQLitePath},
+		}
+	}
 	return &config.Config{
 		Database: config.Database{
+			Driver:   config.DriverPostgres,
 			Host:     DATABASE_HOST,
 			Port:     DATABASE_PORT,
 			User:     DATABASE_USER,
@@ -62,9 +69,10 @@ func TestInitSchema(t *testing.T) {
 }
 
 func createTestAPIKey(t *testing.T, db *DB) APIKey {
+	ctx := context.Background()
 	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
 	key := "test_" + uuid.New().String()
-	apiKey, err := db.CreateAPIKey(key, "Test API Key", &expiresAt)
+	apiKey, err := db.CreateAPIKey(ctx, key, "Test API Key", &expiresAt)
 	if err != nil {
 		t.Fatalf("Failed to create test API key: %v", err)
 	}
@@ -72,19 +80,21 @@ func createTestAPIKey(t *testing.T, db *DB) APIKey {
 }
 
 func deleteTestAPIKey(t *testing.T, db *DB, apiKey APIKey) {
-	if err := db.DeleteAPIKey(apiKey.ID); err != nil {
+	ctx := context.Background()
+	if err := db.DeleteAPIKey(ctx, apiKey.ID); err != nil {
 		t.Fatalf("Failed to delete test API key: %v", err)
 	}
 }
 
 func TestValidateAPIKey(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestDB(t)
 	defer db.Close()
 
 	apiKey := createTestAPIKey(t, db)
 	defer deleteTestAPIKey(t, db, apiKey)
 
-	if valid, err := db.ValidateAPIKey(apiKey.Key); err != nil {
+	if valid, err := db.ValidateAPIKey(ctx, apiKey.Key); err != nil {
 		t.Fatalf("API key validation failed: %v", err)
 	} else if !valid {
 		t.Fatal("Expected valid API key")
@@ -92,13 +102,14 @@ func TestValidateAPIKey(t *testing.T) {
 }
 
 func TestReadApiKeyByID(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestDB(t)
 	defer db.Close()
 
 	apiKey := createTestAPIKey(t, db)
 	defer deleteTestAPIKey(t, db, apiKey)
 
-	retrievedKey, err := db.ReadAPIKeyByID(apiKey.ID)
+	retrievedKey, err := db.ReadAPIKeyByID(ctx, apiKey.ID)
 	if err != nil {
 		t.Fatalf("Failed to read API key by ID: %v", err)
 	}
@@ -109,6 +120,7 @@ func TestReadApiKeyByID(t *testing.T) {
 }
 
 func TestUpdateAPIKey(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -117,7 +129,7 @@ func TestUpdateAPIKey(t *testing.T) {
 
 	apiKey.Description = "Updated Test API Key"
 	apiKey.ExpiresAt = time.Now().Add(2 * time.Hour).UTC()
-	updatedKey, err := db.UpdateAPIKey(apiKey)
+	updatedKey, err := db.UpdateAPIKey(ctx, apiKey)
 	if err != nil {
 		t.Fatalf("Failed to update API key: %v", err)
 	}
@@ -145,16 +157,17 @@ func TestUpdateAPIKey(t *testing.T) {
 }
 
 func TestDeleteAPIKey(t *testing.T) {
+	ctx := context.Background()
 	db := setupTestDB(t)
 	defer db.Close()
 
 	apiKey := createTestAPIKey(t, db)
 
-	if err := db.DeleteAPIKey(apiKey.ID); err != nil {
+	if err := db.DeleteAPIKey(ctx, apiKey.ID); err != nil {
 		t.Fatalf("Failed to delete API key: %v", err)
 	}
 
-	_, err := db.ReadAPIKeyByID(apiKey.ID)
+	_, err := db.ReadAPIKeyByID(ctx, apiKey.ID)
 	if err == nil {
 		t.Fatal("Expected error when reading deleted API key")
 	}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -12,13 +15,14 @@ func setupTestUserDB(t *testing.T) *DB {
 }
 
 func createTestUser(t *testing.T, db *DB) User {
+	id := uniqueID()
 	user := User{
-		ID:        1,
-		Username:  "testuser",
+		ID:        id,
+		Username:  fmt.Sprintf("testuser-%d", id),
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	query := `INSERT INTO User (id, username, created_at, updated_at) VALUES ($1, $2, $3, $4)`
+	query := `INSERT INTO users (id, username, created_at, updated_at) VALUES ($1, $2, $3, $4)`
 	_, err := db.Exec(query, user.ID, user.Username, user.CreatedAt, user.UpdatedAt)
 	if err != nil {
 		t.Fatalf("Failed to create test user: %v", err)
@@ -37,7 +41,7 @@ func TestGetUserByID(t *testing.T) {
 	defer db.Close()
 	created := createTestUser(t, db)
 	var user User
-	query := `SELECT * FROM User WHERE id = $1`
+	query := `SELECT id, username, created_at, updated_at FROM users WHERE id = $1`
 	err := db.Get(&user, query, created.ID)
 	if err != nil {
 		t.Fatalf("Failed to get user by ID: %v", err)
@@ -51,18 +55,18 @@ func TestUpdateUser(t *testing.T) {
 	db := setupTestUserDB(t)
 	defer db.Close()
 	created := createTestUser(t, db)
-	created.Username = "updateduser"
-	query := `UPDATE User SET username = $1, updated_at = $2 WHERE id = $3`
+	created.Username += "-updated"
+	query := `UPDATE users SET username = $1, updated_at = $2 WHERE id = $3`
 	_, err := db.Exec(query, created.Username, time.Now(), created.ID)
 	if err != nil {
 		t.Fatalf("Failed to update user: %v", err)
 	}
 	var user User
-	err = db.Get(&user, `SELECT * FROM User WHERE id = $1`, created.ID)
+	err = db.Get(&user, `SELECT id, username, created_at, updated_at FROM users WHERE id = $1`, created.ID)
 	if err != nil {
 		t.Fatalf("Failed to get user after update: %v", err)
 	}
-	if user.Username != "updateduser" {
+	if user.Username != created.Username {
 		t.Fatalf("Expected updated username, got %s", user.Username)
 	}
 }
@@ -71,13 +75,57 @@ func TestDeleteUser(t *testing.T) {
 	db := setupTestUserDB(t)
 	defer db.Close()
 	created := createTestUser(t, db)
-	_, err := db.Exec(`DELETE FROM User WHERE id = $1`, created.ID)
+	_, err := db.Exec(`DELETE FROM users WHERE id = $1`, created.ID)
 	if err != nil {
 		t.Fatalf("Failed to delete user: %v", err)
 	}
 	var user User
-	err = db.Get(&user, `SELECT * FROM User WHERE id = $1`, created.ID)
+	err = db.Get(&user, `SELECT id, username, created_at, updated_at FROM users WHERE id = $1`, created.ID)
 	if err == nil {
 		t.Fatal("Expected error for deleted user, got nil")
 	}
 }
+
+func TestRoleValid(t *testing.T) {
+	for _, role := range []Role{RoleAthlete, RoleCoach, RoleOperator} {
+		if !role.Valid() {
+			t.Fatalf("Expected role %s to be valid", role)
+		}
+	}
+	if Role("admin").Valid() {
+		t.Fatal("Expected unknown role to be invalid")
+	}
+}
+
+func TestSaveUserTokens(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestUserDB(t)
+	defer db.Close()
+
+	userID := int64(9201)
+	if _, err := db.Exec(`INSERT INTO users (id) VALUES ($1) ON CONFLICT (id) DO NOTHING`, userID); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	defer db.DeleteUser(ctx, userID)
+
+	// Users without a username or tokens can be read
+	if _, err := db.GetUserByID(ctx, userID); err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+
+	expiresAt := time.Now().UTC().Add(6 * time.Hour).Truncate(time.Second)
+	if err := db.SaveUserTokens(ctx, userID, "access", "refresh", expiresAt); err != nil {
+		t.Fatalf("Failed to save tokens: %v", err)
+	}
+	user, err := db.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if user.AccessToken != "access" || user.RefreshToken != "refresh" || !user.TokenExpiresAt.Equal(expiresAt) {
+		t.Fatalf("Expected the saved tokens, got %q %q %v", user.AccessToken, user.RefreshToken, user.TokenExpiresAt)
+	}
+
+	if err := db.SaveUserTokens(ctx, 9299, "access", "refresh", expiresAt); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// webhooks are outbound subscriptions of users to activity events.
+// webhook_deliveries queues one delivery per matching webhook and event and
+// keeps the outcome of its last attempt as the delivery log.
+var webhookSchema = `
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL,
+	url TEXT NOT NULL,
+	events TEXT[] NOT NULL,
+	secret TEXT NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS webhooks_user_idx ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	response_status INT,
+	response_body TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	duration_ms INT,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);`
+
+var sqliteWebhookSchema = `
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id BIGINT NOT NULL,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now'))
+);
+CREATE INDEX IF NOT EXISTS webhooks_user_idx ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+	event TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	response_status INT,
+	response_body TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	duration_ms INT,
+	created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f000', 'now')),
+	finished_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);`
+
+// Delivery states. Failed deliveries are retried until they run out of
+// attempts and are marked failed.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is a user's subscription to events. The secret signs the
+// deliveries and is only shown when the webhook is created.
+type Webhook struct {
+	ID        int64          `db:"id" json:"id"`
+	UserID    int64          `db:"user_id" json:"user_id"`
+	URL       string         `db:"url" json:"url"`
+	Events    pq.StringArray `db:"events" json:"events"`
+	Secret    string         `db:"secret" json:"-"`
+	Active    bool           `db:"active" json:"active"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// WebhookDelivery is a queued or finished delivery. URL and Secret are only
+// set on claimed deliveries.
+type WebhookDelivery struct {
+	ID             int64           `db:"id" json:"id"`
+	WebhookID      int64           `db:"webhook_id" json:"webhook_id"`
+	Event          string          `db:"event" json:"event"`
+	Payload        json.RawMessage `db:"payload" json:"payload"`
+	Status         string          `db:"status" json:"status"`
+	Attempts       int             `db:"attempts" json:"attempts"`
+	NextAttemptAt  time.Time       `db:"next_attempt_at" json:"next_attempt_at"`
+	ResponseStatus *int            `db:"response_status" json:"response_status,omitempty"`
+	ResponseBody   string          `db:"response_body" json:"response_body,omitempty"`
+	Error          string          `db:"error" json:"error,omitempty"`
+	DurationMS     *int            `db:"duration_ms" json:"duration_ms,omitempty"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	FinishedAt     *time.Time      `db:"finished_at" json:"finished_at,omitempty"`
+
+	URL    string `db:"url" json:"-"`
+	Secret string `db:"secret" json:"-"`
+	Active bool   `db:"active" json:"-"`
+}
+
+// WebhookAttempt is the outcome of sending a delivery. ResponseStatus is 0
+// if no response was received.
+type WebhookAttempt struct {
+	Succeeded      bool
+	ResponseStatus int
+	ResponseBody   string
+	Error          string
+	Duration       time.Duration
+}
+
+// DB Schema for webhooks and their deliveries
+func (db *DB) CreateWebhookSchema() {
+	db.MustExec(dialect(db, webhookSchema, sqliteWebhookSchema))
+}
+
+// CreateWebhook stores a new webhook
+func (db *DB) CreateWebhook(ctx context.Context, webhook Webhook) (Webhook, error) {
+	query := `
+		INSERT INTO webhooks (user_id, url, events, secret, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`
+	err := db.GetContext(ctx, &webhook, query, webhook.UserID, webhook.URL, webhook.Events, webhook.Secret, webhook.Active)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("error creating webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// GetWebhook returns a webhook by ID
+func (db *DB) GetWebhook(ctx context.Context, id int64) (Webhook, error) {
+	var webhook Webhook
+	err := db.GetContext(ctx, &webhook, `SELECT * FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, fmt.Errorf("webhook %d %w", id, ErrNotFound)
+		}
+		return Webhook{}, fmt.Errorf("error retrieving webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// ListWebhooks returns the webhooks of a user
+func (db *DB) ListWebhooks(ctx context.Context, userID int64) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := db.SelectContext(ctx, &webhooks, `SELECT * FROM webhooks WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes the URL, events and active flag of a webhook
+func (db *DB) UpdateWebhook(ctx context.Context, webhook Webhook) (Webhook, error) {
+	query := `
+		UPDATE webhooks
+		SET url = $2, events = $3, active = $4, updated_at = NOW()
+		WHERE id = $1
+		RETURNING *
+	`
+	err := db.GetContext(ctx, &webhook, query, webhook.ID, webhook.URL, webhook.Events, webhook.Active)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, fmt.Errorf("webhook %d %w", webhook.ID, ErrNotFound)
+		}
+		return Webhook{}, fmt.Errorf("error updating webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook and its deliveries
+func (db *DB) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("webhook %d %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// queueWebhookDeliveries queues a delivery of an event for every active
+// webhook subscribed to it whose owner may read the activity: the athlete
+// themselves, their coaches and operators
+func queueWebhookDeliveries(ctx context.Context, tx *sqlx.Tx, event string, payload []byte, athleteID int64) error {
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT w.id, $1, $2
+		FROM webhooks w
+		JOIN users u ON u.id = w.user_id
+		WHERE w.active AND ` + dialect(tx, `$1 = ANY (w.events)`, `array_contains(w.events, $1)`) + ` AND (
+			u.role = 'operator'
+			OR u.athlete_id = $3
+			OR EXISTS (
+				SELECT 1 FROM coach_athletes ca
+				JOIN users a ON a.id = ca.athlete_id
+				WHERE ca.coach_id = u.id AND a.athlete_id = $3
+			)
+		)
+	`
+	if _, err := tx.ExecContext(ctx, query, event, payload, athleteID); err != nil {
+		return fmt.Errorf("error queueing webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhookDelivery returns the next due delivery with the URL and secret
+// of its webhook, or nil if none is due. The delivery stays pending but is
+// not due again before lease has passed, so it is retried if the instance
+// sending it dies.
+func (db *DB) ClaimWebhookDelivery(ctx context.Context, lease time.Duration) (*WebhookDelivery, error) {
+	if isSQLite(db) {
+		return db.claimSQLiteWebhookDelivery(ctx, lease)
+	}
+	var delivery WebhookDelivery
+	query := `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET
+				attempts = attempts + 1,
+				next_attempt_at = NOW() + $1::interval
+			WHERE id = (
+				SELECT id FROM webhook_deliveries
+				WHERE status = 'pending' AND next_attempt_at <= NOW()
+				ORDER BY next_attempt_at, id
+				FOR UPDATE SKIP LOCKED
+				LIMIT 1
+			)
+			RETURNING *
+		)
+		SELECT claimed.*, w.url, w.secret, w.active
+		FROM claimed
+		JOIN webhooks w ON w.id = claimed.webhook_id
+	`
+	err := db.GetContext(ctx, &delivery, query, fmt.Sprintf("%d milliseconds", lease.Milliseconds()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error claiming webhook delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// claimSQLiteWebhookDelivery is ClaimWebhookDelivery for SQLite, which does
+// not allow UPDATE in a WITH clause. The transaction holds the write lock, so
+// no other instance can claim the delivery in between.
+func (db *DB) claimSQLiteWebhookDelivery(ctx context.Context, lease time.Duration) (*WebhookDelivery, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	query := `
+		UPDATE webhook_deliveries SET
+			attempts = attempts + 1,
+			next_attempt_at = strftime('%Y-%m-%d %H:%M:%f000', NOW(), ($1 / 1000.0) || ' seconds')
+		WHERE id = (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT 1
+		)
+		RETURNING id
+	`
+	if err := tx.GetContext(ctx, &id, query, lease.Milliseconds()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error claiming webhook delivery: %w", err)
+	}
+
+	var delivery WebhookDelivery
+	query = `
+		SELECT d.*, w.url, w.secret, w.active
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $1
+	`
+	if err := tx.GetContext(ctx, &delivery, query, id); err != nil {
+		return nil, fmt.Errorf("error claiming webhook delivery: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing webhook delivery claim: %w", err)
+	}
+	return &delivery, nil
+}
+
+// RecordWebhookAttempt stores the outcome of an attempt. A failed delivery
+// is retried at retryAt, or marked failed if retryAt is zero.
+func (db *DB) RecordWebhookAttempt(ctx context.Context, id int64, attempt WebhookAttempt, retryAt time.Time) error {
+	status := DeliverySucceeded
+	if !attempt.Succeeded {
+		status = DeliveryFailed
+		if !retryAt.IsZero() {
+			status = DeliveryPending
+		}
+	}
+	var responseStatus *int
+	if attempt.ResponseStatus != 0 {
+		responseStatus = &attempt.ResponseStatus
+	}
+
+	query := `
+		UPDATE webhook_deliveries SET
+			status = $2,
+			next_attempt_at = COALESCE($3, next_attempt_at),
+			response_status = $4,
+			response_body = $5,
+			error = $6,
+			duration_ms = $7,
+			finished_at = CASE WHEN $2 = 'pending' THEN NULL ELSE NOW() END
+		WHERE id = $1
+	`
+	var next *time.Time
+	if status == DeliveryPending {
+		next = &retryAt
+	}
+	_, err := db.ExecContext(ctx, query, id, status, next, responseStatus, attempt.ResponseBody,
+		attempt.Error, attempt.Duration.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("error recording webhook delivery %d: %w", id, err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns the deliveries of a webhook, newest first
+func (db *DB) ListWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	query := `
+		SELECT * FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+	err := db.SelectContext(ctx, &deliveries, query, webhookID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues a delivery of a webhook again with a fresh set of
+// attempts
+func (db *DB) RedeliverWebhook(ctx context.Context, webhookID, deliveryID int64) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	query := `
+		UPDATE webhook_deliveries SET
+			status = 'pending',
+			attempts = 0,
+			next_attempt_at = NOW(),
+			finished_at = NULL
+		WHERE id = $1 AND webhook_id = $2
+		RETURNING *
+	`
+	err := db.GetContext(ctx, &delivery, query, deliveryID, webhookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookDelivery{}, fmt.Errorf("webhook delivery %d %w", deliveryID, ErrNotFound)
+		}
+		return WebhookDelivery{}, fmt.Errorf("error redelivering webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// PruneWebhookDeliveries deletes finished deliveries older than before
+func (db *DB) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM webhook_deliveries
+		WHERE status <> 'pending' AND finished_at < $1
+	`
+	result, err := db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning webhook deliveries: %w", err)
+	}
+	return result.RowsAffected()
+}
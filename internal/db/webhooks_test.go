@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	db := setupTestActivityDB(t)
+	defer db.Close()
+
+	activity := createTestActivity(t, db)
+	newUser := func(athleteID int64) int64 {
+		id := uniqueID()
+		if _, err := db.ExecContext(ctx, `INSERT INTO users (id, athlete_id) VALUES ($1, $2)`, id, athleteID); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		return id
+	}
+	athlete := newUser(activity.AthleteID)
+	stranger := newUser(activity.AthleteID + 1000)
+
+	own, err := db.CreateWebhook(ctx, Webhook{UserID: athlete, URL: "https://hooks.example.com/a", Events: []string{EventActivityUpdated}, Secret: "s", Active: true})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	defer db.DeleteWebhook(ctx, own.ID)
+	other, err := db.CreateWebhook(ctx, Webhook{UserID: stranger, URL: "https://hooks.example.com/b", Events: ActivityEvents, Secret: "s", Active: true})
+	if err != nil {
+		t.Fatalf("Failed to create webhook: %v", err)
+	}
+	defer db.DeleteWebhook(ctx, other.ID)
+
+	activity.Name = "Webhook test"
+	if _, err := db.UpdateActivity(ctx, activity); err != nil {
+		t.Fatalf("Failed to update activity: %v", err)
+	}
+
+	// Only the athlete's webhook is subscribed and may read the activity
+	deliveries, err := db.ListWebhookDeliveries(ctx, own.ID, 10, 0)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Expected one delivery, got %d, %v", len(deliveries), err)
+	}
+	if others, _ := db.ListWebhookDeliveries(ctx, other.ID, 10, 0); len(others) != 0 {
+		t.Fatalf("Expected no delivery for another athlete's webhook, got %d", len(others))
+	}
+	delivery := deliveries[0]
+	var payload ActivityEvent
+	if err := json.Unmarshal(delivery.Payload, &payload); err != nil || payload.Event != EventActivityUpdated || payload.Seq == 0 {
+		t.Fatalf("Unexpected payload %s, %v", delivery.Payload, err)
+	}
+
+	// A claimed delivery is leased and not handed out again before the lease
+	// has passed. Other tests may have left due deliveries behind.
+	for {
+		claimed, err := db.ClaimWebhookDelivery(ctx, time.Minute)
+		if err != nil || claimed == nil {
+			t.Fatalf("Expected to claim the delivery, got %v, %v", claimed, err)
+		}
+		if claimed.ID != delivery.ID {
+			continue
+		}
+		if claimed.Attempts != 1 || claimed.URL != own.URL || !claimed.NextAttemptAt.After(time.Now()) {
+			t.Fatalf("Unexpected claimed delivery %+v", claimed)
+		}
+		break
+	}
+
+	retryAt := time.Now().Add(time.Hour)
+	if err := db.RecordWebhookAttempt(ctx, delivery.ID, WebhookAttempt{ResponseStatus: 500, Error: "unexpected status"}, retryAt); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+	if err := db.RecordWebhookAttempt(ctx, delivery.ID, WebhookAttempt{ResponseStatus: 500}, time.Time{}); err != nil {
+		t.Fatalf("Failed to record attempt: %v", err)
+	}
+	deliveries, _ = db.ListWebhookDeliveries(ctx, own.ID, 10, 0)
+	if deliveries[0].Status != DeliveryFailed || deliveries[0].FinishedAt == nil {
+		t.Fatalf("Expected a failed delivery, got %+v", deliveries[0])
+	}
+
+	redelivered, err := db.RedeliverWebhook(ctx, own.ID, delivery.ID)
+	if err != nil || redelivered.Status != DeliveryPending || redelivered.Attempts != 0 {
+		t.Fatalf("Expected a pending delivery, got %+v, %v", redelivered, err)
+	}
+	if _, err := db.RedeliverWebhook(ctx, other.ID, delivery.ID); err == nil {
+		t.Fatal("Expected an error redelivering through another webhook")
+	}
+}
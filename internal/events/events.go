@@ -0,0 +1,43 @@
+// Package events publishes activity events from the outbox to a message
+// broker
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// ioTimeout bounds every exchange with the broker that has no earlier
+// context deadline
+const ioTimeout = 10 * time.Second
+
+// EventPublisher publishes activity events to a message broker. Publish
+// returns nil once the broker has accepted all events in order. Events may be
+// published more than once, e.g. when the relay fails between publishing and
+// marking them, so consumers deduplicate by the event's Seq.
+type EventPublisher interface {
+	Publish(ctx context.Context, events []db.OutboxEvent) error
+	Close() error
+}
+
+// NewPublisher returns the publisher of the configured broker. It connects
+// on the first Publish.
+func NewPublisher(cfg config.Events) (EventPublisher, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid events URL: %w", err)
+	}
+	switch cfg.Broker {
+	case "nats":
+		return NewNATSPublisher(u, cfg.Password, cfg.Topic), nil
+	case "redis":
+		return NewRedisPublisher(u, cfg.Password, cfg.Topic, cfg.MaxLen)
+	default:
+		return nil, fmt.Errorf("unknown events broker %q", cfg.Broker)
+	}
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes events to <prefix>.<event> subjects of a NATS
+// server. Each message carries the event's change sequence number in the
+// Nats-Msg-Id header, which JetStream uses to drop duplicates.
+type NATSPublisher struct {
+	url      *url.URL
+	password string
+	prefix   string
+
+	mu   sync.Mutex
+	conn *nats.Conn
+}
+
+// NewNATSPublisher creates a publisher for the server at u (nats:// or
+// tls://). A user name in u and password are sent on connect; a password
+// without a user name is sent as token.
+func NewNATSPublisher(u *url.URL, password, prefix string) *NATSPublisher {
+	return &NATSPublisher{url: u, password: password, prefix: prefix}
+}
+
+// Publish sends the events and waits for the server to confirm it has
+// processed them
+func (p *NATSPublisher) Publish(ctx context.Context, events []db.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.publish(ctx, events); err != nil {
+		p.close() // reconnect on the next call
+		return fmt.Errorf("error publishing to NATS: %w", err)
+	}
+	return nil
+}
+
+func (p *NATSPublisher) publish(ctx context.Context, events []db.OutboxEvent) error {
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+
+	for _, event := range events {
+		if max := p.conn.MaxPayload(); max > 0 && int64(len(event.Payload)) > max {
+			return fmt.Errorf("event %d is larger than the server's max_payload of %d bytes", event.ID, max)
+		}
+		msg := nats.NewMsg(p.prefix + "." + event.Event)
+		msg.Header.Set(nats.MsgIdHdr, strconv.FormatInt(event.Seq, 10))
+		msg.Data = event.Payload
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+
+	// The server handles messages in order, so errors of earlier messages
+	// arrive before the PONG of the flush
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ioTimeout)
+		defer cancel()
+	}
+	if err := p.conn.FlushWithContext(ctx); err != nil {
+		return err
+	}
+	return p.conn.LastError()
+}
+
+// connect opens the connection and authenticates. The client does not
+// reconnect on its own: a failed Publish closes the connection and the
+// next one connects again, so no message is buffered unconfirmed.
+func (p *NATSPublisher) connect() error {
+	server := *p.url
+	server.User = nil
+	options := []nats.Option{
+		nats.Name("strava-data-pipeline"),
+		nats.Timeout(ioTimeout),
+		nats.NoReconnect(),
+	}
+	if user := p.url.User.Username(); user != "" {
+		options = append(options, nats.UserInfo(user, p.password))
+	} else if p.password != "" {
+		options = append(options, nats.Token(p.password))
+	}
+
+	conn, err := nats.Connect(server.String(), options...)
+	if err != nil {
+		return err
+	}
+	if !conn.HeadersSupported() {
+		conn.Close()
+		return nats.ErrHeadersNotSupported
+	}
+	p.conn = conn
+	return nil
+}
+
+// Close closes the connection
+func (p *NATSPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.close()
+}
+
+func (p *NATSPublisher) close() error {
+	if p.conn == nil {
+		return nil
+	}
+	p.conn.Close()
+	p.conn = nil
+	return nil
+}
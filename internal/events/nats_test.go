@@ -0,0 +1,179 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// natsMessage is a message received by fakeNATS
+type natsMessage struct {
+	Subject string
+	Header  string
+	Payload string
+}
+
+// fakeNATS is a NATS server that accepts the user "relay" with password
+// "secret" and records the published messages
+type fakeNATS struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []natsMessage
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeNATS{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) url() *url.URL {
+	return &url.URL{Scheme: "nats", Host: f.listener.Addr().String(), User: url.User("relay")}
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, `INFO {"server_id":"fake","headers":true,"max_payload":1024}`+"\r\n")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, args, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch verb {
+		case "CONNECT":
+			var options struct{ User, Pass string }
+			json.Unmarshal([]byte(args), &options)
+			if options.User != "relay" || options.Pass != "secret" {
+				io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "HPUB":
+			fields := strings.Fields(args)
+			headerLen, _ := strconv.Atoi(fields[1])
+			totalLen, _ := strconv.Atoi(fields[2])
+			data := make([]byte, totalLen+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.messages = append(f.messages, natsMessage{
+				Subject: fields[0],
+				Header:  string(data[:headerLen]),
+				Payload: string(data[headerLen:totalLen]),
+			})
+			f.mu.Unlock()
+		default:
+			fmt.Fprintf(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+func testEvents() []db.OutboxEvent {
+	return []db.OutboxEvent{
+		{ID: 1, Event: db.EventActivityCreated, Seq: 41, AthleteID: 3, Payload: []byte(`{"event":"activity.created","seq":41}`)},
+		{ID: 2, Event: db.EventActivityDeleted, Seq: 42, AthleteID: 3, Payload: []byte(`{"event":"activity.deleted","seq":42}`)},
+	}
+}
+
+func TestNATSPublisher(t *testing.T) {
+	server := newFakeNATS(t)
+	publisher := NewNATSPublisher(server.url(), "secret", "strava")
+	defer publisher.Close()
+
+	ctx := context.Background()
+	if err := publisher.Publish(ctx, testEvents()); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+	// The connection is reused
+	if err := publisher.Publish(ctx, testEvents()[:1]); err != nil {
+		t.Fatalf("Failed to publish again: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(server.messages))
+	}
+	got := server.messages[1]
+	if got.Subject != "strava.activity.deleted" || got.Payload != `{"event":"activity.deleted","seq":42}` {
+		t.Fatalf("Unexpected message %+v", got)
+	}
+	if !strings.Contains(got.Header, "Nats-Msg-Id: 42\r\n") {
+		t.Fatalf("Expected the sequence number as message ID, got %q", got.Header)
+	}
+}
+
+func TestNATSPublisherErrors(t *testing.T) {
+	server := newFakeNATS(t)
+	ctx := context.Background()
+
+	publisher := NewNATSPublisher(server.url(), "wrong", "strava")
+	if err := publisher.Publish(ctx, testEvents()); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Fatalf("Expected an authorization error, got %v", err)
+	}
+
+	publisher = NewNATSPublisher(server.url(), "secret", "strava")
+	large := db.OutboxEvent{ID: 3, Event: db.EventActivityUpdated, Payload: []byte(strings.Repeat("x", 2048))}
+	if err := publisher.Publish(ctx, []db.OutboxEvent{large}); err == nil || !strings.Contains(err.Error(), "max_payload") {
+		t.Fatalf("Expected a max_payload error, got %v", err)
+	}
+
+	server.listener.Close()
+	publisher = NewNATSPublisher(server.url(), "secret", "strava")
+	if err := publisher.Publish(ctx, testEvents()); err == nil {
+		t.Fatal("Expected an error when the server is down")
+	}
+}
+
+// TestNATSPublisherServer publishes to the server at EVENTS_TEST_NATS_URL,
+// e.g. nats://localhost:4222 started with `docker run -p 4222:4222 nats`
+func TestNATSPublisherServer(t *testing.T) {
+	raw := os.Getenv("EVENTS_TEST_NATS_URL")
+	if raw == "" {
+		t.Skip("EVENTS_TEST_NATS_URL is not set")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("Invalid EVENTS_TEST_NATS_URL: %v", err)
+	}
+	publisher := NewNATSPublisher(u, os.Getenv("EVENTS_TEST_NATS_PASSWORD"), "strava-test")
+	defer publisher.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := publisher.Publish(ctx, testEvents()); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisPublisher appends events to a Redis stream with XADD. Each entry has
+// the fields event, seq, athlete_id and payload; consumers deduplicate by
+// seq.
+type RedisPublisher struct {
+	client *redis.Client
+	stream string
+	maxLen int
+}
+
+// NewRedisPublisher creates a publisher for the server at u (redis:// or
+// rediss://, optionally with a user name and the database number as path).
+// If maxLen is positive the stream is trimmed to about that many entries.
+func NewRedisPublisher(u *url.URL, password, stream string, maxLen int) (*RedisPublisher, error) {
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if password != "" {
+		opts.Password = password
+	}
+	opts.DialTimeout = ioTimeout
+	opts.ReadTimeout = ioTimeout
+	opts.WriteTimeout = ioTimeout
+	opts.ContextTimeoutEnabled = true
+	return &RedisPublisher{client: redis.NewClient(opts), stream: stream, maxLen: maxLen}, nil
+}
+
+// Publish appends the events in one pipelined round trip
+func (p *RedisPublisher) Publish(ctx context.Context, events []db.OutboxEvent) error {
+	pipe := p.client.Pipeline()
+	for _, event := range events {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: p.stream,
+			MaxLen: int64(p.maxLen),
+			Approx: p.maxLen > 0,
+			Values: []interface{}{
+				"event", event.Event,
+				"seq", strconv.FormatInt(event.Seq, 10),
+				"athlete_id", strconv.FormatInt(event.AthleteID, 10),
+				"payload", string(event.Payload),
+			},
+		})
+	}
+	// Exec reads every reply and returns the first error
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("error publishing to Redis: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connections
+func (p *RedisPublisher) Close() error {
+	return p.client.Close()
+}
@@ -0,0 +1,189 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server that requires the password "secret", knows
+// databases 0 to 15 and records XADD commands. XADD to the stream "broken"
+// fails like a key of the wrong type.
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	commands [][]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeRedis{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) url(path string) *url.URL {
+	return &url.URL{Scheme: "redis", Host: f.listener.Addr().String(), Path: path}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := false
+	ids := 0
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			if args[len(args)-1] != "secret" {
+				io.WriteString(conn, "-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+				continue
+			}
+			authenticated = true
+			io.WriteString(conn, "+OK\r\n")
+		case "SELECT":
+			if n, err := strconv.Atoi(args[1]); err != nil || n > 15 {
+				io.WriteString(conn, "-ERR DB index is out of range\r\n")
+				continue
+			}
+			io.WriteString(conn, "+OK\r\n")
+		case "XADD":
+			if !authenticated {
+				io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+				continue
+			}
+			if args[1] == "broken" {
+				io.WriteString(conn, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+				continue
+			}
+			f.mu.Lock()
+			f.commands = append(f.commands, args)
+			f.mu.Unlock()
+			ids++
+			id := fmt.Sprintf("1700000000000-%d", ids)
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(id), id)
+		default:
+			io.WriteString(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+	if err != nil || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+// newTestRedisPublisher returns a publisher that is closed when the test ends
+func newTestRedisPublisher(t *testing.T, u *url.URL, password, stream string, maxLen int) *RedisPublisher {
+	t.Helper()
+	publisher, err := NewRedisPublisher(u, password, stream, maxLen)
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+	t.Cleanup(func() { publisher.Close() })
+	return publisher
+}
+
+func TestRedisPublisher(t *testing.T) {
+	server := newFakeRedis(t)
+	publisher := newTestRedisPublisher(t, server.url("/2"), "secret", "strava", 1000)
+
+	if err := publisher.Publish(context.Background(), testEvents()); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.commands) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(server.commands))
+	}
+	want := []string{"xadd", "strava", "maxlen", "~", "1000", "*",
+		"event", "activity.deleted", "seq", "42", "athlete_id", "3", "payload", `{"event":"activity.deleted","seq":42}`}
+	if !reflect.DeepEqual(server.commands[1], want) {
+		t.Fatalf("Expected\n%q\ngot\n%q", want, server.commands[1])
+	}
+}
+
+func TestRedisPublisherErrors(t *testing.T) {
+	server := newFakeRedis(t)
+	ctx := context.Background()
+
+	for name, publisher := range map[string]*RedisPublisher{
+		"WRONGPASS":    newTestRedisPublisher(t, server.url(""), "wrong", "strava", 0),
+		"NOAUTH":       newTestRedisPublisher(t, server.url(""), "", "strava", 0),
+		"out of range": newTestRedisPublisher(t, server.url("/99"), "secret", "strava", 0),
+		"WRONGTYPE":    newTestRedisPublisher(t, server.url(""), "secret", "broken", 0),
+	} {
+		if err := publisher.Publish(ctx, testEvents()); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected a %s error, got %v", name, err)
+		}
+	}
+}
+
+// TestRedisPublisherServer publishes to the server at EVENTS_TEST_REDIS_URL,
+// e.g. redis://localhost:6379/15 started with `docker run -p 6379:6379 redis`
+func TestRedisPublisherServer(t *testing.T) {
+	raw := os.Getenv("EVENTS_TEST_REDIS_URL")
+	if raw == "" {
+		t.Skip("EVENTS_TEST_REDIS_URL is not set")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("Invalid EVENTS_TEST_REDIS_URL: %v", err)
+	}
+	publisher := newTestRedisPublisher(t, u, os.Getenv("EVENTS_TEST_REDIS_PASSWORD"), "strava-test", 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := publisher.Publish(ctx, testEvents()); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+}
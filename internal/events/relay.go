@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// maxRetryDelay bounds the wait before retrying while the broker is down
+const maxRetryDelay = time.Minute
+
+// outboxStore is the part of *db.DB the relay uses
+type outboxStore interface {
+	ListPendingOutboxEvents(ctx context.Context, limit int) ([]db.OutboxEvent, error)
+	MarkOutboxEventsPublished(ctx context.Context, ids []int64) error
+	RecordOutboxFailure(ctx context.Context, id int64, cause string) error
+	PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Relay publishes the events of the outbox in order. Only one relay should
+// run at a time, which is why it runs on the leader.
+type Relay struct {
+	db        outboxStore
+	publisher EventPublisher
+	cfg       config.Events
+}
+
+// NewRelay creates a relay publishing with publisher
+func NewRelay(database *db.DB, publisher EventPublisher, cfg config.Events) *Relay {
+	return &Relay{db: database, publisher: publisher, cfg: cfg}
+}
+
+// Run publishes pending events until ctx is cancelled. While the broker
+// fails, the oldest event is retried with growing delays and nothing after
+// it is published, so consumers see events in commit order.
+func (r *Relay) Run(ctx context.Context) {
+	defer r.publisher.Close()
+
+	poll := time.Duration(r.cfg.PollInterval) * time.Second
+	failures := 0
+	for {
+		n, err := r.publishBatch(ctx)
+		wait := poll
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			wait = retryDelay(poll, failures)
+			slog.ErrorContext(ctx, "Error publishing activity events", "failures", failures, "retry_in", wait, "error", err)
+		case n == r.cfg.BatchSize:
+			failures = 0
+			continue // more events are waiting
+		default:
+			failures = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// publishBatch publishes the oldest pending events and returns how many
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	events, err := r.db.ListPendingOutboxEvents(ctx, r.cfg.BatchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	if err := r.publisher.Publish(ctx, events); err != nil {
+		if err := r.db.RecordOutboxFailure(context.WithoutCancel(ctx), events[0].ID, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "Error recording outbox failure", "error", err)
+		}
+		return 0, err
+	}
+
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	// Published events are marked even on shutdown, or they would be sent again
+	if err := r.db.MarkOutboxEventsPublished(context.WithoutCancel(ctx), ids); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// RunRetention deletes events published longer than the configured
+// retention period ago every interval until ctx is cancelled
+func (r *Relay) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().AddDate(0, 0, -r.cfg.RetentionDays)
+		n, err := r.db.PruneOutboxEvents(ctx, cutoff)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error pruning outbox events", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "Pruned outbox events", "count", n, "retention_days", r.cfg.RetentionDays)
+		}
+	}
+}
+
+// retryDelay doubles the poll interval with every consecutive failure up to
+// maxRetryDelay
+func retryDelay(poll time.Duration, failures int) time.Duration {
+	delay := poll
+	for i := 1; i < failures && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
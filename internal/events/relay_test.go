@@ -0,0 +1,123 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// memoryOutbox is an outboxStore kept in memory
+type memoryOutbox struct {
+	mu       sync.Mutex
+	events   []db.OutboxEvent
+	failures map[int64]string
+}
+
+func (m *memoryOutbox) ListPendingOutboxEvents(ctx context.Context, limit int) ([]db.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending []db.OutboxEvent
+	for _, event := range m.events {
+		if event.PublishedAt == nil && len(pending) < limit {
+			pending = append(pending, event)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memoryOutbox) MarkOutboxEventsPublished(ctx context.Context, ids []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for i := range m.events {
+		for _, id := range ids {
+			if m.events[i].ID == id {
+				m.events[i].PublishedAt = &now
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryOutbox) RecordOutboxFailure(ctx context.Context, id int64, cause string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[id] = cause
+	return nil
+}
+
+func (m *memoryOutbox) PruneOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// flakyPublisher fails the first failures calls and records the events of
+// the others
+type flakyPublisher struct {
+	failures  int
+	published []int64
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, events []db.OutboxEvent) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker down")
+	}
+	for _, event := range events {
+		p.published = append(p.published, event.ID)
+	}
+	return nil
+}
+
+func (p *flakyPublisher) Close() error { return nil }
+
+func TestRelayPublishesInOrder(t *testing.T) {
+	store := &memoryOutbox{failures: make(map[int64]string)}
+	for id := int64(1); id <= 5; id++ {
+		store.events = append(store.events, db.OutboxEvent{ID: id, Event: db.EventActivityUpdated, Seq: id})
+	}
+	publisher := &flakyPublisher{failures: 1}
+	relay := &Relay{db: store, publisher: publisher, cfg: config.Events{BatchSize: 2}}
+	ctx := context.Background()
+
+	if _, err := relay.publishBatch(ctx); err == nil {
+		t.Fatal("Expected the first batch to fail")
+	}
+	if store.failures[1] != "broker down" {
+		t.Fatalf("Expected the failure on the oldest event, got %v", store.failures)
+	}
+
+	for {
+		n, err := relay.publishBatch(ctx)
+		if err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(publisher.published, want) {
+		t.Fatalf("Expected %v, got %v", want, publisher.published)
+	}
+	if pending, _ := store.ListPendingOutboxEvents(ctx, 10); len(pending) != 0 {
+		t.Fatalf("Expected no pending events, got %d", len(pending))
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for failures, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		20: maxRetryDelay,
+	} {
+		if got := retryDelay(time.Second, failures); got != want {
+			t.Errorf("retryDelay(1s, %d) = %s, expected %s", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package export
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// Units of distances, speeds, elevations and paces in an export. Strava
+// stores meters and meters per second.
+type Units string
+
+const (
+	// UnitsMeters exports the values as stored: meters, meters per second
+	// and minutes per kilometer
+	UnitsMeters = Units("m")
+	// UnitsKilometers exports kilometers, kilometers per hour, meters of
+	// elevation and minutes per kilometer
+	UnitsKilometers = Units("km")
+	// UnitsMiles exports miles, miles per hour, feet of elevation and
+	// minutes per mile
+	UnitsMiles = Units("mi")
+)
+
+// ParseUnits returns the units with the given name
+func ParseUnits(name string) (Units, error) {
+	switch u := Units(name); u {
+	case UnitsMeters, UnitsKilometers, UnitsMiles:
+		return u, nil
+	}
+	return "", fmt.Errorf("unknown units %q", name)
+}
+
+const (
+	metersPerMile = 1609.344
+	feetPerMeter  = 3.28084
+)
+
+// distance converts meters
+func (u Units) distance(m float64) float64 {
+	switch u {
+	case UnitsKilometers:
+		return m / 1000
+	case UnitsMiles:
+		return m / metersPerMile
+	}
+	return m
+}
+
+// speed converts meters per second
+func (u Units) speed(mps float64) float64 {
+	switch u {
+	case UnitsKilometers:
+		return mps * 3.6
+	case UnitsMiles:
+		return mps * 3600 / metersPerMile
+	}
+	return mps
+}
+
+// elevation converts meters of elevation
+func (u Units) elevation(m float64) float64 {
+	if u == UnitsMiles {
+		return m * feetPerMeter
+	}
+	return m
+}
+
+// pace returns minutes per kilometer or mile, or nil for activities without
+// distance
+func (u Units) pace(movingTime int, distance float64) interface{} {
+	if distance <= 0 || movingTime <= 0 {
+		return nil
+	}
+	perUnit := distance / 1000
+	if u == UnitsMiles {
+		perUnit = distance / metersPerMile
+	}
+	return float64(movingTime) / 60 / perUnit
+}
+
+// kind is the type of a column's values
+type kind int
+
+const (
+	kindInt kind = iota
+	kindFloat
+	kindBool
+	kindString
+	kindTime
+)
+
+// column is an exported activity field. value returns nil for missing
+// values.
+type column struct {
+	name  string
+	kind  kind
+	value func(a db.Activity, u Units) interface{}
+}
+
+// DefaultColumns are exported if no columns are selected
+var DefaultColumns = []string{
+	"id", "athlete_id", "name", "type", "start_date", "start_date_local",
+	"timezone", "distance", "moving_time", "elapsed_time",
+	"total_elevation_gain", "average_speed", "max_speed", "has_heartrate",
+	"average_heartrate", "max_heartrate", "elev_high", "elev_low",
+	"kudos_count", "trainer", "commute", "manual", "private",
+}
+
+// Columns lists every column that can be selected, in the order of the
+// activities table
+var Columns []string
+
+var columnsByName = map[string]column{}
+
+func init() {
+	for _, col := range allColumns {
+		Columns = append(Columns, col.name)
+		columnsByName[col.name] = col
+	}
+}
+
+func intColumn(name string, f func(a db.Activity) int64) column {
+	return column{name, kindInt, func(a db.Activity, _ Units) interface{} { return f(a) }}
+}
+
+func floatColumn(name string, f func(a db.Activity, u Units) float64) column {
+	return column{name, kindFloat, func(a db.Activity, u Units) interface{} { return f(a, u) }}
+}
+
+func boolColumn(name string, f func(a db.Activity) bool) column {
+	return column{name, kindBool, func(a db.Activity, _ Units) interface{} { return f(a) }}
+}
+
+// stringColumn exports empty strings as missing values
+func stringColumn(name string, f func(a db.Activity) string) column {
+	return column{name, kindString, func(a db.Activity, _ Units) interface{} {
+		if s := f(a); s != "" {
+			return s
+		}
+		return nil
+	}}
+}
+
+// timeColumn exports zero times as missing values
+func timeColumn(name string, f func(a db.Activity) time.Time) column {
+	return column{name, kindTime, func(a db.Activity, _ Units) interface{} {
+		if t := f(a); !t.IsZero() {
+			return t.UTC()
+		}
+		return nil
+	}}
+}
+
+var allColumns = []column{
+	intColumn("id", func(a db.Activity) int64 { return a.ID }),
+	intColumn("athlete_id", func(a db.Activity) int64 { return a.AthleteID }),
+	stringColumn("name", func(a db.Activity) string { return a.Name }),
+	stringColumn("description", func(a db.Activity) string { return a.Description }),
+	stringColumn("type", func(a db.Activity) string { return a.Type }),
+	floatColumn("distance", func(a db.Activity, u Units) float64 { return u.distance(a.Distance) }),
+	intColumn("moving_time", func(a db.Activity) int64 { return int64(a.MovingTime) }),
+	intColumn("elapsed_time", func(a db.Activity) int64 { return int64(a.ElapsedTime) }),
+	floatColumn("total_elevation_gain", func(a db.Activity, u Units) float64 { return u.elevation(a.TotalElevationGain) }),
+	timeColumn("start_date", func(a db.Activity) time.Time { return a.StartDate }),
+	timeColumn("start_date_local", func(a db.Activity) time.Time { return a.StartDateLocal }),
+	stringColumn("timezone", func(a db.Activity) string { return a.Timezone }),
+	stringColumn("start_latlng", func(a db.Activity) string { return a.StartLatLng }),
+	stringColumn("end_latlng", func(a db.Activity) string { return a.EndLatLng }),
+	intColumn("achievement_count", func(a db.Activity) int64 { return int64(a.AchievementCount) }),
+	intColumn("kudos_count", func(a db.Activity) int64 { return int64(a.KudosCount) }),
+	intColumn("comment_count", func(a db.Activity) int64 { return int64(a.CommentCount) }),
+	intColumn("athlete_count", func(a db.Activity) int64 { return int64(a.AthleteCount) }),
+	intColumn("photo_count", func(a db.Activity) int64 { return int64(a.PhotoCount) }),
+	stringColumn("map_polyline", func(a db.Activity) string { return a.MapPolyline }),
+	boolColumn("trainer", func(a db.Activity) bool { return a.Trainer }),
+	boolColumn("commute", func(a db.Activity) bool { return a.Commute }),
+	boolColumn("manual", func(a db.Activity) bool { return a.Manual }),
+	boolColumn("private", func(a db.Activity) bool { return a.Private }),
+	stringColumn("visibility", func(a db.Activity) string { return a.Visibility }),
+	intColumn("workout_type", func(a db.Activity) int64 { return int64(a.WorkoutType) }),
+	floatColumn("average_speed", func(a db.Activity, u Units) float64 { return u.speed(a.AverageSpeed) }),
+	floatColumn("max_speed", func(a db.Activity, u Units) float64 { return u.speed(a.MaxSpeed) }),
+	{"pace", kindFloat, func(a db.Activity, u Units) interface{} { return u.pace(a.MovingTime, a.Distance) }},
+	boolColumn("has_heartrate", func(a db.Activity) bool { return a.HasHeartRate }),
+	floatColumn("average_heartrate", func(a db.Activity, _ Units) float64 { return a.AverageHeartRate }),
+	floatColumn("max_heartrate", func(a db.Activity, _ Units) float64 { return a.MaxHeartRate }),
+	floatColumn("elev_high", func(a db.Activity, u Units) float64 { return u.elevation(a.ElevHigh) }),
+	floatColumn("elev_low", func(a db.Activity, u Units) float64 { return u.elevation(a.ElevLow) }),
+	stringColumn("external_id", func(a db.Activity) string { return a.ExternalID }),
+}
@@ -0,0 +1,84 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// csvWriter writes a header row followed by one row per activity
+type csvWriter struct {
+	w           *csv.Writer
+	cols        []column
+	units       Units
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer, cols []column, units Units) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w), cols: cols, units: units}
+}
+
+func (c *csvWriter) Write(activity db.Activity) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+
+	record := make([]string, len(c.cols))
+	for i, col := range c.cols {
+		record[i] = formatCSV(col.value(activity, c.units))
+	}
+	if err := c.w.Write(record); err != nil {
+		return fmt.Errorf("error writing activity %d: %w", activity.ID, err)
+	}
+	return nil
+}
+
+// Close writes the header if no activity was written, so that an empty
+// export is still a valid CSV file
+func (c *csvWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.wroteHeader {
+		return nil
+	}
+	c.wroteHeader = true
+
+	header := make([]string, len(c.cols))
+	for i, col := range c.cols {
+		header[i] = col.name
+	}
+	if err := c.w.Write(header); err != nil {
+		return fmt.Errorf("error writing header: %w", err)
+	}
+	return nil
+}
+
+// formatCSV formats a column value. Times are written in RFC 3339 and
+// missing values as empty cells.
+func formatCSV(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,162 @@
+// Package export writes activities in file formats that analysis tools can
+// read directly
+package export
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// Format of an export
+type Format string
+
+const (
+	FormatCSV     = Format("csv")
+	FormatNDJSON  = Format("ndjson")
+	FormatParquet = Format("parquet")
+)
+
+// ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatCSV, FormatNDJSON, FormatParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown export format %q", name)
+}
+
+// ContentType is the media type of files in the format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	}
+	return "application/vnd.apache.parquet"
+}
+
+// Options control what is exported and how
+type Options struct {
+	Format Format
+	// Columns are the exported columns in output order, DefaultColumns if
+	// empty
+	Columns []string
+	// Units of distances, speeds and paces, UnitsMeters if empty
+	Units Units
+	// Gzip compresses the output. CSV and NDJSON are wrapped in a gzip
+	// stream; Parquet files stay uncompressed on the outside and compress
+	// their pages instead, so that Parquet readers can open them.
+	Gzip bool
+}
+
+// Filename returns the name of an export file with the given base name,
+// e.g. activities.csv.gz
+func (o Options) Filename(base string) string {
+	name := base + "." + string(o.Format)
+	if o.Gzip && o.Format != FormatParquet {
+		name += ".gz"
+	}
+	return name
+}
+
+// Writer writes activities one at a time. Close must be called after the last
+// activity to flush buffered output; it does not close the underlying writer.
+type Writer interface {
+	Write(activity db.Activity) error
+	Close() error
+}
+
+// NewWriter returns a writer for the given options
+func NewWriter(w io.Writer, opts Options) (Writer, error) {
+	if opts.Units == "" {
+		opts.Units = UnitsMeters
+	}
+	if _, err := ParseUnits(string(opts.Units)); err != nil {
+		return nil, err
+	}
+	cols, err := selectColumns(opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.Format {
+	case FormatParquet:
+		return newParquetWriter(w, cols, opts.Units, opts.Gzip), nil
+	case FormatCSV, FormatNDJSON:
+	default:
+		return nil, fmt.Errorf("unknown export format %q", opts.Format)
+	}
+
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	var out Writer
+	if opts.Format == FormatCSV {
+		out = newCSVWriter(w, cols, opts.Units)
+	} else {
+		out = newNDJSONWriter(w, cols, opts.Units)
+	}
+	if gz != nil {
+		out = gzipWriter{out, gz}
+	}
+	return out, nil
+}
+
+// gzipWriter closes the gzip stream after the wrapped writer
+type gzipWriter struct {
+	Writer
+	gz *gzip.Writer
+}
+
+func (g gzipWriter) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		return err
+	}
+	return g.gz.Close()
+}
+
+// ParseColumns splits a comma-separated list of column names and checks that
+// every column exists. An empty list selects DefaultColumns.
+func ParseColumns(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	names := strings.Split(list, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	if _, err := selectColumns(names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// selectColumns looks up the named columns
+func selectColumns(names []string) ([]column, error) {
+	if len(names) == 0 {
+		names = DefaultColumns
+	}
+
+	cols := make([]column, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		col, ok := columnsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q selected twice", name)
+		}
+		seen[name] = true
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
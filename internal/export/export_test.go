@@ -0,0 +1,293 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/parquet-go/parquet-go"
+)
+
+var testActivity = db.Activity{
+	ID:         42,
+	AthleteID:  7,
+	Name:       "Morning, run",
+	Type:       "Run",
+	StartDate:  time.Date(2024, 5, 1, 6, 30, 0, 0, time.UTC),
+	Distance:   10000,
+	MovingTime: 3000,
+	MaxSpeed:   5,
+	Commute:    true,
+	ElevHigh:   100,
+}
+
+// export writes activities with the given options and returns the output
+func export(t *testing.T, opts Options, activities ...db.Activity) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, opts)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	for _, a := range activities {
+		if err := w.Write(a); err != nil {
+			t.Fatalf("Failed to write activity: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// csvRows reads a CSV export into one map per row
+func csvRows(t *testing.T, data []byte) []map[string]string {
+	t.Helper()
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for i, name := range records[0] {
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestCSVWriter(t *testing.T) {
+	rows := csvRows(t, export(t, Options{Format: FormatCSV}, testActivity))
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+
+	want := map[string]string{
+		"id":               "42",
+		"athlete_id":       "7",
+		"name":             "Morning, run",
+		"start_date":       "2024-05-01T06:30:00Z",
+		"start_date_local": "",
+		"distance":         "10000",
+		"commute":          "true",
+	}
+	for name, value := range want {
+		if rows[0][name] != value {
+			t.Errorf("Expected %s %q, got %q", name, value, rows[0][name])
+		}
+	}
+}
+
+func TestCSVWriterEmpty(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(export(t, Options{Format: FormatCSV}))).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(records) != 1 || records[0][0] != "id" {
+		t.Errorf("Expected only the header, got %v", records)
+	}
+}
+
+func TestColumnsAndUnits(t *testing.T) {
+	tests := []struct {
+		units Units
+		want  map[string]string
+	}{
+		{UnitsMeters, map[string]string{"distance": "10000", "max_speed": "5", "pace": "5", "elev_high": "100"}},
+		{UnitsKilometers, map[string]string{"distance": "10", "max_speed": "18", "pace": "5", "elev_high": "100"}},
+		{UnitsMiles, map[string]string{"distance": "6.2137", "max_speed": "11.1846", "pace": "8.0467", "elev_high": "328.084"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.units), func(t *testing.T) {
+			opts := Options{Format: FormatCSV, Units: tt.units, Columns: []string{"distance", "max_speed", "pace", "elev_high"}}
+			data := export(t, opts, testActivity, db.Activity{ID: 1})
+
+			if header := strings.SplitN(string(data), "\n", 2)[0]; header != "distance,max_speed,pace,elev_high" {
+				t.Fatalf("Expected the selected columns in order, got %q", header)
+			}
+			rows := csvRows(t, data)
+			for name, value := range tt.want {
+				if got := rows[0][name]; !strings.HasPrefix(got, value) {
+					t.Errorf("Expected %s %s, got %s", name, value, got)
+				}
+			}
+			if rows[1]["pace"] != "" {
+				t.Errorf("Expected no pace without distance, got %q", rows[1]["pace"])
+			}
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Format: "xlsx"},
+		{Format: FormatCSV, Units: "furlong"},
+		{Format: FormatCSV, Columns: []string{"nope"}},
+		{Format: FormatCSV, Columns: []string{"id", "id"}},
+	} {
+		if _, err := NewWriter(io.Discard, opts); err == nil {
+			t.Errorf("Expected an error for %+v", opts)
+		}
+	}
+
+	if _, err := ParseColumns("id, name"); err != nil {
+		t.Errorf("Expected valid columns, got %v", err)
+	}
+	if cols, err := ParseColumns(""); err != nil || cols != nil {
+		t.Errorf("Expected the default columns, got %v, %v", cols, err)
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	opts := Options{Format: FormatNDJSON, Gzip: true, Columns: []string{"id", "name", "start_date_local", "pace"}}
+	gz, err := gzip.NewReader(bytes.NewReader(export(t, opts, testActivity, testActivity)))
+	if err != nil {
+		t.Fatalf("Expected gzip output: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	if !strings.HasPrefix(lines[0], `{"id":42,"name":"Morning, run",`) {
+		t.Errorf("Expected keys in column order, got %s", lines[0])
+	}
+
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+		t.Fatalf("Invalid JSON line: %v", err)
+	}
+	if v, ok := row["start_date_local"]; !ok || v != nil {
+		t.Errorf("Expected start_date_local null, got %v", v)
+	}
+	if row["pace"] != 5.0 {
+		t.Errorf("Expected pace 5, got %v", row["pace"])
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		activities := []db.Activity{testActivity, {ID: 43, Trainer: true}}
+		for i := 0; i < rowGroupSize; i++ {
+			activities = append(activities, db.Activity{ID: int64(100 + i), Name: "Ride", Distance: float64(i)})
+		}
+		opts := Options{Format: FormatParquet, Gzip: compress}
+		data := export(t, opts, activities...)
+
+		file := readParquet(t, data)
+		if file.numRows != int64(len(activities)) {
+			t.Fatalf("Expected %d rows, got %d", len(activities), file.numRows)
+		}
+		if file.rowGroups != 2 {
+			t.Fatalf("Expected 2 row groups, got %d", file.rowGroups)
+		}
+		cols, _ := selectColumns(nil)
+		for i, col := range cols {
+			if i >= len(file.names) || file.names[i] != col.name {
+				t.Fatalf("Expected the columns in export order, got %v", file.names)
+			}
+		}
+
+		for _, check := range []struct {
+			column string
+			row    int
+			want   interface{}
+		}{
+			{"id", 0, int64(42)},
+			{"id", 1, int64(43)},
+			{"id", len(activities) - 1, int64(100 + rowGroupSize - 1)},
+			{"name", 0, "Morning, run"},
+			{"name", 1, nil},
+			{"name", 2, "Ride"},
+			{"start_date", 0, testActivity.StartDate.UnixMilli()},
+			{"start_date", 1, nil},
+			{"distance", 0, 10000.0},
+			{"distance", 5, 3.0},
+			{"commute", 0, true},
+			{"commute", 1, false},
+			{"trainer", 1, true},
+		} {
+			if got := file.columns[check.column][check.row]; got != check.want {
+				t.Errorf("gzip=%t: expected %s of row %d to be %v, got %v", compress, check.column, check.row, check.want, got)
+			}
+		}
+	}
+}
+
+// parquetFile is what readParquet decodes
+type parquetFile struct {
+	numRows   int64
+	rowGroups int
+	columns   map[string][]interface{}
+	names     []string
+}
+
+// readParquet decodes a file with the Parquet library's reader. Missing
+// values are nil, timestamps are milliseconds since the epoch.
+func readParquet(t *testing.T, data []byte) parquetFile {
+	t.Helper()
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to open parquet file: %v", err)
+	}
+
+	file := parquetFile{
+		numRows:   f.NumRows(),
+		rowGroups: len(f.RowGroups()),
+		columns:   map[string][]interface{}{},
+	}
+	for _, field := range f.Schema().Fields() {
+		file.names = append(file.names, field.Name())
+	}
+
+	buf := make([]parquet.Row, 100)
+	for _, group := range f.RowGroups() {
+		rows := group.Rows()
+		for {
+			n, err := rows.ReadRows(buf)
+			for _, row := range buf[:n] {
+				for _, v := range row {
+					name := file.names[v.Column()]
+					file.columns[name] = append(file.columns[name], goValue(v))
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Failed to read rows: %v", err)
+			}
+		}
+		rows.Close()
+	}
+	return file
+}
+
+func goValue(v parquet.Value) interface{} {
+	switch {
+	case v.IsNull():
+		return nil
+	case v.Kind() == parquet.Boolean:
+		return v.Boolean()
+	case v.Kind() == parquet.Int64:
+		return v.Int64()
+	case v.Kind() == parquet.Double:
+		return v.Double()
+	case v.Kind() == parquet.ByteArray:
+		return string(v.ByteArray())
+	}
+	return v
+}
@@ -0,0 +1,70 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// ndjsonWriter writes one JSON object per line. Keys appear in column order
+// and missing values are null.
+type ndjsonWriter struct {
+	w     *bufio.Writer
+	cols  []column
+	units Units
+	buf   []byte
+}
+
+func newNDJSONWriter(w io.Writer, cols []column, units Units) *ndjsonWriter {
+	return &ndjsonWriter{w: bufio.NewWriter(w), cols: cols, units: units}
+}
+
+func (n *ndjsonWriter) Write(activity db.Activity) error {
+	// Built by hand because a map would lose the column order
+	n.buf = append(n.buf[:0], '{')
+	for i, col := range n.cols {
+		if i > 0 {
+			n.buf = append(n.buf, ',')
+		}
+		n.buf = appendJSON(n.buf, col.name)
+		n.buf = append(n.buf, ':')
+		n.buf = appendJSON(n.buf, jsonValue(col.value(activity, n.units)))
+	}
+	n.buf = append(n.buf, '}', '\n')
+
+	if _, err := n.w.Write(n.buf); err != nil {
+		return fmt.Errorf("error writing activity %d: %w", activity.ID, err)
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
+
+// jsonValue converts values JSON can not represent
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return v
+}
+
+func appendJSON(buf []byte, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// Only the value types of the columns reach here, which all encode
+		return append(buf, "null"...)
+	}
+	return append(buf, data...)
+}
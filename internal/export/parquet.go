@@ -0,0 +1,112 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupSize is the number of rows buffered before a row group is written,
+// which bounds the memory used by an export
+const rowGroupSize = 10000
+
+// parquetWriter writes activities as a flat table of OPTIONAL columns
+type parquetWriter struct {
+	w     *parquet.Writer
+	cols  []column
+	units Units
+	row   parquet.Row
+}
+
+func newParquetWriter(w io.Writer, cols []column, units Units, compress bool) *parquetWriter {
+	options := []parquet.WriterOption{
+		parquetSchema(cols),
+		parquet.MaxRowsPerRowGroup(rowGroupSize),
+		parquet.CreatedBy("strava-data-pipeline", "", ""),
+	}
+	if compress {
+		options = append(options, parquet.Compression(&parquet.Gzip))
+	}
+	return &parquetWriter{
+		w:     parquet.NewWriter(w, options...),
+		cols:  cols,
+		units: units,
+		row:   make(parquet.Row, len(cols)),
+	}
+}
+
+// parquetSchema returns the schema of the columns. parquet.Group orders its
+// fields by name, so the schema is derived from a struct to keep the order
+// of the columns.
+func parquetSchema(cols []column) *parquet.Schema {
+	fields := make([]reflect.StructField, len(cols))
+	for i, col := range cols {
+		var typ reflect.Type
+		tag := col.name + ",optional"
+		switch col.kind {
+		case kindInt:
+			typ = reflect.TypeFor[*int64]()
+		case kindFloat:
+			typ = reflect.TypeFor[*float64]()
+		case kindBool:
+			typ = reflect.TypeFor[*bool]()
+		case kindString:
+			typ = reflect.TypeFor[*string]()
+		case kindTime:
+			typ = reflect.TypeFor[*time.Time]()
+			tag += ",timestamp(millisecond)"
+		}
+		fields[i] = reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: typ,
+			Tag:  reflect.StructTag(`parquet:"` + tag + `"`),
+		}
+	}
+	return parquet.NewSchema("activity", parquet.SchemaOf(reflect.New(reflect.StructOf(fields)).Elem().Interface()))
+}
+
+func (p *parquetWriter) Write(activity db.Activity) error {
+	for i, col := range p.cols {
+		v := col.value(activity, p.units)
+		definitionLevel := 1
+		if v == nil {
+			definitionLevel = 0
+		}
+		p.row[i] = parquetValue(v).Level(0, definitionLevel, i)
+	}
+	if _, err := p.w.WriteRows([]parquet.Row{p.row}); err != nil {
+		return fmt.Errorf("error writing activity %d: %w", activity.ID, err)
+	}
+	return nil
+}
+
+// Close writes the buffered rows and the footer
+func (p *parquetWriter) Close() error {
+	if err := p.w.Close(); err != nil {
+		return fmt.Errorf("error writing parquet footer: %w", err)
+	}
+	return nil
+}
+
+// parquetValue converts a column value
+func parquetValue(v interface{}) parquet.Value {
+	switch v := v.(type) {
+	case nil:
+		return parquet.NullValue()
+	case int64:
+		return parquet.Int64Value(v)
+	case float64:
+		return parquet.DoubleValue(v)
+	case bool:
+		return parquet.BooleanValue(v)
+	case string:
+		return parquet.ByteArrayValue([]byte(v))
+	case time.Time:
+		return parquet.Int64Value(v.UnixMilli())
+	}
+	panic(fmt.Sprintf("export: no parquet encoding for %T", v))
+}
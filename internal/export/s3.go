@@ -0,0 +1,162 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// S3Options configure an S3Storage
+type S3Options struct {
+	// Endpoint is the base URL of an S3-compatible service such as MinIO,
+	// the AWS endpoint of the region if empty
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to all keys
+	Prefix string
+	// PathStyle addresses the bucket as the first path segment instead of
+	// a subdomain of the endpoint
+	PathStyle       bool
+	AccessKeyID     string
+	SecretAccessKey string
+	// Client sends the requests, a traced default client if nil
+	Client *http.Client
+}
+
+// S3Storage stores export files in a bucket of an S3-compatible object
+// store through the AWS SDK, which MinIO, Ceph and most other
+// implementations accept as well
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Storage returns a storage for a bucket
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Endpoint != "" {
+		if u, err := url.Parse(opts.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+		}
+	}
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport,
+				otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+					return "s3 " + req.Method
+				}),
+			),
+			Timeout: 5 * time.Minute,
+		}
+	}
+
+	credentials := aws.Credentials{AccessKeyID: opts.AccessKeyID, SecretAccessKey: opts.SecretAccessKey}
+	client := s3.New(s3.Options{
+		Region: opts.Region,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return credentials, nil
+		}),
+		HTTPClient:   opts.Client,
+		UsePathStyle: opts.PathStyle,
+		// Checksums beyond Content-SHA256 are not supported by every
+		// S3-compatible store
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	}, func(o *s3.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+	})
+	return &S3Storage{client: client, bucket: opts.Bucket, prefix: opts.Prefix}, nil
+}
+
+// Put uploads data in a single PUT request
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.prefix + key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %w", key, s3Err(err))
+	}
+	return nil
+}
+
+// Get downloads the object stored under key
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", key, s3Err(err))
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// List pages through ListObjectsV2
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s: %w", prefix, s3Err(err))
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(object.Key), s.prefix))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes the object stored under key
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if err != nil {
+		if err = s3Err(err); errors.Is(err, ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("error deleting %s: %w", key, err)
+	}
+	return nil
+}
+
+// s3Err wraps ErrNotExist into errors of 404 responses
+func s3Err(err error) error {
+	var resp *smithyhttp.ResponseError
+	if errors.As(err, &resp) && resp.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", err, ErrNotExist)
+	}
+	return err
+}
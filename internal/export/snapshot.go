@@ -0,0 +1,447 @@
+package export
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// Snapshots are written to a target as
+//
+//	<id>/athlete_id=<athlete>/month=<YYYY-MM>/activities.parquet
+//	<id>/deletes.parquet
+//	manifests/<id>.json
+//
+// where the ID is the UTC time of the snapshot followed by its mode, e.g.
+// 20261018T020000Z-full. The Hive-style partition directories let query
+// engines prune by athlete and month. Only incremental snapshots with deleted
+// activities have a deletes file. The manifest is written after all data
+// files, so a snapshot without a manifest is incomplete and ignored.
+
+// Snapshot modes
+const (
+	ModeFull        = "full"
+	ModeIncremental = "incremental"
+)
+
+const (
+	manifestDir       = "manifests/"
+	snapshotIDLayout  = "20060102T150405Z"
+	partitionFilename = "activities.parquet"
+	deletesFilename   = "deletes.parquet"
+	// changePageSize is the number of activity changes read per query
+	changePageSize = 1000
+)
+
+// deleteColumns are the columns of the deletes file, taken from the last
+// state of the deleted activities
+var deleteColumns = []column{
+	intColumn("id", func(a db.Activity) int64 { return a.ID }),
+	intColumn("athlete_id", func(a db.Activity) int64 { return a.AthleteID }),
+	timeColumn("start_date", func(a db.Activity) time.Time { return a.StartDate }),
+}
+
+// Manifest describes a snapshot
+type Manifest struct {
+	ID        string    `json:"id"`
+	Mode      string    `json:"mode"`
+	CreatedAt time.Time `json:"created_at"`
+	// Base is the ID of the full snapshot an incremental snapshot builds on
+	Base string `json:"base,omitempty"`
+	// Since is the Seq of the previous snapshot. An incremental snapshot
+	// holds the activities changed after it.
+	Since *int64 `json:"since_seq,omitempty"`
+	// Seq is the sequence number of the newest activity change the snapshot
+	// includes, the Since of the next incremental snapshot. A full snapshot
+	// may also include later changes, which the next one repeats.
+	Seq        int64          `json:"seq"`
+	Activities int            `json:"activities"`
+	Files      []ManifestFile `json:"files"`
+	// Deleted is the number of activities deleted after Since, which are
+	// listed in Deletes
+	Deleted int           `json:"deleted,omitempty"`
+	Deletes *ManifestFile `json:"deletes,omitempty"`
+}
+
+// ManifestFile is a Parquet file of a snapshot
+type ManifestFile struct {
+	Path      string `json:"path"`
+	AthleteID int64  `json:"athlete_id"`
+	Month     string `json:"month"`
+	Rows      int    `json:"rows"`
+	Bytes     int    `json:"bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+// Snapshotter writes scheduled snapshots of the activities table to the
+// configured export targets
+type Snapshotter struct {
+	db  *db.DB
+	cfg config.Exports
+	now func() time.Time
+}
+
+// NewSnapshotter creates a snapshotter for the export configuration
+func NewSnapshotter(database *db.DB, cfg config.Exports) *Snapshotter {
+	return &Snapshotter{db: database, cfg: cfg, now: time.Now}
+}
+
+// Targets returns the names of the export targets
+func (s *Snapshotter) Targets() []string {
+	names := make([]string, len(s.cfg.Targets))
+	for i, target := range s.cfg.Targets {
+		names[i] = target.Name
+	}
+	return names
+}
+
+func (s *Snapshotter) storage(name string) (Storage, error) {
+	for _, target := range s.cfg.Targets {
+		if target.Name == name {
+			return NewStorage(target)
+		}
+	}
+	return nil, fmt.Errorf("unknown export target %q", name)
+}
+
+// List returns the complete snapshots on the target, oldest first
+func (s *Snapshotter) List(ctx context.Context, target string) ([]Manifest, error) {
+	storage, err := s.storage(target)
+	if err != nil {
+		return nil, err
+	}
+	return ListSnapshots(ctx, storage)
+}
+
+// Run writes a snapshot to the target and deletes the snapshots that fell out
+// of the retention period. In incremental mode the snapshot only holds the
+// activities updated since the previous one, unless full is set, there is no
+// full snapshot yet or the last one is older than the full interval.
+func (s *Snapshotter) Run(ctx context.Context, target string, full bool) (*Manifest, error) {
+	storage, err := s.storage(target)
+	if err != nil {
+		return nil, err
+	}
+	units, err := ParseUnits(s.cfg.Units)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := ListSnapshots(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now().UTC().Truncate(time.Second)
+	manifest := &Manifest{Mode: ModeFull, CreatedAt: now}
+	if s.cfg.Incremental && !full {
+		fullInterval := time.Duration(s.cfg.FullInterval) * 24 * time.Hour
+		// Snapshots without a change sequence number predate the change log
+		if base, ok := latestFull(snapshots); ok && now.Sub(base.CreatedAt) < fullInterval && snapshots[len(snapshots)-1].Seq > 0 {
+			since := snapshots[len(snapshots)-1].Seq
+			manifest.Mode = ModeIncremental
+			manifest.Base = base.ID
+			manifest.Since = &since
+		}
+	}
+	manifest.ID = now.Format(snapshotIDLayout) + "-" + manifest.Mode
+	if len(snapshots) > 0 && manifest.ID <= snapshots[len(snapshots)-1].ID {
+		return nil, fmt.Errorf("snapshot %s already exists on target %s", manifest.ID, target)
+	}
+
+	// Sequence numbers become visible in commit order, so every change up to
+	// the latest one is committed and none is missed by the next snapshot
+	manifest.Seq, err = s.db.GetLatestChangeSeq(ctx)
+	if err != nil {
+		return nil, err
+	}
+	activities := s.db.StreamActivities(ctx, db.ActivityFilter{GroupByAthlete: true})
+	var deleted []db.Activity
+	if manifest.Since != nil {
+		var changed []db.Activity
+		changed, deleted, err = s.changes(ctx, *manifest.Since, manifest.Seq)
+		if err != nil {
+			return nil, err
+		}
+		activities = activitySeq(changed...)
+	}
+
+	if err := WriteSnapshot(ctx, storage, manifest, activities, deleted, units); err != nil {
+		// Leave no orphaned files behind; the next run starts over
+		cleanupCtx := context.WithoutCancel(ctx)
+		if cleanupErr := deletePrefix(cleanupCtx, storage, manifest.ID+"/"); cleanupErr != nil {
+			slog.ErrorContext(ctx, "Error deleting incomplete snapshot", "target", target, "snapshot", manifest.ID, "error", cleanupErr)
+		}
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Wrote export snapshot", "target", target, "snapshot", manifest.ID,
+		"mode", manifest.Mode, "activities", manifest.Activities, "deleted", manifest.Deleted, "files", len(manifest.Files))
+
+	if s.cfg.RetentionDays > 0 {
+		cutoff := now.Add(-time.Duration(s.cfg.RetentionDays) * 24 * time.Hour)
+		for _, old := range Expired(append(snapshots, *manifest), cutoff) {
+			if err := DeleteSnapshot(ctx, storage, old.ID); err != nil {
+				slog.ErrorContext(ctx, "Error deleting expired snapshot", "target", target, "snapshot", old.ID, "error", err)
+				continue
+			}
+			slog.InfoContext(ctx, "Deleted expired snapshot", "target", target, "snapshot", old.ID)
+		}
+	}
+	return manifest, nil
+}
+
+// changes reads the activity changes after since up to and including until.
+// It returns the latest state of the changed activities, ordered by athlete
+// and start date, and the last state of the deleted ones.
+func (s *Snapshotter) changes(ctx context.Context, since, until int64) (changed, deleted []db.Activity, err error) {
+	latest := map[int64]db.ActivityChange{}
+	for since < until {
+		page, err := s.db.ListActivityChanges(ctx, db.ChangeFilter{Since: since, Limit: changePageSize})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, change := range page {
+			if change.Seq > until {
+				break
+			}
+			latest[change.ActivityID] = change
+		}
+		if len(page) < changePageSize {
+			break
+		}
+		since = page[len(page)-1].Seq
+	}
+	return splitChanges(latest)
+}
+
+// splitChanges decodes the latest change of each activity into the changed
+// and the deleted activities
+func splitChanges(latest map[int64]db.ActivityChange) (changed, deleted []db.Activity, err error) {
+	for _, change := range latest {
+		var activity db.Activity
+		if err := json.Unmarshal(change.Activity, &activity); err != nil {
+			return nil, nil, fmt.Errorf("error decoding activity change %d: %w", change.Seq, err)
+		}
+		if change.Op == db.ChangeDelete {
+			deleted = append(deleted, activity)
+		} else {
+			changed = append(changed, activity)
+		}
+	}
+	slices.SortFunc(changed, func(a, b db.Activity) int {
+		return cmp.Or(cmp.Compare(a.AthleteID, b.AthleteID), a.StartDate.Compare(b.StartDate), cmp.Compare(a.ID, b.ID))
+	})
+	slices.SortFunc(deleted, func(a, b db.Activity) int { return cmp.Compare(a.ID, b.ID) })
+	return changed, deleted, nil
+}
+
+// activitySeq yields activities like db.StreamActivities
+func activitySeq(activities ...db.Activity) iter.Seq2[db.Activity, error] {
+	return func(yield func(db.Activity, error) bool) {
+		for _, a := range activities {
+			if !yield(a, nil) {
+				return
+			}
+		}
+	}
+}
+
+// WriteSnapshot writes activities, which must be ordered by athlete and
+// start date, as one Parquet file per athlete and month, then the deleted
+// activities and then the manifest. Only one partition is held in memory at
+// a time.
+func WriteSnapshot(ctx context.Context, storage Storage, manifest *Manifest, activities iter.Seq2[db.Activity, error], deleted []db.Activity, units Units) error {
+	manifest.Files = []ManifestFile{}
+
+	var (
+		buf     bytes.Buffer
+		w       *parquetWriter
+		current ManifestFile
+	)
+	// put closes the current file and uploads it
+	put := func() error {
+		if err := w.Close(); err != nil {
+			return fmt.Errorf("error writing %s: %w", current.Path, err)
+		}
+		current.Bytes = buf.Len()
+		current.SHA256 = sha256Hex(buf.Bytes())
+		if err := storage.Put(ctx, current.Path, buf.Bytes()); err != nil {
+			return err
+		}
+		buf.Reset()
+		w = nil
+		return nil
+	}
+	flush := func() error {
+		if w == nil {
+			return nil
+		}
+		if err := put(); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, current)
+		return nil
+	}
+
+	for activity, err := range activities {
+		if err != nil {
+			return err
+		}
+		month := activity.StartDate.UTC().Format("2006-01")
+		if w == nil || activity.AthleteID != current.AthleteID || month != current.Month {
+			if w != nil && (activity.AthleteID < current.AthleteID ||
+				activity.AthleteID == current.AthleteID && month < current.Month) {
+				return fmt.Errorf("activity %d is out of order", activity.ID)
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+			current = ManifestFile{
+				Path:      partitionPath(manifest.ID, activity.AthleteID, month),
+				AthleteID: activity.AthleteID,
+				Month:     month,
+			}
+			w = newParquetWriter(&buf, allColumns, units, true)
+		}
+		if err := w.Write(activity); err != nil {
+			return fmt.Errorf("error writing %s: %w", current.Path, err)
+		}
+		current.Rows++
+		manifest.Activities++
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if len(deleted) > 0 {
+		current = ManifestFile{Path: manifest.ID + "/" + deletesFilename}
+		w = newParquetWriter(&buf, deleteColumns, units, true)
+		for _, activity := range deleted {
+			if err := w.Write(activity); err != nil {
+				return fmt.Errorf("error writing %s: %w", current.Path, err)
+			}
+			current.Rows++
+		}
+		if err := put(); err != nil {
+			return err
+		}
+		deletes := current
+		manifest.Deletes = &deletes
+		manifest.Deleted = deletes.Rows
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+	return storage.Put(ctx, manifestDir+manifest.ID+".json", data)
+}
+
+func partitionPath(id string, athleteID int64, month string) string {
+	return fmt.Sprintf("%s/athlete_id=%d/month=%s/%s", id, athleteID, month, partitionFilename)
+}
+
+// ListSnapshots returns the manifests of the complete snapshots on a target,
+// oldest first
+func ListSnapshots(ctx context.Context, storage Storage) ([]Manifest, error) {
+	keys, err := storage.List(ctx, manifestDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []Manifest
+	for _, key := range keys {
+		if path.Ext(key) != ".json" {
+			continue
+		}
+		data, err := storage.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, ErrNotExist) {
+				continue // deleted concurrently
+			}
+			return nil, err
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("error decoding %s: %w", key, err)
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool { return manifests[i].ID < manifests[j].ID })
+	return manifests, nil
+}
+
+// latestFull returns the newest full snapshot
+func latestFull(snapshots []Manifest) (Manifest, bool) {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Mode == ModeFull {
+			return snapshots[i], true
+		}
+	}
+	return Manifest{}, false
+}
+
+// Expired returns the snapshots, ordered oldest first, that can be deleted
+// once they are older than cutoff. Snapshots newer than cutoff are kept
+// together with everything they build on: the full snapshot at or before the
+// oldest of them and the incremental snapshots in between. The newest
+// snapshot is always kept.
+func Expired(snapshots []Manifest, cutoff time.Time) []Manifest {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	keep := len(snapshots) - 1
+	for i, snapshot := range snapshots {
+		if !snapshot.CreatedAt.Before(cutoff) {
+			keep = i
+			break
+		}
+	}
+	for keep > 0 && snapshots[keep].Mode != ModeFull {
+		keep--
+	}
+	return snapshots[:keep]
+}
+
+// DeleteSnapshot deletes the manifest and then the files of a snapshot
+func DeleteSnapshot(ctx context.Context, storage Storage, id string) error {
+	if err := storage.Delete(ctx, manifestDir+id+".json"); err != nil {
+		return err
+	}
+	return deletePrefix(ctx, storage, id+"/")
+}
+
+func deletePrefix(ctx context.Context, storage Storage, prefix string) error {
+	keys, err := storage.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := storage.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,232 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+func TestWriteSnapshot(t *testing.T) {
+	ctx := context.Background()
+	storage := NewLocalStorage(t.TempDir())
+	may := time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC)
+	june := time.Date(2024, 6, 1, 6, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+
+	activities := []db.Activity{
+		{ID: 1, AthleteID: 7, StartDate: may, UpdatedAt: updated},
+		{ID: 2, AthleteID: 7, StartDate: may.Add(time.Minute), UpdatedAt: updated.Add(time.Hour)},
+		{ID: 3, AthleteID: 7, StartDate: june, UpdatedAt: updated},
+		{ID: 4, AthleteID: 9, StartDate: may, UpdatedAt: updated},
+	}
+	manifest := &Manifest{ID: "20240602T020000Z-full", Mode: ModeFull, CreatedAt: updated, Seq: 12}
+	if err := WriteSnapshot(ctx, storage, manifest, activitySeq(activities...), nil, UnitsMeters); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	if manifest.Activities != 4 || manifest.Deletes != nil {
+		t.Fatalf("Expected 4 activities and no deletes, got %+v", manifest)
+	}
+	var paths []string
+	rows := make(map[string]int)
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+		rows[f.Path] = f.Rows
+		data, err := storage.Get(ctx, f.Path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", f.Path, err)
+		}
+		if len(data) != f.Bytes || sha256Hex(data) != f.SHA256 {
+			t.Fatalf("Size or checksum of %s does not match the manifest", f.Path)
+		}
+		if file := readParquet(t, data); file.numRows != int64(f.Rows) {
+			t.Fatalf("Expected %d rows in %s, got %d", f.Rows, f.Path, file.numRows)
+		}
+	}
+	want := []string{
+		"20240602T020000Z-full/athlete_id=7/month=2024-05/activities.parquet",
+		"20240602T020000Z-full/athlete_id=7/month=2024-06/activities.parquet",
+		"20240602T020000Z-full/athlete_id=9/month=2024-05/activities.parquet",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("Expected files %v, got %v", want, paths)
+	}
+	if rows[want[0]] != 2 {
+		t.Fatalf("Expected 2 rows in %s, got %d", want[0], rows[want[0]])
+	}
+
+	snapshots, err := ListSnapshots(ctx, storage)
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != manifest.ID || snapshots[0].Seq != 12 || len(snapshots[0].Files) != 3 {
+		t.Fatalf("Expected the written snapshot, got %+v", snapshots)
+	}
+
+	if err := DeleteSnapshot(ctx, storage, manifest.ID); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	if keys, _ := storage.List(ctx, ""); len(keys) != 0 {
+		t.Fatalf("Expected no files after delete, got %v", keys)
+	}
+}
+
+func TestWriteSnapshotIncremental(t *testing.T) {
+	ctx := context.Background()
+	storage := NewLocalStorage(t.TempDir())
+	since := int64(12)
+
+	// Without changes the snapshot is empty
+	manifest := &Manifest{ID: "20240603T020000Z-incremental", Mode: ModeIncremental, Since: &since, Seq: since}
+	if err := WriteSnapshot(ctx, storage, manifest, activitySeq(), nil, UnitsMeters); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if manifest.Activities != 0 || len(manifest.Files) != 0 || manifest.Deletes != nil {
+		t.Fatalf("Expected an empty snapshot, got %+v", manifest)
+	}
+
+	start := time.Date(2024, 6, 1, 6, 0, 0, 0, time.UTC)
+	manifest = &Manifest{ID: "20240604T020000Z-incremental", Mode: ModeIncremental, Since: &since, Seq: 15}
+	err := WriteSnapshot(ctx, storage, manifest, activitySeq(db.Activity{ID: 1, AthleteID: 7, StartDate: start}),
+		[]db.Activity{{ID: 2, AthleteID: 7, StartDate: start}, {ID: 3, AthleteID: 9, StartDate: start}}, UnitsMeters)
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if manifest.Activities != 1 || len(manifest.Files) != 1 || manifest.Deleted != 2 || manifest.Deletes == nil {
+		t.Fatalf("Expected one activity and two deletes, got %+v", manifest)
+	}
+	data, err := storage.Get(ctx, manifest.Deletes.Path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", manifest.Deletes.Path, err)
+	}
+	if manifest.Deletes.Path != "20240604T020000Z-incremental/deletes.parquet" || sha256Hex(data) != manifest.Deletes.SHA256 {
+		t.Fatalf("Unexpected deletes file %+v", manifest.Deletes)
+	}
+	file := readParquet(t, data)
+	if !reflect.DeepEqual(file.columns["id"], []interface{}{int64(2), int64(3)}) || len(file.names) != len(deleteColumns) {
+		t.Fatalf("Expected the deleted activities 2 and 3, got %v", file.columns)
+	}
+
+	// Deleting the snapshot removes the deletes file as well
+	if err := DeleteSnapshot(ctx, storage, manifest.ID); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	if _, err := storage.Get(ctx, manifest.Deletes.Path); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Expected the deletes file to be deleted, got %v", err)
+	}
+}
+
+func TestSplitChanges(t *testing.T) {
+	change := func(seq int64, op string, activity db.Activity) db.ActivityChange {
+		data, _ := json.Marshal(activity)
+		return db.ActivityChange{Seq: seq, ActivityID: activity.ID, AthleteID: activity.AthleteID, Op: op, Activity: data}
+	}
+	may := time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)
+
+	// The latest change of each activity, as collected from the change log
+	latest := map[int64]db.ActivityChange{
+		1: change(14, db.ChangeUpdate, db.Activity{ID: 1, AthleteID: 9, StartDate: may, Name: "Renamed"}),
+		2: change(13, db.ChangeDelete, db.Activity{ID: 2, AthleteID: 7, StartDate: may}),
+		3: change(15, db.ChangeInsert, db.Activity{ID: 3, AthleteID: 7, StartDate: may.AddDate(0, 1, 0)}),
+		4: change(16, db.ChangeInsert, db.Activity{ID: 4, AthleteID: 7, StartDate: may}),
+	}
+	changed, deleted, err := splitChanges(latest)
+	if err != nil {
+		t.Fatalf("Failed to split changes: %v", err)
+	}
+	if got := activityIDs(changed); !reflect.DeepEqual(got, []int64{4, 3, 1}) {
+		t.Fatalf("Expected the changed activities ordered by athlete and start date, got %v", got)
+	}
+	if changed[2].Name != "Renamed" || !changed[2].StartDate.Equal(may) {
+		t.Fatalf("Expected the state after the change, got %+v", changed[2])
+	}
+	if got := activityIDs(deleted); !reflect.DeepEqual(got, []int64{2}) {
+		t.Fatalf("Expected the deleted activity, got %v", got)
+	}
+
+	latest[5] = db.ActivityChange{Seq: 17, ActivityID: 5, Op: db.ChangeInsert, Activity: []byte("{")}
+	if _, _, err := splitChanges(latest); err == nil {
+		t.Fatal("Expected an error for an undecodable change")
+	}
+}
+
+func activityIDs(activities []db.Activity) []int64 {
+	ids := []int64{}
+	for _, a := range activities {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestWriteSnapshotErrors(t *testing.T) {
+	ctx := context.Background()
+	storage := NewLocalStorage(t.TempDir())
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	manifest := &Manifest{ID: "a-full"}
+	err := WriteSnapshot(ctx, storage, manifest, activitySeq(
+		db.Activity{ID: 1, AthleteID: 9, StartDate: start},
+		db.Activity{ID: 2, AthleteID: 7, StartDate: start},
+	), nil, UnitsMeters)
+	if err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Fatalf("Expected an ordering error, got %v", err)
+	}
+
+	failing := func(yield func(db.Activity, error) bool) {
+		yield(db.Activity{}, errors.New("connection reset"))
+	}
+	if err := WriteSnapshot(ctx, storage, &Manifest{ID: "b-full"}, failing, nil, UnitsMeters); err == nil {
+		t.Fatal("Expected the stream error")
+	}
+
+	// Neither snapshot has a manifest
+	if snapshots, _ := ListSnapshots(ctx, storage); len(snapshots) != 0 {
+		t.Fatalf("Expected no complete snapshots, got %+v", snapshots)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 6, d, 2, 0, 0, 0, time.UTC) }
+	snapshot := func(d int, mode string) Manifest {
+		return Manifest{ID: day(d).Format(snapshotIDLayout) + "-" + mode, Mode: mode, CreatedAt: day(d)}
+	}
+	snapshots := []Manifest{
+		snapshot(1, ModeFull),
+		snapshot(2, ModeIncremental),
+		snapshot(3, ModeFull),
+		snapshot(4, ModeIncremental),
+		snapshot(5, ModeIncremental),
+		snapshot(6, ModeFull),
+	}
+	ids := func(ms []Manifest) []string {
+		var ids []string
+		for _, m := range ms {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name   string
+		cutoff time.Time
+		want   []Manifest
+	}{
+		{"nothing expired", day(1), nil},
+		{"incremental chain kept", day(5), snapshots[:2]},
+		{"full snapshot at cutoff", day(3), snapshots[:2]},
+		{"only the newest full snapshot", day(7), snapshots[:5]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(Expired(snapshots, tt.cutoff)); !reflect.DeepEqual(got, ids(tt.want)) {
+				t.Fatalf("Expected %v, got %v", ids(tt.want), got)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package export
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+)
+
+// Storage stores the files of scheduled exports. Keys are slash separated
+// paths relative to the root of the target.
+type Storage interface {
+	// Put stores data under key, replacing an existing file
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the data stored under key or an error wrapping
+	// ErrNotExist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the keys starting with prefix in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage returns the storage of an export target
+func NewStorage(target config.ExportTarget) (Storage, error) {
+	switch target.Type {
+	case "local":
+		return NewLocalStorage(target.Path), nil
+	case "s3":
+		return NewS3Storage(S3Options{
+			Endpoint:        target.Endpoint,
+			Region:          target.Region,
+			Bucket:          target.Bucket,
+			Prefix:          target.Prefix,
+			PathStyle:       target.PathStyle,
+			AccessKeyID:     target.AccessKeyID,
+			SecretAccessKey: target.SecretAccessKey,
+		})
+	}
+	return nil, fmt.Errorf("unknown export target type %q", target.Type)
+}
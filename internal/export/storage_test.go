@@ -0,0 +1,189 @@
+package export
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStorage runs the same checks against every Storage implementation
+func testStorage(t *testing.T, storage Storage) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, key := range []string{"b/athlete_id=1/month=2024-05/a.parquet", "b/x.json", "a.json", "c/d e+f.txt"} {
+		if err := storage.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+	if err := storage.Put(ctx, "a.json", []byte("replaced")); err != nil {
+		t.Fatalf("Failed to replace a.json: %v", err)
+	}
+
+	data, err := storage.Get(ctx, "a.json")
+	if err != nil || string(data) != "replaced" {
+		t.Fatalf("Expected replaced content, got %q, %v", data, err)
+	}
+	if _, err := storage.Get(ctx, "missing.json"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Expected ErrNotExist, got %v", err)
+	}
+
+	keys, err := storage.List(ctx, "b/")
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if want := []string{"b/athlete_id=1/month=2024-05/a.parquet", "b/x.json"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Expected %v, got %v", want, keys)
+	}
+
+	for _, key := range []string{"b/athlete_id=1/month=2024-05/a.parquet", "missing.json"} {
+		if err := storage.Delete(ctx, key); err != nil {
+			t.Fatalf("Failed to delete %s: %v", key, err)
+		}
+	}
+	keys, err = storage.List(ctx, "")
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if want := []string{"a.json", "b/x.json", "c/d e+f.txt"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Expected %v, got %v", want, keys)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	testStorage(t, NewLocalStorage(dir))
+
+	// Deleting the last file of a partition removes its directories
+	if _, err := os.Stat(filepath.Join(dir, "b", "athlete_id=1")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected empty directories to be removed, got %v", err)
+	}
+	if err := NewLocalStorage(dir).Put(context.Background(), "../escape", nil); err == nil {
+		t.Fatal("Expected an error for a key outside the directory")
+	}
+}
+
+// fakeS3 is a minimal path-style S3 server for a single bucket that lists at
+// most two keys per page
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=minio/") || r.Header.Get("x-amz-content-sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code><Message>unsigned request</Message></Error>")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != "exports" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// listResult is the response of ListObjectsV2
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var result listResult
+	if len(keys) > 2 {
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[1]
+		keys = keys[:2]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{key})
+	}
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		listResult
+	}{listResult: result})
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	opts := S3Options{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "exports",
+		Prefix:          "snapshots",
+		PathStyle:       true,
+		AccessKeyID:     "minio",
+		SecretAccessKey: "minio123",
+	}
+	storage, err := NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	testStorage(t, storage)
+
+	if _, ok := fake.objects["snapshots/a.json"]; !ok {
+		t.Fatalf("Expected keys below the prefix, got %v", fake.objects)
+	}
+
+	opts.AccessKeyID = "wrong"
+	storage, err = NewS3Storage(opts)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := storage.Put(context.Background(), "a.json", nil); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Fatalf("Expected AccessDenied, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package health runs named dependency checks for the readiness endpoint
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Status of a single check or of a whole report
+type Status string
+
+const (
+	StatusOK = Status("ok")
+	// StatusDegraded means a non-critical check failed; the instance can
+	// still serve requests
+	StatusDegraded = Status("degraded")
+	StatusFail     = Status("fail")
+)
+
+// Check is a named dependency check. A failing critical check makes the
+// instance unready.
+type Check struct {
+	Name     string
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    Status  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks
+type Report struct {
+	Status Status    `json:"status"`
+	Time   time.Time `json:"time"`
+	Checks []Result  `json:"checks"`
+}
+
+// Run runs the checks concurrently, giving each at most timeout. The results
+// are in the order of checks.
+func Run(ctx context.Context, timeout time.Duration, checks []Check) Report {
+	results := make([]Result, len(checks))
+
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = run(ctx, timeout, check)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Time: time.Now(), Checks: results}
+	for _, result := range results {
+		if result.Status != StatusFail {
+			continue
+		}
+		if result.Critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+// run runs a single check, turning a timeout or panic into a failure
+func run(ctx context.Context, timeout time.Duration, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		done <- check.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOK,
+		Critical:  check.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// HTTPStatus is 503 Service Unavailable if a critical check failed and 200
+// OK otherwise
+func (r Report) HTTPStatus() int {
+	if r.Status == StatusFail {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,74 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func ok(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("down") }
+
+func TestRunAllChecksPass(t *testing.T) {
+	report := Run(context.Background(), time.Second, []Check{
+		{Name: "database", Critical: true, Run: ok},
+		{Name: "sync", Run: ok},
+	})
+	if report.Status != StatusOK || report.HTTPStatus() != http.StatusOK {
+		t.Fatalf("Expected ok, got %s", report.Status)
+	}
+	if len(report.Checks) != 2 || report.Checks[0].Name != "database" || report.Checks[1].Name != "sync" {
+		t.Fatalf("Expected results in the order of the checks, got %+v", report.Checks)
+	}
+}
+
+func TestRunNonCriticalFailureDegrades(t *testing.T) {
+	report := Run(context.Background(), time.Second, []Check{
+		{Name: "database", Critical: true, Run: ok},
+		{Name: "sync", Run: failing},
+	})
+	if report.Status != StatusDegraded || report.HTTPStatus() != http.StatusOK {
+		t.Fatalf("Expected degraded with 200, got %s with %d", report.Status, report.HTTPStatus())
+	}
+	if report.Checks[1].Status != StatusFail || report.Checks[1].Error != "down" {
+		t.Fatalf("Expected the sync check to fail, got %+v", report.Checks[1])
+	}
+}
+
+func TestRunCriticalFailureFails(t *testing.T) {
+	report := Run(context.Background(), time.Second, []Check{
+		{Name: "database", Critical: true, Run: failing},
+		{Name: "sync", Run: failing},
+	})
+	if report.Status != StatusFail || report.HTTPStatus() != http.StatusServiceUnavailable {
+		t.Fatalf("Expected fail with 503, got %s with %d", report.Status, report.HTTPStatus())
+	}
+}
+
+func TestRunTimesOutHangingChecks(t *testing.T) {
+	hang := func(ctx context.Context) error {
+		select {}
+	}
+	start := time.Now()
+	report := Run(context.Background(), 20*time.Millisecond, []Check{
+		{Name: "database", Critical: true, Run: hang},
+	})
+	if time.Since(start) > time.Second {
+		t.Fatal("Expected Run to return after the timeout")
+	}
+	if report.Status != StatusFail {
+		t.Fatalf("Expected a hanging critical check to fail, got %s", report.Status)
+	}
+}
+
+func TestRunRecoversPanics(t *testing.T) {
+	report := Run(context.Background(), time.Second, []Check{
+		{Name: "sync", Run: func(context.Context) error { panic("boom") }},
+	})
+	if report.Checks[0].Status != StatusFail {
+		t.Fatalf("Expected a panicking check to fail, got %+v", report.Checks[0])
+	}
+}
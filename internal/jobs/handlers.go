@@ -0,0 +1,152 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/export"
+	"github.com/TobiKin/strava-data-pipeline/internal/strava"
+)
+
+// StravaStore is the part of the database the Strava job handlers use
+type StravaStore interface {
+	GetActivityByID(ctx context.Context, id int64) (db.Activity, error)
+	GetSyncCheckpoint(ctx context.Context, name string) (time.Time, bool, error)
+	ListAuthorizedUserIDs(ctx context.Context) ([]int64, error)
+}
+
+// RegisterStravaHandlers registers the handlers of the sync, backfill and
+// stream download jobs. Each job calls Strava with the token of the user
+// whose data it fetches.
+func RegisterStravaHandlers(w *Worker, client *strava.Client, database StravaStore) {
+	w.Register(db.JobSync, syncHandler(client, database))
+	w.Register(db.JobBackfill, backfillHandler(client, database))
+	w.Register(db.JobStreamDownload, streamDownloadHandler(client, database))
+}
+
+// RegisterExportHandler registers the handler of the export job
+func RegisterExportHandler(w *Worker, snapshotter *export.Snapshotter) {
+	w.Register(db.JobExport, exportHandler(snapshotter))
+}
+
+func syncHandler(client *strava.Client, database StravaStore) Handler {
+	return func(ctx context.Context, job db.Job, progress func(done, total int)) error {
+		var payload SyncPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if payload.Days <= 0 {
+			payload.Days = 1
+		}
+
+		return forEachUser(ctx, database, payload.UserID, func(userID int64) error {
+			user, err := client.ForUser(ctx, userID)
+			if err != nil {
+				return err
+			}
+
+			after := time.Now().Add(-time.Duration(payload.Days) * 24 * time.Hour)
+			if payload.Resume {
+				checkpoint, ok, err := database.GetSyncCheckpoint(ctx, strava.SyncCheckpointName(userID))
+				if err != nil {
+					slog.ErrorContext(ctx, "Error reading sync checkpoint", "user_id", userID, "error", err)
+				} else if ok && checkpoint.Before(after) {
+					after = checkpoint
+				}
+			}
+
+			return user.FetchActivities(ctx, after, 100, progress)
+		})
+	}
+}
+
+func backfillHandler(client *strava.Client, database StravaStore) Handler {
+	return func(ctx context.Context, job db.Job, progress func(done, total int)) error {
+		var payload BackfillPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return forEachUser(ctx, database, payload.UserID, func(userID int64) error {
+			user, err := client.ForUser(ctx, userID)
+			if err != nil {
+				return err
+			}
+			return user.Backfill(ctx, payload.After, payload.Before, progress)
+		})
+	}
+}
+
+func streamDownloadHandler(client *strava.Client, database StravaStore) Handler {
+	return func(ctx context.Context, job db.Job, progress func(done, total int)) error {
+		var payload StreamDownloadPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+
+		// Only the owner's token can read the streams of an activity
+		activity, err := database.GetActivityByID(ctx, payload.ActivityID)
+		if err != nil {
+			return err
+		}
+		user, err := client.ForUser(ctx, activity.AthleteID)
+		if err != nil {
+			return err
+		}
+		if err := user.DownloadStreams(ctx, payload.ActivityID); err != nil {
+			return err
+		}
+		progress(1, 1)
+		return nil
+	}
+}
+
+func exportHandler(snapshotter *export.Snapshotter) Handler {
+	return func(ctx context.Context, job db.Job, progress func(done, total int)) error {
+		var payload ExportPayload
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		if _, err := snapshotter.Run(ctx, payload.Target, payload.Full); err != nil {
+			return err
+		}
+		progress(1, 1)
+		return nil
+	}
+}
+
+// forEachUser runs fn for the user, or for every user who authorized Strava
+// if userID is 0. A failing user does not stop the others. Their errors are
+// logged, and the job only fails if no user succeeded, so a retry doesn't
+// fetch the activities of every user again for a single failure.
+func forEachUser(ctx context.Context, database StravaStore, userID int64, fn func(userID int64) error) error {
+	if userID != 0 {
+		return fn(userID)
+	}
+
+	userIDs, err := database.ListAuthorizedUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, id := range userIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(id); err != nil {
+			slog.ErrorContext(ctx, "Error fetching activities of user", "user_id", id, "error", err)
+			errs = append(errs, fmt.Errorf("user %d: %w", id, err))
+		}
+	}
+	if len(errs) > 0 && len(errs) == len(userIDs) {
+		return errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		slog.WarnContext(ctx, "Fetched activities of some users", "failed", len(errs), "users", len(userIDs))
+	}
+	return nil
+}
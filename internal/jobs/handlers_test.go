@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/strava"
+	"github.com/TobiKin/strava-data-pipeline/internal/stravatest"
+)
+
+// stravaStore keeps users and activities in a db.MemoryStore and sync
+// checkpoints in a map
+type stravaStore struct {
+	*db.MemoryStore
+	checkpoints map[string]time.Time
+}
+
+func (s *stravaStore) SaveActivityStreams(ctx context.Context, activityID int64, data json.RawMessage) error {
+	return nil
+}
+
+func (s *stravaStore) SaveSyncCheckpoint(ctx context.Context, name string, syncedUntil time.Time) error {
+	s.checkpoints[name] = syncedUntil
+	return nil
+}
+
+func (s *stravaStore) GetSyncCheckpoint(ctx context.Context, name string) (time.Time, bool, error) {
+	checkpoint, ok := s.checkpoints[name]
+	return checkpoint, ok, nil
+}
+
+func TestSyncHandlerUsesTokenOfEachUser(t *testing.T) {
+	server := stravatest.NewServer()
+	defer server.Close()
+	store := &stravaStore{MemoryStore: db.NewMemoryStore(), checkpoints: map[string]time.Time{}}
+	ctx := context.Background()
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	refreshTokens := map[int64]string{}
+	for _, athleteID := range []int64{1, 2} {
+		server.AddAthlete(stravatest.Athlete{ID: athleteID})
+		server.AddActivity(stravatest.Activity{ID: athleteID * 10, AthleteID: athleteID, StartDate: yesterday})
+		tokens := server.IssueTokens(athleteID)
+		refreshTokens[athleteID] = tokens.RefreshToken
+		if err := store.SaveAthlete(ctx, db.Athlete{ID: athleteID}, tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresAt); err != nil {
+			t.Fatalf("Failed to save athlete: %v", err)
+		}
+	}
+	// The token of athlete 2 has expired and is refreshed before the sync
+	server.ExpireTokens(2)
+	if err := store.SaveUserTokens(ctx, 2, "expired", refreshTokens[2], time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Failed to save tokens: %v", err)
+	}
+
+	client, err := strava.New(&config.Config{Strava: server.Config()}, store)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	handler := syncHandler(client, store)
+
+	run := func(payload SyncPayload) error {
+		data, _ := json.Marshal(payload)
+		return handler(ctx, db.Job{Type: db.JobSync, Payload: data}, func(int, int) {})
+	}
+
+	if err := run(SyncPayload{Days: 2, UserID: 1}); err != nil {
+		t.Fatalf("Failed to sync user 1: %v", err)
+	}
+	if activities, _ := store.GetActivitiesByAthlete(ctx, 2, 10, 0); len(activities) != 0 {
+		t.Fatal("Expected a sync of user 1 not to fetch the activities of user 2")
+	}
+
+	if err := run(SyncPayload{Days: 2}); err != nil {
+		t.Fatalf("Failed to sync every user: %v", err)
+	}
+	for _, athleteID := range []int64{1, 2} {
+		if activities, _ := store.GetActivitiesByAthlete(ctx, athleteID, 10, 0); len(activities) != 1 || activities[0].AthleteID != athleteID {
+			t.Fatalf("Expected the activity of athlete %d, got %+v", athleteID, activities)
+		}
+		if _, ok := store.checkpoints[strava.SyncCheckpointName(athleteID)]; !ok {
+			t.Fatalf("Expected a checkpoint of athlete %d, got %v", athleteID, store.checkpoints)
+		}
+	}
+	if user, _ := store.GetUserByID(ctx, 2); user.AccessToken == "expired" {
+		t.Fatal("Expected the expired token to be refreshed and saved")
+	}
+
+	if err := run(SyncPayload{UserID: 3}); err == nil {
+		t.Fatal("Expected a sync of an unknown user to fail")
+	}
+}
+
+func TestForEachUserSucceedsPartially(t *testing.T) {
+	store := &stravaStore{MemoryStore: db.NewMemoryStore(), checkpoints: map[string]time.Time{}}
+	ctx := context.Background()
+	for _, athleteID := range []int64{1, 2, 3} {
+		if err := store.SaveAthlete(ctx, db.Athlete{ID: athleteID}, "access", "refresh", time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Failed to save athlete: %v", err)
+		}
+	}
+
+	var synced []int64
+	err := forEachUser(ctx, store, 0, func(userID int64) error {
+		synced = append(synced, userID)
+		if userID == 2 {
+			return errors.New("rate limited")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the failure of one user to be logged, got %v", err)
+	}
+	if len(synced) != 3 {
+		t.Fatalf("Expected every user to be synced, got %v", synced)
+	}
+
+	err = forEachUser(ctx, store, 0, func(userID int64) error {
+		return errors.New("Strava unavailable")
+	})
+	if err == nil {
+		t.Fatal("Expected an error if every user failed")
+	}
+}
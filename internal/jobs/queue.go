@@ -0,0 +1,148 @@
+// Package jobs runs background work from the Postgres job queue.
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// SyncPayload syncs the activities of the last Days days of a user, or of
+// every user who authorized Strava if UserID is 0. With Resume set, the sync
+// starts at the checkpoint of an earlier sync that did not finish if that is
+// further back.
+type SyncPayload struct {
+	Days   int   `json:"days"`
+	Resume bool  `json:"resume,omitempty"`
+	UserID int64 `json:"user_id,omitempty"`
+}
+
+// BackfillPayload fetches all activities started between After and Before
+// of a user, or of every user who authorized Strava if UserID is 0. A zero
+// Before means the time the job runs.
+type BackfillPayload struct {
+	After  time.Time `json:"after"`
+	Before time.Time `json:"before,omitempty"`
+	UserID int64     `json:"user_id,omitempty"`
+}
+
+// StreamDownloadPayload downloads the time series of an activity
+type StreamDownloadPayload struct {
+	ActivityID int64 `json:"activity_id"`
+}
+
+// ExportPayload writes a snapshot of all activities to an export target.
+// Full forces a full snapshot in incremental mode.
+type ExportPayload struct {
+	Target string `json:"target"`
+	Full   bool   `json:"full,omitempty"`
+}
+
+// Queue enqueues jobs
+type Queue struct {
+	db          *db.DB
+	maxAttempts int
+}
+
+// NewQueue creates a queue whose jobs are retried up to maxAttempts times
+func NewQueue(database *db.DB, maxAttempts int) *Queue {
+	return &Queue{db: database, maxAttempts: maxAttempts}
+}
+
+// EnqueueSync queues a sync of recent activities
+func (q *Queue) EnqueueSync(ctx context.Context, payload SyncPayload, userID int64) (db.Job, error) {
+	return q.db.EnqueueJob(ctx, db.JobSync, payload, q.maxAttempts, userID)
+}
+
+// EnqueueBackfill queues a backfill of historical activities
+func (q *Queue) EnqueueBackfill(ctx context.Context, payload BackfillPayload, userID int64) (db.Job, error) {
+	return q.db.EnqueueJob(ctx, db.JobBackfill, payload, q.maxAttempts, userID)
+}
+
+// EnqueueStreamDownload queues the download of an activity's streams
+func (q *Queue) EnqueueStreamDownload(ctx context.Context, payload StreamDownloadPayload, userID int64) (db.Job, error) {
+	return q.db.EnqueueJob(ctx, db.JobStreamDownload, payload, q.maxAttempts, userID)
+}
+
+// EnqueueExport queues a snapshot export
+func (q *Queue) EnqueueExport(ctx context.Context, payload ExportPayload, userID int64) (db.Job, error) {
+	return q.db.EnqueueJob(ctx, db.JobExport, payload, q.maxAttempts, userID)
+}
+
+// RunSyncSchedule queues a sync of the last 24 hours of every user who
+// authorized Strava every interval until ctx is cancelled. Each user gets a
+// job of their own, so a failing user is retried without syncing the others
+// again.
+func (q *Queue) RunSyncSchedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		userIDs, err := q.db.ListAuthorizedUserIDs(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error listing users for scheduled sync", "error", err)
+			continue
+		}
+		queued := 0
+		for _, userID := range userIDs {
+			_, err := q.EnqueueSync(ctx, SyncPayload{Days: 1, Resume: true, UserID: userID}, 0)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error queueing scheduled sync", "user_id", userID, "error", err)
+				continue
+			}
+			queued++
+		}
+		slog.InfoContext(ctx, "Queued scheduled sync", "jobs", queued, "users", len(userIDs))
+	}
+}
+
+// RunExportSchedule queues an export to each target once per interval until
+// ctx is cancelled. The time of the last export is kept in a sync checkpoint,
+// so a restart or a new leader does not export again before it is due.
+func (q *Queue) RunExportSchedule(ctx context.Context, targets []string, interval time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		for _, target := range targets {
+			q.scheduleExport(ctx, target, interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) scheduleExport(ctx context.Context, target string, interval time.Duration) {
+	checkpoint := "export:" + target
+	last, ok, err := q.db.GetSyncCheckpoint(ctx, checkpoint)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error reading export checkpoint", "target", target, "error", err)
+		return
+	}
+	now := time.Now()
+	if ok && now.Sub(last) < interval {
+		return
+	}
+
+	job, err := q.EnqueueExport(ctx, ExportPayload{Target: target}, 0)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error queueing scheduled export", "target", target, "error", err)
+		return
+	}
+	if err := q.db.SaveSyncCheckpoint(ctx, checkpoint, now); err != nil {
+		slog.ErrorContext(ctx, "Error saving export checkpoint", "target", target, "error", err)
+	}
+	slog.InfoContext(ctx, "Queued scheduled export", "target", target, "job_id", job.ID)
+}
@@ -0,0 +1,249 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+	"github.com/TobiKin/strava-data-pipeline/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/TobiKin/strava-data-pipeline/internal/jobs")
+
+const (
+	// heartbeatInterval is how often a running job's lock is extended
+	heartbeatInterval = 30 * time.Second
+	// staleAfter is how long a job's lock may go without a heartbeat before
+	// the job is queued again
+	staleAfter = 5 * time.Minute
+	// baseBackoff and maxBackoff bound the delay before a failed job is retried
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// errLockLost cancels a handler whose job was requeued as stale or taken over
+// by another worker
+var errLockLost = errors.New("job lock lost")
+
+// Handler runs a job. It reports progress through progress and must return
+// promptly with ctx's error when ctx is cancelled.
+type Handler func(ctx context.Context, job db.Job, progress func(done, total int)) error
+
+// Worker claims jobs from the queue and runs their handlers
+type Worker struct {
+	db           *db.DB
+	id           string
+	concurrency  int
+	pollInterval time.Duration
+	handlers     map[string]Handler
+}
+
+// NewWorker creates a worker that runs up to concurrency jobs at a time and
+// polls for new jobs every pollInterval
+func NewWorker(database *db.DB, id string, concurrency int, pollInterval time.Duration) *Worker {
+	return &Worker{
+		db:           database,
+		id:           id,
+		concurrency:  concurrency,
+		pollInterval: pollInterval,
+		handlers:     make(map[string]Handler),
+	}
+}
+
+// Register sets the handler of a job type. Handlers must be registered before Run.
+func (w *Worker) Register(jobType string, handler Handler) {
+	w.handlers[jobType] = handler
+}
+
+// Run processes jobs until ctx is cancelled. Jobs still running when ctx is
+// cancelled are put back on the queue.
+func (w *Worker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.requeueStale(ctx)
+	}()
+
+	wg.Wait()
+}
+
+// loop claims and runs jobs one at a time
+func (w *Worker) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := w.db.ClaimJob(ctx, w.id)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error claiming job", "error", err)
+		}
+		if job != nil {
+			w.run(ctx, *job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(w.pollInterval):
+		}
+	}
+}
+
+// run executes a claimed job and records the outcome
+func (w *Worker) run(ctx context.Context, job db.Job) {
+	ctx, span := tracer.Start(ctx, "job "+job.Type, trace.WithAttributes(
+		attribute.Int64("job.id", job.ID),
+		attribute.Int("job.attempt", job.Attempts),
+	))
+	ctx = logging.With(ctx, slog.Int64("job_id", job.ID), slog.String("job_type", job.Type))
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		ctx = logging.With(ctx, slog.String("trace_id", traceID))
+	}
+
+	// The job's state is recorded even after ctx is cancelled on shutdown
+	bookkeeping := context.WithoutCancel(ctx)
+
+	handler, ok := w.handlers[job.Type]
+	if !ok {
+		err := fmt.Errorf("no handler for job type %q", job.Type)
+		w.fail(bookkeeping, job, err)
+		tracing.End(span, err)
+		return
+	}
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	go w.heartbeat(jobCtx, cancel, job.ID)
+
+	progress := func(done, total int) {
+		if err := w.db.UpdateJobProgress(bookkeeping, job.ID, w.id, done, total); err != nil {
+			w.lockError(jobCtx, cancel, "Error updating job progress", err)
+		}
+	}
+
+	slog.InfoContext(jobCtx, "Running job", "attempt", job.Attempts, "max_attempts", job.MaxAttempts)
+	err := runHandler(jobCtx, handler, job, progress)
+	if errors.Is(context.Cause(jobCtx), errLockLost) {
+		err = errLockLost
+	}
+	tracing.End(span, err)
+
+	switch {
+	case errors.Is(err, errLockLost):
+		// The job belongs to another worker now, which records its outcome
+		slog.WarnContext(jobCtx, "Stopped job after losing its lock")
+	case err == nil:
+		if err := w.db.CompleteJob(bookkeeping, job.ID, w.id); err != nil {
+			slog.ErrorContext(jobCtx, "Error completing job", "error", err)
+		}
+	case ctx.Err() != nil && errors.Is(err, context.Canceled):
+		// Shutting down: hand the job to the next worker without counting the attempt
+		if err := w.db.ReleaseJob(bookkeeping, job.ID); err != nil {
+			slog.ErrorContext(jobCtx, "Error releasing job", "error", err)
+		}
+		slog.InfoContext(jobCtx, "Released job on shutdown")
+	default:
+		w.fail(bookkeeping, job, err)
+	}
+}
+
+// runHandler calls the handler, turning a panic into an error so one bad job
+// does not take down the worker
+func runHandler(ctx context.Context, handler Handler, job db.Job, progress func(done, total int)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job, progress)
+}
+
+// fail records a failed attempt and schedules the retry
+func (w *Worker) fail(ctx context.Context, job db.Job, cause error) {
+	updated, err := w.db.FailJob(ctx, job.ID, w.id, cause.Error(), time.Now().Add(Backoff(job.Attempts)))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error failing job", "error", err)
+		return
+	}
+	if updated.Status == db.JobDead {
+		slog.ErrorContext(ctx, "Job failed permanently", "attempts", updated.Attempts, "error", cause)
+		return
+	}
+	slog.WarnContext(ctx, "Job failed, retrying", "retry_at", updated.RunAt, "error", cause)
+}
+
+// heartbeat extends the job's lock until ctx is cancelled
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, jobID int64) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.db.HeartbeatJob(ctx, jobID, w.id); err != nil {
+				w.lockError(ctx, cancel, "Error extending job lock", err)
+			}
+		}
+	}
+}
+
+// lockError logs a failed progress update or heartbeat. If the worker no
+// longer holds the job's lock the handler is cancelled with errLockLost.
+func (w *Worker) lockError(ctx context.Context, cancel context.CancelCauseFunc, msg string, err error) {
+	if errors.Is(err, db.ErrNotFound) {
+		cancel(errLockLost)
+		return
+	}
+	slog.ErrorContext(ctx, msg, "error", err)
+}
+
+// requeueStale queues jobs of crashed workers again
+func (w *Worker) requeueStale(ctx context.Context) {
+	ticker := time.NewTicker(staleAfter / 2)
+	defer ticker.Stop()
+
+	for {
+		n, err := w.db.RequeueStaleJobs(ctx, time.Now().Add(-staleAfter))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error requeueing stale jobs", "error", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "Requeued stale jobs", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Backoff returns the delay before retrying a job that failed its given attempt
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := time.Duration(float64(baseBackoff) * math.Pow(2, float64(attempt-1)))
+	if delay > maxBackoff || delay <= 0 {
+		return maxBackoff
+	}
+	return delay
+}
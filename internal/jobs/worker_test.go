@@ -0,0 +1,68 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{8, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt); got != tt.expected {
+			t.Errorf("Backoff(%d) = %v, expected %v", tt.attempt, got, tt.expected)
+		}
+	}
+}
+
+func TestRunHandlerRecoversPanic(t *testing.T) {
+	handler := func(context.Context, db.Job, func(int, int)) error {
+		panic("boom")
+	}
+
+	err := runHandler(context.Background(), handler, db.Job{}, func(int, int) {})
+	if err == nil || err.Error() != "panic: boom" {
+		t.Fatalf("Expected panic to be returned as error, got %v", err)
+	}
+}
+
+func TestRunHandlerReturnsError(t *testing.T) {
+	expected := errors.New("rate limited")
+	handler := func(context.Context, db.Job, func(int, int)) error {
+		return expected
+	}
+
+	if err := runHandler(context.Background(), handler, db.Job{}, func(int, int) {}); !errors.Is(err, expected) {
+		t.Fatalf("Expected %v, got %v", expected, err)
+	}
+}
+
+func TestLockErrorCancelsOnlyLostJobs(t *testing.T) {
+	w := &Worker{id: "worker-1"}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	w.lockError(ctx, cancel, "Error extending job lock", errors.New("connection refused"))
+	if ctx.Err() != nil {
+		t.Fatal("Expected a database error not to cancel the job")
+	}
+
+	w.lockError(ctx, cancel, "Error extending job lock", fmt.Errorf("job 1 locked by worker-1 %w", db.ErrNotFound))
+	if !errors.Is(context.Cause(ctx), errLockLost) {
+		t.Fatalf("Expected the job to be cancelled with errLockLost, got %v", context.Cause(ctx))
+	}
+}
@@ -0,0 +1,117 @@
+// Package lifecycle starts and stops the subsystems of the application in a
+// defined order.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Hook is a subsystem's start and stop functions. Either may be nil.
+type Hook struct {
+	Name    string
+	OnStart func(ctx context.Context) error
+	OnStop  func(ctx context.Context) error
+}
+
+// Lifecycle runs hooks in the order they were appended on start and in
+// reverse order on stop, so a subsystem is stopped before the subsystems it
+// depends on.
+type Lifecycle struct {
+	mu      sync.Mutex
+	hooks   []Hook
+	started int
+}
+
+// New creates an empty lifecycle
+func New() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Append registers a hook. Hooks must be appended before Start is called.
+func (l *Lifecycle) Append(hook Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hook)
+}
+
+// Start runs the start hooks in order. If a hook fails, the hooks started so
+// far are stopped and the error is returned.
+func (l *Lifecycle) Start(ctx context.Context) error {
+	l.mu.Lock()
+	hooks := l.hooks
+	l.mu.Unlock()
+
+	for _, hook := range hooks {
+		if hook.OnStart != nil {
+			if err := hook.OnStart(ctx); err != nil {
+				startErr := fmt.Errorf("error starting %s: %w", hook.Name, err)
+				if stopErr := l.Stop(ctx); stopErr != nil {
+					return errors.Join(startErr, stopErr)
+				}
+				return startErr
+			}
+		}
+		l.mu.Lock()
+		l.started++
+		l.mu.Unlock()
+		slog.InfoContext(ctx, "Started", "component", hook.Name)
+	}
+	return nil
+}
+
+// Stop runs the stop hooks of all started subsystems in reverse order. Every
+// hook is run even if an earlier one fails; all errors are returned.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	hooks := l.hooks[:l.started]
+	l.started = 0
+	l.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hook := hooks[i]
+		if hook.OnStop == nil {
+			continue
+		}
+		if err := hook.OnStop(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("error stopping %s: %w", hook.Name, err))
+			continue
+		}
+		slog.InfoContext(ctx, "Stopped", "component", hook.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// Background returns a hook that runs fn in a goroutine. On stop the context
+// passed to fn is cancelled and the hook waits for fn to return, or for the
+// stop context to expire.
+func Background(name string, fn func(ctx context.Context)) Hook {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+
+	return Hook{
+		Name: name,
+		OnStart: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				fn(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
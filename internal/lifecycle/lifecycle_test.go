@@ -0,0 +1,101 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func recordingHook(name string, calls *[]string, startErr error) Hook {
+	return Hook{
+		Name: name,
+		OnStart: func(context.Context) error {
+			*calls = append(*calls, "start "+name)
+			return startErr
+		},
+		OnStop: func(context.Context) error {
+			*calls = append(*calls, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestStartAndStopOrder(t *testing.T) {
+	var calls []string
+	lc := New()
+	lc.Append(recordingHook("db", &calls, nil))
+	lc.Append(recordingHook("http", &calls, nil))
+
+	if err := lc.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err := lc.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+
+	expected := []string{"start db", "start http", "stop http", "stop db"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected %v, got %v", expected, calls)
+	}
+}
+
+func TestStartFailureStopsStartedHooks(t *testing.T) {
+	var calls []string
+	lc := New()
+	lc.Append(recordingHook("db", &calls, nil))
+	lc.Append(recordingHook("http", &calls, errors.New("address in use")))
+	lc.Append(recordingHook("jobs", &calls, nil))
+
+	if err := lc.Start(context.Background()); err == nil {
+		t.Fatal("Expected start to fail")
+	}
+
+	expected := []string{"start db", "start http", "stop db"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected %v, got %v", expected, calls)
+	}
+}
+
+func TestBackgroundIsCancelledOnStop(t *testing.T) {
+	stopped := make(chan struct{})
+	lc := New()
+	lc.Append(Background("job", func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	}))
+
+	if err := lc.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err := lc.Stop(context.Background()); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Expected background function to return before Stop")
+	}
+}
+
+func TestBackgroundStopTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	lc := New()
+	lc.Append(Background("stuck", func(ctx context.Context) {
+		<-release
+	}))
+
+	if err := lc.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := lc.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+}
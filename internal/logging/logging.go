@@ -0,0 +1,130 @@
+// Package logging configures log/slog and carries request-scoped attributes
+// such as the request ID, user ID and job ID through contexts.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+)
+
+type contextKey string
+
+const (
+	attrsKey   contextKey = "logAttrs"
+	requestKey contextKey = "logRequest"
+)
+
+// Setup installs the default slog logger configured by cfg. Output of the
+// standard log package is routed through it as well.
+func Setup(w io.Writer, cfg config.Logging) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	logger := slog.New(&contextHandler{Handler: handler})
+	slog.SetDefault(logger)
+	return logger, nil
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", level)
+	}
+	return l, nil
+}
+
+// With returns a context whose log lines carry the given attributes in
+// addition to those already on ctx. Within a request started with
+// StartRequest, the attributes are also added to the request's access log line.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if req, ok := ctx.Value(requestKey).(*request); ok {
+		req.add(attrs)
+	}
+	return context.WithValue(ctx, attrsKey, append(attrsFromContext(ctx), attrs...))
+}
+
+// request collects the attributes added while a request is handled, so the
+// access log line written after the handler returns includes e.g. the user ID
+type request struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+func (r *request) add(attrs []slog.Attr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attrs = append(r.attrs, attrs...)
+}
+
+// StartRequest returns a context for handling a request and a function that
+// returns the attributes added to it with With
+func StartRequest(ctx context.Context) (context.Context, func() []slog.Attr) {
+	req := &request{}
+	return context.WithValue(ctx, requestKey, req), func() []slog.Attr {
+		req.mu.Lock()
+		defer req.mu.Unlock()
+		return append([]slog.Attr(nil), req.attrs...)
+	}
+}
+
+// Attr returns the value of the attribute with the given key on ctx
+func Attr(ctx context.Context, key string) (slog.Value, bool) {
+	attrs := attrsFromContext(ctx)
+	for i := len(attrs) - 1; i >= 0; i-- {
+		if attrs[i].Key == key {
+			return attrs[i].Value, true
+		}
+	}
+	return slog.Value{}, false
+}
+
+func attrsFromContext(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey).([]slog.Attr)
+	// Copy so contexts derived from the same parent do not share the array
+	return append([]slog.Attr(nil), attrs...)
+}
+
+// contextHandler adds the attributes stored on the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if attrs, ok := ctx.Value(attrsKey).([]slog.Attr); ok {
+			r.AddAttrs(attrs...)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+)
+
+func TestContextAttributesAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := Setup(&buf, config.Logging{Level: "info", Format: "json"})
+	if err != nil {
+		t.Fatalf("Failed to set up logging: %v", err)
+	}
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+
+	ctx := With(context.Background(), slog.String("request_id", "abc"))
+	ctx = With(ctx, slog.Int64("user_id", 42))
+	logger.InfoContext(ctx, "Hello")
+	logger.DebugContext(ctx, "Hidden")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if record["request_id"] != "abc" || record["user_id"] != float64(42) {
+		t.Fatalf("Expected context attributes in the log line, got %v", record)
+	}
+}
+
+func TestWithDoesNotLeakBetweenSiblings(t *testing.T) {
+	parent := With(context.Background(), slog.String("request_id", "abc"))
+	first := With(parent, slog.Int64("job_id", 1))
+	second := With(parent, slog.Int64("job_id", 2))
+
+	if v, _ := Attr(first, "job_id"); v.Int64() != 1 {
+		t.Fatalf("Expected job_id 1, got %v", v)
+	}
+	if v, _ := Attr(second, "job_id"); v.Int64() != 2 {
+		t.Fatalf("Expected job_id 2, got %v", v)
+	}
+	if _, ok := Attr(parent, "job_id"); ok {
+		t.Fatal("Expected the parent context to have no job_id")
+	}
+}
+
+func TestStartRequestCollectsAttributes(t *testing.T) {
+	ctx, attrs := StartRequest(context.Background())
+	With(ctx, slog.Int64("user_id", 7))
+
+	got := attrs()
+	if len(got) != 1 || got[0].Key != "user_id" {
+		t.Fatalf("Expected user_id to be collected, got %v", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("warn"); err != nil || level != slog.LevelWarn {
+		t.Fatalf("Expected warn, got %v (%v)", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("Expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,105 @@
+// Package problem renders API errors as RFC 7807 problem details. It is
+// shared by package api and the auth middleware.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+)
+
+// ContentType is the media type of problem details responses
+const ContentType = "application/problem+json"
+
+// Code identifies the kind of error. Codes are part of the API contract and
+// must not change once published; clients should branch on them rather than
+// on the human readable detail.
+type Code string
+
+const (
+	CodeBadRequest         = Code("bad_request")
+	CodeInvalidBody        = Code("invalid_body")
+	CodeInvalidParameter   = Code("invalid_parameter")
+	CodeUnauthorized       = Code("unauthorized")
+	CodeInvalidToken       = Code("invalid_token")
+	CodeInvalidAPIKey      = Code("invalid_api_key")
+	CodeTokenReused        = Code("token_reused")
+	CodeForbidden          = Code("forbidden")
+	CodeNotFound           = Code("not_found")
+	CodeMethodNotAllowed   = Code("method_not_allowed")
+	CodeConflict           = Code("conflict")
+	CodeUpstreamError      = Code("upstream_error")
+	CodeServiceUnavailable = Code("service_unavailable")
+	CodeInternalError      = Code("internal_error")
+)
+
+// defaultCodes are used for statuses written without a specific code
+var defaultCodes = map[int]Code{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusMethodNotAllowed:    CodeMethodNotAllowed,
+	http.StatusConflict:            CodeConflict,
+	http.StatusBadGateway:          CodeUpstreamError,
+	http.StatusServiceUnavailable:  CodeServiceUnavailable,
+	http.StatusInternalServerError: CodeInternalError,
+}
+
+// Problem is an RFC 7807 problem details object with the code and request ID
+// as extension members
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      Code   `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// New returns a problem for the status with the status' default code
+func New(status int, detail string) Problem {
+	code, ok := defaultCodes[status]
+	if !ok {
+		code = CodeInternalError
+		if status < http.StatusInternalServerError {
+			code = CodeBadRequest
+		}
+	}
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// WithCode returns a copy of the problem with a more specific code
+func (p Problem) WithCode(code Code) Problem {
+	p.Code = code
+	return p
+}
+
+// Write renders the problem as the response to r. The instance is the request
+// path and the request ID is taken from the request's log attributes.
+func (p Problem) Write(w http.ResponseWriter, r *http.Request) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if id, ok := logging.Attr(r.Context(), "request_id"); ok {
+		p.RequestID = id.String()
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// Write renders a problem with the status' default code
+func Write(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	New(status, detail).Write(w, r)
+}
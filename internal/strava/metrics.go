@@ -0,0 +1,142 @@
+package strava
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	apiCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "strava_api_calls_total",
+		Help: "Calls to the Strava API by endpoint.",
+	}, []string{"endpoint"})
+
+	apiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "strava_api_errors_total",
+		Help: "Failed calls to the Strava API by endpoint.",
+	}, []string{"endpoint"})
+
+	rateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "strava_rate_limit_remaining",
+		Help: "Strava API requests left in the 15 minute (short) and daily (long) window, as of the last response.",
+	}, []string{"window"})
+
+	activitiesSyncedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "strava_activities_synced_total",
+		Help: "Activities saved by syncs and backfills.",
+	})
+
+	syncActivities = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "strava_sync_activities",
+		Help:    "Activities saved per sync run.",
+		Buckets: []float64{0, 1, 5, 10, 25, 50, 100, 200, 500, 1000},
+	}, []string{"kind"})
+
+	syncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "strava_sync_duration_seconds",
+		Help:    "Duration of sync runs.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"kind", "result"})
+
+	lastSyncs = newSyncLagCollector()
+)
+
+func init() {
+	prometheus.MustRegister(lastSyncs)
+}
+
+// observeCall counts a call to the Strava API and whether it failed, and
+// records the rate limit budget reported by the response
+func observeCall(endpoint string, err error) {
+	apiCallsTotal.WithLabelValues(endpoint).Inc()
+	if err != nil {
+		apiErrorsTotal.WithLabelValues(endpoint).Inc()
+	}
+
+	if rl := rateLimits.get(); rl.LimitShort > 0 {
+		rateLimitRemaining.WithLabelValues("short").Set(float64(rl.LimitShort - rl.UsageShort))
+		rateLimitRemaining.WithLabelValues("long").Set(float64(rl.LimitLong - rl.UsageLong))
+	}
+}
+
+// rateLimits holds the rate limit usage reported by the most recent API
+// response. Strava counts requests per application, so it is shared by the
+// clients of all users.
+var rateLimits lastRateLimit
+
+type lastRateLimit struct {
+	mu     sync.Mutex
+	status RateLimitStatus
+}
+
+func (l *lastRateLimit) get() RateLimitStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status
+}
+
+func (l *lastRateLimit) set(status RateLimitStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.status = status
+}
+
+// observeSync records the outcome of a sync or backfill run
+func observeSync(kind string, athleteID int64, start time.Time, saved int, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	syncDuration.WithLabelValues(kind, result).Observe(time.Since(start).Seconds())
+	syncActivities.WithLabelValues(kind).Observe(float64(saved))
+	activitiesSyncedTotal.Add(float64(saved))
+	if err == nil {
+		lastSyncs.record(athleteID, time.Now())
+	}
+}
+
+// syncLagCollector reports the time since the last successful sync of each
+// athlete, computed at scrape time
+type syncLagCollector struct {
+	desc *prometheus.Desc
+
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+func newSyncLagCollector() *syncLagCollector {
+	return &syncLagCollector{
+		desc: prometheus.NewDesc(
+			"strava_sync_lag_seconds",
+			"Seconds since the last successful sync of an athlete.",
+			[]string{"athlete_id"}, nil,
+		),
+		last: make(map[int64]time.Time),
+	}
+}
+
+func (c *syncLagCollector) record(athleteID int64, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last[athleteID] = t
+}
+
+func (c *syncLagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *syncLagCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for athleteID, t := range c.last {
+		label := "unknown"
+		if athleteID != 0 {
+			label = strconv.FormatInt(athleteID, 10)
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(t).Seconds(), label)
+	}
+}
@@ -2,88 +2,255 @@ package strava
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sync/atomic"
 	"time"
 
 	"github.com/TobiKin/strava-data-pipeline/internal/config"
 	"github.com/TobiKin/strava-data-pipeline/internal/db"
-	strava "github.com/strava/go.strava"
+	"github.com/TobiKin/strava-data-pipeline/internal/stravaapi"
+	"github.com/TobiKin/strava-data-pipeline/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Client is a wrapper around the Strava API client
+// SyncCheckpoint is the name of the checkpoint of the activity sync.
+// SyncCheckpointName adds the athlete to it.
+const SyncCheckpoint = "activities"
+
+// SyncCheckpointName returns the name of the checkpoint of an athlete's
+// activity sync
+func SyncCheckpointName(athleteID int64) string {
+	return fmt.Sprintf("%s:%d", SyncCheckpoint, athleteID)
+}
+
+// Client syncs the data of athletes from the Strava API into the database.
+// The client returned by New has no access token and only handles OAuth;
+// ForUser returns one that calls the API as a user.
 type Client struct {
-	config        *config.Config
-	client        *strava.Client
-	authenticator strava.OAuthAuthenticator
-	db            *db.DB
+	config *config.Config
+	db     Store
+
+	// baseURL is where the Strava API is served, stravaapi.DefaultBaseURL
+	// unless configured otherwise
+	baseURL string
+	// http sends the requests, http.DefaultTransport without a timeout if nil
+	http *http.Client
+
+	// token is the access token of athleteID, the user the client calls
+	// Strava for. Both are set by ForUser and never change.
+	token     string
+	athleteID int64
+
+	// tokenRejected is set when Strava answers with 401 Unauthorized and
+	// cleared by the next successful call
+	tokenRejected atomic.Bool
 }
 
-// New creates a new Strava client
-func New(config *config.Config, database *db.DB) (*Client, error) {
-	// Create a new authenticator
-	authenticator := strava.OAuthAuthenticator{
-		CallbackURL: config.Strava.CallbackURL,
-	}
+// Store is the part of the database the Strava client uses
+type Store interface {
+	db.ActivityStore
+	db.UserStore
+	SaveActivityStreams(ctx context.Context, activityID int64, data json.RawMessage) error
+	SaveSyncCheckpoint(ctx context.Context, name string, syncedUntil time.Time) error
+}
 
-	// Set the client ID and secret
-	strava.ClientId = config.Strava.ClientID
-	strava.ClientSecret = config.Strava.ClientSecret
+// Progress reports how many of the known items a long-running operation has
+// processed. total is 0 while it is unknown.
+type Progress func(done, total int)
 
-	// Create a new client with the saved access token
-	client := strava.NewClient(config.Strava.AccessToken)
+// New creates a new Strava client
+func New(config *config.Config, database Store) (*Client, error) {
+	baseURL := stravaapi.DefaultBaseURL
+	if config.Strava.BaseURL != "" {
+		u, err := url.Parse(config.Strava.BaseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid Strava base URL %q", config.Strava.BaseURL)
+		}
+		baseURL = config.Strava.BaseURL
+	}
 
 	return &Client{
-		config:        config,
-		client:        client,
-		authenticator: authenticator,
-		db:            database,
+		config:  config,
+		db:      database,
+		baseURL: baseURL,
 	}, nil
 }
 
-// FetchActivities fetches activities from Strava and stores them in the database
-func (c *Client) FetchActivities(after time.Time, limit int) error {
-	// Convert time to int64
-	afterUnix := after.Unix()
+// SetHTTPClient makes the client send its requests with client instead of
+// http.DefaultTransport. Its transport and timeout are used; the requests
+// are still traced and bound to the context of the call.
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.http = client
+}
+
+// FetchActivities fetches activities from Strava and stores them in the
+// database. If ctx is cancelled, it stops after the current activity, saves
+// a checkpoint and returns the context's error.
+func (c *Client) FetchActivities(ctx context.Context, after time.Time, limit int, progress Progress) (err error) {
+	ctx, span := tracer.Start(ctx, "strava.FetchActivities")
+	start := time.Now()
+	saved := 0
+	defer func() {
+		observeSync("sync", c.athleteID, start, saved, err)
+		span.SetAttributes(attribute.Int("strava.activities_saved", saved))
+		tracing.End(span, err)
+	}()
+
+	// Strava lists activities oldest first only if after is given
+	if after.IsZero() {
+		after = time.Unix(0, 0)
+	}
 
 	// Get activities from Strava
-	service := strava.NewCurrentAthleteService(c.client)
-	activities, err := service.ListActivities().
-		After(int(afterUnix)).
-		Page(1).
-		PerPage(limit).
-		Do()
+	activities, err := c.api(ctx, "list_activities").ListActivities(ctx, stravaapi.ListActivitiesOptions{
+		After:   after,
+		Page:    1,
+		PerPage: limit,
+	})
+	observeCall("list_activities", err)
 
 	if err != nil {
 		return fmt.Errorf("error fetching activities: %w", err)
 	}
 
-	log.Printf("Fetched %d activities from Strava", len(activities))
+	slog.InfoContext(ctx, "Fetched activities from Strava", "count", len(activities))
+
+	// Activities are returned oldest first, so everything up to the last
+	// saved activity is synced. The checkpoint stops advancing at the first
+	// activity that could not be saved.
+	var checkpoint time.Time
+	failed := false
+	defer func() {
+		if checkpoint.IsZero() {
+			return
+		}
+		// Saved even if the sync was cancelled, so the next sync resumes here
+		if err := c.db.SaveSyncCheckpoint(context.WithoutCancel(ctx), SyncCheckpointName(c.athleteID), checkpoint); err != nil {
+			slog.ErrorContext(ctx, "Error saving sync checkpoint", "error", err)
+		}
+	}()
 
 	// Save activities to the database
-	for _, activity := range activities {
+	for i, activity := range activities {
+		if err := ctx.Err(); err != nil {
+			slog.WarnContext(ctx, "Sync cancelled", "processed", i, "total", len(activities))
+			return err
+		}
+
 		// Convert the activity to a map
-		activityMap, err := activityToMap(activity)
+		activityMap, err := activityToMap(&activity)
 		if err != nil {
-			log.Printf("Error converting activity to map: %v", err)
+			slog.ErrorContext(ctx, "Error converting activity to map", "activity_id", activity.ID, "error", err)
+			failed = true
 			continue
 		}
 
 		// Save the activity to the database
-		if err := c.db.SaveActivity(activityMap); err != nil {
-			log.Printf("Error saving activity: %v", err)
+		if err := c.db.SaveActivity(ctx, activityMap); err != nil {
+			slog.ErrorContext(ctx, "Error saving activity", "activity_id", activity.ID, "error", err)
+			failed = true
 			continue
 		}
+
+		saved++
+		if !failed && activity.StartDate.After(checkpoint) {
+			checkpoint = activity.StartDate
+		}
+		if progress != nil {
+			progress(i+1, len(activities))
+		}
 	}
 
 	return nil
 }
 
+// Backfill fetches all activities started between after and before, page by
+// page, and stores them in the database. A zero before means now.
+func (c *Client) Backfill(ctx context.Context, after, before time.Time, progress Progress) (err error) {
+	const perPage = 200
+	if before.IsZero() {
+		before = time.Now()
+	}
+
+	ctx, span := tracer.Start(ctx, "strava.Backfill")
+	start := time.Now()
+	saved := 0
+	defer func() {
+		observeSync("backfill", c.athleteID, start, saved, err)
+		span.SetAttributes(attribute.Int("strava.activities_saved", saved))
+		tracing.End(span, err)
+	}()
+
+	api := c.api(ctx, "list_activities")
+	for page := 1; ; page++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		activities, err := api.ListActivities(ctx, stravaapi.ListActivitiesOptions{
+			After:   after,
+			Before:  before,
+			Page:    page,
+			PerPage: perPage,
+		})
+		observeCall("list_activities", err)
+		if err != nil {
+			return fmt.Errorf("error fetching page %d of activities: %w", page, err)
+		}
+
+		for _, activity := range activities {
+			activityMap, err := activityToMap(&activity)
+			if err != nil {
+				return err
+			}
+			if err := c.db.SaveActivity(ctx, activityMap); err != nil {
+				return err
+			}
+			saved++
+		}
+		if progress != nil {
+			progress(saved, 0)
+		}
+
+		if len(activities) < perPage {
+			slog.InfoContext(ctx, "Backfill complete", "count", saved, "after", after, "before", before)
+			return nil
+		}
+	}
+}
+
+// DownloadStreams fetches the time series of an activity and stores them in the database
+func (c *Client) DownloadStreams(ctx context.Context, activityID int64) (err error) {
+	ctx, span := tracer.Start(ctx, "strava.DownloadStreams",
+		trace.WithAttributes(attribute.Int64("strava.activity_id", activityID)))
+	defer func() { tracing.End(span, err) }()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	streams, err := c.api(ctx, "activity_streams").GetActivityStreams(ctx, activityID, stravaapi.AllStreamTypes)
+	observeCall("activity_streams", err)
+	if err != nil {
+		return fmt.Errorf("error fetching streams of activity %d: %w", activityID, err)
+	}
+
+	data, err := json.Marshal(streams)
+	if err != nil {
+		return fmt.Errorf("error encoding streams of activity %d: %w", activityID, err)
+	}
+
+	return c.db.SaveActivityStreams(ctx, activityID, data)
+}
+
 // activityToMap converts a Strava activity to a map
-func activityToMap(activity *strava.ActivitySummary) (map[string]interface{}, error) {
+func activityToMap(activity *stravaapi.Activity) (map[string]interface{}, error) {
 	// Convert the activity to JSON
 	data, err := json.Marshal(activity)
 	if err != nil {
@@ -99,175 +266,139 @@ func activityToMap(activity *strava.ActivitySummary) (map[string]interface{}, er
 	return activityMap, nil
 }
 
-// RefreshToken refreshes the Strava API tokens
-func (c *Client) RefreshToken(refreshToken string) (*strava.AuthorizationResponse, error) {
-	if refreshToken == "" {
-		return nil, fmt.Errorf("no refresh token available")
+// CheckToken reports whether the client has an access token that Strava has
+// not rejected. It does not call the API.
+func (c *Client) CheckToken() error {
+	if c.token == "" {
+		return errors.New("no Strava access token, the user has to authorize at /api/auth/strava")
 	}
-
-	// Use the OAuth service to refresh the token
-	resp, err := c.authenticator.Authorize(refreshToken, http.DefaultClient)
-	if err != nil {
-		return nil, fmt.Errorf("error refreshing token: %w", err)
+	if c.tokenRejected.Load() {
+		return errors.New("the Strava access token was rejected")
 	}
+	return nil
+}
 
-	// Update the client with the new access token
-	c.client = strava.NewClient(resp.AccessToken)
-
-	// TODO: Save the new tokens to the configuration or database
-	c.config.Strava.AccessToken = resp.AccessToken
+// observeResponse records whether Strava accepted the access token and the
+// rate limit usage it reported
+func (c *Client) observeResponse(resp *http.Response) {
+	if rl, ok := stravaapi.ParseRateLimit(resp.Header); ok {
+		rateLimits.set(RateLimitStatus{
+			RequestTime: time.Now(),
+			LimitShort:  rl.LimitShort,
+			LimitLong:   rl.LimitLong,
+			UsageShort:  rl.UsageShort,
+			UsageLong:   rl.UsageLong,
+		})
+	}
 
-	log.Println("Strava API token refreshed")
-	return resp, nil
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		c.tokenRejected.Store(true)
+	case resp.StatusCode < 300:
+		c.tokenRejected.Store(false)
+	}
 }
 
 // StartAuthFlow starts the OAuth2 authentication flow
 func (c *Client) StartAuthFlow() string {
-	// The scope determines what the app can access
-	// We use the "view_private" permission to access all activities
-	authURL := c.authenticator.AuthorizationURL("strava_state", strava.Permissions.ViewPrivate, false)
-	return authURL
+	// The scope determines what the app can access. activity:read_all
+	// includes private activities.
+	return c.oauth(context.Background(), "oauth_authorize").
+		AuthorizationURL("strava_state", stravaapi.ScopeRead, stravaapi.ScopeActivityReadAll)
 }
 
 // HandleAuthCallback handles the OAuth2 callback
-func (c *Client) HandleAuthCallback(ctx context.Context, code string) (*strava.AuthorizationResponse, error) {
+func (c *Client) HandleAuthCallback(ctx context.Context, code string) (*stravaapi.Token, error) {
 	// Exchange authorization code for token
-	resp, err := c.authenticator.Authorize(code, http.DefaultClient)
+	resp, err := c.oauth(ctx, "oauth_token").Exchange(ctx, code)
+	observeCall("oauth_token", err)
 	if err != nil {
 		return nil, fmt.Errorf("error exchanging code for token: %w", err)
 	}
+	if resp.Athlete == nil {
+		return nil, errors.New("token response has no athlete")
+	}
 
-	// Update the client with the new access token
-	c.client = strava.NewClient(resp.AccessToken)
-
-	// Save the tokens to the config
-	c.config.Strava.AccessToken = resp.AccessToken
-
-	// Save user information to the database
-	err = c.saveAthlete(&resp.Athlete, resp.AccessToken, "", 0) // No refresh token in the API
+	// Save user information and tokens to the database; syncs of the user
+	// read them from there
+	err = c.saveAthlete(ctx, resp.Athlete, resp.AccessToken, resp.RefreshToken, resp.ExpiresAt)
 	if err != nil {
-		log.Printf("Error saving athlete: %v", err)
+		return nil, fmt.Errorf("error saving athlete: %w", err)
 	}
 
 	return resp, nil
 }
 
-// saveAthlete saves athlete information to the database
-func (c *Client) saveAthlete(athlete *strava.AthleteDetailed, accessToken, refreshToken string, expiresAt int64) error {
-	query := `
-		INSERT INTO users (
-			id, username, firstname, lastname, city, country, sex,
-			access_token, refresh_token, token_expires_at
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, to_timestamp($10)
-		) ON CONFLICT (id) DO UPDATE SET
-			firstname = $3,
-			lastname = $4,
-			city = $5,
-			country = $6,
-			sex = $7,
-			access_token = $8,
-			refresh_token = $9,
-			token_expires_at = to_timestamp($10),
-			updated_at = NOW()
-	`
-
-	_, err := c.db.Exec(
-		query,
-		athlete.Id,
-		"", // No username in the API
-		athlete.FirstName,
-		athlete.LastName,
-		athlete.City,
-		athlete.Country,
-		string(athlete.Gender),
-		accessToken,
-		refreshToken,
-		expiresAt,
-	)
+// Deauthorize revokes the application's access to the user's Strava account
+// and removes the stored Strava tokens
+func (c *Client) Deauthorize(ctx context.Context, userID int64) error {
+	token, err := c.db.GetUserAccessToken(ctx, userID)
 	if err != nil {
-		return fmt.Errorf("error saving athlete: %w", err)
+		return err
 	}
 
-	return nil
-}
-
-// GetUserByID retrieves user information from the database
-func (c *Client) GetUserByID(userID int64) (map[string]interface{}, error) {
-	query := `
-		SELECT id, username, firstname, lastname, city, country, sex,
-			created_at, updated_at, token_expires_at
-		FROM users
-		WHERE id = $1
-	`
-
-	rows, err := c.db.Query(query, userID)
-	if err != nil {
-		return nil, fmt.Errorf("error querying user: %w", err)
+	if token != "" {
+		err := c.oauth(ctx, "oauth_deauthorize").Deauthorize(ctx, token)
+		observeCall("oauth_deauthorize", err)
+		if err != nil {
+			return fmt.Errorf("error deauthorizing athlete %d: %w", userID, err)
+		}
 	}
-	defer rows.Close()
 
-	results, err := rowsToMaps(rows)
-	if err != nil {
-		return nil, err
-	}
+	return c.db.ClearUserTokens(ctx, userID)
+}
 
-	if len(results) == 0 {
-		return nil, nil // Not found
+// saveAthlete saves athlete information to the database
+func (c *Client) saveAthlete(ctx context.Context, athlete *stravaapi.Athlete, accessToken, refreshToken string, expiresAt int64) error {
+	var expires time.Time
+	if expiresAt > 0 {
+		expires = time.Unix(expiresAt, 0).UTC()
 	}
-
-	return results[0], nil
+	return c.db.SaveAthlete(ctx, db.Athlete{
+		ID:        athlete.ID,
+		FirstName: athlete.FirstName,
+		LastName:  athlete.LastName,
+		City:      athlete.City,
+		Country:   athlete.Country,
+		Sex:       athlete.Sex,
+	}, accessToken, refreshToken, expires)
 }
 
-// rowsToMaps converts SQL rows to a slice of maps
-func rowsToMaps(rows *sql.Rows) ([]map[string]interface{}, error) {
-	columns, err := rows.Columns()
-	if err != nil {
-		return nil, fmt.Errorf("error getting columns: %w", err)
+// GetUserByID retrieves user information from the database, or nil if the
+// user does not exist
+func (c *Client) GetUserByID(ctx context.Context, userID int64) (map[string]interface{}, error) {
+	athlete, err := c.db.GetAthlete(ctx, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, nil
 	}
-
-	var results []map[string]interface{}
-
-	for rows.Next() {
-		// Create a slice of interface{} to hold the values
-		values := make([]interface{}, len(columns))
-		pointers := make([]interface{}, len(columns))
-		for i := range values {
-			pointers[i] = &values[i]
-		}
-
-		// Scan the result into the pointers
-		if err := rows.Scan(pointers...); err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-
-		// Create a map for this row
-		rowMap := make(map[string]interface{})
-		for i, colName := range columns {
-			rowMap[colName] = values[i]
-		}
-
-		results = append(results, rowMap)
+	if err != nil {
+		return nil, fmt.Errorf("error querying user: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
-	}
+	return map[string]interface{}{
+		"id":               athlete.ID,
+		"username":         athlete.Username,
+		"firstname":        athlete.FirstName,
+		"lastname":         athlete.LastName,
+		"city":             athlete.City,
+		"country":          athlete.Country,
+		"sex":              athlete.Sex,
+		"created_at":       athlete.CreatedAt,
+		"updated_at":       athlete.UpdatedAt,
+		"token_expires_at": athlete.TokenExpiresAt,
+	}, nil
+}
 
-	return results, nil
+// RateLimitStatus describes the Strava API budget as reported by the most recent request
+type RateLimitStatus struct {
+	RequestTime time.Time `json:"request_time"`
+	LimitShort  int       `json:"limit_short"`
+	LimitLong   int       `json:"limit_long"`
+	UsageShort  int       `json:"usage_short"`
+	UsageLong   int       `json:"usage_long"`
 }
 
-// StartSyncJob starts a job to sync activities from Strava
-func (c *Client) StartSyncJob(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	go func() {
-		for {
-			<-ticker.C
-			// Sync activities from the last 24 hours
-			err := c.FetchActivities(time.Now().Add(-24*time.Hour), 100)
-			if err != nil {
-				log.Printf("Error syncing activities: %v", err)
-			}
-		}
-	}()
+// RateLimitStatus returns the Strava API rate limit usage
+func (c *Client) RateLimitStatus() RateLimitStatus {
+	return rateLimits.get()
 }
@@ -0,0 +1,265 @@
+package strava
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/stravatest"
+)
+
+// testStore keeps activities and users in a db.MemoryStore and streams and
+// checkpoints in maps
+type testStore struct {
+	*db.MemoryStore
+	streams     map[int64]json.RawMessage
+	checkpoints map[string]time.Time
+}
+
+func (s *testStore) SaveActivityStreams(ctx context.Context, activityID int64, data json.RawMessage) error {
+	s.streams[activityID] = data
+	return nil
+}
+
+func (s *testStore) SaveSyncCheckpoint(ctx context.Context, name string, syncedUntil time.Time) error {
+	s.checkpoints[name] = syncedUntil
+	return nil
+}
+
+// forUser saves tokens of athlete 42 and returns a client that uses them
+func forUser(t *testing.T, client *Client, server *stravatest.Server, store *testStore) *Client {
+	t.Helper()
+	ctx := context.Background()
+	tokens := server.IssueTokens(42)
+	if err := store.SaveAthlete(ctx, db.Athlete{ID: 42}, tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresAt); err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+	user, err := client.ForUser(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to create client of the user: %v", err)
+	}
+	return user
+}
+
+// newTestClient returns a client of a fake Strava API with one athlete and
+// activities started on consecutive days of May 2024
+func newTestClient(t *testing.T, activities int) (*Client, *stravatest.Server, *testStore) {
+	t.Helper()
+	server := stravatest.NewServer()
+	t.Cleanup(server.Close)
+
+	server.AddAthlete(stravatest.Athlete{ID: 42, FirstName: "Jane", LastName: "Doe", City: "Berlin", Sex: "F"})
+	start := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	for i := 0; i < activities; i++ {
+		server.AddActivity(stravatest.Activity{
+			ID:          int64(1000 + i),
+			AthleteID:   42,
+			Name:        "Morning Run",
+			Type:        "Run",
+			Distance:    5000,
+			MovingTime:  1500,
+			ElapsedTime: 1600,
+			StartDate:   start.AddDate(0, 0, i),
+		})
+	}
+
+	store := &testStore{
+		MemoryStore: db.NewMemoryStore(),
+		streams:     make(map[int64]json.RawMessage),
+		checkpoints: make(map[string]time.Time),
+	}
+	client, err := New(&config.Config{Strava: server.Config()}, store)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client, server, store
+}
+
+func TestOAuthAndSync(t *testing.T) {
+	client, server, store := newTestClient(t, 3)
+	server.SetStreams(1001,
+		stravatest.Stream{Type: "time", Data: []interface{}{0, 1, 2}},
+		stravatest.Stream{Type: "heartrate", Data: []interface{}{120, 125, 131}},
+	)
+	ctx := context.Background()
+
+	if authURL := client.StartAuthFlow(); !strings.HasPrefix(authURL, server.URL+"/") {
+		t.Fatalf("Expected the authorization URL on the fake server, got %s", authURL)
+	}
+	if _, err := client.HandleAuthCallback(ctx, "unknown"); err == nil {
+		t.Fatal("Expected an unknown authorization code to be rejected")
+	}
+	resp, err := client.HandleAuthCallback(ctx, server.Authorize(42))
+	if err != nil {
+		t.Fatalf("Failed to exchange the authorization code: %v", err)
+	}
+	if resp.Athlete.ID != 42 {
+		t.Fatalf("Expected a token of athlete 42, got %+v", resp)
+	}
+	if client.CheckToken() == nil {
+		t.Fatal("Expected the shared client to keep no token")
+	}
+	athlete, err := store.GetAthlete(ctx, 42)
+	if err != nil || athlete.FirstName != "Jane" || athlete.TokenExpiresAt.IsZero() {
+		t.Fatalf("Expected the athlete to be saved with the token expiry, got %+v, %v", athlete, err)
+	}
+	if _, err := client.RefreshUserToken(ctx, 42); err != nil {
+		t.Fatalf("Failed to refresh the token obtained with the authorization code: %v", err)
+	}
+	if client, err = client.ForUser(ctx, 42); err != nil {
+		t.Fatalf("Failed to create client of the user: %v", err)
+	}
+
+	if err := client.FetchActivities(ctx, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), 30, nil); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	activities, err := store.GetActivitiesByAthlete(ctx, 42, 10, 0)
+	if err != nil || len(activities) != 3 {
+		t.Fatalf("Expected 3 synced activities, got %d, %v", len(activities), err)
+	}
+	if want := time.Date(2024, 5, 3, 7, 0, 0, 0, time.UTC); !store.checkpoints["activities:42"].Equal(want) {
+		t.Fatalf("Expected the checkpoint of athlete 42 at the newest activity, got %v", store.checkpoints)
+	}
+
+	if err := client.DownloadStreams(ctx, 1001); err != nil {
+		t.Fatalf("Failed to download streams: %v", err)
+	}
+	var streams map[string]json.RawMessage
+	if err := json.Unmarshal(store.streams[1001], &streams); err != nil {
+		t.Fatalf("Failed to decode saved streams: %v", err)
+	}
+	if streams["time"] == nil || streams["heartrate"] == nil || streams["watts"] != nil {
+		t.Fatalf("Expected the time and heart rate streams, got %s", store.streams[1001])
+	}
+
+	limits := client.RateLimitStatus()
+	if limits.LimitShort != stravatest.DefaultLimitShort || limits.UsageShort != 2 {
+		t.Fatalf("Expected the rate limit usage of 2 API calls, got %+v", limits)
+	}
+
+	if err := client.Deauthorize(ctx, 42); err != nil {
+		t.Fatalf("Failed to deauthorize: %v", err)
+	}
+	if token, _ := store.GetUserAccessToken(ctx, 42); token != "" {
+		t.Fatal("Expected the tokens to be cleared")
+	}
+	if err := client.FetchActivities(ctx, time.Time{}, 30, nil); err == nil || client.CheckToken() == nil {
+		t.Fatal("Expected the revoked token to be rejected")
+	}
+}
+
+func TestRefreshUserTokenAndSyncAsUser(t *testing.T) {
+	client, server, store := newTestClient(t, 2)
+	ctx := context.Background()
+
+	tokens := server.IssueTokens(42)
+	err := store.SaveAthlete(ctx, db.Athlete{ID: 42, FirstName: "Jane"}, tokens.AccessToken, tokens.RefreshToken, tokens.ExpiresAt)
+	if err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+	server.ExpireTokens(42)
+
+	user, err := client.ForUser(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to create client of the user: %v", err)
+	}
+	if err := user.Backfill(ctx, time.Time{}, time.Time{}, nil); err == nil || user.CheckToken() == nil {
+		t.Fatal("Expected the expired token to be rejected")
+	}
+
+	expiresAt, err := client.RefreshUserToken(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to refresh token: %v", err)
+	}
+	if time.Until(expiresAt) < stravatest.TokenLifetime-time.Minute {
+		t.Fatalf("Expected the new token to expire in %v, got %v", stravatest.TokenLifetime, expiresAt)
+	}
+	if _, err := client.RefreshUserToken(ctx, 42); err != nil {
+		t.Fatalf("Failed to refresh with the rotated refresh token: %v", err)
+	}
+
+	user, err = client.ForUser(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to create client of the user: %v", err)
+	}
+	if err := user.Backfill(ctx, time.Time{}, time.Time{}, nil); err != nil || user.CheckToken() != nil {
+		t.Fatalf("Failed to backfill with the refreshed token: %v", err)
+	}
+	if activities, _ := store.GetActivitiesByAthlete(ctx, 42, 10, 0); len(activities) != 2 {
+		t.Fatalf("Expected 2 activities, got %d", len(activities))
+	}
+}
+
+func TestBackfillPages(t *testing.T) {
+	client, server, store := newTestClient(t, 250)
+	ctx := context.Background()
+	client = forUser(t, client, server, store)
+
+	pages := 0
+	err := client.Backfill(ctx, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}, func(done, total int) { pages++ })
+	if err != nil {
+		t.Fatalf("Failed to backfill: %v", err)
+	}
+	if pages != 2 {
+		t.Fatalf("Expected 2 pages, got %d", pages)
+	}
+	if activities, _ := store.ListActivities(ctx, db.ActivityFilter{Limit: 300}); len(activities) != 250 {
+		t.Fatalf("Expected 250 activities, got %d", len(activities))
+	}
+}
+
+func TestSyncErrors(t *testing.T) {
+	client, server, store := newTestClient(t, 1)
+	ctx := context.Background()
+	client = forUser(t, client, server, store)
+
+	server.FailNext("/api/v3/athlete/activities", http.StatusInternalServerError)
+	if err := client.FetchActivities(ctx, time.Time{}, 30, nil); err == nil {
+		t.Fatal("Expected the server error to fail the sync")
+	}
+	if _, ok := store.checkpoints[SyncCheckpointName(42)]; ok {
+		t.Fatal("Expected no checkpoint after a failed sync")
+	}
+
+	server.SetRateLimit(1, 100)
+	if err := client.FetchActivities(ctx, time.Time{}, 30, nil); err != nil {
+		t.Fatalf("Failed to sync within the rate limit: %v", err)
+	}
+	if err := client.DownloadStreams(ctx, 1000); err == nil {
+		t.Fatal("Expected the request beyond the rate limit to fail")
+	}
+	if limits := client.RateLimitStatus(); limits.UsageShort <= limits.LimitShort {
+		t.Fatalf("Expected the rate limit to be exceeded, got %+v", limits)
+	}
+	if client.CheckToken() != nil {
+		t.Fatal("Expected the token to stay valid when rate limited")
+	}
+}
+
+func TestForUserRefreshesExpiredToken(t *testing.T) {
+	client, server, store := newTestClient(t, 1)
+	ctx := context.Background()
+
+	tokens := server.IssueTokens(42)
+	server.ExpireTokens(42)
+	err := store.SaveAthlete(ctx, db.Athlete{ID: 42}, tokens.AccessToken, tokens.RefreshToken, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to save athlete: %v", err)
+	}
+
+	user, err := client.ForUser(ctx, 42)
+	if err != nil {
+		t.Fatalf("Failed to create client of the user: %v", err)
+	}
+	if err := user.FetchActivities(ctx, time.Time{}, 30, nil); err != nil {
+		t.Fatalf("Failed to sync with the refreshed token: %v", err)
+	}
+	if saved, _ := store.GetUserByID(ctx, 42); saved.AccessToken == tokens.AccessToken || time.Until(saved.TokenExpiresAt) < time.Hour {
+		t.Fatalf("Expected the refreshed token to be saved, got %+v", saved)
+	}
+}
@@ -0,0 +1,78 @@
+package strava
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/stravaapi"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/TobiKin/strava-data-pipeline/internal/strava")
+
+// contextTransport binds requests to the context of the call that sends
+// them, so cancelling the context aborts the call. Responses are passed to
+// the client so it notices a rejected token.
+type contextTransport struct {
+	ctx    context.Context
+	client *Client
+	next   http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req.WithContext(t.ctx))
+	if err == nil {
+		t.client.observeResponse(resp)
+	}
+	return resp, err
+}
+
+// httpClient returns an HTTP client whose requests belong to ctx and are
+// traced as spans named after the Strava endpoint
+func (c *Client) httpClient(ctx context.Context, endpoint string) *http.Client {
+	transport := http.DefaultTransport
+	var timeout time.Duration
+	if c.http != nil {
+		if c.http.Transport != nil {
+			transport = c.http.Transport
+		}
+		timeout = c.http.Timeout
+	}
+
+	return &http.Client{
+		Transport: contextTransport{
+			ctx:    ctx,
+			client: c,
+			next: otelhttp.NewTransport(transport,
+				otelhttp.WithSpanNameFormatter(func(string, *http.Request) string {
+					return "strava " + endpoint
+				}),
+			),
+		},
+		Timeout: timeout,
+	}
+}
+
+// api returns an API client that calls endpoint with the current access
+// token on behalf of ctx
+func (c *Client) api(ctx context.Context, endpoint string) *stravaapi.Client {
+	return &stravaapi.Client{
+		BaseURL:     c.baseURL,
+		HTTPClient:  c.httpClient(ctx, endpoint),
+		AccessToken: c.token,
+	}
+}
+
+// oauth returns an OAuth client of the configured application that calls
+// endpoint on behalf of ctx
+func (c *Client) oauth(ctx context.Context, endpoint string) *stravaapi.OAuth {
+	return &stravaapi.OAuth{
+		BaseURL:      c.baseURL,
+		HTTPClient:   c.httpClient(ctx, endpoint),
+		ClientID:     c.config.Strava.ClientID,
+		ClientSecret: c.config.Strava.ClientSecret,
+		RedirectURL:  c.config.Strava.CallbackURL,
+	}
+}
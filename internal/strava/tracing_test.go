@@ -0,0 +1,94 @@
+package strava
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestHTTPClientTracesCallsInContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("traceparent") == "" {
+			t.Error("Expected the trace to be propagated to Strava")
+		}
+	}))
+	defer server.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "sync")
+	resp, err := (&Client{}).httpClient(ctx, "list_activities").Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to call server: %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	call := spans[0]
+	if call.Name() != "strava list_activities" {
+		t.Fatalf("Expected span named after the endpoint, got %q", call.Name())
+	}
+	if call.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("Expected the call span to be a child of the context's span")
+	}
+}
+
+func TestHTTPClientStopsWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Client{}).httpClient(ctx, "list_activities").Get(server.URL); err == nil {
+		t.Fatal("Expected the call to fail with a cancelled context")
+	}
+}
+
+// countingTransport counts the requests it sends
+type countingTransport struct{ calls *int }
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*t.calls++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestAPIUsesBaseURLAndHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/strava/api/v3/athlete" {
+			t.Errorf("Expected the path below the base URL, got %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"id": 42}`))
+	}))
+	defer server.Close()
+
+	client, err := New(&config.Config{Strava: config.Strava{BaseURL: server.URL + "/strava/"}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	calls := 0
+	client.SetHTTPClient(&http.Client{Transport: countingTransport{&calls}})
+
+	ctx := context.Background()
+	if athlete, err := client.api(ctx, "athlete").GetAthlete(ctx); err != nil || athlete.ID != 42 {
+		t.Fatalf("Expected athlete 42, got %+v, %v", athlete, err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected the request to be sent with the configured client, got %d calls", calls)
+	}
+}
@@ -0,0 +1,74 @@
+package strava
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// tokenRefreshMargin is how long before it expires an access token is
+// refreshed, so that it does not expire during a sync
+const tokenRefreshMargin = 5 * time.Minute
+
+// ForUser returns a client that calls Strava with the stored access token of
+// a user. A token that has expired or is about to is refreshed first.
+func (c *Client) ForUser(ctx context.Context, userID int64) (*Client, error) {
+	user, err := c.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.AccessToken == "" {
+		return nil, fmt.Errorf("user %d has not authorized Strava", userID)
+	}
+	if !user.TokenExpiresAt.IsZero() && time.Until(user.TokenExpiresAt) < tokenRefreshMargin {
+		if _, err := c.RefreshUserToken(ctx, userID); err != nil {
+			return nil, err
+		}
+		if user, err = c.db.GetUserByID(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Client{
+		config:    c.config,
+		token:     user.AccessToken,
+		db:        c.db,
+		baseURL:   c.baseURL,
+		http:      c.http,
+		athleteID: userID,
+	}, nil
+}
+
+// RefreshUserToken exchanges the stored refresh token of a user for a new
+// access token and stores both. It returns when the new access token expires.
+func (c *Client) RefreshUserToken(ctx context.Context, userID int64) (time.Time, error) {
+	user, err := c.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if user.RefreshToken == "" {
+		return time.Time{}, fmt.Errorf("user %d has no Strava refresh token", userID)
+	}
+
+	tokens, err := c.oauth(ctx, "oauth_token").Refresh(ctx, user.RefreshToken)
+	observeCall("oauth_token", err)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error refreshing token of user %d: %w", userID, err)
+	}
+
+	// Strava may keep the refresh token, in which case the response repeats
+	// it or leaves it out
+	refreshToken := tokens.RefreshToken
+	if refreshToken == "" {
+		refreshToken = user.RefreshToken
+	}
+
+	expiresAt := tokens.Expiry()
+	if err := c.db.SaveUserTokens(ctx, userID, tokens.AccessToken, refreshToken, expiresAt); err != nil {
+		return time.Time{}, err
+	}
+
+	slog.InfoContext(ctx, "Strava token refreshed", "user_id", userID, "expires_at", expiresAt)
+	return expiresAt, nil
+}
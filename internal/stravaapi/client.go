@@ -0,0 +1,231 @@
+// Package stravaapi is a client of the Strava v3 API. It covers what the
+// pipeline needs: the OAuth authorization code and refresh token grants,
+// the authenticated athlete and their zones, activities with their laps,
+// streams and zones, and gear.
+//
+// Every call takes a context. Failed calls return an *Error that carries the
+// HTTP status and the fault Strava reported. The rate limit usage Strava
+// reports with every response can be read with ParseRateLimit.
+package stravaapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is where Strava serves its API and OAuth endpoints
+const DefaultBaseURL = "https://www.strava.com"
+
+// apiPath is the path of the v3 API below the base URL
+const apiPath = "/api/v3"
+
+// Client calls the Strava API on behalf of the athlete whose access token it
+// holds
+type Client struct {
+	// BaseURL is where the API is served, DefaultBaseURL if empty
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient *http.Client
+	// AccessToken authorizes the requests
+	AccessToken string
+}
+
+// ListActivitiesOptions select a page of the activities of the athlete
+type ListActivitiesOptions struct {
+	// Before and After limit the activities by start time, unset if zero
+	Before time.Time
+	After  time.Time
+	// Page starts at 1. Strava uses page 1 and 30 activities per page if
+	// they are zero.
+	Page    int
+	PerPage int
+}
+
+// GetAthlete returns the authenticated athlete
+func (c *Client) GetAthlete(ctx context.Context) (*Athlete, error) {
+	var athlete Athlete
+	if err := c.get(ctx, "/athlete", nil, &athlete); err != nil {
+		return nil, err
+	}
+	return &athlete, nil
+}
+
+// GetAthleteZones returns the heart rate and power zones of the
+// authenticated athlete
+func (c *Client) GetAthleteZones(ctx context.Context) (*AthleteZones, error) {
+	var zones AthleteZones
+	if err := c.get(ctx, "/athlete/zones", nil, &zones); err != nil {
+		return nil, err
+	}
+	return &zones, nil
+}
+
+// ListActivities returns a page of the activities of the authenticated
+// athlete. Strava lists them oldest first if opts.After is set and newest
+// first otherwise.
+func (c *Client) ListActivities(ctx context.Context, opts ListActivitiesOptions) ([]Activity, error) {
+	query := url.Values{}
+	if !opts.Before.IsZero() {
+		query.Set("before", strconv.FormatInt(opts.Before.Unix(), 10))
+	}
+	if !opts.After.IsZero() {
+		query.Set("after", strconv.FormatInt(opts.After.Unix(), 10))
+	}
+	if opts.Page > 0 {
+		query.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.PerPage > 0 {
+		query.Set("per_page", strconv.Itoa(opts.PerPage))
+	}
+
+	var activities []Activity
+	if err := c.get(ctx, "/athlete/activities", query, &activities); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// GetActivity returns the detailed representation of an activity
+func (c *Client) GetActivity(ctx context.Context, id int64) (*Activity, error) {
+	var activity Activity
+	if err := c.get(ctx, fmt.Sprintf("/activities/%d", id), nil, &activity); err != nil {
+		return nil, err
+	}
+	return &activity, nil
+}
+
+// GetActivityLaps returns the laps of an activity
+func (c *Client) GetActivityLaps(ctx context.Context, id int64) ([]Lap, error) {
+	var laps []Lap
+	if err := c.get(ctx, fmt.Sprintf("/activities/%d/laps", id), nil, &laps); err != nil {
+		return nil, err
+	}
+	return laps, nil
+}
+
+// GetActivityZones returns the time an activity spent in each heart rate
+// and power zone
+func (c *Client) GetActivityZones(ctx context.Context, id int64) ([]ActivityZone, error) {
+	var zones []ActivityZone
+	if err := c.get(ctx, fmt.Sprintf("/activities/%d/zones", id), nil, &zones); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// GetActivityStreams returns the requested streams of an activity. Streams
+// the activity does not have are missing from the result.
+func (c *Client) GetActivityStreams(ctx context.Context, id int64, types []StreamType) (StreamSet, error) {
+	keys := make([]string, len(types))
+	for i, t := range types {
+		keys[i] = string(t)
+	}
+	query := url.Values{
+		"keys":        {strings.Join(keys, ",")},
+		"key_by_type": {"true"},
+	}
+
+	var streams StreamSet
+	if err := c.get(ctx, fmt.Sprintf("/activities/%d/streams", id), query, &streams); err != nil {
+		return nil, err
+	}
+	return streams, nil
+}
+
+// GetGear returns a bike or a pair of shoes
+func (c *Client) GetGear(ctx context.Context, id string) (*Gear, error) {
+	var gear Gear
+	if err := c.get(ctx, "/gear/"+url.PathEscape(id), nil, &gear); err != nil {
+		return nil, err
+	}
+	return &gear, nil
+}
+
+// get calls an API endpoint and decodes the response into out
+func (c *Client) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	u := baseURL(c.BaseURL) + apiPath + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	return do(c.HTTPClient, req, out)
+}
+
+// baseURL returns base without a trailing slash, or DefaultBaseURL if empty
+func baseURL(base string) string {
+	if base == "" {
+		return DefaultBaseURL
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// do sends a request and decodes a successful JSON response into out, or
+// returns the error Strava answered with
+func do(client *http.Client, req *http.Request, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return decodeError(resp)
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response of %s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// RateLimit is the API budget of the application as of a response. The
+// short window is 15 minutes, the long one a day.
+type RateLimit struct {
+	LimitShort int
+	LimitLong  int
+	UsageShort int
+	UsageLong  int
+}
+
+// ParseRateLimit reads the X-RateLimit-Limit and X-RateLimit-Usage headers
+// of a response. It reports false if they are missing or malformed, as they
+// are on OAuth responses.
+func ParseRateLimit(header http.Header) (RateLimit, bool) {
+	var rl RateLimit
+	limit := strings.Split(header.Get("X-RateLimit-Limit"), ",")
+	usage := strings.Split(header.Get("X-RateLimit-Usage"), ",")
+	if len(limit) != 2 || len(usage) != 2 {
+		return RateLimit{}, false
+	}
+
+	var errs [4]error
+	rl.LimitShort, errs[0] = strconv.Atoi(strings.TrimSpace(limit[0]))
+	rl.LimitLong, errs[1] = strconv.Atoi(strings.TrimSpace(limit[1]))
+	rl.UsageShort, errs[2] = strconv.Atoi(strings.TrimSpace(usage[0]))
+	rl.UsageLong, errs[3] = strconv.Atoi(strings.TrimSpace(usage[1]))
+	for _, err := range errs {
+		if err != nil {
+			return RateLimit{}, false
+		}
+	}
+	return rl, true
+}
@@ -0,0 +1,212 @@
+package stravaapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/stravatest"
+)
+
+// newTestServer returns a fake Strava API with athlete 42, who owns two
+// activities, and an OAuth client of it
+func newTestServer(t *testing.T) (*stravatest.Server, *OAuth) {
+	t.Helper()
+	server := stravatest.NewServer()
+	t.Cleanup(server.Close)
+
+	server.AddAthlete(stravatest.Athlete{ID: 42, FirstName: "Jane", Sex: "F"})
+	server.AddAthlete(stravatest.Athlete{ID: 7})
+	start := time.Date(2024, 5, 1, 7, 0, 0, 0, time.UTC)
+	for i, id := range []int64{1, 2} {
+		server.AddActivity(stravatest.Activity{
+			ID: id, AthleteID: 42, Name: "Run", Type: "Run", Description: "Easy",
+			StartDate: start.AddDate(0, 0, i), StartLatLng: []float64{52.5, 13.4},
+		})
+	}
+	server.AddActivity(stravatest.Activity{ID: 3, AthleteID: 7, StartDate: start})
+
+	config := server.Config()
+	return server, &OAuth{
+		BaseURL:      config.BaseURL,
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		RedirectURL:  config.CallbackURL,
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	server, oauth := newTestServer(t)
+	ctx := context.Background()
+
+	authURL, err := url.Parse(oauth.AuthorizationURL("xyz", ScopeRead, ScopeActivityReadAll))
+	if err != nil || authURL.Path != "/oauth/authorize" {
+		t.Fatalf("Expected the authorization page, got %v, %v", authURL, err)
+	}
+	if q := authURL.Query(); q.Get("scope") != "read,activity:read_all" || q.Get("state") != "xyz" || q.Get("client_id") != "1234" {
+		t.Fatalf("Expected the scopes, state and client ID in the query, got %v", q)
+	}
+
+	token, err := oauth.Exchange(ctx, server.Authorize(42))
+	if err != nil {
+		t.Fatalf("Failed to exchange code: %v", err)
+	}
+	if token.Athlete == nil || token.Athlete.ID != 42 || token.RefreshToken == "" {
+		t.Fatalf("Expected tokens of athlete 42, got %+v", token)
+	}
+	if time.Until(token.Expiry()) < stravatest.TokenLifetime-time.Minute {
+		t.Fatalf("Expected the token to expire in %v, got %v", stravatest.TokenLifetime, token.Expiry())
+	}
+
+	refreshed, err := oauth.Refresh(ctx, token.RefreshToken)
+	if err != nil || refreshed.AccessToken == token.AccessToken || refreshed.Athlete != nil {
+		t.Fatalf("Expected a new access token, got %+v, %v", refreshed, err)
+	}
+	if _, err := oauth.Refresh(ctx, token.RefreshToken); !IsStatus(err, http.StatusBadRequest) {
+		t.Fatalf("Expected the used refresh token to be rejected, got %v", err)
+	}
+
+	if err := oauth.Deauthorize(ctx, refreshed.AccessToken); err != nil {
+		t.Fatalf("Failed to deauthorize: %v", err)
+	}
+	client := &Client{BaseURL: oauth.BaseURL, AccessToken: refreshed.AccessToken}
+	if _, err := client.GetAthlete(ctx); !IsStatus(err, http.StatusUnauthorized) {
+		t.Fatalf("Expected the revoked token to be rejected, got %v", err)
+	}
+
+	wrong := *oauth
+	wrong.ClientSecret = "wrong"
+	var apiErr *Error
+	if _, err := wrong.Exchange(ctx, server.Authorize(42)); !errors.As(err, &apiErr) || apiErr.Errors[0].Resource != "Application" {
+		t.Fatalf("Expected the credentials to be rejected, got %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	server, oauth := newTestServer(t)
+	ctx := context.Background()
+	server.SetLaps(1, stravatest.Lap{ID: 11, LapIndex: 1, Distance: 1000}, stravatest.Lap{ID: 12, LapIndex: 2, Distance: 1000})
+	server.SetStreams(1,
+		stravatest.Stream{Type: "time", Data: []interface{}{0, 1}},
+		stravatest.Stream{Type: "latlng", Data: []interface{}{[]float64{52.5, 13.4}, []float64{52.6, 13.5}}},
+	)
+	server.SetActivityZones(1, []ActivityZone{{Type: "heartrate", DistributionBuckets: []TimeInZone{{Min: 0, Max: 120, Time: 60}}}})
+	server.SetAthleteZones(42, AthleteZones{HeartRate: &Zones{Zones: []ZoneRange{{Min: 0, Max: 120}, {Min: 120, Max: -1}}}})
+	server.AddGear(stravatest.Gear{ID: "b1", AthleteID: 42, Name: "Road bike"})
+	server.AddGear(stravatest.Gear{ID: "b2", AthleteID: 7, Name: "Someone else's"})
+	client := &Client{BaseURL: oauth.BaseURL, AccessToken: server.IssueTokens(42).AccessToken}
+
+	athlete, err := client.GetAthlete(ctx)
+	if err != nil || athlete.FirstName != "Jane" || athlete.Sex != "F" {
+		t.Fatalf("Expected athlete 42, got %+v, %v", athlete, err)
+	}
+	zones, err := client.GetAthleteZones(ctx)
+	if err != nil || zones.HeartRate == nil || len(zones.HeartRate.Zones) != 2 {
+		t.Fatalf("Expected 2 heart rate zones, got %+v, %v", zones, err)
+	}
+
+	activities, err := client.ListActivities(ctx, ListActivitiesOptions{After: time.Unix(0, 0), PerPage: 1, Page: 2})
+	if err != nil || len(activities) != 1 || activities[0].ID != 2 {
+		t.Fatalf("Expected the second activity on page 2, got %+v, %v", activities, err)
+	}
+	if activities[0].Athlete.ID != 42 || activities[0].Description != "" || activities[0].StartLatLng != (LatLng{52.5, 13.4}) {
+		t.Fatalf("Expected the summary of the activity, got %+v", activities[0])
+	}
+	activities, err = client.ListActivities(ctx, ListActivitiesOptions{})
+	if err != nil || len(activities) != 2 || activities[0].ID != 2 {
+		t.Fatalf("Expected both activities newest first, got %+v, %v", activities, err)
+	}
+
+	activity, err := client.GetActivity(ctx, 1)
+	if err != nil || activity.Description != "Easy" || !activity.EndLatLng.IsZero() {
+		t.Fatalf("Expected the details of activity 1, got %+v, %v", activity, err)
+	}
+	if _, err := client.GetActivity(ctx, 3); !IsStatus(err, http.StatusNotFound) {
+		t.Fatalf("Expected the activity of another athlete not to be found, got %v", err)
+	}
+
+	laps, err := client.GetActivityLaps(ctx, 1)
+	if err != nil || len(laps) != 2 || laps[1].ID != 12 {
+		t.Fatalf("Expected 2 laps, got %+v, %v", laps, err)
+	}
+	activityZones, err := client.GetActivityZones(ctx, 1)
+	if err != nil || len(activityZones) != 1 || activityZones[0].DistributionBuckets[0].Time != 60 {
+		t.Fatalf("Expected the time in zones, got %+v, %v", activityZones, err)
+	}
+
+	streams, err := client.GetActivityStreams(ctx, 1, []StreamType{StreamTime, StreamLatLng, StreamWatts})
+	if err != nil || len(streams) != 2 {
+		t.Fatalf("Expected 2 streams, got %+v, %v", streams, err)
+	}
+	if latlng := streams[StreamLatLng]; string(latlng.Data) != "[[52.5,13.4],[52.6,13.5]]" || latlng.OriginalSize != 2 {
+		t.Fatalf("Expected the location stream, got %s", latlng.Data)
+	}
+
+	gear, err := client.GetGear(ctx, "b1")
+	if err != nil || gear.Name != "Road bike" {
+		t.Fatalf("Expected the bike, got %+v, %v", gear, err)
+	}
+	if _, err := client.GetGear(ctx, "b2"); !IsStatus(err, http.StatusNotFound) {
+		t.Fatalf("Expected the gear of another athlete not to be found, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server, oauth := newTestServer(t)
+	ctx := context.Background()
+	client := &Client{BaseURL: oauth.BaseURL, AccessToken: server.IssueTokens(42).AccessToken}
+
+	server.FailNext("/api/v3/athlete", http.StatusServiceUnavailable)
+	if _, err := client.GetAthlete(ctx); !IsStatus(err, http.StatusServiceUnavailable) {
+		t.Fatalf("Expected the injected error, got %v", err)
+	}
+
+	server.SetRateLimit(1, 10)
+	if _, err := client.GetAthlete(ctx); err != nil {
+		t.Fatalf("Failed to call within the rate limit: %v", err)
+	}
+	_, err := client.GetAthlete(ctx)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Errors[0].Code != "exceeded" {
+		t.Fatalf("Expected the rate limit to be exceeded, got %v", err)
+	}
+	if want := "strava: 429 Rate Limit Exceeded (Application rate limit exceeded)"; err.Error() != want {
+		t.Fatalf("Expected %q, got %q", want, err.Error())
+	}
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+	_, err = (&Client{BaseURL: proxy.URL}).GetAthlete(ctx)
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "upstream unavailable" {
+		t.Fatalf("Expected the body of a non-JSON error as message, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.GetAthlete(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the call to stop with the context, got %v", err)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	header := http.Header{}
+	if _, ok := ParseRateLimit(header); ok {
+		t.Fatal("Expected no rate limit without headers")
+	}
+	header.Set("X-RateLimit-Limit", "200,2000")
+	header.Set("X-RateLimit-Usage", "12, 345")
+	rl, ok := ParseRateLimit(header)
+	if !ok || rl != (RateLimit{LimitShort: 200, LimitLong: 2000, UsageShort: 12, UsageLong: 345}) {
+		t.Fatalf("Expected the parsed rate limit, got %+v, %v", rl, ok)
+	}
+	header.Set("X-RateLimit-Usage", "12")
+	if _, ok := ParseRateLimit(header); ok {
+		t.Fatal("Expected a malformed usage to be rejected")
+	}
+}
@@ -0,0 +1,60 @@
+package stravaapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody is how much of an error response is read
+const maxErrorBody = 64 << 10
+
+// Error is a failed call. Strava describes the problem in a fault with a
+// message and details naming the offending resource and field.
+type Error struct {
+	StatusCode int           `json:"-"`
+	Message    string        `json:"message"`
+	Errors     []ErrorDetail `json:"errors"`
+}
+
+// ErrorDetail names what was wrong with a request
+type ErrorDetail struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("strava: %d %s", e.StatusCode, e.Message)
+	if len(e.Errors) > 0 {
+		details := make([]string, len(e.Errors))
+		for i, d := range e.Errors {
+			details[i] = strings.TrimSpace(d.Resource + " " + d.Field + " " + d.Code)
+		}
+		msg += " (" + strings.Join(details, ", ") + ")"
+	}
+	return msg
+}
+
+// IsStatus reports whether err is an *Error with the given HTTP status
+func IsStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == status
+}
+
+// decodeError reads the fault of a failed response. Bodies that are not a
+// fault, such as the HTML of a proxy, become the message.
+func decodeError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &Error{StatusCode: resp.StatusCode}
+	if json.Unmarshal(body, e) != nil || e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+		if e.Message == "" || len(e.Message) > 200 {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+	return e
+}
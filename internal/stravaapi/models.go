@@ -0,0 +1,229 @@
+package stravaapi
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Athlete is a Strava athlete. Lists and references only carry some of the
+// fields; GetAthlete returns all of them.
+type Athlete struct {
+	ID                    int64     `json:"id"`
+	Username              string    `json:"username"`
+	FirstName             string    `json:"firstname"`
+	LastName              string    `json:"lastname"`
+	Bio                   string    `json:"bio"`
+	City                  string    `json:"city"`
+	State                 string    `json:"state"`
+	Country               string    `json:"country"`
+	Sex                   string    `json:"sex"` // M, F or empty
+	Premium               bool      `json:"premium"`
+	Summit                bool      `json:"summit"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+	Profile               string    `json:"profile"`        // URL of a 124x124 pixel picture
+	ProfileMedium         string    `json:"profile_medium"` // URL of a 62x62 pixel picture
+	Weight                float64   `json:"weight"`         // kilograms
+	FTP                   int       `json:"ftp"`
+	MeasurementPreference string    `json:"measurement_preference"` // feet or meters
+	Bikes                 []Gear    `json:"bikes"`
+	Shoes                 []Gear    `json:"shoes"`
+}
+
+// AthleteRef refers to the athlete an activity belongs to
+type AthleteRef struct {
+	ID int64 `json:"id"`
+}
+
+// Activity is a Strava activity. Activity lists leave out the description,
+// calories, gear and laps, which GetActivity includes.
+type Activity struct {
+	ID                   int64       `json:"id"`
+	ExternalID           string      `json:"external_id"`
+	UploadID             int64       `json:"upload_id"`
+	Athlete              AthleteRef  `json:"athlete"`
+	Name                 string      `json:"name"`
+	Description          string      `json:"description"`
+	Type                 string      `json:"type"`
+	SportType            string      `json:"sport_type"`
+	WorkoutType          *int        `json:"workout_type"`
+	Distance             float64     `json:"distance"`     // meters
+	MovingTime           int         `json:"moving_time"`  // seconds
+	ElapsedTime          int         `json:"elapsed_time"` // seconds
+	TotalElevationGain   float64     `json:"total_elevation_gain"`
+	ElevHigh             float64     `json:"elev_high"`
+	ElevLow              float64     `json:"elev_low"`
+	StartDate            time.Time   `json:"start_date"`
+	StartDateLocal       time.Time   `json:"start_date_local"`
+	Timezone             string      `json:"timezone"`
+	UTCOffset            float64     `json:"utc_offset"` // seconds
+	StartLatLng          LatLng      `json:"start_latlng"`
+	EndLatLng            LatLng      `json:"end_latlng"`
+	AchievementCount     int         `json:"achievement_count"`
+	KudosCount           int         `json:"kudos_count"`
+	CommentCount         int         `json:"comment_count"`
+	AthleteCount         int         `json:"athlete_count"`
+	PhotoCount           int         `json:"photo_count"`
+	TotalPhotoCount      int         `json:"total_photo_count"`
+	PRCount              int         `json:"pr_count"`
+	Map                  PolylineMap `json:"map"`
+	Trainer              bool        `json:"trainer"`
+	Commute              bool        `json:"commute"`
+	Manual               bool        `json:"manual"`
+	Private              bool        `json:"private"`
+	Visibility           string      `json:"visibility"`
+	Flagged              bool        `json:"flagged"`
+	GearID               string      `json:"gear_id"`
+	Gear                 *Gear       `json:"gear,omitempty"`
+	AverageSpeed         float64     `json:"average_speed"` // meters per second
+	MaxSpeed             float64     `json:"max_speed"`
+	AverageCadence       float64     `json:"average_cadence"`
+	AverageTemp          float64     `json:"average_temp"`
+	AverageWatts         float64     `json:"average_watts"`
+	WeightedAverageWatts int         `json:"weighted_average_watts"`
+	MaxWatts             int         `json:"max_watts"`
+	Kilojoules           float64     `json:"kilojoules"`
+	DeviceWatts          bool        `json:"device_watts"`
+	HasHeartrate         bool        `json:"has_heartrate"`
+	AverageHeartrate     float64     `json:"average_heartrate"`
+	MaxHeartrate         float64     `json:"max_heartrate"`
+	Calories             float64     `json:"calories"`
+	DeviceName           string      `json:"device_name"`
+	Laps                 []Lap       `json:"laps,omitempty"`
+}
+
+// LatLng is a latitude and longitude. Strava sends an empty array for
+// activities without a location, which leaves it zero.
+type LatLng [2]float64
+
+// IsZero reports whether the location is unset
+func (l LatLng) IsZero() bool {
+	return l == LatLng{}
+}
+
+func (l *LatLng) UnmarshalJSON(data []byte) error {
+	var coords []float64
+	if err := json.Unmarshal(data, &coords); err != nil {
+		return err
+	}
+	*l = LatLng{}
+	if len(coords) == 2 {
+		*l = LatLng{coords[0], coords[1]}
+	}
+	return nil
+}
+
+// PolylineMap is the route of an activity as encoded polylines
+type PolylineMap struct {
+	ID              string `json:"id"`
+	Polyline        string `json:"polyline"`
+	SummaryPolyline string `json:"summary_polyline"`
+}
+
+// Lap is a lap of an activity, recorded by the device or split by the
+// athlete
+type Lap struct {
+	ID                 int64     `json:"id"`
+	Name               string    `json:"name"`
+	LapIndex           int       `json:"lap_index"`
+	Split              int       `json:"split"`
+	ElapsedTime        int       `json:"elapsed_time"`
+	MovingTime         int       `json:"moving_time"`
+	StartDate          time.Time `json:"start_date"`
+	StartDateLocal     time.Time `json:"start_date_local"`
+	Distance           float64   `json:"distance"`
+	StartIndex         int       `json:"start_index"`
+	EndIndex           int       `json:"end_index"`
+	TotalElevationGain float64   `json:"total_elevation_gain"`
+	AverageSpeed       float64   `json:"average_speed"`
+	MaxSpeed           float64   `json:"max_speed"`
+	AverageCadence     float64   `json:"average_cadence"`
+	AverageWatts       float64   `json:"average_watts"`
+	AverageHeartrate   float64   `json:"average_heartrate"`
+	MaxHeartrate       float64   `json:"max_heartrate"`
+	PaceZone           int       `json:"pace_zone"`
+}
+
+// StreamType names a time series of an activity
+type StreamType string
+
+// Stream types Strava records
+const (
+	StreamTime           StreamType = "time"
+	StreamLatLng         StreamType = "latlng"
+	StreamDistance       StreamType = "distance"
+	StreamAltitude       StreamType = "altitude"
+	StreamVelocitySmooth StreamType = "velocity_smooth"
+	StreamHeartrate      StreamType = "heartrate"
+	StreamCadence        StreamType = "cadence"
+	StreamWatts          StreamType = "watts"
+	StreamTemp           StreamType = "temp"
+	StreamMoving         StreamType = "moving"
+	StreamGradeSmooth    StreamType = "grade_smooth"
+)
+
+// AllStreamTypes lists every stream type
+var AllStreamTypes = []StreamType{
+	StreamTime, StreamLatLng, StreamDistance, StreamAltitude, StreamVelocitySmooth,
+	StreamHeartrate, StreamCadence, StreamWatts, StreamTemp, StreamMoving, StreamGradeSmooth,
+}
+
+// Stream is a time series. Data holds numbers, booleans for moving and
+// [lat, lng] pairs for latlng, so it is kept as JSON.
+type Stream struct {
+	Data         json.RawMessage `json:"data"`
+	SeriesType   string          `json:"series_type"` // distance or time
+	OriginalSize int             `json:"original_size"`
+	Resolution   string          `json:"resolution"` // low, medium or high
+}
+
+// StreamSet holds the streams of an activity by type
+type StreamSet map[StreamType]Stream
+
+// ActivityZone is the time an activity spent in the zones of one kind
+type ActivityZone struct {
+	Type                string       `json:"type"` // heartrate or power
+	Score               int          `json:"score"`
+	SensorBased         bool         `json:"sensor_based"`
+	CustomZones         bool         `json:"custom_zones"`
+	Max                 int          `json:"max"`
+	DistributionBuckets []TimeInZone `json:"distribution_buckets"`
+}
+
+// TimeInZone is the time spent between Min and Max, -1 for no upper bound
+type TimeInZone struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Time int     `json:"time"` // seconds
+}
+
+// AthleteZones are the heart rate and power zones of an athlete
+type AthleteZones struct {
+	HeartRate *Zones `json:"heart_rate"`
+	Power     *Zones `json:"power"`
+}
+
+// Zones are the ranges of one kind of zone
+type Zones struct {
+	CustomZones bool        `json:"custom_zones"`
+	Zones       []ZoneRange `json:"zones"`
+}
+
+// ZoneRange is a zone from Min to Max, -1 for no upper bound
+type ZoneRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// Gear is a bike or a pair of shoes
+type Gear struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Primary     bool    `json:"primary"`
+	Retired     bool    `json:"retired"`
+	Distance    float64 `json:"distance"` // meters
+	BrandName   string  `json:"brand_name"`
+	ModelName   string  `json:"model_name"`
+	FrameType   int     `json:"frame_type"` // bikes only
+	Description string  `json:"description"`
+}
@@ -0,0 +1,111 @@
+package stravaapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes an application can request when the athlete authorizes it
+const (
+	ScopeRead            = "read"
+	ScopeReadAll         = "read_all"
+	ScopeProfileReadAll  = "profile:read_all"
+	ScopeActivityRead    = "activity:read"
+	ScopeActivityReadAll = "activity:read_all"
+)
+
+// OAuth obtains and revokes the tokens of athletes for an application
+type OAuth struct {
+	// BaseURL is where the OAuth endpoints are served, DefaultBaseURL if
+	// empty
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil
+	HTTPClient   *http.Client
+	ClientID     int
+	ClientSecret string
+	// RedirectURL is where Strava sends the athlete after they decided
+	RedirectURL string
+}
+
+// Token is the response of the token endpoint
+type Token struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresAt is when the access token expires in seconds since the epoch
+	ExpiresAt int64 `json:"expires_at"`
+	ExpiresIn int64 `json:"expires_in"`
+	// Athlete is set when an authorization code was exchanged
+	Athlete *Athlete `json:"athlete"`
+}
+
+// Expiry returns when the access token expires
+func (t *Token) Expiry() time.Time {
+	return time.Unix(t.ExpiresAt, 0).UTC()
+}
+
+// AuthorizationURL returns the page where an athlete grants the application
+// the scopes. Strava passes state on to the redirect URL.
+func (o *OAuth) AuthorizationURL(state string, scopes ...string) string {
+	query := url.Values{
+		"client_id":       {strconv.Itoa(o.ClientID)},
+		"redirect_uri":    {o.RedirectURL},
+		"response_type":   {"code"},
+		"approval_prompt": {"auto"},
+		"scope":           {strings.Join(scopes, ",")},
+		"state":           {state},
+	}
+	return baseURL(o.BaseURL) + "/oauth/authorize?" + query.Encode()
+}
+
+// Exchange trades the authorization code passed to the redirect URL for the
+// tokens of the athlete
+func (o *OAuth) Exchange(ctx context.Context, code string) (*Token, error) {
+	return o.token(ctx, url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+	})
+}
+
+// Refresh trades a refresh token for a new access token. The response may
+// carry a new refresh token, which replaces the old one.
+func (o *OAuth) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	return o.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func (o *OAuth) token(ctx context.Context, form url.Values) (*Token, error) {
+	form.Set("client_id", strconv.Itoa(o.ClientID))
+	form.Set("client_secret", o.ClientSecret)
+
+	var token Token
+	if err := o.post(ctx, "/oauth/token", form, &token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access token")
+	}
+	return &token, nil
+}
+
+// Deauthorize revokes the application's access to the account of the
+// athlete an access token belongs to, invalidating all of their tokens
+func (o *OAuth) Deauthorize(ctx context.Context, accessToken string) error {
+	return o.post(ctx, "/oauth/deauthorize", url.Values{"access_token": {accessToken}}, nil)
+}
+
+func (o *OAuth) post(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL(o.BaseURL)+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return do(o.HTTPClient, req, out)
+}
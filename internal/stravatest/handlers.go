@@ -0,0 +1,379 @@
+package stravatest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// fault is the error body Strava answers with
+type fault struct {
+	Message string        `json:"message"`
+	Errors  []faultDetail `json:"errors"`
+}
+
+type faultDetail struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeFault(w http.ResponseWriter, status int, message, resource, field, code string) {
+	f := fault{Message: message, Errors: []faultDetail{}}
+	if resource != "" {
+		f.Errors = append(f.Errors, faultDetail{Resource: resource, Field: field, Code: code})
+	}
+	writeJSON(w, status, f)
+}
+
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeFault(w, http.StatusNotFound, "Resource Not Found", "resource", "path", "invalid")
+	})
+}
+
+// record logs a request and fails it if an error was injected for its path
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		status := 0
+		for i, f := range s.failures {
+			if strings.HasPrefix(r.URL.Path, f.path) {
+				status = f.status
+				s.failures = slices.Delete(s.failures, i, i+1)
+				break
+			}
+		}
+		s.mu.Unlock()
+
+		if status != 0 {
+			writeFault(w, status, http.StatusText(status), "", "", "")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limit counts API requests against the rate limits and reports the usage
+// in the headers Strava sends
+func (s *Server) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.usageShort++
+		s.usageLong++
+		exceeded := s.usageShort > s.limitShort || s.usageLong > s.limitLong
+		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d,%d", s.limitShort, s.limitLong))
+		w.Header().Set("X-RateLimit-Usage", fmt.Sprintf("%d,%d", s.usageShort, s.usageLong))
+		s.mu.Unlock()
+
+		if exceeded {
+			writeFault(w, http.StatusTooManyRequests, "Rate Limit Exceeded", "Application", "rate limit", "exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type athleteKey struct{}
+
+// authenticate rejects requests without a valid access token and passes the
+// athlete of the token on in the request context
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		g, valid := s.accessTokens[token]
+		s.mu.Unlock()
+		if !ok || !valid || time.Now().After(g.expiresAt) {
+			writeFault(w, http.StatusUnauthorized, "Authorization Error", "Athlete", "access_token", "invalid")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), athleteKey{}, g.athleteID)))
+	})
+}
+
+func athleteID(r *http.Request) int64 {
+	return r.Context().Value(athleteKey{}).(int64)
+}
+
+// tokenResponse is the response of POST /oauth/token
+type tokenResponse struct {
+	TokenType    string   `json:"token_type"`
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresAt    int64    `json:"expires_at"`
+	ExpiresIn    int64    `json:"expires_in"`
+	Athlete      *Athlete `json:"athlete,omitempty"`
+}
+
+// tokenHandler exchanges an authorization code or a refresh token for new
+// tokens. Exchanging a refresh token invalidates it.
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "body", "invalid")
+		return
+	}
+	if r.Form.Get("client_id") != strconv.Itoa(s.ClientID) || r.Form.Get("client_secret") != s.ClientSecret {
+		writeFault(w, http.StatusUnauthorized, "Bad Request", "Application", "client_id", "invalid")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var athleteID int64
+	grantType := r.Form.Get("grant_type")
+	switch grantType {
+	case "authorization_code", "":
+		id, ok := s.codes[r.Form.Get("code")]
+		if !ok {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "RequestToken", "code", "invalid")
+			return
+		}
+		delete(s.codes, r.Form.Get("code"))
+		athleteID = id
+	case "refresh_token":
+		g, ok := s.refreshTokens[r.Form.Get("refresh_token")]
+		if !ok {
+			writeFault(w, http.StatusBadRequest, "Bad Request", "RefreshToken", "refresh_token", "invalid")
+			return
+		}
+		delete(s.refreshTokens, r.Form.Get("refresh_token"))
+		athleteID = g.athleteID
+	default:
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "grant_type", "invalid")
+		return
+	}
+
+	tokens := s.issueTokens(athleteID)
+	resp := tokenResponse{
+		TokenType:    "Bearer",
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt.Unix(),
+		ExpiresIn:    int64(TokenLifetime.Seconds()),
+	}
+	if grantType != "refresh_token" {
+		athlete := s.athletes[athleteID]
+		resp.Athlete = &athlete
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// deauthorizeHandler revokes all tokens of the athlete whose access token
+// is passed as a form value or a bearer token
+func (s *Server) deauthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("access_token")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		token = bearer
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.accessTokens[token]
+	if !ok {
+		writeFault(w, http.StatusUnauthorized, "Authorization Error", "Athlete", "access_token", "invalid")
+		return
+	}
+	s.revokeTokens(g.athleteID)
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": token})
+}
+
+func (s *Server) athleteHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	athlete := s.athletes[athleteID(r)]
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, athlete)
+}
+
+// activityJSON is an activity as Strava renders it, with a reference to the
+// athlete
+type activityJSON struct {
+	Activity
+	Athlete struct {
+		ID            int64 `json:"id"`
+		ResourceState int   `json:"resource_state"`
+	} `json:"athlete"`
+	ResourceState int `json:"resource_state"`
+}
+
+func render(activity Activity, detailed bool) activityJSON {
+	a := activityJSON{Activity: activity, ResourceState: 3}
+	a.Athlete.ID = activity.AthleteID
+	a.Athlete.ResourceState = 1
+	if a.StartLatLng == nil {
+		a.StartLatLng = []float64{}
+	}
+	if a.EndLatLng == nil {
+		a.EndLatLng = []float64{}
+	}
+	if !detailed {
+		a.Description = ""
+		a.Calories = 0
+		a.ResourceState = 2
+	}
+	return a
+}
+
+// queryInt returns the integer query parameter name, or def if it is absent
+func queryInt(r *http.Request, name string, def int64) (int64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	return n, err == nil
+}
+
+// listActivitiesHandler returns a page of the athlete's activities started
+// between the before and after epoch timestamps. Like Strava, it lists them
+// oldest first if after is given and newest first otherwise.
+func (s *Server) listActivitiesHandler(w http.ResponseWriter, r *http.Request) {
+	before, ok1 := queryInt(r, "before", 0)
+	after, ok2 := queryInt(r, "after", 0)
+	page, ok3 := queryInt(r, "page", 1)
+	perPage, ok4 := queryInt(r, "per_page", 30)
+	if !ok1 || !ok2 || !ok3 || !ok4 || page < 1 || perPage < 1 {
+		writeFault(w, http.StatusBadRequest, "Bad Request", "Application", "query", "invalid")
+		return
+	}
+	perPage = min(perPage, 200)
+
+	s.mu.Lock()
+	activities := s.activitiesOf(athleteID(r))
+	s.mu.Unlock()
+
+	matching := []activityJSON{}
+	for _, activity := range activities {
+		start := activity.StartDate.Unix()
+		if (before == 0 || start < before) && (after == 0 || start > after) {
+			matching = append(matching, render(activity, false))
+		}
+	}
+	if r.URL.Query().Get("after") == "" {
+		slices.Reverse(matching)
+	}
+
+	from := min(int64(len(matching)), (page-1)*perPage)
+	to := min(int64(len(matching)), from+perPage)
+	writeJSON(w, http.StatusOK, matching[from:to])
+}
+
+// activityOf returns an activity of the requesting athlete. Activities of
+// other athletes are not found, as private ones are on Strava.
+func (s *Server) activityOf(r *http.Request) (Activity, bool) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	activity, ok := s.activities[id]
+	return activity, ok && activity.AthleteID == athleteID(r)
+}
+
+func (s *Server) activityHandler(w http.ResponseWriter, r *http.Request) {
+	activity, ok := s.activityOf(r)
+	if !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, render(activity, true))
+}
+
+// streamsHandler returns the streams of an activity listed in the keys
+// parameter. With key_by_type=true they are returned as an object keyed by
+// type rather than an array.
+func (s *Server) streamsHandler(w http.ResponseWriter, r *http.Request) {
+	activity, ok := s.activityOf(r)
+	if !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "not found")
+		return
+	}
+
+	wanted := strings.Split(r.URL.Query().Get("keys"), ",")
+
+	s.mu.Lock()
+	var streams []Stream
+	for _, stream := range s.streams[activity.ID] {
+		if slices.Contains(wanted, stream.Type) {
+			streams = append(streams, stream)
+		}
+	}
+	s.mu.Unlock()
+
+	if r.URL.Query().Get("key_by_type") == "true" {
+		byType := make(map[string]Stream, len(streams))
+		for _, stream := range streams {
+			byType[stream.Type] = stream
+		}
+		writeJSON(w, http.StatusOK, byType)
+		return
+	}
+	if streams == nil {
+		streams = []Stream{}
+	}
+	writeJSON(w, http.StatusOK, streams)
+}
+
+func (s *Server) lapsHandler(w http.ResponseWriter, r *http.Request) {
+	activity, ok := s.activityOf(r)
+	if !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "not found")
+		return
+	}
+	s.mu.Lock()
+	laps := s.laps[activity.ID]
+	s.mu.Unlock()
+	if laps == nil {
+		laps = []Lap{}
+	}
+	writeJSON(w, http.StatusOK, laps)
+}
+
+func (s *Server) activityZonesHandler(w http.ResponseWriter, r *http.Request) {
+	activity, ok := s.activityOf(r)
+	if !ok {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Activity", "id", "not found")
+		return
+	}
+	s.mu.Lock()
+	zones, ok := s.activityZones[activity.ID]
+	s.mu.Unlock()
+	if !ok {
+		zones = json.RawMessage("[]")
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+func (s *Server) athleteZonesHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	zones, ok := s.athleteZones[athleteID(r)]
+	s.mu.Unlock()
+	if !ok {
+		zones = json.RawMessage(`{"heart_rate":{"custom_zones":false,"zones":[]}}`)
+	}
+	writeJSON(w, http.StatusOK, zones)
+}
+
+func (s *Server) gearHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	gear, ok := s.gear[mux.Vars(r)["id"]]
+	s.mu.Unlock()
+	if !ok || gear.AthleteID != athleteID(r) {
+		writeFault(w, http.StatusNotFound, "Record Not Found", "Gear", "id", "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, gear)
+}
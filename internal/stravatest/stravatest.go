@@ -0,0 +1,403 @@
+// Package stravatest provides a fake of the parts of the Strava v3 API the
+// pipeline uses, for tests that run without network access or Strava
+// credentials.
+//
+// The fake serves the OAuth token exchange, refresh and deauthorization, the
+// authenticated athlete and their zones, their activities with paging,
+// activity details, laps, zones and streams, and gear. Responses carry rate limit headers, and errors can be injected
+// per path. Tests set up athletes and activities directly and point a client
+// at the server with Config:
+//
+//	server := stravatest.NewServer()
+//	defer server.Close()
+//	server.AddAthlete(stravatest.Athlete{ID: 42, FirstName: "Jane"})
+//	code := server.Authorize(42) // the athlete approves the app
+package stravatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/gorilla/mux"
+)
+
+// TokenLifetime is how long issued access tokens are valid, like on Strava
+const TokenLifetime = 6 * time.Hour
+
+// Default rate limits of a Strava application per 15 minutes and per day
+const (
+	DefaultLimitShort = 200
+	DefaultLimitLong  = 2000
+)
+
+// Athlete is a Strava athlete as returned by GET /athlete
+type Athlete struct {
+	ID        int64   `json:"id"`
+	Username  string  `json:"username"`
+	FirstName string  `json:"firstname"`
+	LastName  string  `json:"lastname"`
+	City      string  `json:"city"`
+	State     string  `json:"state"`
+	Country   string  `json:"country"`
+	Sex       string  `json:"sex"`
+	Premium   bool    `json:"premium"`
+	Weight    float64 `json:"weight"`
+	Bikes     []Gear  `json:"bikes"`
+	Shoes     []Gear  `json:"shoes"`
+}
+
+// Activity is a Strava activity. Description and Calories are only part of
+// the detailed representation, not of activity lists.
+type Activity struct {
+	ID                 int64       `json:"id"`
+	AthleteID          int64       `json:"-"`
+	ExternalID         string      `json:"external_id"`
+	UploadID           int64       `json:"upload_id"`
+	Name               string      `json:"name"`
+	Description        string      `json:"description,omitempty"`
+	Type               string      `json:"type"`
+	SportType          string      `json:"sport_type"`
+	Distance           float64     `json:"distance"`
+	MovingTime         int         `json:"moving_time"`
+	ElapsedTime        int         `json:"elapsed_time"`
+	TotalElevationGain float64     `json:"total_elevation_gain"`
+	StartDate          time.Time   `json:"start_date"`
+	StartDateLocal     time.Time   `json:"start_date_local"`
+	Timezone           string      `json:"timezone"`
+	StartLatLng        []float64   `json:"start_latlng"`
+	EndLatLng          []float64   `json:"end_latlng"`
+	Trainer            bool        `json:"trainer"`
+	Commute            bool        `json:"commute"`
+	Manual             bool        `json:"manual"`
+	Private            bool        `json:"private"`
+	AverageSpeed       float64     `json:"average_speed"`
+	MaxSpeed           float64     `json:"max_speed"`
+	HasHeartrate       bool        `json:"has_heartrate"`
+	AverageHeartrate   float64     `json:"average_heartrate,omitempty"`
+	MaxHeartrate       float64     `json:"max_heartrate,omitempty"`
+	Calories           float64     `json:"calories,omitempty"`
+	Map                ActivityMap `json:"map"`
+}
+
+// ActivityMap is the route of an activity
+type ActivityMap struct {
+	ID              string `json:"id"`
+	SummaryPolyline string `json:"summary_polyline"`
+}
+
+// Lap is a lap of an activity
+type Lap struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	LapIndex    int       `json:"lap_index"`
+	ElapsedTime int       `json:"elapsed_time"`
+	MovingTime  int       `json:"moving_time"`
+	StartDate   time.Time `json:"start_date"`
+	Distance    float64   `json:"distance"`
+}
+
+// Gear is a bike or a pair of shoes of an athlete
+type Gear struct {
+	ID        string  `json:"id"`
+	AthleteID int64   `json:"-"`
+	Name      string  `json:"name"`
+	Primary   bool    `json:"primary"`
+	Distance  float64 `json:"distance"`
+	BrandName string  `json:"brand_name,omitempty"`
+	ModelName string  `json:"model_name,omitempty"`
+}
+
+// Stream is a time series of an activity, such as "time", "latlng" or
+// "heartrate"
+type Stream struct {
+	Type         string        `json:"type"`
+	Data         []interface{} `json:"data"`
+	SeriesType   string        `json:"series_type"`
+	OriginalSize int           `json:"original_size"`
+	Resolution   string        `json:"resolution"`
+}
+
+// Tokens are the tokens issued to an athlete
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// grant is an access or refresh token issued to an athlete
+type grant struct {
+	athleteID int64
+	expiresAt time.Time // zero for refresh tokens
+}
+
+// failure is an error injected with FailNext
+type failure struct {
+	path   string
+	status int
+}
+
+// Server is a fake Strava API served over HTTP
+type Server struct {
+	*httptest.Server
+
+	// ClientID and ClientSecret are the credentials of the only application
+	// the server knows
+	ClientID     int
+	ClientSecret string
+
+	mu            sync.Mutex
+	athletes      map[int64]Athlete
+	activities    map[int64]Activity
+	streams       map[int64][]Stream
+	laps          map[int64][]Lap
+	activityZones map[int64]json.RawMessage
+	athleteZones  map[int64]json.RawMessage
+	gear          map[string]Gear
+	codes         map[string]int64 // authorization code to athlete
+	accessTokens  map[string]grant
+	refreshTokens map[string]grant
+	issued        int
+	failures      []failure
+	requests      []string
+	limitShort    int
+	limitLong     int
+	usageShort    int
+	usageLong     int
+}
+
+// NewServer starts a fake Strava API. The caller closes it when finished.
+func NewServer() *Server {
+	s := &Server{
+		ClientID:      1234,
+		ClientSecret:  "stravatest-secret",
+		athletes:      make(map[int64]Athlete),
+		activities:    make(map[int64]Activity),
+		streams:       make(map[int64][]Stream),
+		laps:          make(map[int64][]Lap),
+		activityZones: make(map[int64]json.RawMessage),
+		athleteZones:  make(map[int64]json.RawMessage),
+		gear:          make(map[string]Gear),
+		codes:         make(map[string]int64),
+		accessTokens:  make(map[string]grant),
+		refreshTokens: make(map[string]grant),
+		limitShort:    DefaultLimitShort,
+		limitLong:     DefaultLimitLong,
+	}
+
+	r := mux.NewRouter()
+	r.Use(s.record)
+	for _, prefix := range []string{"", "/api/v3"} {
+		r.HandleFunc(prefix+"/oauth/token", s.tokenHandler).Methods("POST")
+		r.HandleFunc(prefix+"/oauth/deauthorize", s.deauthorizeHandler).Methods("POST")
+	}
+	api := r.PathPrefix("/api/v3").Subrouter()
+	api.Use(s.limit, s.authenticate)
+	api.HandleFunc("/athlete", s.athleteHandler).Methods("GET")
+	api.HandleFunc("/athlete/zones", s.athleteZonesHandler).Methods("GET")
+	api.HandleFunc("/athlete/activities", s.listActivitiesHandler).Methods("GET")
+	api.HandleFunc("/activities/{id:[0-9]+}", s.activityHandler).Methods("GET")
+	api.HandleFunc("/activities/{id:[0-9]+}/laps", s.lapsHandler).Methods("GET")
+	api.HandleFunc("/activities/{id:[0-9]+}/zones", s.activityZonesHandler).Methods("GET")
+	api.HandleFunc("/activities/{id:[0-9]+}/streams", s.streamsHandler).Methods("GET")
+	api.HandleFunc("/gear/{id}", s.gearHandler).Methods("GET")
+	r.NotFoundHandler = notFoundHandler()
+
+	s.Server = httptest.NewServer(r)
+	return s
+}
+
+// Config returns the Strava configuration of a client of the server
+func (s *Server) Config() config.Strava {
+	return config.Strava{
+		ClientID:     s.ClientID,
+		ClientSecret: s.ClientSecret,
+		CallbackURL:  "http://localhost:8080/auth/callback",
+		BaseURL:      s.URL,
+	}
+}
+
+// AddAthlete creates or replaces an athlete
+func (s *Server) AddAthlete(athlete Athlete) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.athletes[athlete.ID] = athlete
+}
+
+// AddActivity creates or replaces an activity of an athlete added before
+func (s *Server) AddActivity(activity Activity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.athletes[activity.AthleteID]; !ok {
+		panic(fmt.Sprintf("stravatest: activity %d belongs to unknown athlete %d", activity.ID, activity.AthleteID))
+	}
+	activity.StartDate = activity.StartDate.UTC()
+	s.activities[activity.ID] = activity
+}
+
+// SetStreams replaces the streams of an activity. The series type,
+// original size and resolution default to distance, the length of the data
+// and high.
+func (s *Server) SetStreams(activityID int64, streams ...Stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range streams {
+		if streams[i].SeriesType == "" {
+			streams[i].SeriesType = "distance"
+		}
+		if streams[i].OriginalSize == 0 {
+			streams[i].OriginalSize = len(streams[i].Data)
+		}
+		if streams[i].Resolution == "" {
+			streams[i].Resolution = "high"
+		}
+	}
+	s.streams[activityID] = streams
+}
+
+// SetLaps replaces the laps of an activity
+func (s *Server) SetLaps(activityID int64, laps ...Lap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.laps[activityID] = laps
+}
+
+// SetActivityZones replaces the time in zones of an activity, which is
+// returned as JSON-encoded zones
+func (s *Server) SetActivityZones(activityID int64, zones interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activityZones[activityID] = mustMarshal(zones)
+}
+
+// SetAthleteZones replaces the heart rate and power zones of an athlete,
+// which are returned as JSON-encoded zones
+func (s *Server) SetAthleteZones(athleteID int64, zones interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.athleteZones[athleteID] = mustMarshal(zones)
+}
+
+// AddGear creates or replaces a bike or a pair of shoes. Gear is visible to
+// its athlete only.
+func (s *Server) AddGear(gear Gear) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gear[gear.ID] = gear
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("stravatest: %v", err))
+	}
+	return data
+}
+
+// Authorize returns an authorization code as Strava passes it to the
+// callback URL once the athlete approved the application. The code can be
+// exchanged for tokens once.
+func (s *Server) Authorize(athleteID int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issued++
+	code := fmt.Sprintf("code-%d-%d", athleteID, s.issued)
+	s.codes[code] = athleteID
+	return code
+}
+
+// IssueTokens returns new tokens of an athlete as if they had authorized the
+// application before
+func (s *Server) IssueTokens(athleteID int64) Tokens {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueTokens(athleteID)
+}
+
+// issueTokens creates an access and a refresh token. The caller holds s.mu.
+func (s *Server) issueTokens(athleteID int64) Tokens {
+	s.issued++
+	tokens := Tokens{
+		AccessToken:  fmt.Sprintf("access-%d-%d", athleteID, s.issued),
+		RefreshToken: fmt.Sprintf("refresh-%d-%d", athleteID, s.issued),
+		ExpiresAt:    time.Now().Add(TokenLifetime).Truncate(time.Second),
+	}
+	s.accessTokens[tokens.AccessToken] = grant{athleteID: athleteID, expiresAt: tokens.ExpiresAt}
+	s.refreshTokens[tokens.RefreshToken] = grant{athleteID: athleteID}
+	return tokens
+}
+
+// ExpireTokens lets the access tokens of an athlete expire. Their refresh
+// tokens stay valid.
+func (s *Server) ExpireTokens(athleteID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token, g := range s.accessTokens {
+		if g.athleteID == athleteID {
+			g.expiresAt = time.Now().Add(-time.Second)
+			s.accessTokens[token] = g
+		}
+	}
+}
+
+// revokeTokens invalidates all tokens of an athlete. The caller holds s.mu.
+func (s *Server) revokeTokens(athleteID int64) {
+	for token, g := range s.accessTokens {
+		if g.athleteID == athleteID {
+			delete(s.accessTokens, token)
+		}
+	}
+	for token, g := range s.refreshTokens {
+		if g.athleteID == athleteID {
+			delete(s.refreshTokens, token)
+		}
+	}
+}
+
+// FailNext makes the next request whose path starts with path fail with
+// status. Failures queue up, so calling it twice fails two requests.
+func (s *Server) FailNext(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{path: path, status: status})
+}
+
+// SetRateLimit sets the number of API requests allowed per 15 minutes and
+// per day and resets the usage. Requests beyond a limit fail with 429 Too
+// Many Requests. OAuth requests do not count.
+func (s *Server) SetRateLimit(short, long int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limitShort, s.limitLong = short, long
+	s.usageShort, s.usageLong = 0, 0
+}
+
+// Requests returns the requests the server received as "METHOD /path",
+// oldest first
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// activitiesOf returns the activities of an athlete ordered by start date,
+// oldest first. The caller holds s.mu.
+func (s *Server) activitiesOf(athleteID int64) []Activity {
+	var activities []Activity
+	for _, activity := range s.activities {
+		if activity.AthleteID == athleteID {
+			activities = append(activities, activity)
+		}
+	}
+	sort.Slice(activities, func(i, j int) bool {
+		if !activities[i].StartDate.Equal(activities[j].StartDate) {
+			return activities[i].StartDate.Before(activities[j].StartDate)
+		}
+		return activities[i].ID < activities[j].ID
+	})
+	return activities
+}
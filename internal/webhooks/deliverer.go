@@ -0,0 +1,181 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+	"github.com/TobiKin/strava-data-pipeline/internal/jobs"
+	"github.com/TobiKin/strava-data-pipeline/internal/logging"
+)
+
+// maxResponseBody is how much of a receiver's response is kept in the
+// delivery log
+const maxResponseBody = 1024
+
+// Deliverer sends queued webhook deliveries. Any number of instances may run
+// it; each delivery is claimed by one of them.
+type Deliverer struct {
+	db     *db.DB
+	cfg    config.Webhooks
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewDeliverer creates a deliverer for the given configuration
+func NewDeliverer(database *db.DB, cfg config.Webhooks) *Deliverer {
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	return &Deliverer{
+		db:     database,
+		cfg:    cfg,
+		client: NewClient(timeout, cfg.AllowPrivateNetworks),
+		now:    time.Now,
+	}
+}
+
+// Run sends deliveries with cfg.Workers concurrent senders until ctx is
+// cancelled
+func (d *Deliverer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < d.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.loop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// loop claims and sends deliveries one at a time
+func (d *Deliverer) loop(ctx context.Context) {
+	// A claimed delivery is not due again before its attempt has surely ended
+	lease := time.Duration(d.cfg.Timeout)*time.Second + 30*time.Second
+	for ctx.Err() == nil {
+		delivery, err := d.db.ClaimWebhookDelivery(ctx, lease)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "Error claiming webhook delivery", "error", err)
+		}
+		if delivery != nil {
+			d.deliver(ctx, *delivery)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(d.cfg.PollInterval) * time.Second):
+		}
+	}
+}
+
+// deliver sends a claimed delivery and records the outcome
+func (d *Deliverer) deliver(ctx context.Context, delivery db.WebhookDelivery) {
+	ctx = logging.With(ctx,
+		slog.Int64("webhook_id", delivery.WebhookID),
+		slog.Int64("delivery_id", delivery.ID),
+		slog.String("event", delivery.Event),
+	)
+
+	var attempt db.WebhookAttempt
+	if delivery.Active {
+		attempt = d.send(ctx, delivery)
+	} else {
+		attempt.Error = "webhook disabled"
+	}
+
+	var retryAt time.Time
+	if !attempt.Succeeded && delivery.Active && delivery.Attempts < d.cfg.MaxAttempts {
+		retryAt = d.now().Add(jobs.Backoff(delivery.Attempts))
+	}
+	// The outcome is recorded even when ctx is cancelled on shutdown
+	if err := d.db.RecordWebhookAttempt(context.WithoutCancel(ctx), delivery.ID, attempt, retryAt); err != nil {
+		slog.ErrorContext(ctx, "Error recording webhook delivery", "error", err)
+		return
+	}
+
+	switch {
+	case attempt.Succeeded:
+		slog.DebugContext(ctx, "Delivered webhook", "status", attempt.ResponseStatus, "duration", attempt.Duration)
+	case retryAt.IsZero():
+		slog.WarnContext(ctx, "Webhook delivery failed permanently", "attempts", delivery.Attempts, "status", attempt.ResponseStatus, "error", attempt.Error)
+	default:
+		slog.InfoContext(ctx, "Webhook delivery failed, retrying", "retry_at", retryAt, "status", attempt.ResponseStatus, "error", attempt.Error)
+	}
+}
+
+// send posts the payload of a delivery to its webhook's URL. Any 2xx
+// response counts as success.
+func (d *Deliverer) send(ctx context.Context, delivery db.WebhookDelivery) db.WebhookAttempt {
+	var attempt db.WebhookAttempt
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := d.now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "strava-data-pipeline-webhooks")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	attempt.Duration = time.Since(start)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // lets the connection be reused
+	attempt.ResponseStatus = resp.StatusCode
+	attempt.ResponseBody = logBody(body)
+	attempt.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !attempt.Succeeded {
+		attempt.Error = "unexpected status " + resp.Status
+	}
+	return attempt
+}
+
+// RunRetention deletes finished deliveries older than the configured
+// retention period every interval until ctx is cancelled
+func (d *Deliverer) RunRetention(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := d.now().AddDate(0, 0, -d.cfg.RetentionDays)
+		n, err := d.db.PruneWebhookDeliveries(ctx, cutoff)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error pruning webhook deliveries", "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "Pruned webhook deliveries", "count", n, "retention_days", d.cfg.RetentionDays)
+		}
+	}
+}
+
+// logBody returns a response body as text Postgres accepts: invalid UTF-8,
+// such as a rune cut in half by the size limit, and NUL bytes are dropped
+func logBody(body []byte) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(string(body), ""), "\x00", "")
+}
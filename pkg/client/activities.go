@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// defaultPageSize is the page size of the Activities iterator
+const defaultPageSize = 100
+
+// Activity is a Strava activity as stored by the pipeline
+type Activity struct {
+	ID                 int64     `json:"ID"`
+	Name               string    `json:"Name"`
+	Description        string    `json:"Description"`
+	Type               string    `json:"Type"`
+	Distance           float64   `json:"Distance"`    // meters
+	MovingTime         int       `json:"MovingTime"`  // seconds
+	ElapsedTime        int       `json:"ElapsedTime"` // seconds
+	TotalElevationGain float64   `json:"TotalElevationGain"`
+	StartDate          time.Time `json:"StartDate"`
+	StartDateLocal     time.Time `json:"StartDateLocal"`
+	Timezone           string    `json:"Timezone"`
+	StartLatLng        string    `json:"StartLatLng"`
+	EndLatLng          string    `json:"EndLatLng"`
+	AchievementCount   int       `json:"AchievementCount"`
+	KudosCount         int       `json:"KudosCount"`
+	CommentCount       int       `json:"CommentCount"`
+	AthleteCount       int       `json:"AthleteCount"`
+	PhotoCount         int       `json:"PhotoCount"`
+	MapID              string    `json:"MapID"`
+	MapPolyline        string    `json:"MapPolyline"`
+	Trainer            bool      `json:"Trainer"`
+	Commute            bool      `json:"Commute"`
+	Manual             bool      `json:"Manual"`
+	Private            bool      `json:"Private"`
+	Visibility         string    `json:"Visibility"`
+	Flagged            bool      `json:"Flagged"`
+	WorkoutType        int       `json:"WorkoutType"`
+	AverageSpeed       float64   `json:"AverageSpeed"` // meters per second
+	MaxSpeed           float64   `json:"MaxSpeed"`     // meters per second
+	HasHeartRate       bool      `json:"HasHeartRate"`
+	AverageHeartRate   float64   `json:"AverageHeartRate"`
+	MaxHeartRate       float64   `json:"MaxHeartRate"`
+	ElevHigh           float64   `json:"ElevHigh"`
+	ElevLow            float64   `json:"ElevLow"`
+	UploadID           int64     `json:"UploadID"`
+	UploadIDStr        string    `json:"UploadIDStr"`
+	ExternalID         string    `json:"ExternalID"`
+	AthleteID          int64     `json:"AthleteID"`
+	CreatedAt          time.Time `json:"CreatedAt"`
+	UpdatedAt          time.Time `json:"UpdatedAt"`
+}
+
+// ListActivitiesOptions filters and pages activities. Zero values are ignored.
+type ListActivitiesOptions struct {
+	// AthleteID only returns activities of this athlete. Coaches may name
+	// linked athletes; without it callers get their own activities and
+	// operators those of every athlete.
+	AthleteID int64
+	// Type only returns activities of this type, e.g. "Run"
+	Type string
+	// After only returns activities started at or after this time
+	After time.Time
+	// Before only returns activities started before this time
+	Before time.Time
+	// Limit is the page size; the server defaults to 20
+	Limit int
+	// Offset is the number of activities to skip
+	Offset int
+}
+
+func (o ListActivitiesOptions) query() url.Values {
+	q := url.Values{}
+	if o.AthleteID != 0 {
+		q.Set("athlete_id", strconv.FormatInt(o.AthleteID, 10))
+	}
+	if o.Type != "" {
+		q.Set("type", o.Type)
+	}
+	if !o.After.IsZero() {
+		q.Set("after", o.After.Format(time.RFC3339))
+	}
+	if !o.Before.IsZero() {
+		q.Set("before", o.Before.Format(time.RFC3339))
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if o.Offset > 0 {
+		q.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return q
+}
+
+// ListActivities returns a single page of activities, newest first
+func (c *Client) ListActivities(ctx context.Context, opts ListActivitiesOptions) ([]Activity, error) {
+	var activities []Activity
+	if err := c.do(ctx, "GET", "/api/v1/activities", opts.query(), nil, &activities); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
+
+// Activities iterates over all activities matching opts, newest first,
+// fetching pages of opts.Limit activities as needed. Iteration stops after
+// the first error.
+func (c *Client) Activities(ctx context.Context, opts ListActivitiesOptions) iter.Seq2[Activity, error] {
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageSize
+	}
+	return func(yield func(Activity, error) bool) {
+		for {
+			page, err := c.ListActivities(ctx, opts)
+			if err != nil {
+				yield(Activity{}, err)
+				return
+			}
+			for _, activity := range page {
+				if !yield(activity, nil) {
+					return
+				}
+			}
+			if len(page) < opts.Limit {
+				return
+			}
+			opts.Offset += len(page)
+		}
+	}
+}
+
+// GetActivity returns a single activity. A missing activity is reported as
+// an error for which IsNotFound is true.
+func (c *Client) GetActivity(ctx context.Context, id int64) (Activity, error) {
+	var activity Activity
+	err := c.do(ctx, "GET", "/api/v1/activities/"+strconv.FormatInt(id, 10), nil, nil, &activity)
+	return activity, err
+}
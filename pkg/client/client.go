@@ -0,0 +1,226 @@
+// Package client is a Go client for the Strava Data Pipeline API.
+//
+// Activities are read with an API key, key management needs the access token
+// of a signed in user:
+//
+//	c, err := client.New("https://pipeline.example.com", client.WithAPIKey(key))
+//	for activity, err := range c.Activities(ctx, client.ListActivitiesOptions{Type: "Run"}) {
+//		...
+//	}
+//
+// Errors returned by the API are *Error values carrying the stable error code
+// of the problem details response.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// Client calls the API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	apiKey     string
+	token      string
+	userAgent  string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAPIKey authenticates requests with an API key. API keys are accepted
+// by /api/v1 routes.
+func WithAPIKey(key string) Option {
+	return func(c *Client) { c.apiKey = key }
+}
+
+// WithToken authenticates requests with a JWT access token. Access tokens
+// are required for key management and accepted by the team routes.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithUserAgent sets the User-Agent header of requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// WithRetries sets how often idempotent requests are retried after network
+// errors, 429 and 5xx gateway responses, and the bounds of the exponential
+// backoff between attempts. A maxRetries of 0 disables retries.
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a client for the API at baseURL, e.g. https://pipeline.example.com
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		userAgent:  "strava-data-pipeline-go-client",
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// do sends a request with a JSON body, if in is not nil, and decodes a JSON
+// response into out, if out is not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, u.String(), body)
+		if err == nil && resp.StatusCode < 300 {
+			defer resp.Body.Close()
+			if out == nil {
+				return nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("error decoding response: %w", err)
+			}
+			return nil
+		}
+
+		var retryAfter time.Duration
+		if err == nil {
+			err = newError(resp)
+			retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+		}
+
+		if attempt >= c.maxRetries || !retryable(method, err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := max(c.backoff(attempt), retryAfter)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// send sends a single request
+func (c *Client) send(ctx context.Context, method, url string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	return resp, nil
+}
+
+// backoff returns the jittered exponential delay before retry attempt+1
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.minBackoff << attempt
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	// Full jitter in [d/2, d) keeps clients from retrying in lockstep
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether a failed request may be sent again. Requests
+// that are not idempotent are never retried since the first attempt may have
+// taken effect.
+func retryable(method string, err error) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		// Network errors; context errors are checked by the caller
+		return true
+	}
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,272 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TobiKin/strava-data-pipeline/internal/api"
+	"github.com/TobiKin/strava-data-pipeline/internal/auth"
+	"github.com/TobiKin/strava-data-pipeline/internal/config"
+	"github.com/TobiKin/strava-data-pipeline/internal/db"
+)
+
+// Test database, the same as the one used by the db package tests
+var testDatabase = config.Database{
+	Host:     "192.168.64.5",
+	Port:     5432,
+	User:     "user",
+	Password: "password",
+	Name:     "tempdb",
+	SSLMode:  "disable",
+}
+
+const testUserID = int64(9301)
+
+// newTestClient returns a client for server that retries quickly
+func newTestClient(t *testing.T, url string, opts ...Option) *Client {
+	t.Helper()
+	opts = append([]Option{WithRetries(3, time.Millisecond, 5*time.Millisecond)}, opts...)
+	c, err := New(url, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return c
+}
+
+// setupTestServer starts the API server on the test database. Tests using it
+// are skipped when the database is not reachable.
+func setupTestServer(t *testing.T) (*httptest.Server, *db.DB, *auth.Service) {
+	t.Helper()
+	cfg := &config.Config{
+		Database: testDatabase,
+		Auth:     config.Auth{JWTSecret: "client-test-secret", TokenDuration: 15, RefreshTokenDuration: 24},
+	}
+
+	database, err := db.New(cfg)
+	if err != nil {
+		t.Skipf("Test database not available: %v", err)
+	}
+	database.InitSchema()
+
+	authService, err := auth.New(cfg, database)
+	if err != nil {
+		t.Fatalf("Failed to create auth service: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, err := database.ExecContext(ctx, `INSERT INTO users (id, username) VALUES ($1, 'client-test') ON CONFLICT (id) DO NOTHING`, testUserID); err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	server := httptest.NewServer(api.New(database, nil, authService, nil, nil))
+	t.Cleanup(func() {
+		server.Close()
+		database.DeleteUser(ctx, testUserID)
+		database.Close()
+	})
+	return server, database, authService
+}
+
+func TestErrorResponse(t *testing.T) {
+	server := httptest.NewServer(api.New(nil, nil, nil, nil, nil))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	_, err := c.GetActivity(context.Background(), 1)
+	if !IsUnauthorized(err) {
+		t.Fatalf("Expected an unauthorized error, got %v", err)
+	}
+	apiErr := err.(*Error)
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.RequestID == "" || apiErr.Instance != "/api/v1/activities/1" {
+		t.Fatalf("Expected the problem details to be decoded, got %+v", apiErr)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	apiServer := api.New(nil, nil, nil, nil, nil)
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first two attempts hit an overloaded proxy
+		if attempts.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		apiServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, server.URL)
+	if _, err := c.ListActivities(context.Background(), ListActivitiesOptions{}); !IsUnauthorized(err) {
+		t.Fatalf("Expected the retried request to reach the API, got %v", err)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", got)
+	}
+
+	// Requests that are not idempotent are sent once
+	attempts.Store(0)
+	_, err := c.CreateKey(context.Background(), CreateKeyRequest{Description: "test"})
+	if apiErr, ok := err.(*Error); !ok || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the 503 to be returned, got %v", err)
+	}
+	if got := attempts.Load(); got != 1 {
+		t.Fatalf("Expected a single attempt, got %d", got)
+	}
+}
+
+func TestActivities(t *testing.T) {
+	ctx := context.Background()
+	server, database, authService := setupTestServer(t)
+
+	apiKey, err := authService.GenerateAPIKey(ctx, testUserID, db.ScopeUser, "client test", 1)
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+	key := apiKey.Key
+
+	// Activities in 2001 so the filter excludes everything else in the database
+	start := time.Date(2001, 5, 1, 8, 0, 0, 0, time.UTC)
+	types := []string{"Run", "Ride", "Run", "Run"}
+	for i, activityType := range types {
+		id := testUserID*100 + int64(i)
+		_, err := database.CreateActivity(ctx, db.Activity{
+			ID:        id,
+			Name:      "Client test " + activityType,
+			Type:      activityType,
+			StartDate: start.AddDate(0, 0, i),
+			AthleteID: testUserID,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create activity: %v", err)
+		}
+		defer database.DeleteActivity(ctx, id)
+	}
+
+	c := newTestClient(t, server.URL, WithAPIKey(key))
+	opts := ListActivitiesOptions{
+		Type:   "Run",
+		After:  start,
+		Before: start.AddDate(0, 1, 0),
+		Limit:  2,
+	}
+
+	page, err := c.ListActivities(ctx, opts)
+	if err != nil {
+		t.Fatalf("Failed to list activities: %v", err)
+	}
+	if len(page) != 2 || page[0].ID != testUserID*100+3 {
+		t.Fatalf("Expected the 2 newest runs, got %+v", page)
+	}
+
+	var ids []int64
+	for activity, err := range c.Activities(ctx, opts) {
+		if err != nil {
+			t.Fatalf("Failed to iterate activities: %v", err)
+		}
+		ids = append(ids, activity.ID)
+	}
+	if len(ids) != 3 {
+		t.Fatalf("Expected 3 runs across pages, got %v", ids)
+	}
+
+	activity, err := c.GetActivity(ctx, testUserID*100+1)
+	if err != nil {
+		t.Fatalf("Failed to get activity: %v", err)
+	}
+	if activity.Type != "Ride" || !activity.StartDate.Equal(start.AddDate(0, 0, 1)) {
+		t.Fatalf("Unexpected activity %+v", activity)
+	}
+
+	if _, err := c.GetActivity(ctx, testUserID*100+99); !IsNotFound(err) {
+		t.Fatalf("Expected a not found error, got %v", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	server, _, authService := setupTestServer(t)
+
+	token, err := authService.GenerateJWT(testUserID)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	c := newTestClient(t, server.URL, WithToken(token))
+
+	created, err := c.CreateKey(ctx, CreateKeyRequest{Description: "client key test", ExpiryDays: 1})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	if created.Key == "" || created.Scope != ScopeUser {
+		t.Fatalf("Unexpected new key %+v", created)
+	}
+
+	findKey := func(value string) (APIKey, bool) {
+		keys, err := c.ListKeys(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list keys: %v", err)
+		}
+		for _, k := range keys {
+			if k.Key == value {
+				return k, true
+			}
+		}
+		return APIKey{}, false
+	}
+
+	key, ok := findKey(created.Key)
+	if !ok {
+		t.Fatal("Expected the new key to be listed")
+	}
+
+	rotated, err := c.RotateKey(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+	if old, _ := findKey(created.Key); old.IsActive {
+		t.Fatal("Expected the rotated key to be inactive")
+	}
+
+	replacement, ok := findKey(rotated.Key)
+	if !ok || !replacement.IsActive {
+		t.Fatal("Expected the replacement key to be listed as active")
+	}
+	if err := c.RevokeKey(ctx, replacement.ID); err != nil {
+		t.Fatalf("Failed to revoke key: %v", err)
+	}
+	if err := c.RevokeKey(ctx, 0); !IsNotFound(err) {
+		t.Fatalf("Expected a not found error for an unknown key, got %v", err)
+	}
+}
+
+func TestTeamStats(t *testing.T) {
+	ctx := context.Background()
+	server, database, authService := setupTestServer(t)
+
+	team, err := database.CreateTeam(ctx, "Client Test Team", testUserID)
+	if err != nil {
+		t.Fatalf("Failed to create team: %v", err)
+	}
+	defer database.DeleteTeam(ctx, team.ID)
+
+	token, err := authService.GenerateJWT(testUserID)
+	if err != nil {
+		t.Fatalf("Failed to create token: %v", err)
+	}
+	c := newTestClient(t, server.URL, WithToken(token))
+
+	stats, err := c.TeamStats(ctx, team.ID)
+	if err != nil {
+		t.Fatalf("Failed to get team stats: %v", err)
+	}
+	if stats.TeamID != team.ID {
+		t.Fatalf("Expected stats of team %d, got %+v", team.ID, stats)
+	}
+
+	if _, err := newTestClient(t, server.URL).TeamStats(ctx, team.ID); !IsUnauthorized(err) {
+		t.Fatalf("Expected an unauthorized error without credentials, got %v", err)
+	}
+}
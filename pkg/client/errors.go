@@ -0,0 +1,120 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error codes returned by the API. They are stable; new codes may be added.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidBody        = "invalid_body"
+	CodeInvalidParameter   = "invalid_parameter"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidToken       = "invalid_token"
+	CodeInvalidAPIKey      = "invalid_api_key"
+	CodeTokenReused        = "token_reused"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeUpstreamError      = "upstream_error"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeInternalError      = "internal_error"
+)
+
+// maxErrorBody bounds how much of a response that is not problem details is
+// kept as the error detail
+const maxErrorBody = 512
+
+// Error is an error response of the API
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Code is the stable error code, empty if the response did not come
+	// from the API, e.g. from a proxy in between
+	Code      string
+	Title     string
+	Detail    string
+	Instance  string
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("api error %d", e.StatusCode)
+	if e.Code != "" {
+		msg += " " + e.Code
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// newError reads an error response
+func newError(resp *http.Response) *Error {
+	e := &Error{StatusCode: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var problem struct {
+		Title     string `json:"title"`
+		Code      string `json:"code"`
+		Detail    string `json:"detail"`
+		Instance  string `json:"instance"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(body, &problem); err == nil && problem.Code != "" {
+		e.Code = problem.Code
+		e.Title = problem.Title
+		e.Detail = problem.Detail
+		e.Instance = problem.Instance
+		e.RequestID = problem.RequestID
+	} else {
+		detail := strings.TrimSpace(string(body))
+		if len(detail) > maxErrorBody {
+			detail = detail[:maxErrorBody]
+		}
+		e.Detail = detail
+	}
+	if e.RequestID == "" {
+		e.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	return e
+}
+
+// hasCode reports whether err is an API error with one of the codes
+func hasCode(err error, codes ...string) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNotFound reports whether err means the resource does not exist
+func IsNotFound(err error) bool {
+	return hasCode(err, CodeNotFound)
+}
+
+// IsUnauthorized reports whether err means the credentials are missing or
+// were rejected
+func IsUnauthorized(err error) bool {
+	return hasCode(err, CodeUnauthorized, CodeInvalidToken, CodeInvalidAPIKey, CodeTokenReused)
+}
+
+// IsForbidden reports whether err means the credentials do not grant access
+func IsForbidden(err error) bool {
+	return hasCode(err, CodeForbidden)
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// API key scopes
+const (
+	ScopeUser  = "user"
+	ScopeCoach = "coach"
+)
+
+// APIKey is an API key of the signed in user
+type APIKey struct {
+	ID          int64     `json:"ID"`
+	Key         string    `json:"Key"`
+	Description string    `json:"Description"`
+	CreatedAt   time.Time `json:"CreatedAt"`
+	ExpiresAt   time.Time `json:"ExpiresAt"`
+	IsActive    bool      `json:"IsActive"`
+	UserID      *int64    `json:"UserID"`
+	Scope       string    `json:"Scope"`
+}
+
+// CreateKeyRequest describes a new API key
+type CreateKeyRequest struct {
+	Description string `json:"description"`
+	// ExpiryDays is the lifetime of the key; 0 creates a key that does not
+	// expire
+	ExpiryDays int `json:"expiry_days"`
+	// Scope is ScopeUser (the default) or ScopeCoach
+	Scope string `json:"scope,omitempty"`
+}
+
+// NewKey is a newly created API key. The key itself is only returned once.
+type NewKey struct {
+	Key   string `json:"key"`
+	Scope string `json:"scope"`
+}
+
+// ListKeys returns the API keys of the signed in user. It needs a token.
+func (c *Client) ListKeys(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	if err := c.do(ctx, "GET", "/admin/keys", nil, nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CreateKey creates an API key for the signed in user. It needs a token.
+func (c *Client) CreateKey(ctx context.Context, req CreateKeyRequest) (NewKey, error) {
+	var key NewKey
+	err := c.do(ctx, "POST", "/admin/keys", nil, req, &key)
+	return key, err
+}
+
+// RevokeKey deactivates an API key of the signed in user. It needs a token.
+func (c *Client) RevokeKey(ctx context.Context, id int64) error {
+	return c.do(ctx, "DELETE", "/admin/keys/"+strconv.FormatInt(id, 10), nil, nil, nil)
+}
+
+// RotateKey replaces an API key with a new one with the same description,
+// scope and lifetime. It needs a token.
+func (c *Client) RotateKey(ctx context.Context, id int64) (NewKey, error) {
+	var key NewKey
+	err := c.do(ctx, "POST", "/admin/keys/"+strconv.FormatInt(id, 10)+"/rotate", nil, nil, &key)
+	return key, err
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"strconv"
+)
+
+// TeamStats are the totals of the consenting members of a team
+type TeamStats struct {
+	TeamID             int64         `json:"TeamID"`
+	MemberCount        int           `json:"MemberCount"`
+	ActivityCount      int           `json:"ActivityCount"`
+	Distance           float64       `json:"Distance"`   // meters
+	MovingTime         int64         `json:"MovingTime"` // seconds
+	TotalElevationGain float64       `json:"TotalElevationGain"`
+	Members            []MemberStats `json:"Members"`
+}
+
+// MemberStats are the totals of a single team member
+type MemberStats struct {
+	UserID             int64   `json:"UserID"`
+	ActivityCount      int     `json:"ActivityCount"`
+	Distance           float64 `json:"Distance"`
+	MovingTime         int64   `json:"MovingTime"`
+	TotalElevationGain float64 `json:"TotalElevationGain"`
+}
+
+// TeamStats returns the totals of a team. It needs a coach-scoped API key of
+// the team's coach, or a token of the coach or an operator.
+func (c *Client) TeamStats(ctx context.Context, teamID int64) (TeamStats, error) {
+	var stats TeamStats
+	err := c.do(ctx, "GET", "/api/v1/teams/"+strconv.FormatInt(teamID, 10)+"/stats", nil, nil, &stats)
+	return stats, err
+}
@@ -52,4 +52,5 @@ echo "Applying database schema..."
 
 # Run the application in development mode
 echo "Starting the application in development mode..."
+export APP_MODE="development"
 go run ./cmd/server/main.go --config=./config.yaml